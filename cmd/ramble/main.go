@@ -11,6 +11,10 @@ import (
 // @license.name MPL-2.0
 // @host localhost:3000
 // @BasePath /
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description API token, sent as "Bearer rmbl_..."
 func main() {
 	cmd.Execute()
 }
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"text/tabwriter"
@@ -18,6 +19,7 @@ var cacheCmd = &cobra.Command{
 Examples:
   ramble cache list   List cached packs
   ramble cache clear  Remove all cached packs
+  ramble cache verify Check cached packs against their recorded digests
   ramble cache path   Show cache directory path`,
 }
 
@@ -58,6 +60,47 @@ var cacheClearCmd = &cobra.Command{
 	},
 }
 
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check cached packs for tampering or corruption",
+	Long: `Re-check every cached pack against the file digests recorded when it was
+downloaded. Packs that fail should be removed with "ramble cache clear"; they
+are downloaded and verified again on the next run.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cache := pack.NewCache()
+		packs, err := cache.List()
+		if err != nil {
+			return fmt.Errorf("failed to list cache: %w", err)
+		}
+
+		if len(packs) == 0 {
+			fmt.Println("No packs cached")
+			return nil
+		}
+
+		failed := 0
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "REGISTRY\tNAMESPACE\tNAME\tVERSION\tSTATUS")
+		for _, p := range packs {
+			status := "ok"
+			if err := cache.Verify(p); errors.Is(err, pack.ErrNoManifest) {
+				status = "unverified (" + err.Error() + ")"
+			} else if err != nil {
+				status = "FAILED: " + err.Error()
+				failed++
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Registry, p.Namespace, p.Name, p.Version, status)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d cached pack(s) failed verification", failed)
+		}
+		return nil
+	},
+}
+
 var cachePathCmd = &cobra.Command{
 	Use:   "path",
 	Short: "Show cache directory path",
@@ -71,5 +114,6 @@ func init() {
 	rootCmd.AddCommand(cacheCmd)
 	cacheCmd.AddCommand(cacheListCmd)
 	cacheCmd.AddCommand(cacheClearCmd)
+	cacheCmd.AddCommand(cacheVerifyCmd)
 	cacheCmd.AddCommand(cachePathCmd)
 }
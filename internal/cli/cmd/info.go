@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"rmbl/internal/pack"
+)
+
+// printDetail shows a pack or job with the variables of the version that
+// version resolves to, or of the shown when it is empty, and that version's
+// README if asked for. Jobs are printed through their PackDetail conversion,
+// which has the same fields.
+func printDetail(ref, version string, detail pack.PackDetail, asJSON, readme bool) error {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(detail)
+	}
+
+	fmt.Printf("Name:        %s\n", detail.Name)
+	if detail.Namespace != "" {
+		fmt.Printf("Namespace:   %s\n", detail.Namespace)
+	}
+	fmt.Printf("Description: %s\n", detail.Description)
+	if detail.Visibility != "" && detail.Visibility != "public" {
+		fmt.Printf("Visibility:  %s\n", detail.Visibility)
+	}
+	if detail.License != "" {
+		fmt.Printf("License:     %s\n", detail.License)
+	}
+	if detail.RepositoryURL != "" {
+		fmt.Printf("Repository:  %s\n", detail.RepositoryURL)
+	}
+	if len(detail.Tags) > 0 {
+		fmt.Printf("Tags:        %s\n", strings.Join(detail.Tags, ", "))
+	}
+	fmt.Printf("Stars:       %d\n", detail.Stars)
+	fmt.Printf("Downloads:   %d\n", detail.Downloads)
+	if !detail.CreatedAt.IsZero() {
+		fmt.Printf("Created:     %s\n", detail.CreatedAt.Format("2006-01-02"))
+		fmt.Printf("Updated:     %s\n", detail.UpdatedAt.Format("2006-01-02"))
+	}
+	fmt.Printf("Versions:    %d\n", len(detail.Versions))
+	if detail.Deprecation != nil {
+		fmt.Printf("Deprecated:  %s\n", detail.Deprecation.Warning(ref))
+	}
+	fmt.Println()
+
+	if len(detail.Versions) > 0 {
+		printVersionHistory(detail.Versions)
+	}
+
+	shown, err := pack.ResolveVersion(detail.Versions, version)
+	if err != nil {
+		if version != "" {
+			return fmt.Errorf("version %s: %w", version, err)
+		}
+		return nil
+	}
+	if len(shown.Variables) > 0 {
+		fmt.Printf("\nVariables (%s):\n", shown.Version)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, v := range shown.Variables {
+			fmt.Fprintf(w, "  %s\t%s\n", v.Name, v.Description)
+		}
+		w.Flush()
+	}
+	if readme {
+		if shown.Readme == "" {
+			fmt.Printf("\n%s has no README\n", shown.Version)
+		} else {
+			fmt.Printf("\nREADME (%s):\n\n%s\n", shown.Version, strings.TrimRight(shown.Readme, "\n"))
+		}
+	}
+
+	return nil
+}
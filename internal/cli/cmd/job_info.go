@@ -1,10 +1,7 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"strings"
 
 	"rmbl/internal/cli/config"
 	"rmbl/internal/pack"
@@ -15,15 +12,20 @@ import (
 var (
 	jobInfoRegistry string
 	jobInfoJSON     bool
+	jobInfoReadme   bool
 )
 
 var jobInfoCmd = &cobra.Command{
 	Use:   "info <namespace/job>",
 	Short: "Get detailed information about a job",
-	Long: `Display detailed information about a specific job including version history.
+	Long: `Display detailed information about a specific job: its owner, license,
+tags, stars and downloads, the version history and the variables of the latest
+version. --readme also prints its README. Name a version
+or constraint with @ to see its variables and README instead of the latest's.
 
 Examples:
   ramble job info user1/my-job
+  ramble job info user1/my-job --readme
   ramble job info user1/my-job --json`,
 	Args: cobra.ExactArgs(1),
 	RunE: runJobInfo,
@@ -34,11 +36,12 @@ func init() {
 
 	jobInfoCmd.Flags().StringVarP(&jobInfoRegistry, "registry", "r", "", "Registry URL (uses default if not specified)")
 	jobInfoCmd.Flags().BoolVar(&jobInfoJSON, "json", false, "Output as JSON")
+	jobInfoCmd.Flags().BoolVar(&jobInfoReadme, "readme", false, "Also print the README of the latest version")
 }
 
 func runJobInfo(cmd *cobra.Command, args []string) error {
 	jobRef := args[0]
-	namespace, name := parseJobRef(jobRef)
+	namespace, name, version := parsePackReference(jobRef)
 
 	if namespace == "" {
 		return fmt.Errorf("namespace required: use namespace/jobname format")
@@ -50,42 +53,12 @@ func runJobInfo(cmd *cobra.Command, args []string) error {
 		registryURL = cfg.GetDefaultURL()
 	}
 
-	client := pack.NewClient(registryURL)
+	client := registryClient(registryURL)
 
 	detail, err := client.GetJob(namespace, name)
 	if err != nil {
 		return fmt.Errorf("failed to get job info: %w", err)
 	}
 
-	if jobInfoJSON {
-		enc := json.NewEncoder(os.Stdout)
-		enc.SetIndent("", "  ")
-		return enc.Encode(detail)
-	}
-
-	fmt.Printf("Name:        %s\n", detail.Name)
-	fmt.Printf("Description: %s\n", detail.Description)
-	fmt.Printf("Versions:    %d\n", len(detail.Versions))
-	fmt.Println()
-
-	if len(detail.Versions) > 0 {
-		fmt.Println("Version History:")
-		for _, v := range detail.Versions {
-			fmt.Printf("  - %s\n", v.Version)
-		}
-	}
-
-	return nil
-}
-
-// parseJobRef parses "namespace/name" into components
-func parseJobRef(ref string) (namespace, name string) {
-	// Handle version suffix
-	ref = strings.Split(ref, "@")[0]
-
-	parts := strings.SplitN(ref, "/", 2)
-	if len(parts) == 2 {
-		return parts[0], parts[1]
-	}
-	return "", parts[0]
+	return printDetail(namespace+"/"+name, version, pack.PackDetail(*detail), jobInfoJSON, jobInfoReadme)
 }
@@ -1,10 +1,7 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"text/tabwriter"
 
 	"rmbl/internal/cli/config"
 	"rmbl/internal/pack"
@@ -13,9 +10,13 @@ import (
 )
 
 var (
-	jobListRegistry string
-	jobListJSON     bool
-	jobListSearch   string
+	jobListRegistry  string
+	jobListNamespace string
+	jobListJSON      bool
+	jobListSearch    string
+	jobListTag       string
+	jobListSort      string
+	jobListLimit     int
 )
 
 var jobListCmd = &cobra.Command{
@@ -23,9 +24,13 @@ var jobListCmd = &cobra.Command{
 	Short: "List jobs from the registry",
 	Long: `List all available Nomad job files from the registry.
 
+Results are fetched page by page, so large registries can be listed in full.
+Use --limit to stop early.
+
 Examples:
   ramble job list                    List all jobs
   ramble job list --search postgres  Search for jobs
+  ramble job list --tag database --sort downloads
   ramble job list --json             Output as JSON`,
 	RunE: runJobList,
 }
@@ -34,8 +39,12 @@ func init() {
 	jobCmd.AddCommand(jobListCmd)
 
 	jobListCmd.Flags().StringVarP(&jobListRegistry, "registry", "r", "", "Registry URL (uses default if not specified)")
+	jobListCmd.Flags().StringVarP(&jobListNamespace, "namespace", "n", "", "Filter by namespace (user or org)")
 	jobListCmd.Flags().BoolVar(&jobListJSON, "json", false, "Output as JSON")
-	jobListCmd.Flags().StringVarP(&jobListSearch, "search", "s", "", "Search jobs by name or description")
+	jobListCmd.Flags().StringVarP(&jobListSearch, "search", "s", "", "Search jobs by name, description, README and content")
+	jobListCmd.Flags().StringVar(&jobListTag, "tag", "", "Filter by tag")
+	jobListCmd.Flags().StringVar(&jobListSort, "sort", "", "Sort by relevance, updated, stars or downloads")
+	jobListCmd.Flags().IntVar(&jobListLimit, "limit", 0, "Show at most this many jobs (0 for all)")
 }
 
 func runJobList(cmd *cobra.Command, args []string) error {
@@ -45,44 +54,29 @@ func runJobList(cmd *cobra.Command, args []string) error {
 		registryURL = cfg.GetDefaultURL()
 	}
 
-	client := pack.NewClient(registryURL)
-
-	var jobs []pack.JobSummary
-	var err error
-
-	if jobListSearch != "" {
-		jobs, err = client.SearchJobs(jobListSearch)
-	} else {
-		jobs, err = client.ListAllJobs()
-	}
+	client := registryClient(registryURL)
 
+	opts := pack.SearchOptions{Query: jobListSearch, Type: "job", Tag: jobListTag, Namespace: jobListNamespace, Sort: jobListSort}
+	jobs, err := searchResources(client, opts, jobListLimit, func() ([]pack.SearchResult, error) {
+		if jobListNamespace != "" {
+			return nil, fmt.Errorf("this registry does not support --namespace for jobs")
+		}
+		var summaries []pack.JobSummary
+		var err error
+		if jobListSearch != "" {
+			summaries, err = client.SearchJobs(jobListSearch)
+		} else {
+			summaries, err = client.ListAllJobs()
+		}
+		results := make([]pack.SearchResult, len(summaries))
+		for i, j := range summaries {
+			results[i] = pack.SearchResult{Name: j.Name, Namespace: j.Namespace, Type: "job", Description: j.Description}
+		}
+		return results, err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to list jobs: %w", err)
 	}
 
-	if jobListJSON {
-		enc := json.NewEncoder(os.Stdout)
-		enc.SetIndent("", "  ")
-		return enc.Encode(jobs)
-	}
-
-	if len(jobs) == 0 {
-		fmt.Println("No jobs found")
-		return nil
-	}
-
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "NAME\tDESCRIPTION")
-	for _, j := range jobs {
-		desc := j.Description
-		if len(desc) > 50 {
-			desc = desc[:47] + "..."
-		}
-		fullName := j.Name
-		if j.Namespace != "" {
-			fullName = j.Namespace + "/" + j.Name
-		}
-		fmt.Fprintf(w, "%s\t%s\n", fullName, desc)
-	}
-	return w.Flush()
+	return printResources(jobs, jobListJSON, "job")
 }
@@ -16,6 +16,7 @@ var (
 	jobRunNomadAddr  string
 	jobRunRegistry   string
 	jobRunDryRun     bool
+	jobRunRequireSig bool
 )
 
 var jobRunCmd = &cobra.Command{
@@ -25,11 +26,23 @@ var jobRunCmd = &cobra.Command{
 
 The job can be specified as:
   - Local file path (e.g., ./app.nomad.hcl)
-  - Registry reference (e.g., namespace/jobname)
+  - Registry reference (e.g., namespace/jobname[@version])
+
+A registry version may be a constraint such as @~1.4 or @">=2.0,<3". Without
+one the latest release is used.
+
+Signed registry versions are checked against the keys pinned with
+'ramble trust' before they are submitted. Without pinned keys they are
+checked against the namespace's keys in the registry, with a warning, as
+the registry could serve a key of its own. With --require-signature, or a
+registry trust policy that requires it, versions must be signed with a
+pinned key.
 
 Examples:
   ramble job run ./app.nomad.hcl
   ramble job run user1/my-job
+  ramble job run user1/my-job@^2
+  ramble job run user1/my-job --require-signature
   ramble job run ./app.nomad.hcl --nomad-addr http://localhost:4646`,
 	Args: cobra.ExactArgs(1),
 	RunE: runJobRun,
@@ -41,6 +54,7 @@ func init() {
 	jobRunCmd.Flags().StringVar(&jobRunNomadAddr, "nomad-addr", "", "Nomad address (overrides NOMAD_ADDR)")
 	jobRunCmd.Flags().StringVarP(&jobRunRegistry, "registry", "r", "", "Registry URL (uses default if not specified)")
 	jobRunCmd.Flags().BoolVar(&jobRunDryRun, "dry-run", false, "Print job content without submitting")
+	jobRunCmd.Flags().BoolVar(&jobRunRequireSig, "require-signature", false, "Refuse versions that are not signed with a key pinned with 'ramble trust'")
 }
 
 func runJobRun(cmd *cobra.Command, args []string) error {
@@ -75,11 +89,32 @@ func runJobRun(cmd *cobra.Command, args []string) error {
 			registryURL = cfg.GetDefaultURL()
 		}
 
-		client := pack.NewClient(registryURL)
-		content, err := client.GetRawContent(namespace, name, version)
+		client := registryClient(registryURL)
+
+		// Resolve the version or constraint against the registry's versions
+		detail, err := client.GetJob(namespace, name)
+		if err != nil {
+			return fmt.Errorf("failed to get job info: %w", err)
+		}
+		resolved, err := pack.ResolveVersion(detail.Versions, version)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %w", namespace, name, err)
+		}
+		printWarnings(namespace+"/"+name, detail.Deprecation, resolved)
+		if version != resolved.Version {
+			fmt.Fprintf(os.Stderr, "Using version: %s\n", resolved.Version)
+		}
+		if err := verifyVersionSignature(client, namespace, name, resolved, signatureRequired(client, jobRunRequireSig)); err != nil {
+			return err
+		}
+
+		content, err := client.GetRawContent(namespace, name, resolved.Version)
 		if err != nil {
 			return fmt.Errorf("failed to fetch job from registry: %w", err)
 		}
+		if err := pack.VerifyDigest([]byte(content), resolved.Digest); err != nil {
+			return fmt.Errorf("refusing to run %s/%s@%s: %w", namespace, name, resolved.Version, err)
+		}
 		jobContent = content
 	} else {
 		return fmt.Errorf("job file not found: %s", jobRef)
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"rmbl/internal/signing"
+
+	"github.com/spf13/cobra"
+)
+
+var keysOut string
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage release signing keys",
+}
+
+var keysGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Create an ed25519 key pair for signing releases",
+	Long: `Create an ed25519 key pair for signing releases.
+
+The private key is written to --out, readable only by you. The public key
+is printed: register it under Settings → Signing Keys for your user or
+organization, then sign releases with 'ramble publish --sign-key'.
+
+Examples:
+  ramble keys generate --out ~/.ramble/release.key`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := os.Stat(keysOut); err == nil {
+			return fmt.Errorf("%s already exists", keysOut)
+		}
+
+		pub, priv, err := signing.GenerateKey()
+		if err != nil {
+			return err
+		}
+		data, err := signing.EncodePrivateKey(priv)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(keysOut), 0700); err != nil {
+			return fmt.Errorf("failed to create key directory: %w", err)
+		}
+		if err := os.WriteFile(keysOut, data, 0600); err != nil {
+			return fmt.Errorf("failed to write private key: %w", err)
+		}
+
+		fmt.Printf("Private key written to %s\n", keysOut)
+		fmt.Printf("Fingerprint: %s\n\n", signing.Fingerprint(pub))
+		fmt.Println("Public key:")
+		fmt.Println(signing.FormatPublicKey(pub))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keysCmd)
+	keysCmd.AddCommand(keysGenerateCmd)
+
+	keysGenerateCmd.Flags().StringVarP(&keysOut, "out", "o", "ramble-signing.key", "Where to write the private key")
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"rmbl/internal/pack"
+)
+
+// searchResources lists packs or jobs page by page through the registry's
+// search API. Registries without it are listed with legacy instead.
+func searchResources(client *pack.Client, opts pack.SearchOptions, max int, legacy func() ([]pack.SearchResult, error)) ([]pack.SearchResult, error) {
+	if opts.Limit == 0 {
+		opts.Limit = 100 // Fewer round trips on large registries
+	}
+	results, err := client.SearchAll(opts, max)
+	if !errors.Is(err, pack.ErrNotFound) {
+		return results, err
+	}
+
+	if opts.Tag != "" || opts.Sort != "" {
+		return nil, fmt.Errorf("this registry does not support --tag or --sort")
+	}
+	results, err = legacy()
+	if max > 0 && len(results) > max {
+		results = results[:max]
+	}
+	return results, err
+}
+
+// printResources prints search results as a table, or as JSON
+func printResources(results []pack.SearchResult, asJSON bool, kind string) error {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	if len(results) == 0 {
+		fmt.Printf("No %ss found\n", kind)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tDESCRIPTION")
+	for _, r := range results {
+		desc := r.Description
+		if len(desc) > 50 {
+			desc = desc[:47] + "..."
+		}
+		fullName := r.Name
+		if r.Namespace != "" {
+			fullName = r.Namespace + "/" + r.Name
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", fullName, r.LatestVersion, desc)
+	}
+	return w.Flush()
+}
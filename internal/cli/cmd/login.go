@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"rmbl/internal/cli/config"
+	"rmbl/internal/pack"
+
+	"github.com/spf13/cobra"
+)
+
+var loginToken string
+
+var loginCmd = &cobra.Command{
+	Use:   "login [registry]",
+	Short: "Save an API token for a registry",
+	Long: `Verify an API token and save it for a registry.
+
+Create a token in the web interface under Settings -> API Tokens. The
+registry can be the name of a configured registry or its URL. An unknown
+URL is added as a new registry named after its hostname. Without an
+argument, the default registry is used.
+
+The token is read from --token, then the RAMBLE_TOKEN environment
+variable, then standard input.
+
+Examples:
+  ramble login
+  ramble login myteam
+  ramble login https://ramble.internal.company.com
+  echo "$TOKEN" | ramble login ramble`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runLogin,
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout [registry]",
+	Short: "Remove the saved API token for a registry",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runLogout,
+}
+
+func init() {
+	rootCmd.AddCommand(loginCmd)
+	rootCmd.AddCommand(logoutCmd)
+
+	loginCmd.Flags().StringVarP(&loginToken, "token", "t", "", "API token (prefer RAMBLE_TOKEN or stdin to keep it out of shell history)")
+}
+
+// resolveRegistry finds a configured registry by name or URL. When create is
+// true, an unknown URL is added to the config under its hostname.
+func resolveRegistry(cfg *config.Config, ref string, create bool) (string, *config.Registry, error) {
+	if ref == "" {
+		ref = cfg.DefaultRegistry
+	}
+	if _, ok := cfg.Registries[ref]; ok {
+		reg, err := cfg.GetRegistry(ref)
+		return ref, reg, err
+	}
+
+	if !strings.Contains(ref, ".") && !strings.Contains(ref, "://") {
+		return "", nil, fmt.Errorf("registry not found: %s", ref)
+	}
+
+	// Treat anything that looks like a host as a URL
+	regURL := ref
+	if !strings.HasPrefix(regURL, "http://") && !strings.HasPrefix(regURL, "https://") {
+		regURL = "https://" + regURL
+	}
+	regURL = strings.TrimSuffix(regURL, "/")
+
+	if name, ok := cfg.FindRegistryByURL(regURL); ok {
+		reg, err := cfg.GetRegistry(name)
+		return name, reg, err
+	}
+	if !create {
+		return "", nil, fmt.Errorf("registry not found: %s", ref)
+	}
+
+	parsed, err := url.Parse(regURL)
+	if err != nil || parsed.Host == "" {
+		return "", nil, fmt.Errorf("invalid registry URL: %s", ref)
+	}
+	name := parsed.Hostname()
+	cfg.AddRegistry(name, regURL, "")
+	if cfg.DefaultRegistry == "" {
+		cfg.DefaultRegistry = name
+	}
+	reg, err := cfg.GetRegistry(name)
+	return name, reg, err
+}
+
+// readToken returns the token from the flag, environment or standard input
+func readToken() (string, error) {
+	if loginToken != "" {
+		return loginToken, nil
+	}
+	if env := os.Getenv("RAMBLE_TOKEN"); env != "" {
+		return env, nil
+	}
+
+	fmt.Fprint(os.Stderr, "Paste your API token: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	token := strings.TrimSpace(line)
+	if token == "" {
+		if err != nil {
+			return "", fmt.Errorf("failed to read token: %w", err)
+		}
+		return "", fmt.Errorf("no token provided")
+	}
+	return token, nil
+}
+
+func runLogin(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	ref := ""
+	if len(args) > 0 {
+		ref = args[0]
+	}
+	name, reg, err := resolveRegistry(cfg, ref, true)
+	if err != nil {
+		return err
+	}
+
+	token, err := readToken()
+	if err != nil {
+		return err
+	}
+
+	client := pack.NewClient(reg.URL)
+	client.Token = token
+	user, err := client.WhoAmI()
+	if err != nil {
+		return fmt.Errorf("token rejected by %s: %w", reg.URL, err)
+	}
+
+	if err := cfg.SetToken(name, token); err != nil {
+		return err
+	}
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+
+	if user.TokenOrganization != "" {
+		fmt.Printf("Logged in to '%s' as %s (organization token for %s)\n", name, user.Username, user.TokenOrganization)
+	} else {
+		fmt.Printf("Logged in to '%s' as %s\n", name, user.Username)
+	}
+	return nil
+}
+
+func runLogout(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	ref := ""
+	if len(args) > 0 {
+		ref = args[0]
+	}
+	name, reg, err := resolveRegistry(cfg, ref, false)
+	if err != nil {
+		return err
+	}
+
+	if reg.Token == "" {
+		fmt.Printf("Not logged in to '%s'\n", name)
+		return nil
+	}
+
+	if err := cfg.SetToken(name, ""); err != nil {
+		return err
+	}
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Logged out of '%s'. The token is still valid until you revoke it in the web interface.\n", name)
+	return nil
+}
@@ -1,6 +1,11 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+
+	"rmbl/internal/pack"
+
 	"github.com/spf13/cobra"
 )
 
@@ -19,3 +24,33 @@ Examples:
 func init() {
 	rootCmd.AddCommand(packCmd)
 }
+
+// printWarnings tells the user on stderr that a resource is deprecated or the
+// version they are about to use has been yanked
+func printWarnings(ref string, deprecation *pack.Deprecation, version pack.PackVersion) {
+	if deprecation != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", deprecation.Warning(ref))
+	}
+	if w := version.YankWarning(); w != "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+}
+
+// printVersionHistory lists versions with their commit and yank status
+func printVersionHistory(versions []pack.PackVersion) {
+	fmt.Println("Version History:")
+	for _, v := range versions {
+		line := "  - " + v.Version
+		if len(v.CommitSHA) >= 7 {
+			line += " (" + v.CommitSHA[:7] + ")"
+		}
+		if v.Yanked {
+			line += " [yanked"
+			if v.YankReason != "" {
+				line += ": " + v.YankReason
+			}
+			line += "]"
+		}
+		fmt.Println(line)
+	}
+}
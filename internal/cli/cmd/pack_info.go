@@ -1,13 +1,9 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"strings"
 
 	"rmbl/internal/cli/config"
-	"rmbl/internal/pack"
 
 	"github.com/spf13/cobra"
 )
@@ -15,12 +11,16 @@ import (
 var (
 	infoRegistry string
 	infoJSON     bool
+	infoReadme   bool
 )
 
 var packInfoCmd = &cobra.Command{
 	Use:   "info <namespace/pack>",
 	Short: "Get detailed information about a pack",
-	Long: `Display detailed information about a specific pack including version history.
+	Long: `Display detailed information about a specific pack: its owner, license,
+tags, stars and downloads, the version history and the variables of the latest
+version. --readme also prints its README. Name a version
+or constraint with @ to see its variables and README instead of the latest's.
 
 The pack can be specified as:
   - namespace/packname (e.g., user1/mysql)
@@ -29,6 +29,7 @@ The pack can be specified as:
 Examples:
   ramble pack info user1/mysql
   ramble pack info mysql --namespace user1
+  ramble pack info user1/mysql --readme
   ramble pack info user1/mysql --json`,
 	Args: cobra.ExactArgs(1),
 	RunE: runPackInfo,
@@ -39,11 +40,12 @@ func init() {
 
 	packInfoCmd.Flags().StringVarP(&infoRegistry, "registry", "r", "", "Registry URL (uses default if not specified)")
 	packInfoCmd.Flags().BoolVar(&infoJSON, "json", false, "Output as JSON")
+	packInfoCmd.Flags().BoolVar(&infoReadme, "readme", false, "Also print the README of the latest version")
 }
 
 func runPackInfo(cmd *cobra.Command, args []string) error {
 	packRef := args[0]
-	namespace, name := parsePackRef(packRef)
+	namespace, name, version := parsePackReference(packRef)
 
 	if namespace == "" {
 		return fmt.Errorf("namespace required: use namespace/packname format")
@@ -55,42 +57,12 @@ func runPackInfo(cmd *cobra.Command, args []string) error {
 		registryURL = cfg.GetDefaultURL()
 	}
 
-	client := pack.NewClient(registryURL)
+	client := registryClient(registryURL)
 
 	detail, err := client.GetPack(namespace, name)
 	if err != nil {
 		return fmt.Errorf("failed to get pack info: %w", err)
 	}
 
-	if infoJSON {
-		enc := json.NewEncoder(os.Stdout)
-		enc.SetIndent("", "  ")
-		return enc.Encode(detail)
-	}
-
-	fmt.Printf("Name:        %s\n", detail.Name)
-	fmt.Printf("Description: %s\n", detail.Description)
-	fmt.Printf("Versions:    %d\n", len(detail.Versions))
-	fmt.Println()
-
-	if len(detail.Versions) > 0 {
-		fmt.Println("Version History:")
-		for _, v := range detail.Versions {
-			fmt.Printf("  - %s\n", v.Version)
-		}
-	}
-
-	return nil
-}
-
-// parsePackRef parses "namespace/name" or just "name" into components
-func parsePackRef(ref string) (namespace, name string) {
-	// Handle version suffix first (namespace/name@version)
-	ref = strings.Split(ref, "@")[0]
-
-	parts := strings.SplitN(ref, "/", 2)
-	if len(parts) == 2 {
-		return parts[0], parts[1]
-	}
-	return "", parts[0]
+	return printDetail(namespace+"/"+name, version, *detail, infoJSON, infoReadme)
 }
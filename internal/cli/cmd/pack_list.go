@@ -1,10 +1,7 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"text/tabwriter"
 
 	"rmbl/internal/cli/config"
 	"rmbl/internal/pack"
@@ -17,6 +14,9 @@ var (
 	listNamespace string
 	listJSON      bool
 	listSearch    string
+	listTag       string
+	listSort      string
+	listLimit     int
 )
 
 var packListCmd = &cobra.Command{
@@ -24,10 +24,14 @@ var packListCmd = &cobra.Command{
 	Short: "List packs from the registry",
 	Long: `List all available Nomad packs from the registry.
 
+Results are fetched page by page, so large registries can be listed in full.
+Use --limit to stop early.
+
 Examples:
   ramble pack list                          List all packs
   ramble pack list --namespace user1        List packs from a specific namespace
   ramble pack list --search mysql           Search for packs
+  ramble pack list --tag database --sort stars
   ramble pack list --json                   Output as JSON`,
 	RunE: runPackList,
 }
@@ -38,7 +42,10 @@ func init() {
 	packListCmd.Flags().StringVarP(&listRegistry, "registry", "r", "", "Registry URL (uses default if not specified)")
 	packListCmd.Flags().StringVarP(&listNamespace, "namespace", "n", "", "Filter by namespace (user or org)")
 	packListCmd.Flags().BoolVar(&listJSON, "json", false, "Output as JSON")
-	packListCmd.Flags().StringVarP(&listSearch, "search", "s", "", "Search packs by name or description")
+	packListCmd.Flags().StringVarP(&listSearch, "search", "s", "", "Search packs by name, description, README and variables")
+	packListCmd.Flags().StringVar(&listTag, "tag", "", "Filter by tag")
+	packListCmd.Flags().StringVar(&listSort, "sort", "", "Sort by relevance, updated, stars or downloads")
+	packListCmd.Flags().IntVar(&listLimit, "limit", 0, "Show at most this many packs (0 for all)")
 }
 
 func runPackList(cmd *cobra.Command, args []string) error {
@@ -48,46 +55,28 @@ func runPackList(cmd *cobra.Command, args []string) error {
 		registryURL = cfg.GetDefaultURL()
 	}
 
-	client := pack.NewClient(registryURL)
-
-	var packs []pack.PackSummary
-	var err error
-
-	if listSearch != "" {
-		packs, err = client.SearchPacks(listSearch)
-	} else if listNamespace != "" {
-		packs, err = client.ListPacks(listNamespace)
-	} else {
-		packs, err = client.ListAllPacks()
-	}
-
+	client := registryClient(registryURL)
+
+	opts := pack.SearchOptions{Query: listSearch, Type: "pack", Tag: listTag, Namespace: listNamespace, Sort: listSort}
+	packs, err := searchResources(client, opts, listLimit, func() ([]pack.SearchResult, error) {
+		var summaries []pack.PackSummary
+		var err error
+		if listSearch != "" {
+			summaries, err = client.SearchPacks(listSearch)
+		} else if listNamespace != "" {
+			summaries, err = client.ListPacks(listNamespace)
+		} else {
+			summaries, err = client.ListAllPacks()
+		}
+		results := make([]pack.SearchResult, len(summaries))
+		for i, p := range summaries {
+			results[i] = pack.SearchResult{Name: p.Name, Namespace: p.Namespace, Type: "pack", Description: p.Description}
+		}
+		return results, err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to list packs: %w", err)
 	}
 
-	if listJSON {
-		enc := json.NewEncoder(os.Stdout)
-		enc.SetIndent("", "  ")
-		return enc.Encode(packs)
-	}
-
-	if len(packs) == 0 {
-		fmt.Println("No packs found")
-		return nil
-	}
-
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "NAME\tDESCRIPTION")
-	for _, p := range packs {
-		desc := p.Description
-		if len(desc) > 50 {
-			desc = desc[:47] + "..."
-		}
-		fullName := p.Name
-		if p.Namespace != "" {
-			fullName = p.Namespace + "/" + p.Name
-		}
-		fmt.Fprintf(w, "%s\t%s\n", fullName, desc)
-	}
-	return w.Flush()
+	return printResources(packs, listJSON, "pack")
 }
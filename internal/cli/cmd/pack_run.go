@@ -20,6 +20,7 @@ var (
 	runDryRun   bool
 	runOutput   string
 	runNomadAddr string
+	runRequireSignature bool
 )
 
 var packRunCmd = &cobra.Command{
@@ -29,14 +30,27 @@ var packRunCmd = &cobra.Command{
 
 The pack can be specified as:
   - namespace/packname[@version] (from registry)
+  - namespace/packname@<constraint>, e.g. @~1.4 or @">=2.0,<3"
   - Local path (if starts with ./ or /)
 
+Without a version the latest release is used; pre-releases are only
+picked when named or when the constraint mentions one.
+
+Signed versions are checked against the keys pinned with 'ramble trust',
+and refused if the signature does not match. Without pinned keys they are
+checked against the namespace's keys in the registry, with a warning, as
+the registry could serve a key of its own. With --require-signature, or a
+registry trust policy that requires signatures, versions must be signed
+with a pinned key.
+
 Examples:
   ramble pack run user1/mysql
   ramble pack run user1/mysql@v1.0.0
+  ramble pack run user1/mysql@~1.4
   ramble pack run user1/mysql --var count=3 --var db_name=mydb
   ramble pack run ./my-local-pack
-  ramble pack run user1/mysql --dry-run`,
+  ramble pack run user1/mysql --dry-run
+  ramble pack run user1/mysql --require-signature`,
 	Args: cobra.ExactArgs(1),
 	RunE: runPackRun,
 }
@@ -50,6 +64,7 @@ func init() {
 	packRunCmd.Flags().BoolVar(&runDryRun, "dry-run", false, "Render and print without submitting to Nomad")
 	packRunCmd.Flags().StringVarP(&runOutput, "output", "o", "", "Write rendered job to file instead of submitting")
 	packRunCmd.Flags().StringVar(&runNomadAddr, "nomad-addr", "", "Nomad address (overrides NOMAD_ADDR)")
+	packRunCmd.Flags().BoolVar(&runRequireSignature, "require-signature", false, "Refuse versions that are not signed with a key pinned with 'ramble trust'")
 }
 
 func runPackRun(cmd *cobra.Command, args []string) error {
@@ -82,51 +97,67 @@ func runPackRun(cmd *cobra.Command, args []string) error {
 		}
 
 		cache := pack.NewCache()
-		client := pack.NewClient(registryURL)
+		client := registryClient(registryURL)
 
-		// Check if version specified
-		if version == "" {
-			// Get latest version from registry
-			detail, err := client.GetPack(namespace, name)
-			if err != nil {
-				return fmt.Errorf("failed to get pack info: %w", err)
+		detail, detailErr := client.GetPack(namespace, name)
+		requireSig := signatureRequired(client, runRequireSignature)
+
+		// An exact version that is already cached also runs offline
+		if version != "" && cache.IsCached(registryURL, namespace, name, version) {
+			if detailErr != nil && requireSig {
+				return fmt.Errorf("cannot verify the signature of %s/%s@%s: %w", namespace, name, version, detailErr)
 			}
-			if len(detail.Versions) == 0 {
-				return fmt.Errorf("no versions available for pack: %s/%s", namespace, name)
+			if detailErr == nil {
+				pinned, _ := pack.ResolveVersion(detail.Versions, version)
+				printWarnings(namespace+"/"+name, detail.Deprecation, pinned)
+				if err := verifyVersionSignature(client, namespace, name, pinned, requireSig); err != nil {
+					return err
+				}
+				if err := checkCachedDigest(cache, registryURL, namespace, name, pinned); err != nil {
+					return err
+				}
 			}
-			version = detail.Versions[0].Version
-			fmt.Printf("Using latest version: %s\n", version)
-		}
-
-		// Check cache
-		if cache.IsCached(registryURL, namespace, name, version) {
 			packPath, _ = cache.Load(registryURL, namespace, name, version)
 			fmt.Printf("Using cached pack: %s\n", packPath)
 		} else {
-			// Get pack detail for download URL
-			detail, err := client.GetPack(namespace, name)
-			if err != nil {
-				return fmt.Errorf("failed to get pack info: %w", err)
+			if detailErr != nil {
+				return fmt.Errorf("failed to get pack info: %w", detailErr)
 			}
 
-			// Find version
-			var downloadURL string
-			for _, v := range detail.Versions {
-				if v.Version == version {
-					downloadURL = v.URL
-					break
-				}
+			// Resolve the version or constraint against the registry's versions
+			resolved, err := pack.ResolveVersion(detail.Versions, version)
+			if err != nil {
+				return fmt.Errorf("%s/%s: %w", namespace, name, err)
+			}
+			printWarnings(namespace+"/"+name, detail.Deprecation, resolved)
+			if version == "" {
+				fmt.Printf("Using latest version: %s\n", resolved.Version)
+			} else if version != resolved.Version {
+				fmt.Printf("Resolved %s to %s\n", version, resolved.Version)
 			}
-			if downloadURL == "" {
-				return fmt.Errorf("version not found: %s", version)
+			version = resolved.Version
+			if err := verifyVersionSignature(client, namespace, name, resolved, requireSig); err != nil {
+				return err
 			}
 
-			fmt.Printf("Downloading pack %s/%s@%s...\n", namespace, name, version)
-			packPath, err = cache.Store(registryURL, namespace, name, version, downloadURL)
-			if err != nil {
-				return fmt.Errorf("failed to download pack: %w", err)
+			if cache.IsCached(registryURL, namespace, name, version) {
+				if err := checkCachedDigest(cache, registryURL, namespace, name, resolved); err != nil {
+					return err
+				}
+				packPath, _ = cache.Load(registryURL, namespace, name, version)
+				fmt.Printf("Using cached pack: %s\n", packPath)
+			} else {
+				fmt.Printf("Downloading pack %s/%s@%s...\n", namespace, name, version)
+				data, err := client.Download(resolved.URL)
+				if err != nil {
+					return fmt.Errorf("failed to download pack: %w", err)
+				}
+				packPath, err = cache.StoreArchive(registryURL, namespace, name, version, data, resolved.Digest)
+				if err != nil {
+					return fmt.Errorf("failed to download pack: %w", err)
+				}
+				fmt.Printf("Cached to: %s\n", packPath)
 			}
-			fmt.Printf("Cached to: %s\n", packPath)
 		}
 	}
 
@@ -205,7 +236,9 @@ func runPackRun(cmd *cobra.Command, args []string) error {
 	return nomad.SubmitJob(result, runNomadAddr)
 }
 
-// parsePackReference parses "namespace/name@version" into components
+// parsePackReference parses "namespace/name@version" into components. The
+// version may also be a constraint such as "~1.4" or ">=2.0,<3", which is
+// resolved against the registry's version list by pack.ResolveVersion.
 func parsePackReference(ref string) (namespace, name, version string) {
 	// Handle version suffix
 	if idx := strings.Index(ref, "@"); idx != -1 {
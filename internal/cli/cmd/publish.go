@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"rmbl/internal/pack"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	publishRegistry    string
+	publishNamespace   string
+	publishVersion     string
+	publishRepoURL     string
+	publishType        string
+	publishFile        string
+	publishDescription string
+	publishTags        string
+	publishDryRun      bool
+	publishSignKey     string
+)
+
+var publishCmd = &cobra.Command{
+	Use:   "publish [path]",
+	Short: "Publish a pack or job to the registry",
+	Long: `Publish a release of a pack or job to the registry.
+
+For packs, the name, description and version come from metadata.hcl and
+variables.hcl is checked locally before anything is sent. If the resource
+does not exist yet it is created, otherwise the version is added to it.
+The registry fetches the content itself from the git repository, so push
+your tag before publishing.
+
+The version is taken from --version, then a git tag pointing at HEAD,
+then the version in metadata.hcl. The repository URL defaults to the
+"origin" remote.
+
+With --sign-key, publish waits for the registry to ingest the version,
+checks the content against its digest and signs the digest with the given
+private key (see 'ramble keys generate' and 'ramble sign').
+
+Requires an API token with the resources:write and versions:write
+scopes. Run 'ramble login' first or set RAMBLE_TOKEN.
+
+Examples:
+  ramble publish
+  ramble publish ./packs/mysql --version v1.2.0
+  ramble publish --namespace myorg
+  ramble publish --type job --file app.nomad.hcl
+  ramble publish --sign-key ~/.ramble/release.key
+  ramble publish --dry-run`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runPublish,
+}
+
+func init() {
+	rootCmd.AddCommand(publishCmd)
+
+	publishCmd.Flags().StringVarP(&publishRegistry, "registry", "r", "", "Registry name or URL (uses default if not specified)")
+	publishCmd.Flags().StringVarP(&publishNamespace, "namespace", "n", "", "Namespace to publish to (defaults to your user, or the token's organization)")
+	publishCmd.Flags().StringVarP(&publishVersion, "version", "v", "", "Version to publish (defaults to the git tag at HEAD)")
+	publishCmd.Flags().StringVar(&publishRepoURL, "repo-url", "", "Git repository URL (defaults to the origin remote)")
+	publishCmd.Flags().StringVar(&publishType, "type", "", "Resource type: pack or job (detected from metadata.hcl)")
+	publishCmd.Flags().StringVarP(&publishFile, "file", "f", "", "Job file to publish (jobs only)")
+	publishCmd.Flags().StringVar(&publishDescription, "description", "", "Description (overrides metadata.hcl)")
+	publishCmd.Flags().StringVar(&publishTags, "tags", "", "Comma-separated tags for a new resource")
+	publishCmd.Flags().BoolVar(&publishDryRun, "dry-run", false, "Show what would be published without changing the registry")
+	publishCmd.Flags().StringVar(&publishSignKey, "sign-key", "", "Private key file to sign the release with")
+}
+
+func runPublish(cmd *cobra.Command, args []string) error {
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("invalid path: %w", err)
+	}
+
+	resType := publishType
+	if resType == "" {
+		resType = "job"
+		if _, err := os.Stat(filepath.Join(dir, "metadata.hcl")); err == nil {
+			resType = "pack"
+		}
+	}
+
+	var name, description, metaVersion, filePath string
+	var localContent []byte
+	switch resType {
+	case "pack":
+		meta, err := loadPackMetadata(dir)
+		if err != nil {
+			return fmt.Errorf("failed to read metadata.hcl: %w", err)
+		}
+		if meta.Name == "" {
+			return fmt.Errorf("metadata.hcl does not set a pack name")
+		}
+		name, description, metaVersion = meta.Name, meta.Description, meta.Version
+
+		varsPath := filepath.Join(dir, "variables.hcl")
+		if _, err := os.Stat(varsPath); err == nil {
+			vars, err := pack.ParseVariablesFile(varsPath)
+			if err != nil {
+				return fmt.Errorf("invalid variables.hcl: %w", err)
+			}
+			fmt.Printf("Found %d variables in variables.hcl\n", len(vars))
+		}
+		filePath = strings.TrimSuffix(gitPrefix(dir), "/")
+	case "job":
+		jobFile, err := findJobFile(dir)
+		if err != nil {
+			return err
+		}
+		name = strings.TrimSuffix(strings.TrimSuffix(filepath.Base(jobFile), ".hcl"), ".nomad")
+		if localContent, err = os.ReadFile(filepath.Join(dir, jobFile)); err != nil {
+			return fmt.Errorf("failed to read job file: %w", err)
+		}
+		filePath = gitPrefix(dir) + jobFile
+	default:
+		return fmt.Errorf("invalid type %q: must be pack or job", resType)
+	}
+	if publishDescription != "" {
+		description = publishDescription
+	}
+
+	version := publishVersion
+	if version == "" {
+		version, _ = gitOutput(dir, "describe", "--tags", "--exact-match", "HEAD")
+	}
+	if version == "" {
+		version = metaVersion
+	}
+	if version == "" {
+		return fmt.Errorf("could not determine the version: tag HEAD or pass --version")
+	}
+
+	repoURL := publishRepoURL
+	if repoURL == "" {
+		remote, err := gitOutput(dir, "remote", "get-url", "origin")
+		if err == nil {
+			repoURL = normalizeRepoURL(remote)
+		}
+		if repoURL == "" {
+			return fmt.Errorf("could not determine the repository URL: pass --repo-url")
+		}
+	}
+
+	var signKey ed25519.PrivateKey
+	if publishSignKey != "" {
+		if signKey, err = readPrivateKey(publishSignKey); err != nil {
+			return err
+		}
+	}
+
+	client, regName, err := authenticatedClient(publishRegistry)
+	if err != nil {
+		return err
+	}
+	user, err := client.WhoAmI()
+	if err != nil {
+		return fmt.Errorf("failed to authenticate with %s: %w", client.BaseURL, err)
+	}
+
+	namespace := publishNamespace
+	if namespace == "" {
+		namespace = user.Username
+		if user.TokenOrganization != "" {
+			namespace = user.TokenOrganization
+		}
+	}
+	owner := "user"
+	if !strings.EqualFold(namespace, user.Username) {
+		owner = "org:" + namespace
+	}
+
+	// Find out whether the resource already exists
+	var existingID uint
+	var existingVersions []pack.PackVersion
+	if resType == "pack" {
+		detail, err := client.GetPack(namespace, name)
+		if err == nil {
+			existingID, existingVersions = detail.ID, detail.Versions
+		} else if !errors.Is(err, pack.ErrNotFound) {
+			return fmt.Errorf("failed to look up %s/%s: %w", namespace, name, err)
+		}
+	} else {
+		detail, err := client.GetJob(namespace, name)
+		if err == nil {
+			existingID, existingVersions = detail.ID, detail.Versions
+		} else if !errors.Is(err, pack.ErrNotFound) {
+			return fmt.Errorf("failed to look up %s/%s: %w", namespace, name, err)
+		}
+	}
+	for _, v := range existingVersions {
+		if v.Version == version {
+			return fmt.Errorf("%s/%s@%s is already published", namespace, name, version)
+		}
+	}
+
+	fmt.Printf("Publishing %s %s/%s@%s\n", resType, namespace, name, version)
+	fmt.Printf("  Registry:   %s (%s)\n", regName, client.BaseURL)
+	fmt.Printf("  Repository: %s\n", repoURL)
+	if filePath != "" {
+		fmt.Printf("  Path:       %s\n", filePath)
+	}
+	if publishDryRun {
+		if existingID == 0 {
+			fmt.Println("Dry run: would create a new resource")
+		} else {
+			fmt.Println("Dry run: would add a new version")
+		}
+		return nil
+	}
+
+	if existingID == 0 {
+		var tags []string
+		for _, t := range strings.Split(publishTags, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+		res, err := client.CreateResource(pack.NewResource{
+			Name:          name,
+			Type:          resType,
+			Owner:         owner,
+			Description:   description,
+			RepositoryURL: repoURL,
+			FilePath:      filePath,
+			Version:       version,
+			Tags:          tags,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", name, err)
+		}
+		fmt.Printf("Created %s\n", res.URL)
+	} else {
+		if _, err := client.AddVersion(existingID, version); err != nil {
+			return fmt.Errorf("failed to publish version %s: %w", version, err)
+		}
+		fmt.Printf("Published %s/%s@%s\n", namespace, name, version)
+	}
+
+	if signKey == nil {
+		return nil
+	}
+	return signRelease(client, namespace, name, version, signKey, localContent)
+}
+
+// findJobFile returns the job file to publish, relative to dir
+func findJobFile(dir string) (string, error) {
+	if publishFile != "" {
+		if _, err := os.Stat(filepath.Join(dir, publishFile)); err != nil {
+			return "", fmt.Errorf("job file not found: %s", publishFile)
+		}
+		return filepath.ToSlash(publishFile), nil
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.nomad.hcl"))
+	if len(matches) == 0 {
+		matches, _ = filepath.Glob(filepath.Join(dir, "*.nomad"))
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no metadata.hcl or *.nomad.hcl found in %s", dir)
+	case 1:
+		return filepath.Base(matches[0]), nil
+	default:
+		return "", fmt.Errorf("found %d job files in %s: choose one with --file", len(matches), dir)
+	}
+}
+
+// gitOutput runs a git command in dir and returns its trimmed output
+func gitOutput(dir string, args ...string) (string, error) {
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitPrefix returns the path of dir relative to the repository root, with a
+// trailing slash, or "" at the root or outside a repository
+func gitPrefix(dir string) string {
+	prefix, _ := gitOutput(dir, "rev-parse", "--show-prefix")
+	return prefix
+}
+
+// normalizeRepoURL converts a git remote (SSH or HTTPS) into the HTTPS
+// repository URL the registry expects. The URL is shown publicly, so any
+// credentials in the remote, such as a CI job token, are dropped. It returns
+// "" if the remote can't be parsed.
+func normalizeRepoURL(remote string) string {
+	remote = strings.TrimSpace(remote)
+	if !strings.Contains(remote, "://") {
+		// git@github.com:owner/repo.git
+		remote = "ssh://" + strings.Replace(remote, ":", "/", 1)
+	}
+	u, err := url.Parse(remote)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		// The port of an ssh:// remote is the SSH port, not an HTTPS one
+		u.Scheme, u.Host = "https", u.Hostname()
+	}
+	u.User = nil
+	u.RawQuery, u.Fragment = "", ""
+	return strings.TrimSuffix(strings.TrimSuffix(u.String(), "/"), ".git")
+}
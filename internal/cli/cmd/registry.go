@@ -7,7 +7,6 @@ import (
 	"text/tabwriter"
 
 	"rmbl/internal/cli/config"
-	"rmbl/internal/pack"
 
 	"github.com/spf13/cobra"
 )
@@ -155,7 +154,7 @@ func runRegistryList(cmd *cobra.Command, args []string) error {
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "NAME\tURL\tNAMESPACE\tDEFAULT")
+	fmt.Fprintln(w, "NAME\tURL\tNAMESPACE\tDEFAULT\tLOGGED IN")
 	for name, reg := range cfg.Registries {
 		isDefault := ""
 		if name == cfg.DefaultRegistry {
@@ -165,7 +164,11 @@ func runRegistryList(cmd *cobra.Command, args []string) error {
 		if namespace == "" {
 			namespace = "(all)"
 		}
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, reg.URL, namespace, isDefault)
+		loggedIn := ""
+		if reg.Token != "" {
+			loggedIn = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, reg.URL, namespace, isDefault, loggedIn)
 	}
 	return w.Flush()
 }
@@ -232,7 +235,7 @@ func runRegistryBrowse(cmd *cobra.Command, args []string) error {
 	}
 
 	registryURL := cfg.GetDefaultURL()
-	client := pack.NewClient(registryURL)
+	client := registryClient(registryURL)
 
 	var namespaces []string
 	if registryBrowseSearch != "" {
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"rmbl/internal/cli/update"
+	"rmbl/internal/pack"
 
 	"github.com/spf13/cobra"
 )
@@ -33,6 +34,7 @@ Examples:
   ramble registry browse --search go  Search namespaces
   ramble pack run user/mysql          Run a pack`,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		pack.UserAgent = "ramble-cli/" + Version
 		// Start update check in background (skip for version command, it does its own check)
 		if cmd.Name() != "version" && cmd.Name() != "help" {
 			updateCheck = update.CheckForUpdateAsync(Version)
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"time"
+
+	"rmbl/internal/cli/config"
+	"rmbl/internal/pack"
+	"rmbl/internal/signing"
+
+	"github.com/spf13/cobra"
+)
+
+// signWaitTimeout is how long signing waits for the registry to ingest a
+// version and record its digest
+const signWaitTimeout = 5 * time.Minute
+
+var (
+	signRegistry string
+	signKeyFile  string
+)
+
+var signCmd = &cobra.Command{
+	Use:   "sign <namespace/name@version>",
+	Short: "Sign a published version",
+	Long: `Sign a version that is already published, with a private key whose public
+key is registered under Settings → Signing Keys for the namespace.
+
+The content is downloaded and checked against the digest the registry
+recorded for the version, then a signature over that digest is attached.
+'ramble publish --sign-key' does the same straight after publishing.
+
+Requires an API token with the versions:write scope.
+
+Examples:
+  ramble sign myorg/mysql@v1.2.0 --key ~/.ramble/release.key`,
+	Args: cobra.ExactArgs(1),
+	RunE: runSign,
+}
+
+func init() {
+	rootCmd.AddCommand(signCmd)
+
+	signCmd.Flags().StringVarP(&signRegistry, "registry", "r", "", "Registry name or URL (uses default if not specified)")
+	signCmd.Flags().StringVarP(&signKeyFile, "key", "k", "", "Private key file (from 'ramble keys generate')")
+	signCmd.MarkFlagRequired("key")
+}
+
+func runSign(cmd *cobra.Command, args []string) error {
+	namespace, name, version := parsePackReference(args[0])
+	if namespace == "" || version == "" {
+		return fmt.Errorf("use namespace/name@version")
+	}
+
+	priv, err := readPrivateKey(signKeyFile)
+	if err != nil {
+		return err
+	}
+	client, _, err := authenticatedClient(signRegistry)
+	if err != nil {
+		return err
+	}
+	return signRelease(client, namespace, name, version, priv, nil)
+}
+
+// authenticatedClient returns a client for a registry using its stored
+// token, or RAMBLE_TOKEN, along with the registry's name
+func authenticatedClient(registry string) (*pack.Client, string, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, "", err
+	}
+	regName, reg, err := resolveRegistry(cfg, registry, false)
+	if err != nil {
+		return nil, "", err
+	}
+	token := os.Getenv("RAMBLE_TOKEN")
+	if token == "" {
+		token = reg.Token
+	}
+	if token == "" {
+		return nil, "", fmt.Errorf("not logged in to '%s': run 'ramble login %s' or set RAMBLE_TOKEN", regName, regName)
+	}
+
+	client := pack.NewClient(reg.URL)
+	client.Token = token
+	return client, regName, nil
+}
+
+// registryClient returns a client for reading from a registry URL. If it is
+// a configured registry, its stored token or RAMBLE_TOKEN is sent along so
+// internal and private resources can be seen; logging in is not required.
+func registryClient(registryURL string) *pack.Client {
+	client := pack.NewClient(registryURL)
+	cfg, err := config.Load()
+	if err != nil {
+		return client
+	}
+	_, reg, err := resolveRegistry(cfg, registryURL, false)
+	if err != nil {
+		return client
+	}
+	client.Token = os.Getenv("RAMBLE_TOKEN")
+	if client.Token == "" {
+		client.Token = reg.Token
+	}
+	return client
+}
+
+// readPrivateKey loads a PEM private key written by 'ramble keys generate'
+func readPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	priv, err := signing.DecodePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key %s: %w", path, err)
+	}
+	return priv, nil
+}
+
+// signRelease waits for a version to be ingested, checks the registry's
+// content against its digest and attaches a signature over the digest. For
+// jobs, local is the file that was published and must match the registry's
+// copy exactly; nil skips that check.
+func signRelease(client *pack.Client, namespace, name, version string, priv ed25519.PrivateKey, local []byte) error {
+	ref := fmt.Sprintf("%s/%s@%s", namespace, name, version)
+
+	var detail *pack.PackDetail
+	var v pack.PackVersion
+	deadline := time.Now().Add(signWaitTimeout)
+	for {
+		var err error
+		detail, err = client.GetPack(namespace, name)
+		if err != nil {
+			return fmt.Errorf("failed to look up %s/%s: %w", namespace, name, err)
+		}
+		found := false
+		for _, candidate := range detail.Versions {
+			if candidate.Version == version {
+				v, found = candidate, true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s is not published", ref)
+		}
+		if v.IngestState == "failed" {
+			return fmt.Errorf("%s failed to ingest: %s", ref, v.IngestError)
+		}
+		if v.Digest != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for the registry to ingest %s: sign it later with 'ramble sign %s'", ref, ref)
+		}
+		fmt.Fprintf(os.Stderr, "Waiting for %s to be ingested...\n", ref)
+		time.Sleep(3 * time.Second)
+	}
+
+	// Only sign content we have seen match the digest
+	switch detail.Type {
+	case "pack":
+		data, err := client.Download(v.URL)
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %w", ref, err)
+		}
+		if err := pack.VerifyDigest(data, v.Digest); err != nil {
+			return fmt.Errorf("refusing to sign %s: %w", ref, err)
+		}
+	case "job":
+		content, err := client.GetRawContent(namespace, name, version)
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s: %w", ref, err)
+		}
+		if err := pack.VerifyDigest([]byte(content), v.Digest); err != nil {
+			return fmt.Errorf("refusing to sign %s: %w", ref, err)
+		}
+		if local != nil && !bytes.Equal(local, []byte(content)) {
+			return fmt.Errorf("refusing to sign %s: the registry's copy differs from the local job file", ref)
+		}
+	default:
+		return fmt.Errorf("registry does not support signing (unknown resource type %q)", detail.Type)
+	}
+
+	pub := priv.Public().(ed25519.PublicKey)
+	fingerprint := signing.Fingerprint(pub)
+	if err := client.SignVersion(detail.ID, version, signing.Sign(priv, v.Digest), fingerprint); err != nil {
+		return fmt.Errorf("failed to sign %s: %w", ref, err)
+	}
+	fmt.Printf("Signed %s (%s) with key %s\n", ref, v.Digest, fingerprint)
+	return nil
+}
@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"rmbl/internal/cli/config"
+	"rmbl/internal/pack"
+	"rmbl/internal/signing"
+
+	"github.com/spf13/cobra"
+)
+
+var trustRegistry string
+
+var trustCmd = &cobra.Command{
+	Use:   "trust",
+	Short: "Manage which signing keys are trusted",
+	Long: `Commands for managing a registry's trust policy.
+
+Publishers sign releases with ed25519 keys registered in the registry.
+Before a pack or job is run, a signed version is checked against the keys
+pinned here for its namespace. Without pinned keys it is checked against
+the keys the namespace publishes in the same registry, with a warning: that
+only catches accidents, since a compromised registry can serve its own key
+with its own signature. When signatures are required, the key must be
+pinned.
+
+Examples:
+  ramble trust add myorg ed25519:3q2+7w...
+  ramble trust add myorg ./myorg-release.pub
+  ramble trust require on
+  ramble trust list`,
+}
+
+var trustAddCmd = &cobra.Command{
+	Use:   "add <namespace> <public-key|file>",
+	Short: "Pin a public key for a namespace",
+	Long: `Pin a public key for a namespace. Versions from that namespace must then be
+signed with a pinned key. Use "*" as the namespace to trust a key for every
+namespace. The key is given as ed25519:<base64>, a PEM public key, or a file
+holding either.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pub, err := readPublicKey(args[1])
+		if err != nil {
+			return err
+		}
+		return updateTrust(func(cfg *config.Config, name string) error {
+			if err := cfg.AddTrustedKey(name, args[0], signing.FormatPublicKey(pub)); err != nil {
+				return err
+			}
+			fmt.Printf("Trusting %s for %s on '%s'\n", signing.Fingerprint(pub), args[0], name)
+			return nil
+		})
+	},
+}
+
+var trustRemoveCmd = &cobra.Command{
+	Use:   "remove <namespace> <public-key|file>",
+	Short: "Unpin a public key",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pub, err := readPublicKey(args[1])
+		if err != nil {
+			return err
+		}
+		return updateTrust(func(cfg *config.Config, name string) error {
+			if err := cfg.RemoveTrustedKey(name, args[0], signing.FormatPublicKey(pub)); err != nil {
+				return err
+			}
+			fmt.Printf("No longer trusting %s for %s on '%s'\n", signing.Fingerprint(pub), args[0], name)
+			return nil
+		})
+	},
+}
+
+var trustRequireCmd = &cobra.Command{
+	Use:       "require <on|off>",
+	Short:     "Require signed versions from a registry",
+	Long:      `Refuse to run versions from the registry unless they are signed with a key pinned with 'ramble trust add', as if --require-signature were always passed.`,
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"on", "off"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		require := args[0] == "on"
+		return updateTrust(func(cfg *config.Config, name string) error {
+			if err := cfg.SetRequireSignature(name, require); err != nil {
+				return err
+			}
+			if require {
+				fmt.Printf("Signatures are now required on '%s'\n", name)
+			} else {
+				fmt.Printf("Signatures are no longer required on '%s'\n", name)
+			}
+			return nil
+		})
+	},
+}
+
+var trustListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Show the trust policy of a registry",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		name, reg, err := resolveRegistry(cfg, trustRegistry, false)
+		if err != nil {
+			return err
+		}
+
+		policy := reg.Trust
+		if policy == nil {
+			policy = &config.TrustPolicy{}
+		}
+		fmt.Printf("Registry:           %s (%s)\n", name, reg.URL)
+		fmt.Printf("Require signatures: %t\n", policy.RequireSignature)
+		if len(policy.Keys) == 0 {
+			fmt.Println("No pinned keys; signatures are checked against the registry's own keys, and versions are refused if signatures are required")
+			return nil
+		}
+
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAMESPACE\tFINGERPRINT")
+		for _, k := range policy.Keys {
+			fingerprint := "(invalid key)"
+			if pub, err := signing.ParsePublicKey(k.PublicKey); err == nil {
+				fingerprint = signing.Fingerprint(pub)
+			}
+			fmt.Fprintf(w, "%s\t%s\n", k.Namespace, fingerprint)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(trustCmd)
+	trustCmd.AddCommand(trustAddCmd)
+	trustCmd.AddCommand(trustRemoveCmd)
+	trustCmd.AddCommand(trustRequireCmd)
+	trustCmd.AddCommand(trustListCmd)
+
+	trustCmd.PersistentFlags().StringVarP(&trustRegistry, "registry", "r", "", "Registry name or URL (uses default if not specified)")
+}
+
+// updateTrust applies a change to the selected registry's trust policy and
+// saves the config
+func updateTrust(change func(cfg *config.Config, name string) error) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	name, _, err := resolveRegistry(cfg, trustRegistry, false)
+	if err != nil {
+		return err
+	}
+	if err := change(cfg, name); err != nil {
+		return err
+	}
+	return cfg.Save()
+}
+
+// readPublicKey parses a public key given inline or as a file path
+func readPublicKey(arg string) (ed25519.PublicKey, error) {
+	text := arg
+	if data, err := os.ReadFile(arg); err == nil {
+		text = string(data)
+	}
+	return signing.ParsePublicKey(text)
+}
+
+// signatureRequired reports whether unsigned versions from the client's
+// registry must be refused, by flag or by the registry's trust policy
+func signatureRequired(client *pack.Client, flag bool) bool {
+	if flag {
+		return true
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return false
+	}
+	policy := cfg.TrustPolicyFor(client.BaseURL)
+	return policy != nil && policy.RequireSignature
+}
+
+// verifyVersionSignature checks a version's signature before it is run.
+// Keys pinned for the namespace make a signature required. A required
+// signature must verify against a pinned key: the registry's own keys come
+// from the same place as the signature, so they can't prove who published
+// the version. Without a requirement they are still checked, with a
+// warning. A signature that does not verify is always refused.
+func verifyVersionSignature(client *pack.Client, namespace, name string, v pack.PackVersion, required bool) error {
+	ref := fmt.Sprintf("%s/%s@%s", namespace, name, v.Version)
+
+	var keys []string
+	if cfg, err := config.Load(); err == nil {
+		keys = cfg.TrustPolicyFor(client.BaseURL).KeysFor(namespace)
+	}
+	if v.Signature == "" {
+		if required || len(keys) > 0 {
+			return fmt.Errorf("%s is not signed, and a signature is required", ref)
+		}
+		return nil
+	}
+	if len(keys) == 0 {
+		if required {
+			return fmt.Errorf("refusing to use %s: no key is pinned for %s, and a signature is required (pin the publisher's key with 'ramble trust add %s <key>')", ref, namespace, namespace)
+		}
+		registryKeys, err := client.GetSigningKeys(namespace)
+		if err != nil {
+			return nil
+		}
+		keys = pack.ActiveKeys(registryKeys)
+		fmt.Fprintf(os.Stderr, "Warning: no key is pinned for %s; checking %s against the keys the registry lists, which doesn't protect against a compromised registry. Pin the publisher's key with 'ramble trust add %s <key>'.\n", namespace, ref, namespace)
+	}
+
+	if err := pack.CheckSignature(v, keys); err != nil {
+		return fmt.Errorf("refusing to use %s: %w", ref, err)
+	}
+	fmt.Fprintf(os.Stderr, "Verified signature of %s (key %s)\n", ref, v.SigningKey)
+	return nil
+}
+
+// checkCachedDigest makes sure a cached copy of a signed version is still
+// exactly the content the signature covers
+func checkCachedDigest(cache *pack.Cache, registryURL, namespace, name string, v pack.PackVersion) error {
+	if v.Signature == "" {
+		return nil
+	}
+	digest, err := cache.VerifiedDigest(registryURL, namespace, name, v.Version)
+	if err == nil && digest != v.Digest {
+		err = fmt.Errorf("%w: cached %s, signed %s", pack.ErrDigestMismatch, digest, v.Digest)
+	}
+	if err != nil {
+		return fmt.Errorf("cached copy of %s/%s@%s cannot be trusted: %w (run 'ramble cache clear')", namespace, name, v.Version, err)
+	}
+	return nil
+}
@@ -5,12 +5,44 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // Registry represents a saved registry configuration
 type Registry struct {
-	URL       string `json:"url"`
-	Namespace string `json:"namespace,omitempty"` // Optional: limit to specific namespace
+	URL       string       `json:"url"`
+	Namespace string       `json:"namespace,omitempty"` // Optional: limit to specific namespace
+	Token     string       `json:"token,omitempty"`     // API token saved by `ramble login`
+	Trust     *TrustPolicy `json:"trust,omitempty"`     // Signature requirements for this registry
+}
+
+// TrustPolicy decides which versions from a registry the CLI will run
+type TrustPolicy struct {
+	RequireSignature bool         `json:"require_signature,omitempty"` // Reject unsigned versions
+	Keys             []TrustedKey `json:"keys,omitempty"`
+}
+
+// TrustedKey pins the public key a namespace's releases must be signed with.
+// Namespaces without pinned keys are checked against the keys they publish
+// in the registry.
+type TrustedKey struct {
+	Namespace string `json:"namespace"`  // "*" for every namespace
+	PublicKey string `json:"public_key"` // ed25519:<base64>
+}
+
+// KeysFor returns the public keys pinned for a namespace, including keys
+// pinned for every namespace
+func (p *TrustPolicy) KeysFor(namespace string) []string {
+	if p == nil {
+		return nil
+	}
+	var keys []string
+	for _, k := range p.Keys {
+		if k.Namespace == "*" || strings.EqualFold(k.Namespace, namespace) {
+			keys = append(keys, k.PublicKey)
+		}
+	}
+	return keys
 }
 
 // Config holds CLI configuration
@@ -63,7 +95,7 @@ func (c *Config) Save() error {
 	path := configPath()
 
 	// Create directory if needed
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
 		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
@@ -72,19 +104,111 @@ func (c *Config) Save() error {
 		return fmt.Errorf("failed to serialize config: %w", err)
 	}
 
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	// The config may hold API tokens, so keep it private to the user
+	if err := os.WriteFile(path, data, 0600); err != nil {
 		return fmt.Errorf("failed to write config: %w", err)
 	}
+	if err := os.Chmod(path, 0600); err != nil {
+		return fmt.Errorf("failed to set config permissions: %w", err)
+	}
 
 	return nil
 }
 
 // AddRegistry adds or updates a registry
 func (c *Config) AddRegistry(name, url, namespace string) {
-	c.Registries[name] = Registry{
+	reg := Registry{
 		URL:       url,
 		Namespace: namespace,
 	}
+	// Keep the saved token and trust policy if the registry still points at
+	// the same server
+	if existing, ok := c.Registries[name]; ok && existing.URL == url {
+		reg.Token = existing.Token
+		reg.Trust = existing.Trust
+	}
+	c.Registries[name] = reg
+}
+
+// SetToken stores an API token for a registry
+func (c *Config) SetToken(name, token string) error {
+	reg, ok := c.Registries[name]
+	if !ok {
+		return fmt.Errorf("registry not found: %s", name)
+	}
+	reg.Token = token
+	c.Registries[name] = reg
+	return nil
+}
+
+// TrustPolicyFor returns the trust policy of the registry with the given
+// URL, or nil if it has none
+func (c *Config) TrustPolicyFor(url string) *TrustPolicy {
+	if name, ok := c.FindRegistryByURL(url); ok {
+		return c.Registries[name].Trust
+	}
+	return nil
+}
+
+// AddTrustedKey pins a public key for a namespace of a registry
+func (c *Config) AddTrustedKey(name, namespace, publicKey string) error {
+	reg, ok := c.Registries[name]
+	if !ok {
+		return fmt.Errorf("registry not found: %s", name)
+	}
+	if reg.Trust == nil {
+		reg.Trust = &TrustPolicy{}
+	}
+	for _, k := range reg.Trust.Keys {
+		if k.PublicKey == publicKey && strings.EqualFold(k.Namespace, namespace) {
+			return nil
+		}
+	}
+	reg.Trust.Keys = append(reg.Trust.Keys, TrustedKey{Namespace: namespace, PublicKey: publicKey})
+	c.Registries[name] = reg
+	return nil
+}
+
+// RemoveTrustedKey unpins a public key from a namespace of a registry
+func (c *Config) RemoveTrustedKey(name, namespace, publicKey string) error {
+	reg, ok := c.Registries[name]
+	if !ok {
+		return fmt.Errorf("registry not found: %s", name)
+	}
+	if reg.Trust != nil {
+		for i, k := range reg.Trust.Keys {
+			if k.PublicKey == publicKey && strings.EqualFold(k.Namespace, namespace) {
+				reg.Trust.Keys = append(reg.Trust.Keys[:i], reg.Trust.Keys[i+1:]...)
+				c.Registries[name] = reg
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("key is not trusted for %s", namespace)
+}
+
+// SetRequireSignature sets whether unsigned versions from a registry are rejected
+func (c *Config) SetRequireSignature(name string, require bool) error {
+	reg, ok := c.Registries[name]
+	if !ok {
+		return fmt.Errorf("registry not found: %s", name)
+	}
+	if reg.Trust == nil {
+		reg.Trust = &TrustPolicy{}
+	}
+	reg.Trust.RequireSignature = require
+	c.Registries[name] = reg
+	return nil
+}
+
+// FindRegistryByURL returns the name of the registry with the given URL
+func (c *Config) FindRegistryByURL(url string) (string, bool) {
+	for name, reg := range c.Registries {
+		if reg.URL == url {
+			return name, true
+		}
+	}
+	return "", false
 }
 
 // RemoveRegistry removes a registry
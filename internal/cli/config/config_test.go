@@ -139,3 +139,97 @@ func TestGetDefaultURL(t *testing.T) {
 	cfg2 := &Config{}
 	assert.Equal(t, "https://ramble.openwander.org", cfg2.GetDefaultURL())
 }
+
+func TestSetToken(t *testing.T) {
+	cfg := &Config{
+		Registries: map[string]Registry{
+			"a": {URL: "https://a.com"},
+		},
+	}
+
+	require.NoError(t, cfg.SetToken("a", "rmbl_abc"))
+	assert.Equal(t, "rmbl_abc", cfg.Registries["a"].Token)
+
+	assert.Error(t, cfg.SetToken("nonexistent", "rmbl_abc"))
+}
+
+func TestAddRegistryKeepsToken(t *testing.T) {
+	cfg := &Config{
+		Registries: map[string]Registry{
+			"a": {URL: "https://a.com", Token: "rmbl_abc"},
+		},
+	}
+
+	// Same URL keeps the token
+	cfg.AddRegistry("a", "https://a.com", "team")
+	assert.Equal(t, "rmbl_abc", cfg.Registries["a"].Token)
+
+	// Pointing at a different server drops it
+	cfg.AddRegistry("a", "https://b.com", "")
+	assert.Empty(t, cfg.Registries["a"].Token)
+}
+
+func TestSaveWithTokenIsPrivate(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", tmpDir)
+
+	cfg := &Config{
+		DefaultRegistry: "a",
+		Registries: map[string]Registry{
+			"a": {URL: "https://a.com", Token: "rmbl_abc"},
+		},
+	}
+	require.NoError(t, cfg.Save())
+
+	info, err := os.Stat(filepath.Join(tmpDir, "ramble", "config.json"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	loaded, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, "rmbl_abc", loaded.Registries["a"].Token)
+}
+
+func TestFindRegistryByURL(t *testing.T) {
+	cfg := &Config{
+		Registries: map[string]Registry{
+			"a": {URL: "https://a.com"},
+		},
+	}
+
+	name, ok := cfg.FindRegistryByURL("https://a.com")
+	assert.True(t, ok)
+	assert.Equal(t, "a", name)
+
+	_, ok = cfg.FindRegistryByURL("https://b.com")
+	assert.False(t, ok)
+}
+
+func TestTrustPolicy(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", tmpDir)
+
+	cfg := &Config{Registries: map[string]Registry{"prod": {URL: "https://example.com"}}}
+	assert.Nil(t, cfg.TrustPolicyFor("https://example.com"))
+
+	require.NoError(t, cfg.AddTrustedKey("prod", "myorg", "ed25519:AAAA"))
+	require.NoError(t, cfg.AddTrustedKey("prod", "*", "ed25519:BBBB"))
+	require.NoError(t, cfg.AddTrustedKey("prod", "myorg", "ed25519:AAAA")) // No duplicate
+	require.NoError(t, cfg.SetRequireSignature("prod", true))
+	assert.Error(t, cfg.AddTrustedKey("missing", "myorg", "ed25519:AAAA"))
+	require.NoError(t, cfg.Save())
+
+	loaded, err := Load()
+	require.NoError(t, err)
+	policy := loaded.TrustPolicyFor("https://example.com")
+	require.NotNil(t, policy)
+	assert.True(t, policy.RequireSignature)
+	assert.Equal(t, []string{"ed25519:AAAA", "ed25519:BBBB"}, policy.KeysFor("MyOrg"))
+	assert.Equal(t, []string{"ed25519:BBBB"}, policy.KeysFor("other"))
+
+	// The policy survives re-adding the registry at the same URL
+	loaded.AddRegistry("prod", "https://example.com", "")
+	require.NoError(t, loaded.RemoveTrustedKey("prod", "myorg", "ed25519:AAAA"))
+	assert.Equal(t, []string{"ed25519:BBBB"}, loaded.TrustPolicyFor("https://example.com").KeysFor("myorg"))
+	assert.Error(t, loaded.RemoveTrustedKey("prod", "myorg", "ed25519:AAAA"))
+}
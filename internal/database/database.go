@@ -44,18 +44,71 @@ func Connect() {
 
 	log.Println("Database connection successfully opened")
 
+	// Resources created before signed webhooks keep their ?secret= URLs working
+	backfillQuerySecret := !DB.Migrator().HasColumn(&models.NomadResource{}, "WebhookQuerySecret")
+
+	// Versions ingested before content digests were recorded get one computed
+	backfillDigests := !DB.Migrator().HasColumn(&models.ResourceVersion{}, "Digest")
+
+	// Members from before finer-grained roles keep editing and publishing
+	backfillRoles := !DB.Migrator().HasColumn(&models.Organization{}, "RolePermissions")
+
+	// Deliveries logged before verification failures were kept apart lose
+	// the payload their unverified sender chose
+	backfillRejected := !DB.Migrator().HasColumn(&models.WebhookDelivery{}, "Rejected")
+
+	// Existing resources keep fetching with their publisher's token, bound to
+	// their current repository
+	backfillSource := !DB.Migrator().HasColumn(&models.NomadResource{}, "SourceUserID")
+
 	// Auto Migrate
 	log.Println("Running Migrations...")
 	err = DB.AutoMigrate(
 		&models.User{},
 		&models.Organization{},
 		&models.Membership{},
+		&models.Invitation{},
 		&models.NomadResource{},
 		&models.ResourceVersion{},
 		&models.Tag{},
+		&models.APIToken{},
+		&models.VersionArchive{},
+		&models.IngestJob{},
+		&models.WebhookDelivery{},
+		&models.SigningKey{},
+		&models.DownloadEvent{},
+		&models.DownloadStat{},
+		&models.AuditEvent{},
+		&models.AbuseReport{},
+		&models.RecoveryCode{},
+		&models.SiteSetting{},
+		&models.Session{},
 	)
 	if err != nil {
 		log.Fatal("Migration failed: ", err)
 	}
+	if err := migrateSearch(DB); err != nil {
+		log.Fatal("Migration failed: ", err)
+	}
+	if backfillQuerySecret {
+		DB.Model(&models.NomadResource{}).Where("1 = 1").Update("webhook_query_secret", true)
+	}
+	if backfillDigests {
+		DB.Exec(`UPDATE resource_versions SET digest = 'sha256:' || version_archives.sha256
+			FROM version_archives WHERE version_archives.resource_version_id = resource_versions.id`)
+		DB.Exec(`UPDATE resource_versions SET digest = 'sha256:' || encode(sha256(convert_to(content, 'UTF8')), 'hex')
+			FROM nomad_resources WHERE nomad_resources.id = resource_versions.resource_id
+			AND nomad_resources.type = 'job' AND resource_versions.content <> ''`)
+	}
+	if backfillSource {
+		DB.Exec("UPDATE nomad_resources SET source_user_id = user_id, source_repository_url = repository_url")
+	}
+	if backfillRejected {
+		DB.Model(&models.WebhookDelivery{}).Where("error = ?", "Invalid or missing signature").
+			Updates(map[string]interface{}{"rejected": true, "headers": "", "payload": ""})
+	}
+	if backfillRoles {
+		DB.Model(&models.Membership{}).Where("role = ?", "member").Update("role", models.RoleMaintainer)
+	}
 	log.Println("Migrations completed")
 }
\ No newline at end of file
@@ -0,0 +1,24 @@
+package database
+
+import (
+	"gorm.io/gorm"
+)
+
+// migrateSearch adds the full-text search vector of nomad_resources and the
+// indexes search uses. The vector is not a model field: handlers rebuild it
+// from the resource, its tags and its latest version.
+func migrateSearch(db *gorm.DB) error {
+	statements := []string{
+		`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+		`ALTER TABLE nomad_resources ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+		`CREATE INDEX IF NOT EXISTS idx_nomad_resources_search ON nomad_resources USING GIN (search_vector)`,
+		`CREATE INDEX IF NOT EXISTS idx_nomad_resources_name_trgm ON nomad_resources USING GIN (name gin_trgm_ops)`,
+		`CREATE INDEX IF NOT EXISTS idx_nomad_resources_description_trgm ON nomad_resources USING GIN (description gin_trgm_ops)`,
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
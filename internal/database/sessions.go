@@ -0,0 +1,98 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"rmbl/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SessionStorage is a fiber.Storage that keeps sessions in the sessions
+// table, so they survive restarts and are shared by every instance. Expired
+// sessions are deleted in the background.
+type SessionStorage struct {
+	db   *gorm.DB
+	done chan struct{}
+}
+
+// NewSessionStorage returns a session storage on db that deletes expired
+// sessions every gcInterval until it's closed
+func NewSessionStorage(db *gorm.DB, gcInterval time.Duration) *SessionStorage {
+	s := &SessionStorage{db: db, done: make(chan struct{})}
+	go s.gc(gcInterval)
+	return s
+}
+
+// HashSessionID returns the key a session is stored under
+func HashSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *SessionStorage) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.db.Where("expires_at < ?", time.Now()).Delete(&models.Session{}).Error; err != nil {
+				log.Printf("Could not delete expired sessions: %v", err)
+			}
+		}
+	}
+}
+
+// Get returns the session's data, or nil if it doesn't exist or expired
+func (s *SessionStorage) Get(key string) ([]byte, error) {
+	if key == "" {
+		return nil, nil
+	}
+	var session models.Session
+	err := s.db.Select("data").
+		Where("id = ? AND (expires_at IS NULL OR expires_at > ?)", HashSessionID(key), time.Now()).
+		Limit(1).Find(&session).Error
+	return session.Data, err
+}
+
+// Set stores the session's data. It leaves who the session belongs to and
+// the device it's used from alone.
+func (s *SessionStorage) Set(key string, val []byte, exp time.Duration) error {
+	if key == "" || len(val) == 0 {
+		return nil
+	}
+	session := models.Session{ID: HashSessionID(key), Data: val}
+	if exp > 0 {
+		expiresAt := time.Now().Add(exp)
+		session.ExpiresAt = &expiresAt
+	}
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"data", "expires_at"}),
+	}).Create(&session).Error
+}
+
+// Delete removes the session
+func (s *SessionStorage) Delete(key string) error {
+	if key == "" {
+		return nil
+	}
+	return s.db.Where("id = ?", HashSessionID(key)).Delete(&models.Session{}).Error
+}
+
+// Reset removes every session
+func (s *SessionStorage) Reset() error {
+	return s.db.Where("1 = 1").Delete(&models.Session{}).Error
+}
+
+// Close stops deleting expired sessions
+func (s *SessionStorage) Close() error {
+	close(s.done)
+	return nil
+}
@@ -55,13 +55,29 @@ func SetupTestDB() (*gorm.DB, func()) {
 		&models.User{},
 		&models.Organization{},
 		&models.Membership{},
+		&models.Invitation{},
 		&models.NomadResource{},
 		&models.ResourceVersion{},
 		&models.Tag{},
+		&models.APIToken{},
+		&models.VersionArchive{},
+		&models.IngestJob{},
+		&models.WebhookDelivery{},
+		&models.SigningKey{},
+		&models.DownloadEvent{},
+		&models.DownloadStat{},
+		&models.AuditEvent{},
+		&models.AbuseReport{},
+		&models.RecoveryCode{},
+		&models.SiteSetting{},
+		&models.Session{},
 	)
 	if err != nil {
 		log.Fatalf("Migration failed: %s", err)
 	}
+	if err := migrateSearch(db); err != nil {
+		log.Fatalf("Migration failed: %s", err)
+	}
 
 	DB = db // Set global DB
 
@@ -0,0 +1,192 @@
+package gitprovider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"rmbl/internal/semver"
+	"sort"
+	"strings"
+	"time"
+)
+
+// gitTimeout bounds each git command so an unresponsive host cannot hold a
+// worker indefinitely
+const gitTimeout = 2 * time.Minute
+
+// Git reads any repository reachable with the git command, such as
+// Bitbucket or a plain git server. Every call fetches the ref it needs with
+// a shallow fetch into a temporary directory, so it is slower than the API
+// based providers and should only be used when none of them fit.
+type Git struct {
+	url string
+	// Protocols lists the transports git may use, in GIT_ALLOW_PROTOCOL form
+	Protocols string
+}
+
+// NewGit returns a generic provider for a repository URL
+func NewGit(repoURL string) (*Git, error) {
+	repo, err := parseRepository(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Git{url: repo.BaseURL + "/" + repo.Path, Protocols: "https:http"}, nil
+}
+
+func (g *Git) Kind() string { return KindGit }
+
+// run executes git with a clean, non-interactive environment
+func (g *Git) run(dir string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_ALLOW_PROTOCOL="+g.Protocols,
+		"GIT_CONFIG_NOSYSTEM=1",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if strings.Contains(msg, "couldn't find remote ref") || strings.Contains(msg, "not found") ||
+			strings.Contains(msg, "does not exist") || strings.Contains(msg, "exists on disk, but not in") ||
+			strings.Contains(msg, "Not a valid object name") {
+			return nil, ErrNotFound
+		}
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return out, nil
+}
+
+// fetch shallow-fetches ref into a new temporary repository and returns its
+// directory. The caller must remove it.
+func (g *Git) fetch(ref string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("invalid ref: %s", ref)
+	}
+	dir, err := os.MkdirTemp("", "ramble-git-")
+	if err != nil {
+		return "", err
+	}
+	if _, err := g.run(dir, "init", "-q"); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	if _, err := g.run(dir, "fetch", "-q", "--depth", "1", "--", g.url, ref); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+func (g *Git) Metadata() (*Metadata, error) {
+	out, err := g.run("", "ls-remote", "--symref", "--", g.url, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	meta := &Metadata{Name: path.Base(g.url)}
+	// ref: refs/heads/main	HEAD
+	for _, line := range strings.Split(string(out), "\n") {
+		if rest, ok := strings.CutPrefix(line, "ref: refs/heads/"); ok {
+			meta.DefaultBranch = strings.Fields(rest)[0]
+		}
+	}
+	return meta, nil
+}
+
+func (g *Git) Tags() ([]string, error) {
+	out, err := g.run("", "ls-remote", "--tags", "--refs", "--", g.url)
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			tags = append(tags, strings.TrimPrefix(fields[1], "refs/tags/"))
+		}
+	}
+	// ls-remote has no date order, so put the highest version first. Tags
+	// that aren't semantic versions go last, in name order.
+	sort.Strings(tags)
+	semver.Sort(tags)
+	return tags, nil
+}
+
+func (g *Git) CommitSHA(ref string) (string, error) {
+	dir, err := g.fetch(ref)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	out, err := g.run(dir, "rev-parse", "FETCH_HEAD^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (g *Git) FetchFile(ref, filePath string) ([]byte, error) {
+	dir, err := g.fetch(ref)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	return g.run(dir, "show", "FETCH_HEAD:"+strings.TrimPrefix(filePath, "/"))
+}
+
+func (g *Git) ListTree(ref, treeDir string) ([]Entry, error) {
+	dir, err := g.fetch(ref)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	treeish := "FETCH_HEAD"
+	if treeDir = strings.Trim(treeDir, "/"); treeDir != "" {
+		treeish += ":" + treeDir
+	}
+	out, err := g.run(dir, "ls-tree", treeish)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	// 100644 blob <sha>\tname
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		meta, name, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		entries = append(entries, Entry{Name: name, Path: path.Join(treeDir, name), Dir: len(fields) > 1 && fields[1] == "tree"})
+	}
+	return entries, nil
+}
+
+// ArchiveURL is not supported; use Archive instead
+func (g *Git) ArchiveURL(ref string) (string, error) {
+	return "", ErrUnsupported
+}
+
+// Archive returns a tar.gz of the repository at ref, with a single root
+// directory like the archives served by hosting providers
+func (g *Git) Archive(ref string) ([]byte, error) {
+	dir, err := g.fetch(ref)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	return g.run(dir, "archive", "--format=tar.gz", "--prefix="+path.Base(g.url)+"/", "FETCH_HEAD")
+}
@@ -0,0 +1,141 @@
+package gitprovider
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newLocalRepo creates a repository with a tagged pack commit and a later
+// commit on main, and returns a provider reading it over file://
+func newLocalRepo(t *testing.T) *Git {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := filepath.Join(t.TempDir(), "packs")
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@test.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@test.com")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	write := func(name, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	git("init", "-q", "-b", "main")
+	write("mysql/metadata.hcl", `pack { name = "mysql" }`)
+	write("mysql/templates/mysql.nomad.tpl", "job {}")
+	git("add", ".")
+	git("commit", "-q", "-m", "first")
+	git("tag", "v1.0.0")
+	write("mysql/metadata.hcl", `pack { name = "mysql" version = "2" }`)
+	git("commit", "-q", "-am", "second")
+	git("tag", "v1.1.0")
+	git("tag", "v1.9.0")
+	git("tag", "v1.10.0")
+	git("tag", "weekly")
+
+	return &Git{url: "file://" + dir, Protocols: "file"}
+}
+
+func TestGit(t *testing.T) {
+	g := newLocalRepo(t)
+	assert.Equal(t, KindGit, g.Kind())
+
+	meta, err := g.Metadata()
+	require.NoError(t, err)
+	assert.Equal(t, "packs", meta.Name)
+	assert.Equal(t, "main", meta.DefaultBranch)
+
+	tags, err := g.Tags()
+	require.NoError(t, err)
+	// Highest version first, not in name order
+	assert.Equal(t, []string{"v1.10.0", "v1.9.0", "v1.1.0", "v1.0.0", "weekly"}, tags)
+
+	tagged, err := g.CommitSHA("v1.0.0")
+	require.NoError(t, err)
+	assert.Len(t, tagged, 40)
+	head, err := g.CommitSHA("main")
+	require.NoError(t, err)
+	assert.NotEqual(t, tagged, head)
+
+	body, err := g.FetchFile(tagged, "mysql/metadata.hcl")
+	require.NoError(t, err)
+	assert.Equal(t, `pack { name = "mysql" }`, string(body))
+
+	body, err = g.FetchFile("v1.0.0", "mysql/metadata.hcl")
+	require.NoError(t, err)
+	assert.Equal(t, `pack { name = "mysql" }`, string(body))
+
+	body, err = g.FetchFile("", "mysql/metadata.hcl")
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `version = "2"`)
+
+	_, err = g.FetchFile("v1.0.0", "missing.hcl")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = g.FetchFile("v9.9.9", "mysql/metadata.hcl")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	entries, err := g.ListTree("v1.0.0", "mysql")
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Name: "metadata.hcl", Path: "mysql/metadata.hcl"},
+		{Name: "templates", Path: "mysql/templates", Dir: true},
+	}, entries)
+
+	_, err = g.ArchiveURL("v1.0.0")
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestGit_Archive(t *testing.T) {
+	g := newLocalRepo(t)
+
+	data, err := DownloadArchive(g, "v1.0.0")
+	require.NoError(t, err)
+
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	tr := tar.NewReader(gzr)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if header.Typeflag != tar.TypeXGlobalHeader {
+			names = append(names, header.Name)
+		}
+	}
+	assert.Contains(t, names, "packs/mysql/metadata.hcl")
+	assert.Contains(t, names, "packs/mysql/templates/mysql.nomad.tpl")
+}
+
+func TestGit_ProtocolRestricted(t *testing.T) {
+	g := newLocalRepo(t)
+	g.Protocols = "https"
+
+	_, err := g.Tags()
+	assert.Error(t, err)
+}
+
+func TestGit_RejectsOptionRefs(t *testing.T) {
+	g := newLocalRepo(t)
+
+	_, err := g.FetchFile("--upload-pack=touch /tmp/pwned", "metadata.hcl")
+	assert.ErrorContains(t, err, "invalid ref")
+}
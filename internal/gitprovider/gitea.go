@@ -0,0 +1,120 @@
+package gitprovider
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Gitea reads repositories on Gitea and Forgejo servers, including
+// codeberg.org. Both share the same API.
+type Gitea struct {
+	repo  repository
+	token string
+}
+
+// NewGitea returns a provider for a Gitea or Forgejo repository URL
+func NewGitea(repoURL, token string) (*Gitea, error) {
+	repo, err := parseRepository(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Gitea{repo: repo, token: token}, nil
+}
+
+func (g *Gitea) Kind() string { return KindGitea }
+
+func (g *Gitea) header() http.Header {
+	h := http.Header{}
+	if g.token != "" {
+		h.Set("Authorization", "token "+g.token)
+	}
+	return h
+}
+
+func (g *Gitea) repoAPI() string {
+	return g.repo.BaseURL + "/api/v1/repos/" + escapePath(g.repo.Path)
+}
+
+func withRef(rawURL, ref string) string {
+	if ref == "" {
+		return rawURL
+	}
+	return rawURL + "?ref=" + url.QueryEscape(ref)
+}
+
+func (g *Gitea) Metadata() (*Metadata, error) {
+	var repo struct {
+		Name          string   `json:"name"`
+		Description   string   `json:"description"`
+		Topics        []string `json:"topics"`
+		DefaultBranch string   `json:"default_branch"`
+	}
+	if err := getJSON(g.repoAPI(), g.header(), &repo); err != nil {
+		return nil, err
+	}
+	return &Metadata{Name: repo.Name, Description: repo.Description, Topics: repo.Topics, DefaultBranch: repo.DefaultBranch}, nil
+}
+
+func (g *Gitea) Tags() ([]string, error) {
+	var tags []struct {
+		Name string `json:"name"`
+	}
+	if err := getJSON(g.repoAPI()+"/tags?limit=50", g.header(), &tags); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = t.Name
+	}
+	return names, nil
+}
+
+func (g *Gitea) CommitSHA(ref string) (string, error) {
+	commitsURL := g.repoAPI() + "/commits?limit=1&stat=false"
+	if ref != "" {
+		commitsURL += "&sha=" + url.QueryEscape(ref)
+	}
+	var commits []struct {
+		SHA string `json:"sha"`
+	}
+	if err := getJSON(commitsURL, g.header(), &commits); err != nil {
+		return "", err
+	}
+	if len(commits) == 0 {
+		return "", ErrNotFound
+	}
+	return commits[0].SHA, nil
+}
+
+func (g *Gitea) FetchFile(ref, path string) ([]byte, error) {
+	return get(withRef(g.repoAPI()+"/raw/"+escapePath(path), ref), g.header(), maxResponseBytes)
+}
+
+func (g *Gitea) ListTree(ref, dir string) ([]Entry, error) {
+	var contents []struct {
+		Name string `json:"name"`
+		Path string `json:"path"`
+		Type string `json:"type"`
+	}
+	if err := getJSON(withRef(g.repoAPI()+"/contents/"+escapePath(dir), ref), g.header(), &contents); err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, len(contents))
+	for i, c := range contents {
+		entries[i] = Entry{Name: c.Name, Path: c.Path, Dir: c.Type == "dir"}
+	}
+	return entries, nil
+}
+
+func (g *Gitea) ArchiveURL(ref string) (string, error) {
+	if ref == "" {
+		meta, err := g.Metadata()
+		if err != nil {
+			return "", err
+		}
+		ref = meta.DefaultBranch
+	}
+	// https://codeberg.org/owner/repo/archive/v1.0.0.tar.gz
+	return fmt.Sprintf("%s/%s/archive/%s.tar.gz", g.repo.BaseURL, escapePath(g.repo.Path), escapePath(ref)), nil
+}
@@ -0,0 +1,83 @@
+package gitprovider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newGiteaServer stands in for a Gitea or Forgejo API
+func newGiteaServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/repos/owner/repo", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"name":           "repo",
+			"description":    "Hosted on Forgejo",
+			"topics":         []string{"nomad"},
+			"default_branch": "main",
+		})
+	})
+	mux.HandleFunc("/api/v1/repos/owner/repo/tags", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]string{{"name": "v0.2.0"}, {"name": "v0.1.0"}})
+	})
+	mux.HandleFunc("/api/v1/repos/owner/repo/commits", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sha") != "v0.2.0" {
+			json.NewEncoder(w).Encode([]map[string]string{})
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]string{{"sha": "fedcba9876543210fedcba9876543210fedcba98"}})
+	})
+	mux.HandleFunc("/api/v1/repos/owner/repo/raw/metadata.hcl", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "v0.2.0", r.URL.Query().Get("ref"))
+		w.Write([]byte(`pack { name = "repo" }`))
+	})
+	mux.HandleFunc("/api/v1/repos/owner/repo/contents/", func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.URL.Query().Get("ref"))
+		json.NewEncoder(w).Encode([]map[string]string{
+			{"name": "metadata.hcl", "path": "metadata.hcl", "type": "file"},
+			{"name": "templates", "path": "templates", "type": "dir"},
+		})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestGitea(t *testing.T) {
+	server := newGiteaServer(t)
+	defer server.Close()
+
+	g, err := NewGitea(server.URL+"/owner/repo", "")
+	require.NoError(t, err)
+	assert.Equal(t, KindGitea, g.Kind())
+
+	meta, err := g.Metadata()
+	require.NoError(t, err)
+	assert.Equal(t, &Metadata{Name: "repo", Description: "Hosted on Forgejo", Topics: []string{"nomad"}, DefaultBranch: "main"}, meta)
+
+	tags, err := g.Tags()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v0.2.0", "v0.1.0"}, tags)
+
+	sha, err := g.CommitSHA("v0.2.0")
+	require.NoError(t, err)
+	assert.Equal(t, "fedcba9876543210fedcba9876543210fedcba98", sha)
+
+	_, err = g.CommitSHA("v9.9.9")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	body, err := g.FetchFile("v0.2.0", "metadata.hcl")
+	require.NoError(t, err)
+	assert.Equal(t, `pack { name = "repo" }`, string(body))
+
+	entries, err := g.ListTree("", "")
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{Name: "metadata.hcl", Path: "metadata.hcl"}, {Name: "templates", Path: "templates", Dir: true}}, entries)
+
+	// Without a ref the archive is taken from the default branch
+	archive, err := g.ArchiveURL("")
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/owner/repo/archive/main.tar.gz", archive)
+}
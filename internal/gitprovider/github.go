@@ -0,0 +1,138 @@
+package gitprovider
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GitHub reads repositories on github.com or a GitHub Enterprise server
+type GitHub struct {
+	repo   repository
+	token  string
+	apiURL string
+	rawURL string // raw.githubusercontent.com; empty on Enterprise
+}
+
+// NewGitHub returns a provider for a GitHub repository URL
+func NewGitHub(repoURL, token string) (*GitHub, error) {
+	repo, err := parseRepository(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	g := &GitHub{repo: repo, token: token}
+	if u, _ := url.Parse(repo.BaseURL); u.Hostname() == "github.com" || u.Hostname() == "www.github.com" {
+		g.repo.BaseURL = "https://github.com"
+		g.apiURL = "https://api.github.com"
+		g.rawURL = "https://raw.githubusercontent.com"
+	} else {
+		g.apiURL = repo.BaseURL + "/api/v3"
+	}
+	return g, nil
+}
+
+func (g *GitHub) Kind() string { return KindGitHub }
+
+func (g *GitHub) header() http.Header {
+	h := http.Header{}
+	h.Set("Accept", "application/vnd.github+json")
+	if g.token != "" {
+		h.Set("Authorization", "token "+g.token)
+	}
+	return h
+}
+
+func (g *GitHub) repoAPI() string {
+	return g.apiURL + "/repos/" + escapePath(g.repo.Path)
+}
+
+func (g *GitHub) Metadata() (*Metadata, error) {
+	var repo struct {
+		Name          string   `json:"name"`
+		Description   string   `json:"description"`
+		Topics        []string `json:"topics"`
+		DefaultBranch string   `json:"default_branch"`
+		License       *struct {
+			SpdxID string `json:"spdx_id"`
+		} `json:"license"`
+	}
+	if err := getJSON(g.repoAPI(), g.header(), &repo); err != nil {
+		return nil, err
+	}
+	meta := &Metadata{Name: repo.Name, Description: repo.Description, Topics: repo.Topics, DefaultBranch: repo.DefaultBranch}
+	if repo.License != nil && repo.License.SpdxID != "NOASSERTION" {
+		meta.License = repo.License.SpdxID
+	}
+	return meta, nil
+}
+
+func (g *GitHub) Tags() ([]string, error) {
+	var tags []struct {
+		Name string `json:"name"`
+	}
+	if err := getJSON(g.repoAPI()+"/tags?per_page=100", g.header(), &tags); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = t.Name
+	}
+	return names, nil
+}
+
+func (g *GitHub) CommitSHA(ref string) (string, error) {
+	h := g.header()
+	h.Set("Accept", "application/vnd.github.sha")
+	body, err := get(g.repoAPI()+"/commits/"+escapePath(refParam(ref)), h, maxResponseBytes)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+func (g *GitHub) FetchFile(ref, path string) ([]byte, error) {
+	if g.rawURL != "" && g.token == "" {
+		// raw.githubusercontent.com is not rate limited like the API
+		if ref == "" {
+			ref = "HEAD"
+		}
+		return get(fmt.Sprintf("%s/%s/%s/%s", g.rawURL, escapePath(g.repo.Path), escapePath(ref), escapePath(path)), nil, maxResponseBytes)
+	}
+
+	h := g.header()
+	h.Set("Accept", "application/vnd.github.raw")
+	fileURL := g.repoAPI() + "/contents/" + escapePath(path)
+	if ref != "" {
+		fileURL += "?ref=" + url.QueryEscape(ref)
+	}
+	return get(fileURL, h, maxResponseBytes)
+}
+
+func (g *GitHub) ListTree(ref, dir string) ([]Entry, error) {
+	treeURL := g.repoAPI() + "/contents/" + escapePath(dir)
+	if ref != "" {
+		treeURL += "?ref=" + url.QueryEscape(ref)
+	}
+	var contents []struct {
+		Name string `json:"name"`
+		Path string `json:"path"`
+		Type string `json:"type"`
+	}
+	if err := getJSON(treeURL, g.header(), &contents); err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, len(contents))
+	for i, c := range contents {
+		entries[i] = Entry{Name: c.Name, Path: c.Path, Dir: c.Type == "dir"}
+	}
+	return entries, nil
+}
+
+func (g *GitHub) ArchiveURL(ref string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	// https://github.com/owner/repo/archive/v1.0.0.tar.gz
+	return fmt.Sprintf("%s/%s/archive/%s.tar.gz", g.repo.BaseURL, escapePath(g.repo.Path), escapePath(ref)), nil
+}
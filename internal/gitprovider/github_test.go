@@ -0,0 +1,99 @@
+package gitprovider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newGitHubServer stands in for a GitHub Enterprise API
+func newGitHubServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/owner/repo", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "token secret", r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode(map[string]any{
+			"name":           "repo",
+			"description":    "A pack repo",
+			"topics":         []string{"nomad", "pack"},
+			"default_branch": "main",
+			"license":        map[string]string{"spdx_id": "MIT"},
+		})
+	})
+	mux.HandleFunc("/api/v3/repos/owner/repo/tags", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]string{{"name": "v1.1.0"}, {"name": "v1.0.0"}})
+	})
+	mux.HandleFunc("/api/v3/repos/owner/repo/commits/v1.0.0", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/vnd.github.sha", r.Header.Get("Accept"))
+		w.Write([]byte("0123456789abcdef0123456789abcdef01234567"))
+	})
+	mux.HandleFunc("/api/v3/repos/owner/repo/contents/packs/mysql/metadata.hcl", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/vnd.github.raw", r.Header.Get("Accept"))
+		assert.Equal(t, "v1.0.0", r.URL.Query().Get("ref"))
+		w.Write([]byte(`pack { name = "mysql" }`))
+	})
+	mux.HandleFunc("/api/v3/repos/owner/repo/contents/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/owner/repo/contents/" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]string{
+			{"name": "packs", "path": "packs", "type": "dir"},
+			{"name": "app.nomad.hcl", "path": "app.nomad.hcl", "type": "file"},
+		})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestGitHub(t *testing.T) {
+	server := newGitHubServer(t)
+	defer server.Close()
+
+	g, err := NewGitHub(server.URL+"/owner/repo.git", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, KindGitHub, g.Kind())
+
+	meta, err := g.Metadata()
+	require.NoError(t, err)
+	assert.Equal(t, &Metadata{Name: "repo", Description: "A pack repo", License: "MIT", Topics: []string{"nomad", "pack"}, DefaultBranch: "main"}, meta)
+
+	tags, err := g.Tags()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v1.1.0", "v1.0.0"}, tags)
+
+	sha, err := g.CommitSHA("v1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef0123456789abcdef01234567", sha)
+
+	_, err = g.CommitSHA("v9.9.9")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	body, err := g.FetchFile("v1.0.0", "packs/mysql/metadata.hcl")
+	require.NoError(t, err)
+	assert.Equal(t, `pack { name = "mysql" }`, string(body))
+
+	_, err = g.FetchFile("v1.0.0", "missing.hcl")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	entries, err := g.ListTree("", "")
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{Name: "packs", Path: "packs", Dir: true}, {Name: "app.nomad.hcl", Path: "app.nomad.hcl"}}, entries)
+
+	archive, err := g.ArchiveURL("v1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/owner/repo/archive/v1.0.0.tar.gz", archive)
+}
+
+func TestGitHub_PublicHost(t *testing.T) {
+	g, err := NewGitHub("https://github.com/owner/repo", "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.github.com", g.apiURL)
+	assert.Equal(t, "https://raw.githubusercontent.com", g.rawURL)
+
+	archive, err := g.ArchiveURL("v1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/owner/repo/archive/v1.0.0.tar.gz", archive)
+}
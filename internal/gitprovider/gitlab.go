@@ -0,0 +1,132 @@
+package gitprovider
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// GitLab reads repositories on gitlab.com or any self-hosted GitLab
+type GitLab struct {
+	repo  repository
+	token string
+}
+
+// NewGitLab returns a provider for a GitLab project URL. Nested groups
+// (group/subgroup/project) are supported.
+func NewGitLab(repoURL, token string) (*GitLab, error) {
+	repo, err := parseRepository(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	return &GitLab{repo: repo, token: token}, nil
+}
+
+func (g *GitLab) Kind() string { return KindGitLab }
+
+func (g *GitLab) header() http.Header {
+	h := http.Header{}
+	if g.token != "" {
+		h.Set("Authorization", "Bearer "+g.token)
+	}
+	return h
+}
+
+func (g *GitLab) projectAPI() string {
+	return g.repo.BaseURL + "/api/v4/projects/" + url.PathEscape(g.repo.Path)
+}
+
+// refParam returns ref, or HEAD for the default branch
+func refParam(ref string) string {
+	if ref == "" {
+		return "HEAD"
+	}
+	return ref
+}
+
+func (g *GitLab) Metadata() (*Metadata, error) {
+	var project struct {
+		Name          string   `json:"name"`
+		Description   string   `json:"description"`
+		Topics        []string `json:"topics"`
+		TagList       []string `json:"tag_list"` // Older servers
+		DefaultBranch string   `json:"default_branch"`
+		License       *struct {
+			Key string `json:"key"`
+		} `json:"license"`
+	}
+	if err := getJSON(g.projectAPI()+"?license=true", g.header(), &project); err != nil {
+		return nil, err
+	}
+	meta := &Metadata{Name: project.Name, Description: project.Description, Topics: project.Topics, DefaultBranch: project.DefaultBranch}
+	if len(meta.Topics) == 0 {
+		meta.Topics = project.TagList
+	}
+	if project.License != nil {
+		meta.License = project.License.Key
+	}
+	return meta, nil
+}
+
+func (g *GitLab) Tags() ([]string, error) {
+	var tags []struct {
+		Name string `json:"name"`
+	}
+	if err := getJSON(g.projectAPI()+"/repository/tags?per_page=100", g.header(), &tags); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = t.Name
+	}
+	return names, nil
+}
+
+func (g *GitLab) CommitSHA(ref string) (string, error) {
+	if ref == "" {
+		meta, err := g.Metadata()
+		if err != nil {
+			return "", err
+		}
+		ref = meta.DefaultBranch
+	}
+	var commit struct {
+		ID string `json:"id"`
+	}
+	if err := getJSON(g.projectAPI()+"/repository/commits/"+url.PathEscape(ref), g.header(), &commit); err != nil {
+		return "", err
+	}
+	return commit.ID, nil
+}
+
+func (g *GitLab) FetchFile(ref, path string) ([]byte, error) {
+	fileURL := fmt.Sprintf("%s/repository/files/%s/raw?ref=%s", g.projectAPI(), url.PathEscape(path), url.QueryEscape(refParam(ref)))
+	return get(fileURL, g.header(), maxResponseBytes)
+}
+
+func (g *GitLab) ListTree(ref, dir string) ([]Entry, error) {
+	treeURL := fmt.Sprintf("%s/repository/tree?per_page=100&ref=%s", g.projectAPI(), url.QueryEscape(refParam(ref)))
+	if dir != "" {
+		treeURL += "&path=" + url.QueryEscape(dir)
+	}
+	var tree []struct {
+		Name string `json:"name"`
+		Path string `json:"path"`
+		Type string `json:"type"`
+	}
+	if err := getJSON(treeURL, g.header(), &tree); err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, len(tree))
+	for i, t := range tree {
+		entries[i] = Entry{Name: t.Name, Path: t.Path, Dir: t.Type == "tree"}
+	}
+	return entries, nil
+}
+
+func (g *GitLab) ArchiveURL(ref string) (string, error) {
+	// https://gitlab.com/owner/repo/-/archive/v1.0.0/repo-v1.0.0.tar.gz
+	ref = refParam(ref)
+	return fmt.Sprintf("%s/%s/-/archive/%s/%s-%s.tar.gz", g.repo.BaseURL, escapePath(g.repo.Path),
+		escapePath(ref), url.PathEscape(g.repo.name()), url.PathEscape(ref)), nil
+}
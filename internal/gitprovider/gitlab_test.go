@@ -0,0 +1,82 @@
+package gitprovider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newGitLabServer stands in for a self-hosted GitLab API. The project lives
+// in a nested group to check that the path is encoded as a single segment.
+func newGitLabServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		switch r.URL.EscapedPath() {
+		case "/api/v4/projects/team%2Finfra%2Fpacks":
+			json.NewEncoder(w).Encode(map[string]any{
+				"name":           "packs",
+				"description":    "Infra packs",
+				"tag_list":       []string{"nomad"},
+				"default_branch": "trunk",
+				"license":        map[string]string{"key": "apache-2.0"},
+			})
+		case "/api/v4/projects/team%2Finfra%2Fpacks/repository/tags":
+			json.NewEncoder(w).Encode([]map[string]string{{"name": "v2.0.0"}})
+		case "/api/v4/projects/team%2Finfra%2Fpacks/repository/commits/trunk":
+			json.NewEncoder(w).Encode(map[string]string{"id": "89abcdef0123456789abcdef0123456789abcdef"})
+		case "/api/v4/projects/team%2Finfra%2Fpacks/repository/files/redis%2Fmetadata.hcl/raw":
+			assert.Equal(t, "HEAD", r.URL.Query().Get("ref"))
+			w.Write([]byte(`pack { name = "redis" }`))
+		case "/api/v4/projects/team%2Finfra%2Fpacks/repository/tree":
+			assert.Equal(t, "redis", r.URL.Query().Get("path"))
+			assert.Equal(t, "v2.0.0", r.URL.Query().Get("ref"))
+			json.NewEncoder(w).Encode([]map[string]string{
+				{"name": "templates", "path": "redis/templates", "type": "tree"},
+				{"name": "metadata.hcl", "path": "redis/metadata.hcl", "type": "blob"},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestGitLab(t *testing.T) {
+	server := newGitLabServer(t)
+	defer server.Close()
+
+	g, err := NewGitLab(server.URL+"/team/infra/packs", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, KindGitLab, g.Kind())
+
+	meta, err := g.Metadata()
+	require.NoError(t, err)
+	assert.Equal(t, &Metadata{Name: "packs", Description: "Infra packs", License: "apache-2.0", Topics: []string{"nomad"}, DefaultBranch: "trunk"}, meta)
+
+	tags, err := g.Tags()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v2.0.0"}, tags)
+
+	// The default branch is looked up rather than sent as HEAD
+	sha, err := g.CommitSHA("")
+	require.NoError(t, err)
+	assert.Equal(t, "89abcdef0123456789abcdef0123456789abcdef", sha)
+
+	body, err := g.FetchFile("", "redis/metadata.hcl")
+	require.NoError(t, err)
+	assert.Equal(t, `pack { name = "redis" }`, string(body))
+
+	_, err = g.FetchFile("", "README.md")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	entries, err := g.ListTree("v2.0.0", "redis")
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{Name: "templates", Path: "redis/templates", Dir: true}, {Name: "metadata.hcl", Path: "redis/metadata.hcl"}}, entries)
+
+	archive, err := g.ArchiveURL("v2.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/team/infra/packs/-/archive/v2.0.0/packs-v2.0.0.tar.gz", archive)
+}
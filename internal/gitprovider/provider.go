@@ -0,0 +1,231 @@
+// Package gitprovider reads repository contents from git hosts. Each host
+// has its own Provider implementation; the generic Git provider shells out
+// to git and works with any host that serves the smart HTTP protocol.
+package gitprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Provider kinds, as stored on a resource
+const (
+	KindGitHub = "github"
+	KindGitLab = "gitlab"
+	KindGitea  = "gitea"
+	KindGit    = "git"
+)
+
+// Kind describes a provider that can be selected for a resource
+type Kind struct {
+	Value string
+	Label string
+}
+
+// Kinds lists the selectable providers, in the order shown in forms
+var Kinds = []Kind{
+	{Value: KindGitHub, Label: "GitHub"},
+	{Value: KindGitLab, Label: "GitLab"},
+	{Value: KindGitea, Label: "Gitea / Forgejo"},
+	{Value: KindGit, Label: "Other (git clone)"},
+}
+
+var (
+	// ErrNotFound is returned when a repository, ref or file does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrUnsupported is returned when a provider cannot perform an operation
+	ErrUnsupported = errors.New("not supported by this provider")
+)
+
+// Metadata is the repository information shown when submitting a resource
+type Metadata struct {
+	Name          string
+	Description   string
+	License       string // SPDX identifier when the host knows it
+	Topics        []string
+	DefaultBranch string
+}
+
+// Entry is a file or directory in a repository tree
+type Entry struct {
+	Name string
+	Path string
+	Dir  bool
+}
+
+// Provider reads a single repository. An empty ref means the default branch.
+type Provider interface {
+	// Kind returns the provider kind, e.g. "github"
+	Kind() string
+	// Metadata returns the repository name, description and topics
+	Metadata() (*Metadata, error)
+	// Tags returns the repository's tag names, newest first where the host
+	// reports an order
+	Tags() ([]string, error)
+	// CommitSHA resolves ref (a tag, branch or commit) to a full commit SHA
+	CommitSHA(ref string) (string, error)
+	// FetchFile returns the contents of a file at ref
+	FetchFile(ref, path string) ([]byte, error)
+	// ListTree returns the entries of a directory at ref ("" for the root)
+	ListTree(ref, dir string) ([]Entry, error)
+	// ArchiveURL returns a URL serving a tar.gz of the repository at ref
+	ArchiveURL(ref string) (string, error)
+}
+
+// Archiver is implemented by providers that can produce an archive without
+// a download URL
+type Archiver interface {
+	Archive(ref string) ([]byte, error)
+}
+
+// IsValidKind reports whether kind names a known provider
+func IsValidKind(kind string) bool {
+	for _, k := range Kinds {
+		if k.Value == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect guesses the provider for a repository URL from its host name.
+// Hosts that are not recognised use the generic git provider.
+func Detect(repoURL string) string {
+	u, err := url.Parse(strings.TrimSpace(repoURL))
+	if err != nil {
+		return KindGit
+	}
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "github.com" || host == "www.github.com":
+		return KindGitHub
+	case host == "gitlab.com" || strings.HasPrefix(host, "gitlab."):
+		return KindGitLab
+	case host == "codeberg.org" || host == "gitea.com" || strings.HasPrefix(host, "gitea.") || strings.HasPrefix(host, "forgejo."):
+		return KindGitea
+	}
+	return KindGit
+}
+
+// New returns a provider of the given kind for a repository. An empty kind
+// is detected from the URL. The token, if set, is sent to the host's API.
+func New(kind, repoURL, token string) (Provider, error) {
+	if kind == "" {
+		kind = Detect(repoURL)
+	}
+	switch kind {
+	case KindGitHub:
+		return NewGitHub(repoURL, token)
+	case KindGitLab:
+		return NewGitLab(repoURL, token)
+	case KindGitea:
+		return NewGitea(repoURL, token)
+	case KindGit:
+		return NewGit(repoURL)
+	}
+	return nil, fmt.Errorf("unknown git provider %q", kind)
+}
+
+// DownloadArchive returns a tar.gz of the repository at ref, using the
+// provider's own archiver when it has one and its archive URL otherwise
+func DownloadArchive(p Provider, ref string) ([]byte, error) {
+	if a, ok := p.(Archiver); ok {
+		return a.Archive(ref)
+	}
+	archiveURL, err := p.ArchiveURL(ref)
+	if err != nil {
+		return nil, err
+	}
+	return get(archiveURL, nil, maxArchiveBytes)
+}
+
+// repository is the parsed form of a repository URL
+type repository struct {
+	BaseURL string // scheme and host, e.g. https://gitlab.example.com
+	Path    string // e.g. owner/repo or group/subgroup/repo
+}
+
+func (r repository) name() string {
+	return r.Path[strings.LastIndex(r.Path, "/")+1:]
+}
+
+// parseRepository splits a repository web URL into its host and path
+func parseRepository(repoURL string) (repository, error) {
+	trimmed := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSpace(repoURL), "/"), ".git")
+	u, err := url.Parse(trimmed)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return repository{}, fmt.Errorf("invalid repository URL: %s", repoURL)
+	}
+	path := strings.Trim(u.Path, "/")
+	if strings.Count(path, "/") < 1 {
+		return repository{}, fmt.Errorf("invalid repository URL: %s", repoURL)
+	}
+	return repository{BaseURL: u.Scheme + "://" + u.Host, Path: path}, nil
+}
+
+// escapePath escapes each segment of a slash-separated path
+func escapePath(p string) string {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+const (
+	maxResponseBytes = 10 << 20
+	maxArchiveBytes  = 100 << 20
+)
+
+var httpClient = &http.Client{Timeout: 2 * time.Minute}
+
+// get fetches a URL and returns its body, mapping 404 to ErrNotFound
+func get(rawURL string, header http.Header, limit int64) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", "RMBL-Registry")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, req.URL.Host)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("response from %s is larger than %d bytes", req.URL.Host, limit)
+	}
+	return body, nil
+}
+
+// getJSON fetches a URL and decodes its JSON body into v
+func getJSON(rawURL string, header http.Header, v any) error {
+	body, err := get(rawURL, header, maxResponseBytes)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
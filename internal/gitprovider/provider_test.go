@@ -0,0 +1,85 @@
+package gitprovider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		url  string
+		kind string
+	}{
+		{"https://github.com/owner/repo", KindGitHub},
+		{"https://www.github.com/owner/repo.git", KindGitHub},
+		{"https://gitlab.com/group/sub/repo", KindGitLab},
+		{"https://gitlab.example.com/team/repo", KindGitLab},
+		{"https://codeberg.org/owner/repo", KindGitea},
+		{"https://gitea.example.com/owner/repo", KindGitea},
+		{"https://forgejo.example.com/owner/repo", KindGitea},
+		{"https://bitbucket.org/owner/repo", KindGit},
+		{"https://git.example.com/owner/repo", KindGit},
+		{"https://example.com/github.com/owner/repo", KindGit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			assert.Equal(t, tt.kind, Detect(tt.url))
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	p, err := New("", "https://gitlab.com/group/repo", "")
+	require.NoError(t, err)
+	assert.Equal(t, KindGitLab, p.Kind())
+
+	// An explicit kind overrides detection
+	p, err = New(KindGitea, "https://git.example.com/owner/repo", "")
+	require.NoError(t, err)
+	assert.Equal(t, KindGitea, p.Kind())
+
+	_, err = New("svn", "https://example.com/owner/repo", "")
+	assert.Error(t, err)
+
+	_, err = New(KindGitHub, "not a url", "")
+	assert.Error(t, err)
+}
+
+func TestParseRepository(t *testing.T) {
+	repo, err := parseRepository("https://gitlab.example.com/group/sub/project.git/")
+	require.NoError(t, err)
+	assert.Equal(t, "https://gitlab.example.com", repo.BaseURL)
+	assert.Equal(t, "group/sub/project", repo.Path)
+	assert.Equal(t, "project", repo.name())
+
+	for _, bad := range []string{"", "github.com/owner/repo", "ssh://git@example.com/owner/repo", "https://example.com/repo", "file:///tmp/repo"} {
+		_, err := parseRepository(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestIsValidKind(t *testing.T) {
+	assert.True(t, IsValidKind(KindGit))
+	assert.False(t, IsValidKind(""))
+	assert.False(t, IsValidKind("bitbucket"))
+}
+
+func TestDownloadArchive_UsesArchiveURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/owner/repo/archive/v1.0.0.tar.gz", r.URL.Path)
+		w.Write([]byte("archive"))
+	}))
+	defer server.Close()
+
+	p, err := NewGitea(server.URL+"/owner/repo", "")
+	require.NoError(t, err)
+
+	data, err := DownloadArchive(p, "v1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, "archive", string(data))
+}
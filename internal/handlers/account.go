@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// freshLoginWindow is how recently a user without two-factor authentication
+// must have logged in to delete their account
+const freshLoginWindow = 15 * time.Minute
+
+// loggedInRecently reports whether the session was logged in within
+// freshLoginWindow
+func loggedInRecently(c *fiber.Ctx) bool {
+	sess, err := Store.Get(c)
+	if err != nil {
+		return false
+	}
+	at, _ := sess.Get("logged_in_at").(int64)
+	return time.Since(time.Unix(at, 0)) < freshLoginWindow
+}
+
+// personalResources returns the resources in a user's personal namespace,
+// which go away with the account unless they are transferred
+func personalResources(userID uint) []models.NomadResource {
+	var resources []models.NomadResource
+	database.DB.Where("user_id = ? AND organization_id IS NULL", userID).Order("name").Find(&resources)
+	return resources
+}
+
+// transferTargets returns the organizations a user may move their personal
+// resources to: those whose role lets them publish, as when moving a
+// resource from its edit page
+func transferTargets(userID uint) []models.Organization {
+	var memberships []models.Membership
+	database.DB.Preload("Organization").Where("user_id = ?", userID).Find(&memberships)
+
+	orgs := []models.Organization{}
+	for _, m := range memberships {
+		if rolePermissions(m.Organization)[membershipRole(m)][PermPublish] {
+			orgs = append(orgs, m.Organization)
+		}
+	}
+	return orgs
+}
+
+// lastOwnedOrgs returns the organizations a user is the last owner of. They
+// can't delete their account until someone else owns these, like
+// PostRemoveMember won't remove a last owner.
+func lastOwnedOrgs(userID uint) []models.Organization {
+	var memberships []models.Membership
+	database.DB.Preload("Organization").Where("user_id = ? AND role = ?", userID, models.RoleOwner).Find(&memberships)
+
+	orgs := []models.Organization{}
+	for _, m := range memberships {
+		if isLastOwner(m.OrganizationID) {
+			orgs = append(orgs, m.Organization)
+		}
+	}
+	return orgs
+}
+
+// successorOwner returns an owner of the organization other than userID, who
+// takes over what the user published there
+func successorOwner(tx *gorm.DB, orgID, userID uint) (uint, bool) {
+	var m models.Membership
+	err := tx.Where("organization_id = ? AND role = ? AND user_id <> ?", orgID, models.RoleOwner, userID).Order("id").First(&m).Error
+	return m.UserID, err == nil
+}
+
+// GetAccountSettings renders the account settings page: data export and
+// account deletion
+func GetAccountSettings(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+	var user models.User
+	database.DB.First(&user, userID)
+	return c.Render("settings_account", MergeContext(BaseContext(c), fiber.Map{
+		"Resources":       personalResources(userID),
+		"Organizations":   transferTargets(userID),
+		"LastOwned":       lastOwnedOrgs(userID),
+		"Has2FA":          has2FA(user),
+		"NeedsFreshLogin": !has2FA(user) && !loggedInRecently(c),
+	}), "layouts/main")
+}
+
+// accountExport is the JSON archive of a user's data
+type accountExport struct {
+	ExportedAt  time.Time                 `json:"exported_at"`
+	Profile     accountExportProfile      `json:"profile"`
+	Memberships []accountExportMembership `json:"memberships"`
+	Resources   []accountExportResource   `json:"resources"`
+	Stars       []string                  `json:"stars"`
+	APITokens   []accountExportToken      `json:"api_tokens"`
+	SigningKeys []accountExportKey        `json:"signing_keys"`
+}
+
+type accountExportProfile struct {
+	ID            uint      `json:"id"`
+	Username      string    `json:"username"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	TwoFactor     bool      `json:"two_factor"`
+	AvatarURL     string    `json:"avatar_url,omitempty"`
+	Provider      string    `json:"provider,omitempty"`
+	IsAdmin       bool      `json:"is_admin"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type accountExportMembership struct {
+	Organization string    `json:"organization"`
+	Role         string    `json:"role"`
+	JoinedAt     time.Time `json:"joined_at"`
+}
+
+type accountExportResource struct {
+	ID            uint                   `json:"id"`
+	Namespace     string                 `json:"namespace"`
+	Name          string                 `json:"name"`
+	Type          models.ResourceType    `json:"type"`
+	Description   string                 `json:"description"`
+	Visibility    string                 `json:"visibility"`
+	RepositoryURL string                 `json:"repository_url"`
+	License       string                 `json:"license"`
+	Tags          []string               `json:"tags"`
+	CreatedAt     time.Time              `json:"created_at"`
+	Versions      []accountExportVersion `json:"versions"`
+}
+
+type accountExportVersion struct {
+	Version     string    `json:"version"`
+	Ref         string    `json:"ref,omitempty"`
+	TrackBranch bool      `json:"track_branch"`
+	CommitSHA   string    `json:"commit_sha,omitempty"`
+	Digest      string    `json:"digest,omitempty"`
+	SignedBy    string    `json:"signed_by,omitempty"`
+	Yanked      bool      `json:"yanked"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type accountExportToken struct {
+	Name         string     `json:"name"`
+	Prefix       string     `json:"prefix"`
+	Scopes       []string   `json:"scopes"`
+	Organization string     `json:"organization,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+}
+
+type accountExportKey struct {
+	Name         string     `json:"name"`
+	Fingerprint  string     `json:"fingerprint"`
+	PublicKey    string     `json:"public_key"`
+	Organization string     `json:"organization,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+}
+
+// buildAccountExport collects everything the registry stores about a user.
+// Secrets (password, OAuth and API token hashes) are left out.
+func buildAccountExport(userID uint) (accountExport, error) {
+	var user models.User
+	if err := database.DB.Preload("Memberships.Organization").Preload("Starred.User").Preload("Starred.Organization").First(&user, userID).Error; err != nil {
+		return accountExport{}, err
+	}
+
+	export := accountExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: accountExportProfile{
+			ID: user.ID, Username: user.Username, Name: user.Name, Email: user.Email, EmailVerified: user.EmailVerified, TwoFactor: has2FA(user),
+			AvatarURL: user.AvatarURL, Provider: user.Provider, IsAdmin: user.IsAdmin, CreatedAt: user.CreatedAt,
+		},
+		Memberships: []accountExportMembership{},
+		Resources:   []accountExportResource{},
+		Stars:       []string{},
+		APITokens:   []accountExportToken{},
+		SigningKeys: []accountExportKey{},
+	}
+	for _, m := range user.Memberships {
+		export.Memberships = append(export.Memberships, accountExportMembership{m.Organization.Name, membershipRole(m), m.CreatedAt})
+	}
+	for _, r := range user.Starred {
+		export.Stars = append(export.Stars, getResourceNamespace(r)+"/"+r.Name)
+	}
+
+	var resources []models.NomadResource
+	database.DB.Preload("User").Preload("Organization").Preload("Tags").Preload("Versions").
+		Where("user_id = ?", userID).Order("id").Find(&resources)
+	for _, r := range resources {
+		sortVersions(r.Versions)
+		res := accountExportResource{
+			ID: r.ID, Namespace: getResourceNamespace(r), Name: r.Name, Type: r.Type, Description: r.Description,
+			Visibility: r.Visibility, RepositoryURL: r.RepositoryURL, License: r.License, CreatedAt: r.CreatedAt,
+			Tags: []string{}, Versions: []accountExportVersion{},
+		}
+		for _, t := range r.Tags {
+			res.Tags = append(res.Tags, t.Name)
+		}
+		for _, v := range r.Versions {
+			res.Versions = append(res.Versions, accountExportVersion{
+				Version: v.Version, Ref: v.Ref, TrackBranch: v.TrackBranch, CommitSHA: v.CommitSHA,
+				Digest: v.Digest, SignedBy: v.SignedBy, Yanked: v.Yanked, CreatedAt: v.CreatedAt,
+			})
+		}
+		export.Resources = append(export.Resources, res)
+	}
+
+	var tokens []models.APIToken
+	database.DB.Preload("Organization").Where("user_id = ?", userID).Order("id").Find(&tokens)
+	for _, t := range tokens {
+		export.APITokens = append(export.APITokens, accountExportToken{
+			Name: t.Name, Prefix: t.Prefix, Scopes: strings.Split(t.Scopes, ","), Organization: t.Organization.Name,
+			CreatedAt: t.CreatedAt, ExpiresAt: t.ExpiresAt, LastUsedAt: t.LastUsedAt, RevokedAt: t.RevokedAt,
+		})
+	}
+
+	var keys []models.SigningKey
+	database.DB.Preload("Organization").Where("user_id = ?", userID).Order("id").Find(&keys)
+	for _, k := range keys {
+		export.SigningKeys = append(export.SigningKeys, accountExportKey{
+			Name: k.Name, Fingerprint: k.Fingerprint, PublicKey: k.PublicKey, Organization: k.Organization.Name,
+			CreatedAt: k.CreatedAt, RevokedAt: k.RevokedAt,
+		})
+	}
+	return export, nil
+}
+
+// GetAccountExport downloads a JSON archive of the user's profile,
+// memberships, resources with their versions, stars, tokens and keys
+func GetAccountExport(c *fiber.Ctx) error {
+	export, err := buildAccountExport(currentUserID(c))
+	if err != nil {
+		return c.Status(500).SendString("Failed to export account")
+	}
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return c.Status(500).SendString("Failed to export account")
+	}
+
+	c.Set("Content-Type", fiber.MIMEApplicationJSON)
+	c.Set("Content-Disposition", `attachment; filename="ramble-`+export.Profile.Username+`.json"`)
+	return c.Send(data)
+}
+
+// resourceChoice is what happens to a personal resource when its owner
+// deletes their account: deleted, or moved to an organization
+type resourceChoice struct {
+	Resource models.NomadResource
+	OrgID    *uint // Nil to delete
+}
+
+// PostDeleteAccount deletes the current user's account. Each personal
+// resource needs an explicit choice, resource_<id>=delete or org:<id>;
+// resources they published in organizations pass to another owner.
+// Personal data is scrubbed, personal signing keys are revoked and the
+// account can't log in again. Users with two-factor authentication confirm
+// with a code, others must have logged in within freshLoginWindow.
+func PostDeleteAccount(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return c.Status(404).SendString("User not found")
+	}
+	if c.FormValue("confirm") != user.Username {
+		return c.Status(400).SendString("Type your username to confirm")
+	}
+	if orgs := lastOwnedOrgs(userID); len(orgs) > 0 {
+		return c.Status(400).SendString("You are the last owner of " + orgs[0].Name + ". An organization needs at least one owner: make someone else an owner first.")
+	}
+
+	allowed := map[uint]bool{}
+	for _, o := range transferTargets(userID) {
+		allowed[o.ID] = true
+	}
+	var choices []resourceChoice
+	for _, r := range personalResources(userID) {
+		choice := c.FormValue("resource_" + strconv.FormatUint(uint64(r.ID), 10))
+		switch {
+		case choice == "delete":
+			choices = append(choices, resourceChoice{Resource: r})
+		case strings.HasPrefix(choice, "org:"):
+			id, err := strconv.ParseUint(strings.TrimPrefix(choice, "org:"), 10, 32)
+			if err != nil || !allowed[uint(id)] {
+				return c.Status(403).SendString("Your role does not allow publishing " + r.Name + " in that organization")
+			}
+			orgID := uint(id)
+			var count int64
+			database.DB.Model(&models.NomadResource{}).Where("name = ? AND organization_id = ?", r.Name, orgID).Count(&count)
+			if count > 0 {
+				return c.Status(400).SendString("That organization already has a resource named " + r.Name)
+			}
+			choices = append(choices, resourceChoice{Resource: r, OrgID: &orgID})
+		default:
+			return c.Status(400).SendString("Choose whether to delete or transfer " + r.Name)
+		}
+	}
+
+	// A session left open on a shared computer isn't enough to delete the
+	// account. Checked last so a recovery code isn't used up by a form error.
+	if has2FA(user) {
+		if !checkSecondFactor(&user, c.FormValue("code")) {
+			return c.Status(400).SendString("Invalid code")
+		}
+	} else if !loggedInRecently(c) {
+		return c.Status(403).SendString("Log out and log in again to delete your account")
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, choice := range choices {
+			if choice.OrgID == nil {
+				if err := tx.Delete(&choice.Resource).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			owner, ok := successorOwner(tx, *choice.OrgID, userID)
+			if !ok {
+				return fmt.Errorf("organization %d has no other owner", *choice.OrgID)
+			}
+			if err := tx.Model(&choice.Resource).Updates(map[string]interface{}{"organization_id": *choice.OrgID, "user_id": owner}).Error; err != nil {
+				return err
+			}
+		}
+
+		// What they published in organizations stays there, under another owner
+		var orgResources []models.NomadResource
+		tx.Where("user_id = ? AND organization_id IS NOT NULL", userID).Find(&orgResources)
+		for _, r := range orgResources {
+			owner, ok := successorOwner(tx, *r.OrganizationID, userID)
+			if !ok {
+				return fmt.Errorf("organization %d has no other owner", *r.OrganizationID)
+			}
+			if err := tx.Model(&r).Update("user_id", owner).Error; err != nil {
+				return err
+			}
+		}
+
+		// Stars come off the resources' counts
+		var starred []uint
+		tx.Table("user_stars").Where("user_id = ?", userID).Pluck("nomad_resource_id", &starred)
+		if len(starred) > 0 {
+			tx.Model(&models.NomadResource{}).Where("id IN ?", starred).Update("star_count", gorm.Expr("star_count - 1"))
+			tx.Exec("DELETE FROM user_stars WHERE user_id = ?", userID)
+		}
+
+		tx.Where("user_id = ?", userID).Delete(&models.Membership{})
+		tx.Where("user_id = ?", userID).Delete(&models.APIToken{})
+		tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{})
+		tx.Where("user_id = ?", userID).Delete(&models.Session{})
+		tx.Where("email = ?", user.Email).Delete(&models.Invitation{})
+
+		// Their personal keys stop verifying, so nothing they signed keeps
+		// vouching for a namespace whose name someone else may register.
+		// Organization keys they added belong to the organization.
+		if err := tx.Model(&models.SigningKey{}).
+			Where("user_id = ? AND organization_id IS NULL AND revoked_at IS NULL", userID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		// Free the username and email and drop personal data before the
+		// soft delete, so nothing identifying is left on the row
+		deleted := fmt.Sprintf("deleted-%d", user.ID)
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"username": deleted, "email": deleted + "@deleted.invalid", "name": "", "avatar_url": "",
+			"password_hash": "", "provider": "", "provider_id": "", "access_token": "",
+			"reset_token": "", "verification_token": "", "totp_secret": "", "totp_enabled_at": nil,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	})
+	if err != nil {
+		return c.Status(500).SendString("Failed to delete account")
+	}
+
+	// Nothing identifying goes into the audit log: the events name the
+	// account by its scrubbed username, which Updates wrote back into user,
+	// as the actor and as the namespace of its former resources
+	c.Locals("User", user)
+	for _, choice := range choices {
+		choice.Resource.User = user
+		if choice.OrgID == nil {
+			auditResource(c, AuditResourceDelete, choice.Resource, auditDiff(resourceAuditFields(choice.Resource), nil))
+		} else {
+			auditResource(c, AuditResourceEdit, choice.Resource, map[string]AuditChange{"organization_id": {Before: nil, After: *choice.OrgID}})
+		}
+	}
+	recordAuditBy(c, userID, AuditUserDelete, AuditTargetUser, user.ID, user.Username, nil)
+
+	if sess, err := Store.Get(c); err == nil {
+		sess.Destroy()
+	}
+	SetFlash(c, "success", "Your account has been deleted.")
+	c.Set("HX-Redirect", "/")
+	return c.SendStatus(200)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"rmbl/internal/totp"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func TestGetAccountExport(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	user := createTestUser(t, "exporter")
+	other := createTestUser(t, "exportother")
+	_, orgPack := createTestOrgResource(t, "testexportorg",
+		models.Membership{UserID: user.ID, Role: models.RoleMaintainer},
+		models.Membership{UserID: other.ID, Role: models.RoleOwner})
+	pack := createTestPack(t, user.ID, "exported-pack")
+	starred := createTestPack(t, other.ID, "starred-pack")
+	require.NoError(t, database.DB.Model(&user).Association("Starred").Append(&starred))
+	createTestToken(t, user.ID, nil, ScopeResourcesWrite)
+
+	app := setupAuthenticatedApp(user)
+	app.Get("/settings/account/export", GetAccountExport)
+	resp, err := app.Test(httptest.NewRequest("GET", "/settings/account/export", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "ramble-exporter.json")
+
+	body, _ := io.ReadAll(resp.Body)
+	var export accountExport
+	require.NoError(t, json.Unmarshal(body, &export))
+	assert.Equal(t, "exporter", export.Profile.Username)
+	assert.Equal(t, "exporter@test.com", export.Profile.Email)
+	require.Len(t, export.Memberships, 1)
+	assert.Equal(t, "testexportorg", export.Memberships[0].Organization)
+	assert.Equal(t, models.RoleMaintainer, export.Memberships[0].Role)
+	require.Len(t, export.Resources, 2)
+	assert.Equal(t, "testexportorg", export.Resources[0].Namespace)
+	assert.Equal(t, orgPack.ID, export.Resources[0].ID)
+	assert.Equal(t, "exporter", export.Resources[1].Namespace)
+	assert.Equal(t, pack.ID, export.Resources[1].ID)
+	require.Len(t, export.Resources[1].Versions, 1)
+	assert.Equal(t, "v1.0.0", export.Resources[1].Versions[0].Version)
+	assert.Equal(t, []string{"exportother/starred-pack"}, export.Stars)
+	require.Len(t, export.APITokens, 1)
+	assert.NotContains(t, string(body), "hash")
+}
+
+// freshLogin marks the session of each request to app as just logged in.
+// Call it before registering routes.
+func freshLogin(app *fiber.App) {
+	app.Use(func(c *fiber.Ctx) error {
+		sess, _ := Store.Get(c)
+		sess.Set("logged_in_at", time.Now().Unix())
+		sess.Save()
+		return c.Next()
+	})
+}
+
+func TestPostDeleteAccount(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("CorrectPass123!"), bcrypt.DefaultCost)
+	user := createTestUser(t, "leaver")
+	database.DB.Model(&user).Update("password_hash", string(hash))
+	owner := createTestUser(t, "stayer")
+	org, orgPack := createTestOrgResource(t, "testleaveorg",
+		models.Membership{UserID: user.ID, Role: models.RolePublisher},
+		models.Membership{UserID: owner.ID, Role: models.RoleOwner})
+	kept := createTestPack(t, user.ID, "kept-pack")
+	dropped := createTestPack(t, user.ID, "dropped-pack")
+	starred := createTestPack(t, owner.ID, "liked-pack")
+	require.NoError(t, database.DB.Model(&user).Association("Starred").Append(&starred))
+	database.DB.Model(&starred).Update("star_count", 1)
+	createTestToken(t, user.ID, nil, ScopeResourcesWrite)
+	key := models.SigningKey{Name: "laptop", PublicKey: "ed25519:AAAA", Fingerprint: "SHA256:leaver", UserID: user.ID}
+	require.NoError(t, database.DB.Create(&key).Error)
+
+	path := "/settings/account/delete"
+	keep := "resource_" + toString(kept.ID) + "=org:" + toString(org.ID)
+	drop := "resource_" + toString(dropped.ID) + "=delete"
+
+	// An old session isn't enough
+	app := setupAuthenticatedApp(user)
+	app.Post("/settings/account/delete", PostDeleteAccount)
+	assert.Equal(t, 403, postForm(t, app, path, "confirm=leaver&"+keep+"&"+drop))
+
+	app = setupAuthenticatedApp(user)
+	freshLogin(app)
+	app.Post("/settings/account/delete", PostDeleteAccount)
+	assert.Equal(t, 400, postForm(t, app, path, "confirm=wrong&"+keep+"&"+drop))
+	// Every personal resource needs a choice
+	assert.Equal(t, 400, postForm(t, app, path, "confirm=leaver&"+keep))
+	assert.Equal(t, 200, postForm(t, app, path, "confirm=leaver&"+keep+"&"+drop))
+
+	// The account is gone and scrubbed
+	var stored models.User
+	require.NoError(t, database.DB.Unscoped().First(&stored, user.ID).Error)
+	assert.True(t, stored.DeletedAt.Valid)
+	assert.Equal(t, "deleted-"+toString(user.ID), stored.Username)
+	assert.Empty(t, stored.Name)
+	assert.Empty(t, stored.PasswordHash)
+
+	// Transferred and org resources belong to the org's owner
+	var moved models.NomadResource
+	require.NoError(t, database.DB.First(&moved, kept.ID).Error)
+	require.NotNil(t, moved.OrganizationID)
+	assert.Equal(t, org.ID, *moved.OrganizationID)
+	assert.Equal(t, owner.ID, moved.UserID)
+	require.NoError(t, database.DB.First(&moved, orgPack.ID).Error)
+	assert.Equal(t, owner.ID, moved.UserID)
+	assert.ErrorIs(t, database.DB.First(&models.NomadResource{}, dropped.ID).Error, gorm.ErrRecordNotFound)
+
+	var count int64
+	database.DB.Model(&models.Membership{}).Where("user_id = ?", user.ID).Count(&count)
+	assert.Zero(t, count)
+	database.DB.Model(&models.APIToken{}).Where("user_id = ?", user.ID).Count(&count)
+	assert.Zero(t, count)
+	database.DB.Table("user_stars").Where("user_id = ?", user.ID).Count(&count)
+	assert.Zero(t, count)
+	require.NoError(t, database.DB.First(&starred, starred.ID).Error)
+	assert.Equal(t, 0, starred.StarCount)
+	require.NoError(t, database.DB.First(&key, key.ID).Error)
+	assert.NotNil(t, key.RevokedAt)
+
+	loginApp := setupTestApp()
+	loginApp.Post("/login", PostLogin)
+	assert.NotEqual(t, 200, postForm(t, loginApp, "/login", "email=leaver@test.com&password=CorrectPass123!"))
+
+	// The audit event doesn't keep who they were
+	event, diff := lastAuditEvent(t, AuditUserDelete)
+	assert.Equal(t, user.ID, event.TargetID)
+	assert.Equal(t, "deleted-"+toString(user.ID), event.TargetName)
+	assert.Equal(t, "deleted-"+toString(user.ID), event.ActorName)
+	assert.Empty(t, diff)
+	event, _ = lastAuditEvent(t, AuditResourceDelete)
+	assert.Equal(t, "deleted-"+toString(user.ID)+"/dropped-pack", event.TargetName)
+	assert.Equal(t, "deleted-"+toString(user.ID), event.ActorName)
+}
+
+func TestPostDeleteAccount_TwoFactor(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "twofactorleaver")
+	secret := enable2FA(t, &user)
+
+	// Even right after logging in, the code is required
+	app := setupAuthenticatedApp(user)
+	freshLogin(app)
+	app.Post("/settings/account/delete", PostDeleteAccount)
+	path := "/settings/account/delete"
+	assert.Equal(t, 400, postForm(t, app, path, "confirm=twofactorleaver"))
+	assert.Equal(t, 400, postForm(t, app, path, "confirm=twofactorleaver&code=000000"))
+	require.NoError(t, database.DB.First(&models.User{}, user.ID).Error)
+
+	code, _ := totp.CodeAt(secret, totp.Step(time.Now()))
+	assert.Equal(t, 200, postForm(t, app, path, "confirm=twofactorleaver&code="+code))
+	assert.ErrorIs(t, database.DB.First(&models.User{}, user.ID).Error, gorm.ErrRecordNotFound)
+}
+
+func TestPostDeleteAccount_LastOwner(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	user := createTestUser(t, "soleowner")
+	createTestOrgResource(t, "testsoleorg", models.Membership{UserID: user.ID, Role: models.RoleOwner})
+
+	app := setupAuthenticatedApp(user)
+	app.Post("/settings/account/delete", PostDeleteAccount)
+	assert.Equal(t, 400, postForm(t, app, "/settings/account/delete", "confirm=soleowner"))
+
+	var stored models.User
+	require.NoError(t, database.DB.First(&stored, user.ID).Error)
+	assert.Equal(t, "soleowner", stored.Username)
+}
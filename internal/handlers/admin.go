@@ -3,12 +3,16 @@ package handlers
 import (
 	"rmbl/internal/database"
 	"rmbl/internal/models"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 // RequireAdmin middleware ensures the user is an admin
 func RequireAdmin(c *fiber.Ctx) error {
+	if isTokenRequest(c) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "This endpoint does not accept API tokens"})
+	}
 	userLoc := c.Locals("User")
 	if userLoc == nil {
 		return c.Redirect("/login")
@@ -18,6 +22,10 @@ func RequireAdmin(c *fiber.Ctx) error {
 		SetFlash(c, "error", "Access denied: Administrator privileges required.")
 		return c.Redirect("/")
 	}
+	if adminRequires2FA() && !satisfies2FA(user) {
+		SetFlash(c, "error", "Admins must turn on two-factor authentication to use the admin pages.")
+		return c.Redirect("/settings/security")
+	}
 	return c.Next()
 }
 
@@ -32,6 +40,9 @@ func GetAdminDashboard(c *fiber.Ctx) error {
 	database.DB.Model(&models.NomadResource{}).Count(&resourceCount)
 	database.DB.Table("user_stars").Count(&starCount)
 
+	var openReports int64
+	database.DB.Model(&models.AbuseReport{}).Where("status = ?", models.ReportOpen).Count(&openReports)
+
 	var latestUsers []models.User
 	database.DB.Order("created_at desc").Limit(5).Find(&latestUsers)
 
@@ -43,6 +54,8 @@ func GetAdminDashboard(c *fiber.Ctx) error {
 		"OrgCount":        orgCount,
 		"ResourceCount":   resourceCount,
 		"StarCount":       starCount,
+		"OpenReports":     openReports,
+		"RequireAdmin2FA": adminRequires2FA(),
 		"LatestUsers":     latestUsers,
 		"LatestResources": latestResources,
 		"Page":            "admin",
@@ -87,6 +100,9 @@ func PostToggleAdmin(c *fiber.Ctx) error {
 	// Toggle admin status
 	user.IsAdmin = !user.IsAdmin
 	database.DB.Save(&user)
+	recordAudit(c, AuditUserToggleAdmin, AuditTargetUser, user.ID, user.Username, map[string]AuditChange{
+		"is_admin": {Before: !user.IsAdmin, After: user.IsAdmin},
+	})
 
 	// Return updated user row HTML
 	return c.Render("partials/admin_user_row", fiber.Map{
@@ -112,6 +128,8 @@ func DeleteUser(c *fiber.Ctx) error {
 
 	// Delete user (this will cascade delete resources, memberships, etc. if configured in the model)
 	database.DB.Delete(&user)
+	database.DB.Where("user_id = ?", user.ID).Delete(&models.Session{})
+	recordAudit(c, AuditUserDelete, AuditTargetUser, user.ID, user.Username, auditDiff(userAuditFields(user), nil))
 
 	// Return empty response (HTMX will swap with empty content, removing the row)
 	return c.SendString("")
@@ -152,6 +170,8 @@ func PostEditUser(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid input")
 	}
 
+	before := userAuditFields(user)
+
 	// Update user fields
 	user.Username = input.Username
 	user.Name = input.Name
@@ -161,6 +181,7 @@ func PostEditUser(c *fiber.Ctx) error {
 	if err := database.DB.Save(&user).Error; err != nil {
 		return c.Status(500).SendString("Failed to update user")
 	}
+	recordAudit(c, AuditUserEdit, AuditTargetUser, user.ID, user.Username, auditDiff(before, userAuditFields(user)))
 
 	// Return updated user row
 	return c.Render("partials/admin_user_row", fiber.Map{
@@ -213,6 +234,8 @@ func PostEditOrganization(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Invalid input")
 	}
 
+	before := orgAuditFields(org)
+
 	// Update organization fields
 	org.Name = input.Name
 	org.Description = input.Description
@@ -220,6 +243,7 @@ func PostEditOrganization(c *fiber.Ctx) error {
 	if err := database.DB.Save(&org).Error; err != nil {
 		return c.Status(500).SendString("Failed to update organization")
 	}
+	recordAudit(c, AuditOrgEdit, AuditTargetOrganization, org.ID, org.Name, auditDiff(before, orgAuditFields(org)))
 
 	// Return updated org row
 	return c.Render("partials/admin_org_row", fiber.Map{
@@ -239,7 +263,58 @@ func DeleteOrganization(c *fiber.Ctx) error {
 
 	// Delete organization (cascade deletes resources and memberships)
 	database.DB.Delete(&org)
+	recordAudit(c, AuditOrgDelete, AuditTargetOrganization, org.ID, org.Name, auditDiff(orgAuditFields(org), nil))
 
 	// Return empty response (HTMX will swap with empty content, removing the row)
 	return c.SendString("")
 }
+
+// ingestRow is a version shown on the admin ingestion page
+type ingestRow struct {
+	VersionID    uint
+	Version      string
+	ResourceName string
+	Error        string
+	Attempts     int
+	UpdatedAt    time.Time
+	RunAt        *time.Time
+}
+
+// GetAdminIngestion lists versions whose ingestion failed and the jobs still
+// waiting in the queue
+func GetAdminIngestion(c *fiber.Ctx) error {
+	var failed []ingestRow
+	database.DB.Table("resource_versions").
+		Select("resource_versions.id AS version_id, resource_versions.version, nomad_resources.name AS resource_name, resource_versions.ingest_error AS error, resource_versions.updated_at").
+		Joins("JOIN nomad_resources ON nomad_resources.id = resource_versions.resource_id").
+		Where("resource_versions.ingest_state = ? AND resource_versions.deleted_at IS NULL", models.IngestFailed).
+		Order("resource_versions.updated_at desc").Limit(100).Scan(&failed)
+
+	var queued []ingestRow
+	database.DB.Table("ingest_jobs").
+		Select("resource_versions.id AS version_id, resource_versions.version, nomad_resources.name AS resource_name, ingest_jobs.last_error AS error, ingest_jobs.attempts, ingest_jobs.updated_at, ingest_jobs.run_at").
+		Joins("JOIN resource_versions ON resource_versions.id = ingest_jobs.resource_version_id").
+		Joins("JOIN nomad_resources ON nomad_resources.id = resource_versions.resource_id").
+		Order("ingest_jobs.run_at asc").Limit(100).Scan(&queued)
+
+	return c.Render("admin/ingestion", MergeContext(BaseContext(c), fiber.Map{
+		"Failed":      failed,
+		"Queued":      queued,
+		"MaxAttempts": maxIngestAttempts,
+		"Page":        "admin_ingestion",
+	}), "layouts/main")
+}
+
+// PostRetryIngestion puts a version back on the ingestion queue
+func PostRetryIngestion(c *fiber.Ctx) error {
+	var version models.ResourceVersion
+	if err := database.DB.First(&version, c.Params("id")).Error; err != nil {
+		return c.Status(404).SendString("Version not found")
+	}
+	if err := enqueueIngest(version.ID); err != nil {
+		return c.Status(500).SendString("Could not queue version")
+	}
+	SetFlash(c, "success", "Version "+version.Version+" queued for ingestion.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
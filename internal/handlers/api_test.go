@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"io"
 	"net/http/httptest"
 	"rmbl/internal/database"
 	"rmbl/internal/models"
@@ -65,9 +66,22 @@ func createTestJob(t *testing.T, userID uint, name string) models.NomadResource
 
 // cleanupTestData removes test data created during tests
 func cleanupTestData(t *testing.T) {
+	database.DB.Exec("DELETE FROM audit_events")
+	database.DB.Exec("DELETE FROM abuse_reports")
+	database.DB.Exec("DELETE FROM api_tokens")
+	database.DB.Exec("DELETE FROM recovery_codes")
+	database.DB.Exec("DELETE FROM sessions")
+	database.DB.Exec("DELETE FROM site_settings")
+	database.DB.Exec("DELETE FROM download_events")
+	database.DB.Exec("DELETE FROM download_stats")
+	database.DB.Exec("DELETE FROM signing_keys")
+	database.DB.Exec("DELETE FROM ingest_jobs")
+	database.DB.Exec("DELETE FROM webhook_deliveries")
+	database.DB.Exec("DELETE FROM version_archives")
 	database.DB.Exec("DELETE FROM resource_versions")
 	database.DB.Exec("DELETE FROM nomad_resources")
 	database.DB.Exec("DELETE FROM users WHERE email LIKE '%@test.com'")
+	database.DB.Exec("DELETE FROM users WHERE email LIKE '%@deleted.invalid'")
 }
 
 func TestContentNegotiation_UserProfile_JSON(t *testing.T) {
@@ -382,6 +396,45 @@ func TestGetPackAPI_Success(t *testing.T) {
 	assert.Equal(t, "detail-api-pack", result.Name)
 	assert.Len(t, result.Versions, 1)
 	assert.Equal(t, "v1.0.0", result.Versions[0].Version)
+	assert.Equal(t, "http://example.com/getpackuser/detail-api-pack/v/v1.0.0/archive.tar.gz", result.Versions[0].URL)
+}
+
+func TestGetPackAPI_FullDetail(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "getpackuser3")
+	pack := createTestPack(t, user.ID, "full-detail-pack")
+	database.DB.Model(&pack).Updates(map[string]interface{}{"license": "MIT", "star_count": 2, "download_count": 7})
+	var tag models.Tag
+	database.DB.Where(models.Tag{Name: "database"}).FirstOrCreate(&tag)
+	database.DB.Model(&pack).Association("Tags").Append(&tag)
+	database.DB.Model(&models.ResourceVersion{}).Where("resource_id = ?", pack.ID).
+		Updates(map[string]interface{}{"digest": "sha256:abc", "variables": `[{"name":"port","description":"Port to listen on"}]`})
+
+	app := setupTestApp()
+	app.Get("/:username/v1/packs/:packname", GetPackAPI)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/GETPACKUSER3/v1/packs/full-detail-pack", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var result PackDetail
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, "getpackuser3", result.Namespace)
+	assert.Equal(t, "pack", result.Type)
+	assert.Equal(t, "MIT", result.License)
+	assert.Equal(t, "https://github.com/test/full-detail-pack", result.RepositoryURL)
+	assert.Equal(t, []string{"database"}, result.Tags)
+	assert.Equal(t, 2, result.Stars)
+	assert.Equal(t, 7, result.Downloads)
+	assert.False(t, result.CreatedAt.IsZero())
+
+	require.Len(t, result.Versions, 1)
+	v := result.Versions[0]
+	assert.Equal(t, "# full-detail-pack", v.Readme)
+	assert.Equal(t, []PackVariable{{Name: "port", Description: "Port to listen on"}}, v.Variables)
+	assert.Equal(t, "sha256:abc", v.Digest)
+	assert.False(t, v.CreatedAt.IsZero())
 }
 
 func TestGetPackAPI_NotFound(t *testing.T) {
@@ -542,6 +595,39 @@ func TestFetchReadme_Success(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Equal(t, 200, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "# Test README")
+
+	// Reading the README never queues the version or touches the repository
+	var count int64
+	database.DB.Model(&models.IngestJob{}).Where("resource_version_id = ?", version.ID).Count(&count)
+	assert.Zero(t, count)
+}
+
+func TestPostRefreshVersion(t *testing.T) {
+	defer cleanupTestData(t)
+
+	owner := createTestUser(t, "refreshowner")
+	other := createTestUser(t, "refreshother")
+	pack := createTestPack(t, owner.ID, "refresh-pack")
+	database.DB.Model(&pack).Update("license", "MIT")
+	path := "/resource/" + toString(pack.ID) + "/versions/v1.0.0/refresh"
+
+	app := setupAuthenticatedApp(other)
+	app.Post("/resource/:id/versions/:version/refresh", PostRefreshVersion)
+	assert.Equal(t, 403, postForm(t, app, path, ""))
+
+	app = setupAuthenticatedApp(owner)
+	app.Post("/resource/:id/versions/:version/refresh", PostRefreshVersion)
+	assert.Equal(t, 404, postForm(t, app, "/resource/"+toString(pack.ID)+"/versions/v9.9.9/refresh", ""))
+	assert.Equal(t, 200, postForm(t, app, path, ""))
+
+	var version models.ResourceVersion
+	require.NoError(t, database.DB.Where("resource_id = ? AND version = ?", pack.ID, "v1.0.0").First(&version).Error)
+	assert.Equal(t, models.IngestPending, version.IngestState)
+	var count int64
+	database.DB.Model(&models.IngestJob{}).Where("resource_version_id = ?", version.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
 }
 
 // GetMyRepos tests
@@ -778,6 +864,9 @@ func TestGetJobAPI(t *testing.T) {
 	assert.Contains(t, result.Description, "test job")
 	assert.Len(t, result.Versions, 1)
 	assert.Equal(t, "v2.0.0", result.Versions[0].Version)
+	assert.Equal(t, "jobdetailuser", result.Namespace)
+	assert.Equal(t, "job", result.Type)
+	assert.Equal(t, "# Detail Job", result.Versions[0].Readme)
 }
 
 func TestGetJobAPI_NotFound(t *testing.T) {
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"path"
+	"rmbl/internal/database"
+	"rmbl/internal/gitprovider"
+	"rmbl/internal/models"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm/clause"
+)
+
+// Limits applied when snapshotting an upstream repository archive
+const (
+	maxArchiveSize  = 50 << 20 // uncompressed pack tree
+	maxArchiveFiles = 5000
+)
+
+// archiveModTime is stamped on every entry so identical trees produce
+// byte-identical archives
+var archiveModTime = time.Unix(0, 0).UTC()
+
+// archiveURL returns the absolute URL of a version's registry archive
+func archiveURL(c *fiber.Ctx, namespace, name, version string) string {
+	return fmt.Sprintf("%s/%s/%s/v/%s/archive.tar.gz", GetBaseURL(c),
+		url.PathEscape(namespace), url.PathEscape(name), url.PathEscape(version))
+}
+
+// findResource looks up a resource by namespace (user or organization) and name
+func findResource(namespace, name string) (models.NomadResource, error) {
+	var resource models.NomadResource
+
+	var user models.User
+	if err := database.DB.Where("username ILIKE ?", escapeLikeString(namespace)).First(&user).Error; err == nil {
+		err := database.DB.Where("user_id = ? AND organization_id IS NULL AND name ILIKE ?", user.ID, escapeLikeString(name)).First(&resource).Error
+		return resource, err
+	}
+
+	var org models.Organization
+	if err := database.DB.Where("name ILIKE ?", escapeLikeString(namespace)).First(&org).Error; err != nil {
+		return resource, err
+	}
+	err := database.DB.Where("organization_id = ? AND name ILIKE ?", org.ID, escapeLikeString(name)).First(&resource).Error
+	return resource, err
+}
+
+// ensureVersionArchive returns the stored archive for a pack version, taking
+// the snapshot first if there is none. The snapshot is taken at the version's
+// commit when it is known. An existing archive is never replaced, except for
+// branch-tracking versions whose branch has moved to a new commit. It
+// downloads from the upstream host, so only ingestion calls it; downloads
+// serve what storedVersionArchive finds.
+func ensureVersionArchive(resource models.NomadResource, version models.ResourceVersion) (*models.VersionArchive, error) {
+	var existing models.VersionArchive
+	found := database.DB.Where("resource_version_id = ?", version.ID).First(&existing).Error == nil
+	if found && (!version.TrackBranch || existing.CommitSHA == version.CommitSHA) {
+		return &existing, nil
+	}
+
+	provider, err := gitprovider.New(resource.GitProvider, resource.RepositoryURL, sourceToken(resource))
+	if err != nil {
+		return nil, err
+	}
+	ref := sourceRef(resource, version)
+	sourceURL, err := provider.ArchiveURL(ref)
+	if err != nil {
+		sourceURL = resource.RepositoryURL
+	}
+	body, err := gitprovider.DownloadArchive(provider, ref)
+	if err != nil {
+		return nil, fmt.Errorf("download failed: %w", err)
+	}
+
+	root := resource.Name + "-" + strings.ReplaceAll(version.Version, "/", "-")
+	data, err := buildPackArchive(bytes.NewReader(body), resource.FilePath, root)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	archive := models.VersionArchive{
+		ResourceVersionID: version.ID,
+		Data:              data,
+		Size:              int64(len(data)),
+		SHA256:            hex.EncodeToString(sum[:]),
+		SourceURL:         sourceURL,
+		CommitSHA:         version.CommitSHA,
+	}
+	if found {
+		err := database.DB.Model(&existing).Updates(map[string]interface{}{
+			"data": archive.Data, "size": archive.Size, "sha256": archive.SHA256,
+			"source_url": archive.SourceURL, "commit_sha": archive.CommitSHA,
+		}).Error
+		if err != nil {
+			return nil, fmt.Errorf("could not store archive: %w", err)
+		}
+		archive.Model = existing.Model
+		return &archive, nil
+	}
+	// Another ingestion may have won the race; keep whichever was stored first
+	if err := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&archive).Error; err != nil {
+		return nil, fmt.Errorf("could not store archive: %w", err)
+	}
+	var stored models.VersionArchive
+	if err := database.DB.Where("resource_version_id = ?", version.ID).First(&stored).Error; err != nil {
+		return nil, fmt.Errorf("could not store archive: %w", err)
+	}
+	return &stored, nil
+}
+
+// storedVersionArchive returns the archive stored for a pack version. A
+// branch-tracking version's archive is stale once the branch has moved on,
+// and isn't returned.
+func storedVersionArchive(version models.ResourceVersion) (*models.VersionArchive, bool) {
+	var archive models.VersionArchive
+	if database.DB.Where("resource_version_id = ?", version.ID).First(&archive).Error != nil {
+		return nil, false
+	}
+	if version.TrackBranch && archive.CommitSHA != version.CommitSHA {
+		return nil, false
+	}
+	return &archive, true
+}
+
+// contentDigest returns the digest clients verify downloads against, in the
+// form "sha256:<hex>"
+func contentDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+type archiveEntry struct {
+	name string
+	mode int64
+	dir  bool
+	data []byte
+}
+
+// buildPackArchive repacks an upstream repository tarball into a normalized
+// archive of just the pack tree. The upstream root directory is dropped,
+// only entries under subdir are kept, and everything is placed under root.
+// Symlinks and special files are skipped, and ownership and timestamps are
+// cleared so the output depends only on file names, contents and modes.
+func buildPackArchive(r io.Reader, subdir, root string) ([]byte, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream archive: %w", err)
+	}
+	defer gzr.Close()
+
+	subdir = strings.Trim(path.Clean("/"+subdir), "/")
+
+	var entries []archiveEntry
+	var total int64
+	hasMetadata := false
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream archive: %w", err)
+		}
+
+		// Drop the upstream root directory (e.g. "repo-v1.0.0/")
+		parts := strings.SplitN(header.Name, "/", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		name := strings.Trim(path.Clean("/"+parts[1]), "/")
+		if subdir != "" {
+			if name != subdir && !strings.HasPrefix(name, subdir+"/") {
+				continue
+			}
+			name = strings.TrimPrefix(strings.TrimPrefix(name, subdir), "/")
+		}
+		if name == "" {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			entries = append(entries, archiveEntry{name: name, mode: 0755, dir: true})
+		case tar.TypeReg:
+			total += header.Size
+			if total > maxArchiveSize {
+				return nil, fmt.Errorf("pack is larger than %d bytes", maxArchiveSize)
+			}
+			data, err := io.ReadAll(io.LimitReader(tr, header.Size))
+			if err != nil {
+				return nil, fmt.Errorf("invalid upstream archive: %w", err)
+			}
+			mode := int64(0644)
+			if header.Mode&0111 != 0 {
+				mode = 0755
+			}
+			entries = append(entries, archiveEntry{name: name, mode: mode, data: data})
+			if name == "metadata.hcl" {
+				hasMetadata = true
+			}
+		}
+		if len(entries) > maxArchiveFiles {
+			return nil, fmt.Errorf("pack has more than %d files", maxArchiveFiles)
+		}
+	}
+	if !hasMetadata {
+		if subdir == "" {
+			return nil, fmt.Errorf("metadata.hcl not found at the repository root")
+		}
+		return nil, fmt.Errorf("metadata.hcl not found in %s", subdir)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	write := func(header *tar.Header, data []byte) error {
+		header.ModTime = archiveModTime
+		header.Format = tar.FormatPAX
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := write(&tar.Header{Name: root + "/", Typeflag: tar.TypeDir, Mode: 0755}, nil); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		header := &tar.Header{Name: root + "/" + e.name, Typeflag: tar.TypeReg, Mode: e.mode, Size: int64(len(e.data))}
+		if e.dir {
+			header = &tar.Header{Name: root + "/" + e.name + "/", Typeflag: tar.TypeDir, Mode: e.mode}
+		}
+		if err := write(header, e.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readArchiveFiles returns the contents of the named top-level files in a
+// registry archive. Missing files are left out of the result.
+func readArchiveFiles(data []byte, names ...string) (map[string]string, error) {
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+
+	wanted := make(map[string]bool, len(names))
+	for _, n := range names {
+		wanted[n] = true
+	}
+
+	files := make(map[string]string)
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.SplitN(header.Name, "/", 2)
+		if len(parts) < 2 || !wanted[parts[1]] || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[parts[1]] = string(b)
+	}
+	return files, nil
+}
+
+// GetVersionArchive godoc
+// @Summary Download a pack version archive
+// @Description Serve the registry's immutable tar.gz snapshot of a pack version. A version without a current snapshot, such as one published before snapshots existed, is queued for ingestion and the download fails with 503 until it is ready.
+// @Tags nomad-pack
+// @Produce application/gzip
+// @Param username path string true "User or Organization namespace"
+// @Param resourcename path string true "Pack name"
+// @Param version path string true "Version"
+// @Success 200 {file} binary "tar.gz archive"
+// @Failure 404 {string} string "Not Found"
+// @Failure 409 {string} string "Archive doesn't match the version's digest"
+// @Failure 503 {string} string "Archive is being prepared"
+// @Router /{username}/{resourcename}/v/{version}/archive.tar.gz [get]
+func GetVersionArchive(c *fiber.Ctx) error {
+	resource, err := findResource(c.Params("username"), c.Params("resourcename"))
+	if err != nil || resource.Type != models.ResourceTypePack || !canViewResource(c, resource) {
+		return c.Status(404).SendString("Pack not found")
+	}
+
+	var version models.ResourceVersion
+	if err := database.DB.Where("resource_id = ? AND version = ?", resource.ID, c.Params("version")).First(&version).Error; err != nil {
+		return c.Status(404).SendString("Version not found")
+	}
+
+	// Snapshots are taken by the ingestion queue, never while a client waits.
+	// A version is queued once; failed ingestions stay failed until someone
+	// retries them.
+	archive, ok := storedVersionArchive(version)
+	if !ok {
+		switch version.IngestState {
+		case models.IngestFailed:
+			return c.Status(404).SendString("No archive available for this version")
+		case models.IngestReady, "":
+			if err := enqueueIngest(version.ID); err != nil {
+				return c.Status(404).SendString("No archive available for this version")
+			}
+		}
+		c.Set("Retry-After", "60")
+		return c.Status(503).SendString("This version's archive is being prepared, try again shortly")
+	}
+
+	// The archive must be what the digest, and any signature over it, vouch
+	// for. A mismatch is left for a maintainer to sort out by re-ingesting.
+	if digest := "sha256:" + archive.SHA256; version.Digest != "" && version.Digest != digest {
+		log.Printf("Archive of %s@%s is %s but the version's digest is %s; refusing to serve it", resource.Name, version.Version, digest, version.Digest)
+		return c.Status(409).SendString("This version's archive doesn't match its digest")
+	}
+
+	etag := `"` + archive.SHA256 + `"`
+	c.Set("ETag", etag)
+	c.Set("X-Ramble-Digest", "sha256:"+archive.SHA256)
+	switch {
+	case version.TrackBranch:
+		c.Set("Cache-Control", "no-cache") // Changes when the branch moves
+	case resource.Visibility != models.VisibilityPublic:
+		c.Set("Cache-Control", "private, max-age=31536000, immutable") // Keep it out of shared caches
+	default:
+		c.Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	if c.Get("If-None-Match") == etag {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	recordDownload(c, resource, version.Version)
+
+	setVersionWarning(c, resource, version)
+	c.Set("Content-Type", "application/gzip")
+	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.tar.gz"`, resource.Name, version.Version))
+	return c.Send(archive.Data)
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http/httptest"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tarFile struct {
+	Name    string
+	Body    string
+	Mode    int64
+	Symlink string
+	ModTime time.Time
+}
+
+// makeTarGz builds an upstream-style repository tarball
+func makeTarGz(t *testing.T, files []tarFile) []byte {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	for _, f := range files {
+		header := &tar.Header{Name: f.Name, Mode: f.Mode, ModTime: f.ModTime, Uid: 1000, Uname: "dev"}
+		switch {
+		case f.Symlink != "":
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = f.Symlink
+		case f.Name[len(f.Name)-1] == '/':
+			header.Typeflag = tar.TypeDir
+		default:
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(len(f.Body))
+		}
+		if header.Mode == 0 {
+			header.Mode = 0644
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		_, err := tw.Write([]byte(f.Body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+	return buf.Bytes()
+}
+
+// listTarGz returns the entry names of an archive in order
+func listTarGz(t *testing.T, data []byte) []string {
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	tr := tar.NewReader(gzr)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+	return names
+}
+
+func upstreamPackRepo(modTime time.Time) []tarFile {
+	return []tarFile{
+		{Name: "repo-v1.0.0/", ModTime: modTime},
+		{Name: "repo-v1.0.0/README.md", Body: "# repo", ModTime: modTime},
+		{Name: "repo-v1.0.0/packs/mysql/templates/mysql.nomad.tpl", Body: "job {}", ModTime: modTime},
+		{Name: "repo-v1.0.0/packs/mysql/metadata.hcl", Body: `pack { name = "mysql" }`, ModTime: modTime},
+		{Name: "repo-v1.0.0/packs/mysql/README.md", Body: "# mysql", ModTime: modTime},
+		{Name: "repo-v1.0.0/packs/mysql/hooks/setup.sh", Body: "#!/bin/sh", Mode: 0775, ModTime: modTime},
+		{Name: "repo-v1.0.0/packs/mysql/link", Symlink: "/etc/passwd", ModTime: modTime},
+		{Name: "repo-v1.0.0/packs/mysql-extra/metadata.hcl", Body: "other", ModTime: modTime},
+	}
+}
+
+func TestBuildPackArchive_Subdirectory(t *testing.T) {
+	src := makeTarGz(t, upstreamPackRepo(time.Now()))
+
+	data, err := buildPackArchive(bytes.NewReader(src), "packs/mysql/", "mysql-v1.0.0")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"mysql-v1.0.0/",
+		"mysql-v1.0.0/README.md",
+		"mysql-v1.0.0/hooks/setup.sh",
+		"mysql-v1.0.0/metadata.hcl",
+		"mysql-v1.0.0/templates/mysql.nomad.tpl",
+	}, listTarGz(t, data))
+
+	files, err := readArchiveFiles(data, "README.md", "metadata.hcl", "variables.hcl")
+	require.NoError(t, err)
+	assert.Equal(t, "# mysql", files["README.md"])
+	assert.Equal(t, `pack { name = "mysql" }`, files["metadata.hcl"])
+	_, ok := files["variables.hcl"]
+	assert.False(t, ok)
+}
+
+func TestBuildPackArchive_Deterministic(t *testing.T) {
+	first := upstreamPackRepo(time.Now())
+	second := upstreamPackRepo(time.Now().Add(-48 * time.Hour))
+	sort.Slice(second, func(i, j int) bool { return second[i].Name > second[j].Name })
+
+	a, err := buildPackArchive(bytes.NewReader(makeTarGz(t, first)), "packs/mysql", "mysql-v1.0.0")
+	require.NoError(t, err)
+	b, err := buildPackArchive(bytes.NewReader(makeTarGz(t, second)), "packs/mysql", "mysql-v1.0.0")
+	require.NoError(t, err)
+
+	assert.Equal(t, a, b)
+}
+
+func TestBuildPackArchive_MissingMetadata(t *testing.T) {
+	src := makeTarGz(t, upstreamPackRepo(time.Now()))
+
+	_, err := buildPackArchive(bytes.NewReader(src), "", "repo-v1.0.0")
+	assert.ErrorContains(t, err, "metadata.hcl not found")
+
+	_, err = buildPackArchive(bytes.NewReader(src), "packs/postgres", "postgres-v1.0.0")
+	assert.ErrorContains(t, err, "metadata.hcl not found in packs/postgres")
+}
+
+func TestBuildPackArchive_InvalidInput(t *testing.T) {
+	_, err := buildPackArchive(bytes.NewReader([]byte("not a tarball")), "", "x")
+	assert.Error(t, err)
+}
+
+// createTestArchive stores a snapshot for the first version of a resource
+func createTestArchive(t *testing.T, resource models.NomadResource) models.VersionArchive {
+	var version models.ResourceVersion
+	require.NoError(t, database.DB.Where("resource_id = ?", resource.ID).First(&version).Error)
+
+	src := makeTarGz(t, []tarFile{
+		{Name: "repo/"},
+		{Name: "repo/metadata.hcl", Body: `pack { name = "` + resource.Name + `" }`},
+	})
+	data, err := buildPackArchive(bytes.NewReader(src), "", resource.Name+"-"+version.Version)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256(data)
+	archive := models.VersionArchive{
+		ResourceVersionID: version.ID,
+		Data:              data,
+		Size:              int64(len(data)),
+		SHA256:            hex.EncodeToString(sum[:]),
+	}
+	require.NoError(t, database.DB.Create(&archive).Error)
+	return archive
+}
+
+func TestGetVersionArchive(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "archiveuser")
+	resource := createTestPack(t, user.ID, "archived-pack")
+	archive := createTestArchive(t, resource)
+
+	app := setupTestApp()
+	app.Get("/:username/:resourcename/v/:version/archive.tar.gz", GetVersionArchive)
+
+	req := httptest.NewRequest("GET", "/archiveuser/archived-pack/v/v1.0.0/archive.tar.gz", nil)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "application/gzip", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `"`+archive.SHA256+`"`, resp.Header.Get("ETag"))
+	assert.Equal(t, "sha256:"+archive.SHA256, resp.Header.Get("X-Ramble-Digest"))
+	assert.Contains(t, resp.Header.Get("Cache-Control"), "immutable")
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, archive.Data, body)
+
+	var updated models.NomadResource
+	database.DB.First(&updated, resource.ID)
+	assert.Equal(t, 1, updated.DownloadCount)
+
+	// Conditional requests are answered without the body
+	req = httptest.NewRequest("GET", "/archiveuser/archived-pack/v/v1.0.0/archive.tar.gz", nil)
+	req.Header.Set("If-None-Match", `"`+archive.SHA256+`"`)
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 304, resp.StatusCode)
+}
+
+func TestGetVersionArchive_DigestMismatch(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "mismatchuser")
+	resource := createTestPack(t, user.ID, "mismatch-pack")
+	createTestArchive(t, resource)
+	database.DB.Model(&models.ResourceVersion{}).Where("resource_id = ?", resource.ID).
+		Updates(map[string]interface{}{"digest": "sha256:signed", "signature": "sig", "signed_by": "key"})
+
+	app := setupTestApp()
+	app.Get("/:username/:resourcename/v/:version/archive.tar.gz", GetVersionArchive)
+
+	req := httptest.NewRequest("GET", "/mismatchuser/mismatch-pack/v/v1.0.0/archive.tar.gz", nil)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 409, resp.StatusCode)
+
+	// Downloads never rewrite what the version vouches for
+	var version models.ResourceVersion
+	database.DB.Where("resource_id = ?", resource.ID).First(&version)
+	assert.Equal(t, "sha256:signed", version.Digest)
+	assert.Equal(t, "sig", version.Signature)
+	assert.Equal(t, "key", version.SignedBy)
+}
+
+func TestGetVersionArchive_NotSnapshotted(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "snapshotuser")
+	resource := createTestPack(t, user.ID, "unsnapshotted-pack")
+	var version models.ResourceVersion
+	require.NoError(t, database.DB.Where("resource_id = ?", resource.ID).First(&version).Error)
+
+	app := setupTestApp()
+	app.Get("/:username/:resourcename/v/:version/archive.tar.gz", GetVersionArchive)
+	get := func() int {
+		resp, err := app.Test(httptest.NewRequest("GET", "/snapshotuser/unsnapshotted-pack/v/v1.0.0/archive.tar.gz", nil))
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	// The download queues the snapshot instead of taking it, and only once
+	assert.Equal(t, 503, get())
+	assert.Equal(t, 503, get())
+	var jobs []models.IngestJob
+	database.DB.Where("resource_version_id = ?", version.ID).Find(&jobs)
+	require.Len(t, jobs, 1)
+	database.DB.First(&version, version.ID)
+	assert.Equal(t, models.IngestPending, version.IngestState)
+
+	// A failed ingestion isn't retried by downloads
+	database.DB.Delete(&jobs[0])
+	database.DB.Model(&version).Update("ingest_state", models.IngestFailed)
+	assert.Equal(t, 404, get())
+	var count int64
+	database.DB.Model(&models.IngestJob{}).Where("resource_version_id = ?", version.ID).Count(&count)
+	assert.Zero(t, count)
+}
+
+func TestGetVersionArchive_OrgNamespace(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	user := createTestUser(t, "archiveorguser")
+	org := models.Organization{Name: "testarchiveorg"}
+	require.NoError(t, database.DB.Create(&org).Error)
+	resource := createTestPack(t, user.ID, "org-archived-pack")
+	database.DB.Model(&resource).Update("organization_id", org.ID)
+	createTestArchive(t, resource)
+
+	app := setupTestApp()
+	app.Get("/:username/:resourcename/v/:version/archive.tar.gz", GetVersionArchive)
+
+	req := httptest.NewRequest("GET", "/testarchiveorg/org-archived-pack/v/v1.0.0/archive.tar.gz", nil)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestGetVersionArchive_NotFound(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "archiveuser2")
+	resource := createTestPack(t, user.ID, "archived-pack2")
+	createTestArchive(t, resource)
+	createTestJob(t, user.ID, "archived-job")
+
+	app := setupTestApp()
+	app.Get("/:username/:resourcename/v/:version/archive.tar.gz", GetVersionArchive)
+
+	for _, path := range []string{
+		"/archiveuser2/archived-pack2/v/v9.9.9/archive.tar.gz",
+		"/archiveuser2/missing/v/v1.0.0/archive.tar.gz",
+		"/archiveuser2/archived-job/v/v1.0.0/archive.tar.gz",
+	} {
+		resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+		require.NoError(t, err)
+		assert.Equal(t, 404, resp.StatusCode, path)
+	}
+}
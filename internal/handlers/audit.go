@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Audited actions, named after their target
+const (
+	AuditUserToggleAdmin      = "user.toggle_admin"
+	AuditUserEdit             = "user.edit"
+	AuditUserDelete           = "user.delete"
+	AuditUserSuspend          = "user.suspend"
+	AuditUserUnsuspend        = "user.unsuspend"
+	AuditUserEnable2FA        = "user.enable_2fa"
+	AuditUserDisable2FA       = "user.disable_2fa"
+	AuditOrgCreate            = "org.create"
+	AuditOrgEdit              = "org.edit"
+	AuditOrgDelete            = "org.delete"
+	AuditOrgInvite            = "org.invite"
+	AuditOrgRevokeInvitation  = "org.revoke_invitation"
+	AuditOrgJoin              = "org.join"
+	AuditOrgChangeRole        = "org.change_role"
+	AuditOrgRemoveMember      = "org.remove_member"
+	AuditOrgEditRoles         = "org.edit_roles"
+	AuditResourceCreate       = "resource.create"
+	AuditResourceEdit         = "resource.edit"
+	AuditResourceDelete       = "resource.delete"
+	AuditResourcePublish      = "resource.publish"
+	AuditResourceYank         = "resource.yank"
+	AuditResourceUnyank       = "resource.unyank"
+	AuditResourceDeprecate    = "resource.deprecate"
+	AuditResourceUndeprecate  = "resource.undeprecate"
+	AuditResourceRotateSecret = "resource.rotate_secret"
+	AuditResourceHide         = "resource.hide"
+	AuditResourceUnhide       = "resource.unhide"
+	AuditResourceDismiss      = "resource.dismiss_report"
+	AuditSiteEditSettings     = "site.edit_settings"
+)
+
+// AuditActions lists every audited action, for the filter on /admin/audit
+var AuditActions = []string{
+	AuditUserToggleAdmin, AuditUserEdit, AuditUserDelete, AuditUserSuspend, AuditUserUnsuspend,
+	AuditUserEnable2FA, AuditUserDisable2FA,
+	AuditOrgCreate, AuditOrgEdit, AuditOrgDelete, AuditOrgInvite, AuditOrgRevokeInvitation,
+	AuditOrgJoin, AuditOrgChangeRole, AuditOrgRemoveMember, AuditOrgEditRoles,
+	AuditResourceCreate, AuditResourceEdit, AuditResourceDelete, AuditResourcePublish,
+	AuditResourceYank, AuditResourceUnyank, AuditResourceDeprecate, AuditResourceUndeprecate,
+	AuditResourceRotateSecret, AuditResourceHide, AuditResourceUnhide, AuditResourceDismiss,
+	AuditSiteEditSettings,
+}
+
+// Kinds of audit target
+const (
+	AuditTargetUser         = "user"
+	AuditTargetOrganization = "organization"
+	AuditTargetResource     = "resource"
+	AuditTargetSite         = "site" // Instance-wide settings
+)
+
+const (
+	auditPerPage     = 50
+	auditExportLimit = 10000 // Events per export response; page on with after=<last id>
+)
+
+// AuditChange is the value of one field before and after an action. A nil
+// side means the field didn't exist, e.g. before a member was added.
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// auditDiff returns the fields whose values differ between before and after
+func auditDiff(before, after map[string]interface{}) map[string]AuditChange {
+	diff := map[string]AuditChange{}
+	for k, v := range before {
+		if a, ok := after[k]; !ok || !reflect.DeepEqual(v, a) {
+			diff[k] = AuditChange{Before: v, After: a}
+		}
+	}
+	for k, a := range after {
+		if _, ok := before[k]; !ok {
+			diff[k] = AuditChange{After: a}
+		}
+	}
+	return diff
+}
+
+// resourceAuditFields are the fields of a resource that its audit diffs cover
+func resourceAuditFields(r models.NomadResource) map[string]interface{} {
+	var orgID interface{}
+	if r.OrganizationID != nil {
+		orgID = *r.OrganizationID
+	}
+	return map[string]interface{}{
+		"name":                 r.Name,
+		"type":                 string(r.Type),
+		"organization_id":      orgID,
+		"description":          r.Description,
+		"repository_url":       r.RepositoryURL,
+		"git_provider":         r.GitProvider,
+		"default_branch":       r.DefaultBranch,
+		"file_path":            r.FilePath,
+		"license":              r.License,
+		"visibility":           r.Visibility,
+		"webhook_query_secret": r.WebhookQuerySecret,
+	}
+}
+
+// userAuditFields are the fields of a user that its audit diffs cover
+func userAuditFields(u models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"username":       u.Username,
+		"name":           u.Name,
+		"email":          u.Email,
+		"email_verified": u.EmailVerified,
+		"is_admin":       u.IsAdmin,
+	}
+}
+
+// orgAuditFields are the fields of an organization that its audit diffs cover
+func orgAuditFields(o models.Organization) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        o.Name,
+		"description": o.Description,
+		"require_2fa": o.Require2FA,
+	}
+}
+
+// deprecationAuditFields are the fields of a resource that deprecation
+// audit diffs cover
+func deprecationAuditFields(r models.NomadResource) map[string]interface{} {
+	return map[string]interface{}{
+		"deprecated":          r.Deprecated,
+		"deprecation_message": r.DeprecationMessage,
+		"successor":           successorPath(r),
+	}
+}
+
+// yankAuditFields are the diff fields for whether a version is yanked
+func yankAuditFields(version string, yanked bool, reason string) map[string]interface{} {
+	return map[string]interface{}{
+		"versions." + version + ".yanked":      yanked,
+		"versions." + version + ".yank_reason": reason,
+	}
+}
+
+// auditResource records an action on a resource, named namespace/name
+func auditResource(c *fiber.Ctx, action string, resource models.NomadResource, diff map[string]AuditChange) {
+	if resource.User.ID == 0 {
+		database.DB.Preload("User").Preload("Organization").First(&resource, resource.ID)
+	}
+	recordAudit(c, action, AuditTargetResource, resource.ID, getResourceNamespace(resource)+"/"+resource.Name, diff)
+}
+
+// roleAuditFields turns a permission matrix into diff fields: the sorted
+// permissions of each role other than owner, as "roles.<role>"
+func roleAuditFields(matrix map[string]map[Permission]bool) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for role, perms := range matrix {
+		if role == models.RoleOwner {
+			continue
+		}
+		granted := []string{}
+		for _, p := range Permissions {
+			if perms[p.Key] {
+				granted = append(granted, string(p.Key))
+			}
+		}
+		fields["roles."+role] = granted
+	}
+	return fields
+}
+
+// auditOrg records an action on an organization by its ID
+func auditOrg(c *fiber.Ctx, action string, orgID uint, diff map[string]AuditChange) {
+	var org models.Organization
+	database.DB.Select("id", "name").First(&org, orgID)
+	recordAudit(c, action, AuditTargetOrganization, orgID, org.Name, diff)
+}
+
+// memberAuditKey is the diff field for a user's membership, as
+// "members.<username>"
+func memberAuditKey(userID uint) string {
+	var user models.User
+	database.DB.Select("username").First(&user, userID)
+	return "members." + user.Username
+}
+
+// recordAudit appends an event for an action the request's user took. Audit
+// failures are logged and never fail the action itself.
+func recordAudit(c *fiber.Ctx, action, targetType string, targetID uint, targetName string, diff map[string]AuditChange) {
+	recordAuditBy(c, currentUserID(c), action, targetType, targetID, targetName, diff)
+}
+
+// recordAuditBy is recordAudit for actions whose actor isn't logged in yet,
+// such as joining an organization while signing up
+func recordAuditBy(c *fiber.Ctx, userID uint, action, targetType string, targetID uint, targetName string, diff map[string]AuditChange) {
+	event := models.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		TargetName: targetName,
+		IP:         c.IP(),
+	}
+	if userID != 0 {
+		event.ActorID = &userID
+		if user, ok := c.Locals("User").(models.User); ok && user.ID == userID {
+			event.ActorName = user.Username
+		} else {
+			var user models.User
+			database.DB.Select("username").First(&user, userID)
+			event.ActorName = user.Username
+		}
+	}
+	if token, ok := apiTokenFromCtx(c); ok {
+		event.APITokenID = &token.ID
+	}
+	if len(diff) > 0 {
+		data, _ := json.Marshal(diff)
+		event.Diff = string(data)
+	}
+	if err := database.DB.Create(&event).Error; err != nil {
+		fmt.Printf("Failed to record audit event %s: %v\n", action, err)
+	}
+}
+
+// auditFilters are the query parameters shared by the audit page and export
+type auditFilters struct {
+	Actor      string // Username
+	Action     string
+	TargetType string
+	Target     string // Target name, case-insensitive substring
+	Since      string // YYYY-MM-DD, inclusive
+	Until      string // YYYY-MM-DD, inclusive
+}
+
+func parseAuditFilters(c *fiber.Ctx) auditFilters {
+	return auditFilters{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		Target:     c.Query("target"),
+		Since:      c.Query("since"),
+		Until:      c.Query("until"),
+	}
+}
+
+func (f auditFilters) apply(db *gorm.DB) *gorm.DB {
+	if f.Actor != "" {
+		db = db.Where("actor_name ILIKE ?", escapeLikeString(f.Actor))
+	}
+	if f.Action != "" {
+		db = db.Where("action = ?", f.Action)
+	}
+	if f.TargetType != "" {
+		db = db.Where("target_type = ?", f.TargetType)
+	}
+	if f.Target != "" {
+		db = db.Where("target_name ILIKE ?", "%"+escapeLikeString(f.Target)+"%")
+	}
+	if t, err := time.Parse("2006-01-02", f.Since); err == nil {
+		db = db.Where("created_at >= ?", t)
+	}
+	if t, err := time.Parse("2006-01-02", f.Until); err == nil {
+		db = db.Where("created_at < ?", t.AddDate(0, 0, 1))
+	}
+	return db
+}
+
+// auditURL links to path with the filters and, if it's not 0, a page
+func auditURL(path string, f auditFilters, page int) string {
+	v := url.Values{}
+	if page > 0 {
+		v.Set("page", fmt.Sprint(page))
+	}
+	for key, value := range map[string]string{
+		"actor": f.Actor, "action": f.Action, "target_type": f.TargetType,
+		"target": f.Target, "since": f.Since, "until": f.Until,
+	} {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	if len(v) == 0 {
+		return path
+	}
+	return path + "?" + v.Encode()
+}
+
+// auditChangeView is one changed field of an audit event, formatted for
+// display. Empty values were absent.
+type auditChangeView struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// auditEventView is an audit event with its diff decoded for display
+type auditEventView struct {
+	models.AuditEvent
+	Changes []auditChangeView
+}
+
+// formatAuditValue shows strings as they are and anything else as JSON
+func formatAuditValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// auditChanges decodes a stored diff into rows sorted by field
+func auditChanges(diff string) []auditChangeView {
+	var changes map[string]AuditChange
+	if diff == "" || json.Unmarshal([]byte(diff), &changes) != nil {
+		return nil
+	}
+	rows := make([]auditChangeView, 0, len(changes))
+	for field, change := range changes {
+		rows = append(rows, auditChangeView{field, formatAuditValue(change.Before), formatAuditValue(change.After)})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Field < rows[j].Field })
+	return rows
+}
+
+// GetAdminAudit shows the audit log, newest first, with filters by actor,
+// action, target and date
+func GetAdminAudit(c *fiber.Ctx) error {
+	filters := parseAuditFilters(c)
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+
+	var events []models.AuditEvent
+	filters.apply(database.DB.Model(&models.AuditEvent{})).Order("id DESC").
+		Offset((page - 1) * auditPerPage).Limit(auditPerPage + 1).Find(&events)
+	hasMore := len(events) > auditPerPage
+	if hasMore {
+		events = events[:auditPerPage]
+	}
+
+	views := make([]auditEventView, len(events))
+	for i, e := range events {
+		views[i] = auditEventView{AuditEvent: e, Changes: auditChanges(e.Diff)}
+	}
+
+	return c.Render("admin/audit", MergeContext(BaseContext(c), fiber.Map{
+		"Events":      views,
+		"Filters":     filters,
+		"ExportURL":   auditURL("/admin/audit/export", filters, 0),
+		"PrevURL":     auditURL("/admin/audit", filters, page-1),
+		"NextURL":     auditURL("/admin/audit", filters, page+1),
+		"Actions":     AuditActions,
+		"TargetTypes": []string{AuditTargetUser, AuditTargetOrganization, AuditTargetResource, AuditTargetSite},
+		"PageNum":     page,
+		"HasMore":     hasMore,
+		"Page":        "admin_audit",
+	}), "layouts/main")
+}
+
+// auditEventJSON is one line of the audit export
+type auditEventJSON struct {
+	ID         uint                   `json:"id"`
+	Time       time.Time              `json:"time"`
+	ActorID    *uint                  `json:"actor_id"`
+	Actor      string                 `json:"actor"`
+	APITokenID *uint                  `json:"api_token_id,omitempty"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type"`
+	TargetID   uint                   `json:"target_id"`
+	Target     string                 `json:"target"`
+	IP         string                 `json:"ip"`
+	Diff       map[string]AuditChange `json:"diff,omitempty"`
+}
+
+// GetAuditExport godoc
+// @Summary Export the audit log
+// @Description Audit events as JSON lines, oldest first, with the same filters as /admin/audit. Responses hold up to 10000 events; pass the last id as after to fetch the next ones. Needs an admin session or an admin's token with the audit:read scope.
+// @Tags admin
+// @Produce application/x-ndjson
+// @Param after query int false "Only events with a higher id"
+// @Param actor query string false "Actor username"
+// @Param action query string false "Action, e.g. resource.delete"
+// @Param target_type query string false "user, organization or resource"
+// @Param target query string false "Part of the target's name"
+// @Param since query string false "First day, YYYY-MM-DD"
+// @Param until query string false "Last day, YYYY-MM-DD"
+// @Success 200 {string} string "JSON lines"
+// @Failure 403 {object} map[string]string
+// @Security BearerAuth
+// @Router /v1/audit [get]
+func GetAuditExport(c *fiber.Ctx) error {
+	if !isAdminRequest(c) {
+		return apiError(c, fiber.StatusForbidden, "Administrator privileges required")
+	}
+
+	query := parseAuditFilters(c).apply(database.DB.Model(&models.AuditEvent{}))
+	if after := c.QueryInt("after", 0); after > 0 {
+		query = query.Where("id > ?", after)
+	}
+	var events []models.AuditEvent
+	if err := query.Order("id ASC").Limit(auditExportLimit).Find(&events).Error; err != nil {
+		return apiError(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	for _, e := range events {
+		line := auditEventJSON{
+			ID: e.ID, Time: e.CreatedAt, ActorID: e.ActorID, Actor: e.ActorName, APITokenID: e.APITokenID,
+			Action: e.Action, TargetType: e.TargetType, TargetID: e.TargetID, Target: e.TargetName, IP: e.IP,
+		}
+		if e.Diff != "" {
+			json.Unmarshal([]byte(e.Diff), &line.Diff)
+		}
+		enc.Encode(line)
+	}
+
+	c.Set("Content-Type", "application/x-ndjson")
+	if !isTokenRequest(c) {
+		c.Set("Content-Disposition", `attachment; filename="audit-`+time.Now().Format("20060102")+`.jsonl"`)
+	}
+	return c.Send(out.Bytes())
+}
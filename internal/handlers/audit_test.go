@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http/httptest"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lastAuditEvent returns the newest audit event with the given action
+func lastAuditEvent(t *testing.T, action string) (models.AuditEvent, map[string]AuditChange) {
+	var event models.AuditEvent
+	require.NoError(t, database.DB.Where("action = ?", action).Order("id DESC").First(&event).Error)
+	var diff map[string]AuditChange
+	if event.Diff != "" {
+		require.NoError(t, json.Unmarshal([]byte(event.Diff), &diff))
+	}
+	return event, diff
+}
+
+func TestAudit_AdminToggle(t *testing.T) {
+	defer cleanupTestData(t)
+
+	admin := createAdminUser(t, "auditadmin")
+	user := createTestUser(t, "audittarget")
+
+	app := setupAuthenticatedApp(admin)
+	app.Post("/admin/users/:id/toggle-admin", PostToggleAdmin)
+	resp, err := app.Test(httptest.NewRequest("POST", "/admin/users/"+toString(user.ID)+"/toggle-admin", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	event, diff := lastAuditEvent(t, AuditUserToggleAdmin)
+	assert.Equal(t, "auditadmin", event.ActorName)
+	require.NotNil(t, event.ActorID)
+	assert.Equal(t, admin.ID, *event.ActorID)
+	assert.Equal(t, AuditTargetUser, event.TargetType)
+	assert.Equal(t, user.ID, event.TargetID)
+	assert.Equal(t, "audittarget", event.TargetName)
+	assert.NotEmpty(t, event.IP)
+	assert.Equal(t, AuditChange{Before: false, After: true}, diff["is_admin"])
+}
+
+func TestAudit_ResourceEditAndDelete(t *testing.T) {
+	defer cleanupTestData(t)
+
+	owner := createTestUser(t, "auditresowner")
+	pack := createTestPack(t, owner.ID, "audit-pack")
+
+	app := setupAuthenticatedApp(owner)
+	app.Post("/resource/:id/edit", PostEditResource)
+	app.Delete("/resource/:id", DeleteResource)
+
+	form := "name=audit-pack&description=Changed&repository_url=" + pack.RepositoryURL + "&type=pack"
+	req := httptest.NewRequest("POST", "/resource/"+toString(pack.ID)+"/edit", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	event, diff := lastAuditEvent(t, AuditResourceEdit)
+	assert.Equal(t, "auditresowner/audit-pack", event.TargetName)
+	assert.Equal(t, AuditChange{Before: "Test pack: audit-pack", After: "Changed"}, diff["description"])
+	assert.NotContains(t, diff, "name", "unchanged fields are left out")
+
+	resp, err = app.Test(httptest.NewRequest("DELETE", "/resource/"+toString(pack.ID), nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	event, diff = lastAuditEvent(t, AuditResourceDelete)
+	assert.Equal(t, pack.ID, event.TargetID)
+	assert.Equal(t, "audit-pack", diff["name"].Before)
+	assert.Nil(t, diff["name"].After)
+}
+
+func TestAudit_OrgRoleChange(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	owner := createTestUser(t, "auditorgowner")
+	member := createTestUser(t, "auditorgmember")
+	org, _ := createTestOrgResource(t, "testauditorg",
+		models.Membership{UserID: owner.ID, Role: models.RoleOwner},
+		models.Membership{UserID: member.ID, Role: models.RoleViewer},
+	)
+
+	app := setupAuthenticatedApp(owner)
+	app.Post("/orgs/:orgname/members/:member_id/role", RequireOrgPermission(PermManageMembers), PostUpdateMemberRole)
+	req := httptest.NewRequest("POST", "/orgs/testauditorg/members/"+toString(member.ID)+"/role", strings.NewReader("role=publisher"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	event, diff := lastAuditEvent(t, AuditOrgChangeRole)
+	assert.Equal(t, AuditTargetOrganization, event.TargetType)
+	assert.Equal(t, org.ID, event.TargetID)
+	assert.Equal(t, AuditChange{Before: models.RoleViewer, After: models.RolePublisher}, diff["members.auditorgmember"])
+}
+
+func TestAudit_AppendOnly(t *testing.T) {
+	defer cleanupTestData(t)
+
+	event := models.AuditEvent{Action: AuditUserEdit, TargetType: AuditTargetUser, TargetName: "someone"}
+	require.NoError(t, database.DB.Create(&event).Error)
+
+	assert.ErrorIs(t, database.DB.Model(&event).Update("target_name", "someone-else").Error, models.ErrAuditAppendOnly)
+	assert.ErrorIs(t, database.DB.Delete(&event).Error, models.ErrAuditAppendOnly)
+
+	var stored models.AuditEvent
+	require.NoError(t, database.DB.First(&stored, event.ID).Error)
+	assert.Equal(t, "someone", stored.TargetName)
+}
+
+func TestGetAdminAudit_Filters(t *testing.T) {
+	defer cleanupTestData(t)
+
+	admin := createAdminUser(t, "auditviewer")
+	database.DB.Create(&models.AuditEvent{ActorName: "alice", Action: AuditResourceDelete, TargetType: AuditTargetResource, TargetName: "alice/first-pack"})
+	database.DB.Create(&models.AuditEvent{ActorName: "bob", Action: AuditOrgEdit, TargetType: AuditTargetOrganization, TargetName: "bobs-org",
+		Diff: `{"description":{"before":"Old","after":"New"}}`})
+
+	app := setupAuthenticatedApp(admin)
+	app.Get("/admin/audit", GetAdminAudit)
+
+	status, body := getStatus(t, app, "/admin/audit", "")
+	assert.Equal(t, 200, status)
+	assert.Contains(t, body, "alice/first-pack")
+	assert.Contains(t, body, "bobs-org")
+	assert.Contains(t, body, "New")
+
+	_, body = getStatus(t, app, "/admin/audit?actor=bob", "")
+	assert.NotContains(t, body, "alice/first-pack")
+	assert.Contains(t, body, "bobs-org")
+
+	_, body = getStatus(t, app, "/admin/audit?action=resource.delete&target=first", "")
+	assert.Contains(t, body, "alice/first-pack")
+	assert.NotContains(t, body, "bobs-org")
+}
+
+func TestGetAuditExport(t *testing.T) {
+	defer cleanupTestData(t)
+
+	admin := createAdminUser(t, "auditexporter")
+	user := createTestUser(t, "auditnonadmin")
+	for _, name := range []string{"one", "two", "three"} {
+		database.DB.Create(&models.AuditEvent{ActorName: "auditexporter", Action: AuditUserEdit, TargetType: AuditTargetUser, TargetName: name})
+	}
+
+	adminToken, _ := createTestToken(t, admin.ID, nil, ScopeAuditRead)
+	unscopedToken, _ := createTestToken(t, admin.ID, nil, ScopeResourcesWrite)
+	userToken, _ := createTestToken(t, user.ID, nil, ScopeAuditRead)
+
+	app := setupTokenApp()
+	app.Get("/v1/audit", RequireAuthOrToken(ScopeAuditRead), GetAuditExport)
+
+	status, _ := getStatus(t, app, "/v1/audit", unscopedToken)
+	assert.Equal(t, 403, status)
+	status, _ = getStatus(t, app, "/v1/audit", userToken)
+	assert.Equal(t, 403, status, "only admins can export")
+
+	status, body := getStatus(t, app, "/v1/audit", adminToken)
+	require.Equal(t, 200, status)
+	var lines []auditEventJSON
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		var line auditEventJSON
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 3)
+	assert.Equal(t, "one", lines[0].Target, "oldest first")
+
+	// Paging continues after the last id seen
+	_, body = getStatus(t, app, "/v1/audit?after="+toString(lines[1].ID), adminToken)
+	assert.Equal(t, 1, strings.Count(body, "\n"))
+	assert.Contains(t, body, `"target":"three"`)
+
+	_, body = getStatus(t, app, "/v1/audit?target=tw", adminToken)
+	assert.Equal(t, 1, strings.Count(body, "\n"))
+	assert.Contains(t, body, `"target":"two"`)
+}
+
+func TestPostCreateToken_AuditScopeAdminsOnly(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "auditscopeuser")
+	admin := createAdminUser(t, "auditscopeadmin")
+	create := func(actor models.User) int {
+		app := setupAuthenticatedApp(actor)
+		app.Post("/settings/tokens", PostCreateToken)
+		req := httptest.NewRequest("POST", "/settings/tokens", strings.NewReader("name=siem&owner=user&scopes=audit:read"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, 403, create(user))
+	assert.Equal(t, 200, create(admin))
+}
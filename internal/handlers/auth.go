@@ -5,11 +5,13 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"log"
 	"os"
 	"regexp"
 	"rmbl/internal/database"
 	"rmbl/internal/models"
 	"rmbl/internal/services/email"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -23,6 +25,9 @@ import (
 
 var Store *session.Store
 
+// sessionStorage is where Store keeps sessions; in memory when nil
+var sessionStorage fiber.Storage
+
 // validatePassword checks if a password meets security requirements
 func validatePassword(password string) error {
 	if len(password) < 12 {
@@ -44,7 +49,13 @@ func validatePassword(password string) error {
 }
 
 func InitSession() {
+	// Sessions are kept in the database, so restarts don't log everyone out
+	// and every instance sees them
+	if sessionStorage == nil && database.DB != nil {
+		sessionStorage = database.NewSessionStorage(database.DB, sessionGCInterval)
+	}
 	Store = session.New(session.Config{
+		Storage:        sessionStorage,
 		Expiration:     24 * time.Hour,
 		CookieSecure:   os.Getenv("ENV") == "production", // Only send over HTTPS in production
 		CookieHTTPOnly: true,                             // Prevent XSS access to cookies
@@ -65,6 +76,16 @@ func InitSession() {
 		github.New(os.Getenv("GITHUB_KEY"), os.Getenv("GITHUB_SECRET"), baseURL+"/auth/github/callback", "public_repo", "user:email"),
 		gitlab.New(os.Getenv("GITLAB_KEY"), os.Getenv("GITLAB_SECRET"), baseURL+"/auth/gitlab/callback", "read_api", "read_user"),
 	)
+
+	// A self-hosted identity provider that can't be reached leaves the
+	// other logins working
+	oidc, err := OIDCConfigFromEnv()
+	if err == nil && oidc != nil {
+		err = ConfigureOIDC(oidc, baseURL+"/auth/"+oidcProviderName+"/callback")
+	}
+	if err != nil {
+		log.Printf("OIDC login disabled: %v", err)
+	}
 }
 
 func BeginAuth(c *fiber.Ctx) error {
@@ -78,14 +99,24 @@ func AuthCallback(c *fiber.Ctx) error {
 		return c.Redirect("/login")
 	}
 
+	isOIDC := gothUser.Provider == oidcProviderName
+	if isOIDC && gothUser.Email == "" {
+		SetFlash(c, "error", "Your identity provider didn't share an email address.")
+		return c.Redirect("/login")
+	}
+
 	var user models.User
+	created := false
 	// Check if user exists by Provider + ProviderID
 	result := database.DB.Where("provider = ? AND provider_id = ?", gothUser.Provider, gothUser.UserID).First(&user)
 
 	if result.Error != nil {
 		// User doesn't exist, check by email to link accounts
 		result = database.DB.Where("email = ?", gothUser.Email).First(&user)
-		if result.Error == nil {
+		if result.Error == nil && isOIDC && !oidcEmailVerified(gothUser) {
+			SetFlash(c, "error", "Verify your email address with your identity provider to link it to your account.")
+			return c.Redirect("/login")
+		} else if result.Error == nil {
 			// Link account
 			user.Provider = gothUser.Provider
 			user.ProviderID = gothUser.UserID
@@ -98,6 +129,9 @@ func AuthCallback(c *fiber.Ctx) error {
 			if username == "" {
 				username = gothUser.Name
 			}
+			if isOIDC {
+				username = oidcUsername(gothUser)
+			}
 			// Simple username collision check or suffix
 			user = models.User{
 				Username:      username,
@@ -113,12 +147,48 @@ func AuthCallback(c *fiber.Ctx) error {
 				SetFlash(c, "error", "Failed to create user account.")
 				return c.Redirect("/signup")
 			}
+			created = true
 		}
 	} else {
 		// Update existing user token
 		user.AccessToken = gothUser.AccessToken
 		database.DB.Save(&user)
 	}
+	if user.SuspendedAt != nil {
+		SetFlash(c, "error", "This account has been suspended.")
+		return c.Redirect("/login")
+	}
+	// Accounts linked to a password login that turned on two-factor
+	// authentication give their code here too. Their memberships follow
+	// their groups once they have.
+	if has2FA(user) {
+		if err := beginSecondFactor(c, user); err != nil {
+			return err
+		}
+		if isOIDC {
+			if err := rememberOIDCGroups(c, gothUser); err != nil {
+				return err
+			}
+		}
+		return c.Redirect("/login/2fa")
+	}
+	if isOIDC {
+		syncOIDCMemberships(c, user, oidcGroups(gothUser))
+	}
+
+	// Signing up from an invitation sent to this address joins its
+	// organization; everyone else goes back to the invitation
+	redirect := "/"
+	flash := "Successfully logged in via " + gothUser.Provider
+	invitation, inviteToken, invited := pendingInvitation(c)
+	joined := invited && created && invitationFor(invitation, user)
+	if joined {
+		joinInvitation(c, user, invitation)
+		redirect = "/" + invitation.Organization.Name
+		flash = "Welcome to RMBL! You have joined " + invitation.Organization.Name + "."
+	} else if invited {
+		redirect = "/invitations/" + inviteToken
+	}
 
 	// Set Session
 	sess, err := Store.Get(c)
@@ -127,18 +197,28 @@ func AuthCallback(c *fiber.Ctx) error {
 			return c.Status(fiber.StatusInternalServerError).SendString("Failed to regenerate session")
 		}
 		sess.Set("user_id", user.ID)
+		sess.Set("logged_in_at", time.Now().Unix())
 		sess.Set("flash_type", "success")
-		sess.Set("flash_message", "Successfully logged in via "+gothUser.Provider)
+		sess.Set("flash_message", flash)
+		if joined {
+			sess.Delete("invite_token")
+		}
+		sessionID := sess.ID()
 		if err := sess.Save(); err != nil {
 			return c.Status(fiber.StatusInternalServerError).SendString("Failed to save session")
 		}
+		trackSession(c, sessionID, user.ID)
 	}
 
-	return c.Redirect("/")
+	return c.Redirect(redirect)
 }
 
 func GetLogin(c *fiber.Ctx) error {
-	return c.Render("login", BaseContext(c), "layouts/main")
+	ctx := BaseContext(c)
+	if oidcConfig != nil {
+		ctx = MergeContext(ctx, fiber.Map{"OIDCName": oidcConfig.DisplayName})
+	}
+	return c.Render("login", ctx, "layouts/main")
 }
 
 func PostLogin(c *fiber.Ctx) error {
@@ -161,29 +241,71 @@ func PostLogin(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).SendString("Invalid email or password")
 	}
+	if user.SuspendedAt != nil {
+		return c.Status(fiber.StatusForbidden).SendString("This account has been suspended")
+	}
+
+	// With two-factor authentication on, the password only gets them to the
+	// code step
+	if has2FA(user) {
+		if err := beginSecondFactor(c, user); err != nil {
+			return err
+		}
+		c.Set("HX-Redirect", "/login/2fa")
+		return c.SendStatus(fiber.StatusOK)
+	}
+
+	return completeLogin(c, user)
+}
+
+// completeLogin logs the user in once their credentials have been checked.
+// Identity provider groups remembered at the first factor are applied now.
+func completeLogin(c *fiber.Ctx, user models.User) error {
+	// Back to the invitation they followed, if any, otherwise home
+	redirect := "/"
+	if _, token, ok := pendingInvitation(c); ok {
+		redirect = "/invitations/" + token
+	}
 
 	// Set Session
 	sess, err := Store.Get(c)
 	if err != nil {
 		return err
 	}
+	pendingID, _ := sess.Get("pending_2fa_user_id").(uint)
+	groups, oidcLogin := sess.Get("pending_oidc_groups").([]string)
 	if err := sess.Regenerate(); err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to regenerate session")
 	}
+	sess.Delete("pending_2fa_user_id")
+	sess.Delete("pending_2fa_at")
+	sess.Delete("pending_oidc_groups")
 	sess.Set("user_id", user.ID)
+	sess.Set("logged_in_at", time.Now().Unix())
 	sess.Set("flash_type", "success")
 	sess.Set("flash_message", "Welcome back, "+user.Name+"!")
+	sessionID := sess.ID()
 	if err := sess.Save(); err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to save session")
 	}
+	trackSession(c, sessionID, user.ID)
+	if oidcLogin && pendingID == user.ID {
+		syncOIDCMemberships(c, user, groups)
+	}
 
-	// Redirect to home
-	c.Set("HX-Redirect", "/")
+	c.Set("HX-Redirect", redirect)
 	return c.SendStatus(fiber.StatusOK)
 }
 
 func GetSignup(c *fiber.Ctx) error {
-	return c.Render("signup", BaseContext(c), "layouts/main")
+	ctx := BaseContext(c)
+	if invitation, _, ok := pendingInvitation(c); ok {
+		ctx = MergeContext(ctx, fiber.Map{"Invitation": invitation})
+	}
+	if oidcConfig != nil {
+		ctx = MergeContext(ctx, fiber.Map{"OIDCName": oidcConfig.DisplayName})
+	}
+	return c.Render("signup", ctx, "layouts/main")
 }
 
 func PostSignup(c *fiber.Ctx) error {
@@ -211,7 +333,7 @@ func PostSignup(c *fiber.Ctx) error {
 
 	// Check if username is taken by an Org
 	var count int64
-	database.DB.Model(&models.Organization{}).Where("name ILIKE ?", input.Username).Count(&count)
+	database.DB.Model(&models.Organization{}).Where("name ILIKE ?", escapeLikeString(input.Username)).Count(&count)
 	if count > 0 {
 		return c.Status(fiber.StatusBadRequest).SendString("Username is already taken by an organization")
 	}
@@ -242,20 +364,35 @@ func PostSignup(c *fiber.Ctx) error {
 		VerificationTokenExpires: time.Now().Add(24 * time.Hour),
 	}
 
+	// Following an invitation sent to this address proves it is theirs.
+	// Signing up with another address doesn't join the organization.
+	invitation, _, invited := pendingInvitation(c)
+	joined := invited && strings.EqualFold(invitation.Email, input.Email)
+	if joined {
+		user.EmailVerified = true
+		user.VerificationToken = ""
+	}
+
 	if err := database.DB.Create(&user).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Could not create user")
 	}
 
-	// Send verification email
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		baseURL = "http://localhost:3000"
+	if !user.EmailVerified {
+		// Send verification email
+		baseURL := os.Getenv("BASE_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:3000"
+		}
+		verificationLink := fmt.Sprintf("%s/verify-email?token=%s", baseURL, verificationToken)
+
+		if err := email.SendVerificationEmail(user.Email, verificationLink); err != nil {
+			// Log error but don't fail signup
+			fmt.Printf("Failed to send verification email: %v\n", err)
+		}
 	}
-	verificationLink := fmt.Sprintf("%s/verify-email?token=%s", baseURL, verificationToken)
 
-	if err := email.SendVerificationEmail(user.Email, verificationLink); err != nil {
-		// Log error but don't fail signup
-		fmt.Printf("Failed to send verification email: %v\n", err)
+	if joined {
+		joinInvitation(c, user, invitation)
 	}
 
 	// Auto login
@@ -267,13 +404,21 @@ func PostSignup(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to regenerate session")
 	}
 	sess.Set("user_id", user.ID)
+	sess.Set("logged_in_at", time.Now().Unix())
+	sess.Delete("invite_token")
+	sessionID := sess.ID()
 	if err := sess.Save(); err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to save session")
 	}
+	trackSession(c, sessionID, user.ID)
 
-	SetFlash(c, "success", "Welcome to RMBL! Please check your email to verify your account.")
-
-	c.Set("HX-Redirect", "/")
+	if joined {
+		SetFlash(c, "success", "Welcome to RMBL! You have joined "+invitation.Organization.Name+".")
+		c.Set("HX-Redirect", "/"+invitation.Organization.Name)
+	} else {
+		SetFlash(c, "success", "Welcome to RMBL! Please check your email to verify your account.")
+		c.Set("HX-Redirect", "/")
+	}
 	return c.SendStatus(fiber.StatusOK)
 }
 
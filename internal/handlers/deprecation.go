@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxNoticeLength caps yank reasons and deprecation messages
+const maxNoticeLength = 500
+
+// Deprecation is the API form of a resource's deprecation notice
+type Deprecation struct {
+	Message   string `json:"message,omitempty"`
+	Successor string `json:"successor,omitempty"` // namespace/name of the resource to use instead
+}
+
+// resourceDeprecation returns the deprecation notice of a resource, or nil if
+// it is not deprecated
+func resourceDeprecation(resource models.NomadResource) *Deprecation {
+	if !resource.Deprecated {
+		return nil
+	}
+	return &Deprecation{Message: resource.DeprecationMessage, Successor: successorPath(resource)}
+}
+
+// successorPath returns "namespace/name" of a resource's successor, or "" if
+// it has none
+func successorPath(resource models.NomadResource) string {
+	if resource.SuccessorID == nil {
+		return ""
+	}
+	var successor models.NomadResource
+	if err := database.DB.Preload("User").Preload("Organization").First(&successor, *resource.SuccessorID).Error; err != nil {
+		return ""
+	}
+	return getResourceNamespace(successor) + "/" + successor.Name
+}
+
+// setVersionWarning adds an X-Ramble-Warning header when a version is yanked
+// or its resource deprecated, so clients fetching by exact pin can tell
+func setVersionWarning(c *fiber.Ctx, resource models.NomadResource, version models.ResourceVersion) {
+	var warnings []string
+	if version.Yanked {
+		w := "version " + version.Version + " has been yanked"
+		if version.YankReason != "" {
+			w += ": " + version.YankReason
+		}
+		warnings = append(warnings, w)
+	}
+	if resource.Deprecated {
+		w := resource.Name + " is deprecated"
+		if resource.DeprecationMessage != "" {
+			w += ": " + resource.DeprecationMessage
+		}
+		if successor := successorPath(resource); successor != "" {
+			w += " (use " + successor + " instead)"
+		}
+		warnings = append(warnings, w)
+	}
+	if len(warnings) > 0 {
+		c.Set("X-Ramble-Warning", strings.Join(warnings, "; "))
+	}
+}
+
+// noticeValue returns a trimmed form value, or the htmx prompt answer when the
+// field is missing, capped at maxNoticeLength
+func noticeValue(c *fiber.Ctx, key string) string {
+	value := strings.TrimSpace(c.FormValue(key))
+	if value == "" {
+		value = strings.TrimSpace(c.Get("HX-Prompt"))
+	}
+	if len(value) > maxNoticeLength {
+		value = value[:maxNoticeLength]
+	}
+	return value
+}
+
+// PostYankVersion godoc
+// @Summary Yank a version
+// @Description Withdraw a version. Yanked versions are skipped when resolving the latest version and hidden from listings, but can still be fetched by exact version, with a warning.
+// @Tags resources
+// @Param id path string true "Resource ID"
+// @Param version path string true "Version"
+// @Param reason formData string false "Why the version was yanked"
+// @Success 200 {object} object
+// @Failure 403 {string} string "Unauthorized"
+// @Failure 404 {string} string "Not found"
+// @Security BearerAuth
+// @Router /resource/{id}/versions/{version}/yank [post]
+func PostYankVersion(c *fiber.Ctx) error {
+	return setYanked(c, true)
+}
+
+// PostUnyankVersion godoc
+// @Summary Restore a yanked version
+// @Description Undo a yank, making the version visible and eligible as latest again.
+// @Tags resources
+// @Param id path string true "Resource ID"
+// @Param version path string true "Version"
+// @Success 200 {object} object
+// @Failure 403 {string} string "Unauthorized"
+// @Failure 404 {string} string "Not found"
+// @Security BearerAuth
+// @Router /resource/{id}/versions/{version}/unyank [post]
+func PostUnyankVersion(c *fiber.Ctx) error {
+	return setYanked(c, false)
+}
+
+func setYanked(c *fiber.Ctx, yanked bool) error {
+	var resource models.NomadResource
+	if err := database.DB.First(&resource, c.Params("id")).Error; err != nil {
+		return apiError(c, 404, "Resource not found")
+	}
+	if !hasPermission(currentUserID(c), resource, PermPublish) || !tokenAllowsNamespace(c, resource.OrganizationID) {
+		return apiError(c, 403, "Unauthorized")
+	}
+
+	var version models.ResourceVersion
+	if err := database.DB.Where("resource_id = ? AND version = ?", resource.ID, c.Params("version")).First(&version).Error; err != nil {
+		return apiError(c, 404, "Version not found")
+	}
+
+	reason := ""
+	var yankedAt *time.Time
+	if yanked {
+		now := time.Now()
+		reason, yankedAt = noticeValue(c, "reason"), &now
+	}
+	before := yankAuditFields(version.Version, version.Yanked, version.YankReason)
+	updates := map[string]interface{}{"yanked": yanked, "yank_reason": reason, "yanked_at": yankedAt}
+	if err := database.DB.Model(&version).Updates(updates).Error; err != nil {
+		return apiError(c, 500, "Could not update version")
+	}
+	refreshSearchIndex(resource.ID) // The latest version may have changed
+	action := AuditResourceUnyank
+	if yanked {
+		action = AuditResourceYank
+	}
+	auditResource(c, action, resource, auditDiff(before, yankAuditFields(version.Version, yanked, reason)))
+
+	if isTokenRequest(c) {
+		return c.JSON(fiber.Map{"resource_id": resource.ID, "version": version.Version, "yanked": yanked, "yank_reason": reason})
+	}
+	if yanked {
+		SetFlash(c, "success", "Version "+version.Version+" has been yanked.")
+	} else {
+		SetFlash(c, "success", "Version "+version.Version+" has been restored.")
+	}
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// PostDeprecateResource godoc
+// @Summary Deprecate a resource
+// @Description Mark a resource as deprecated with a message and an optional successor. Clients show the notice when the resource is used. Calling it again updates the notice.
+// @Tags resources
+// @Param id path string true "Resource ID"
+// @Param message formData string false "Deprecation message"
+// @Param successor formData string false "Resource to use instead, as namespace/name"
+// @Success 200 {object} object
+// @Failure 400 {string} string "Bad Request"
+// @Failure 403 {string} string "Unauthorized"
+// @Security BearerAuth
+// @Router /resource/{id}/deprecate [post]
+func PostDeprecateResource(c *fiber.Ctx) error {
+	var resource models.NomadResource
+	if err := database.DB.First(&resource, c.Params("id")).Error; err != nil {
+		return apiError(c, 404, "Resource not found")
+	}
+	if !hasPermission(currentUserID(c), resource, PermEdit) || !tokenAllowsNamespace(c, resource.OrganizationID) {
+		return apiError(c, 403, "Unauthorized")
+	}
+
+	var successorID *uint
+	if ref := strings.Trim(strings.TrimSpace(c.FormValue("successor")), "/"); ref != "" {
+		parts := strings.SplitN(ref, "/", 2)
+		if len(parts) != 2 {
+			return apiError(c, 400, "Successor must be given as namespace/name")
+		}
+		successor, err := findResource(parts[0], parts[1])
+		if err != nil || !canViewResource(c, successor) {
+			return apiError(c, 400, "Successor "+ref+" not found")
+		}
+		if successor.ID == resource.ID {
+			return apiError(c, 400, "A resource cannot be its own successor")
+		}
+		successorID = &successor.ID
+	}
+
+	before := deprecationAuditFields(resource)
+	resource.Deprecated = true
+	resource.DeprecationMessage = noticeValue(c, "message")
+	resource.SuccessorID = successorID
+	if err := database.DB.Model(&resource).Updates(map[string]interface{}{
+		"deprecated": true, "deprecation_message": resource.DeprecationMessage, "successor_id": successorID,
+	}).Error; err != nil {
+		return apiError(c, 500, "Could not deprecate resource")
+	}
+
+	auditResource(c, AuditResourceDeprecate, resource, auditDiff(before, deprecationAuditFields(resource)))
+
+	if isTokenRequest(c) {
+		return c.JSON(fiber.Map{"id": resource.ID, "deprecation": resourceDeprecation(resource)})
+	}
+	SetFlash(c, "success", "Resource '"+resource.Name+"' has been marked as deprecated.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// PostUndeprecateResource godoc
+// @Summary Remove a deprecation
+// @Description Clear the deprecation notice of a resource.
+// @Tags resources
+// @Param id path string true "Resource ID"
+// @Success 200 {object} object
+// @Failure 403 {string} string "Unauthorized"
+// @Security BearerAuth
+// @Router /resource/{id}/undeprecate [post]
+func PostUndeprecateResource(c *fiber.Ctx) error {
+	var resource models.NomadResource
+	if err := database.DB.First(&resource, c.Params("id")).Error; err != nil {
+		return apiError(c, 404, "Resource not found")
+	}
+	if !hasPermission(currentUserID(c), resource, PermEdit) || !tokenAllowsNamespace(c, resource.OrganizationID) {
+		return apiError(c, 403, "Unauthorized")
+	}
+
+	before := deprecationAuditFields(resource)
+	if err := database.DB.Model(&resource).Updates(map[string]interface{}{
+		"deprecated": false, "deprecation_message": "", "successor_id": nil,
+	}).Error; err != nil {
+		return apiError(c, 500, "Could not update resource")
+	}
+	resource.Deprecated, resource.DeprecationMessage, resource.SuccessorID = false, "", nil
+	auditResource(c, AuditResourceUndeprecate, resource, auditDiff(before, deprecationAuditFields(resource)))
+
+	if isTokenRequest(c) {
+		return c.JSON(fiber.Map{"id": resource.ID, "deprecated": false})
+	}
+	SetFlash(c, "success", "Resource '"+resource.Name+"' is no longer deprecated.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
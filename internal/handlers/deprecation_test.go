@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostYankVersion(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "yankuser1")
+	resource := createTestJob(t, user.ID, "yank-job-1")
+	database.DB.Create(&models.ResourceVersion{ResourceID: resource.ID, Version: "v1.0.0", Content: "job \"a\" {}"})
+	database.DB.Create(&models.ResourceVersion{ResourceID: resource.ID, Version: "v1.1.0", Content: "job \"b\" {}"})
+
+	app := setupAuthenticatedApp(user)
+	app.Post("/resource/:id/versions/:version/yank", PostYankVersion)
+	app.Post("/resource/:id/versions/:version/unyank", PostUnyankVersion)
+	app.Get("/:username/:resourcename/v/:version/raw", GetRawResourceVersion)
+
+	req := httptest.NewRequest("POST", "/resource/"+toString(resource.ID)+"/versions/v1.1.0/yank", strings.NewReader("reason=Leaks+credentials"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var versions []models.ResourceVersion
+	database.DB.Where("resource_id = ?", resource.ID).Find(&versions)
+	latest, ok := latestVersion(versions)
+	require.True(t, ok)
+	assert.Equal(t, "v1.0.0", latest.Version)
+
+	// Still fetchable when pinned, with a warning
+	req = httptest.NewRequest("GET", "/yankuser1/yank-job-1/v/v1.1.0/raw", nil)
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "version v1.1.0 has been yanked: Leaks credentials", resp.Header.Get("X-Ramble-Warning"))
+
+	req = httptest.NewRequest("POST", "/resource/"+toString(resource.ID)+"/versions/v1.1.0/unyank", nil)
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var restored models.ResourceVersion
+	database.DB.Where("resource_id = ? AND version = ?", resource.ID, "v1.1.0").First(&restored)
+	assert.False(t, restored.Yanked)
+	assert.Empty(t, restored.YankReason)
+	assert.Nil(t, restored.YankedAt)
+}
+
+func TestPostYankVersion_NotOwner(t *testing.T) {
+	defer cleanupTestData(t)
+
+	owner := createTestUser(t, "yankuser2")
+	other := createTestUser(t, "yankuser3")
+	resource := createTestPack(t, owner.ID, "yank-pack-2")
+
+	app := setupAuthenticatedApp(other)
+	app.Post("/resource/:id/versions/:version/yank", PostYankVersion)
+
+	req := httptest.NewRequest("POST", "/resource/"+toString(resource.ID)+"/versions/v1.0.0/yank", nil)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
+}
+
+func TestPostDeprecateResource(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "deprecateuser1")
+	resource := createTestPack(t, user.ID, "old-pack")
+	createTestPack(t, user.ID, "new-pack")
+
+	app := setupAuthenticatedApp(user)
+	app.Post("/resource/:id/deprecate", PostDeprecateResource)
+	app.Post("/resource/:id/undeprecate", PostUndeprecateResource)
+	app.Get("/:username/v1/packs/:packname", GetPackAPI)
+
+	req := httptest.NewRequest("POST", "/resource/"+toString(resource.ID)+"/deprecate", strings.NewReader("message=Superseded&successor=deprecateuser1/new-pack"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	req = httptest.NewRequest("GET", "/deprecateuser1/v1/packs/old-pack", nil)
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	var detail PackDetail
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&detail))
+	require.NotNil(t, detail.Deprecation)
+	assert.Equal(t, "Superseded", detail.Deprecation.Message)
+	assert.Equal(t, "deprecateuser1/new-pack", detail.Deprecation.Successor)
+
+	req = httptest.NewRequest("POST", "/resource/"+toString(resource.ID)+"/undeprecate", nil)
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var updated models.NomadResource
+	database.DB.First(&updated, resource.ID)
+	assert.False(t, updated.Deprecated)
+	assert.Nil(t, updated.SuccessorID)
+}
+
+func TestPostDeprecateResource_InvalidSuccessor(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "deprecateuser2")
+	resource := createTestPack(t, user.ID, "lonely-pack")
+
+	app := setupAuthenticatedApp(user)
+	app.Post("/resource/:id/deprecate", PostDeprecateResource)
+
+	for _, successor := range []string{"missing/pack", "lonely-pack", "deprecateuser2/lonely-pack"} {
+		req := httptest.NewRequest("POST", "/resource/"+toString(resource.ID)+"/deprecate", strings.NewReader("successor="+successor))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode, successor)
+	}
+
+	var updated models.NomadResource
+	database.DB.First(&updated, resource.ID)
+	assert.False(t, updated.Deprecated)
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	statsDefaultDays = 30
+	statsMaxDays     = 365
+)
+
+// DailyDownloads is the number of downloads on one day
+type DailyDownloads struct {
+	Date      string `json:"date"` // YYYY-MM-DD, UTC
+	Downloads int    `json:"downloads"`
+}
+
+// DownloadStats summarizes the downloads of a resource over recent days
+type DownloadStats struct {
+	Total    int              `json:"total"` // All time, including downloads counted before daily statistics
+	Days     int              `json:"days"`
+	Daily    []DailyDownloads `json:"daily"`    // Oldest first, one entry for every day of the period
+	Versions map[string]int   `json:"versions"` // Downloads in the period per version
+	Clients  map[string]int   `json:"clients"`  // Downloads in the period per kind of client
+}
+
+// downloadDay is the UTC day a download at t counts towards
+func downloadDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// downloadClient tells the kind of client that made a request from its
+// User-Agent. nomad-pack fetches with Go's default client; the ramble CLI
+// names itself.
+func downloadClient(c *fiber.Ctx) string {
+	ua := strings.ToLower(c.Get("User-Agent"))
+	switch {
+	case strings.HasPrefix(ua, "ramble-cli"):
+		return models.DownloadClientCLI
+	case strings.Contains(ua, "nomad-pack"), strings.HasPrefix(ua, "go-http-client"), strings.HasPrefix(ua, "go-getter"):
+		return models.DownloadClientNomadPack
+	case strings.HasPrefix(ua, "mozilla/"):
+		return models.DownloadClientBrowser
+	default:
+		return models.DownloadClientOther
+	}
+}
+
+// recordDownload counts a download of a version, once per client IP and
+// day. The first download of the day from an IP is added to the day's
+// statistics and the resource's total; repeats are ignored.
+func recordDownload(c *fiber.Ctx, resource models.NomadResource, version string) {
+	if c.Method() == fiber.MethodHead {
+		return
+	}
+
+	day := downloadDay(time.Now())
+	visitor := sha256.Sum256([]byte(day.Format("2006-01-02") + "|" + c.IP()))
+	event := models.DownloadEvent{
+		ResourceID: resource.ID,
+		Version:    version,
+		Day:        day,
+		Visitor:    hex.EncodeToString(visitor[:]),
+	}
+	result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&event)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+
+	database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "resource_id"}, {Name: "version"}, {Name: "day"}, {Name: "client"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("download_stats.count + 1")}),
+	}).Create(&models.DownloadStat{
+		ResourceID: resource.ID,
+		Version:    version,
+		Day:        day,
+		Client:     downloadClient(c),
+		Count:      1,
+	})
+	// UpdateColumn leaves updated_at alone, so downloads don't reorder "latest"
+	database.DB.Model(&resource).UpdateColumn("download_count", gorm.Expr("download_count + ?", 1))
+
+	// Earlier days' events can no longer deduplicate anything
+	database.DB.Where("day < ?", day).Delete(&models.DownloadEvent{})
+}
+
+// downloadStats sums a resource's downloads over the given number of days,
+// today included
+func downloadStats(resource models.NomadResource, days int) DownloadStats {
+	today := downloadDay(time.Now())
+	since := today.AddDate(0, 0, -(days - 1))
+	stats := DownloadStats{
+		Total:    resource.DownloadCount,
+		Days:     days,
+		Daily:    make([]DailyDownloads, days),
+		Versions: map[string]int{},
+		Clients:  map[string]int{},
+	}
+
+	var rows []models.DownloadStat
+	database.DB.Where("resource_id = ? AND day >= ?", resource.ID, since).Find(&rows)
+
+	byDay := map[string]int{}
+	for _, r := range rows {
+		byDay[r.Day.UTC().Format("2006-01-02")] += r.Count
+		stats.Versions[r.Version] += r.Count
+		stats.Clients[r.Client] += r.Count
+	}
+	for i := range stats.Daily {
+		date := since.AddDate(0, 0, i).Format("2006-01-02")
+		stats.Daily[i] = DailyDownloads{Date: date, Downloads: byDay[date]}
+	}
+	return stats
+}
+
+// sparklinePoints lays daily downloads out as the points of an SVG polyline
+// of the given size, oldest on the left
+func sparklinePoints(daily []DailyDownloads, width, height int) string {
+	max := 0
+	for _, d := range daily {
+		if d.Downloads > max {
+			max = d.Downloads
+		}
+	}
+	points := make([]string, len(daily))
+	for i, d := range daily {
+		x := 0.0
+		if len(daily) > 1 {
+			x = float64(i) * float64(width) / float64(len(daily)-1)
+		}
+		y := float64(height)
+		if max > 0 {
+			y -= float64(d.Downloads) * float64(height) / float64(max)
+		}
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+	return strings.Join(points, " ")
+}
+
+// GetResourceStats godoc
+// @Summary Get download statistics
+// @Description Daily downloads of a pack or job over recent days, with totals per version and per kind of client (cli, browser, nomad-pack or other). Each IP counts once per version per day.
+// @Tags resources
+// @Produce json
+// @Param username path string true "User or Organization namespace"
+// @Param resourcename path string true "Resource name"
+// @Param days query int false "Days to cover, up to 365 (default 30)"
+// @Success 200 {object} DownloadStats
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /{username}/{resourcename}/stats [get]
+func GetResourceStats(c *fiber.Ctx) error {
+	resource, err := findResource(c.Params("username"), c.Params("resourcename"))
+	if err != nil || !canViewResource(c, resource) {
+		return c.Status(404).JSON(fiber.Map{"error": "Resource not found"})
+	}
+
+	days := c.QueryInt("days", statsDefaultDays)
+	if days < 1 || days > statsMaxDays {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("days must be between 1 and %d", statsMaxDays)})
+	}
+
+	return c.JSON(downloadStats(resource, days))
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fetchStats calls the stats endpoint of a resource
+func fetchStats(t *testing.T, path string) DownloadStats {
+	app := setupTestApp()
+	app.Get("/:username/:resourcename/stats", GetResourceStats)
+
+	resp, err := app.Test(httptest.NewRequest("GET", path+"/stats?days=7", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var stats DownloadStats
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	return stats
+}
+
+func TestRecordDownload_OncePerIPAndDay(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "downloaduser1")
+	job := createTestJob(t, user.ID, "counted-job")
+	database.DB.Create(&models.ResourceVersion{ResourceID: job.ID, Version: "v1.0.0", Content: `job "counted" {}`})
+
+	app := setupTestApp()
+	app.Get("/:username/:resourcename/raw", GetRawResource)
+	app.Get("/:username/:resourcename/v/:version/raw", GetRawResourceVersion)
+
+	for _, path := range []string{"/downloaduser1/counted-job/raw", "/downloaduser1/counted-job/v/v1.0.0/raw"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("User-Agent", "ramble-cli/1.4.0")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		require.Equal(t, 200, resp.StatusCode)
+	}
+
+	// Both requests came from the same IP on the same day
+	stats := fetchStats(t, "/downloaduser1/counted-job")
+	assert.Equal(t, 1, stats.Total)
+	require.Len(t, stats.Daily, 7)
+	assert.Equal(t, 1, stats.Daily[6].Downloads)
+	assert.Equal(t, map[string]int{"v1.0.0": 1}, stats.Versions)
+	assert.Equal(t, map[string]int{models.DownloadClientCLI: 1}, stats.Clients)
+}
+
+func TestRecordDownload_OrgOwned(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "downloaduser2")
+	org := models.Organization{Name: "downloadorg", Description: "Test"}
+	require.NoError(t, database.DB.Create(&org).Error)
+	defer database.DB.Unscoped().Delete(&org)
+	job := createTestJob(t, user.ID, "org-job")
+	database.DB.Model(&job).Update("organization_id", org.ID)
+	database.DB.Create(&models.ResourceVersion{ResourceID: job.ID, Version: "v1.0.0", Content: `job "org" {}`})
+
+	app := setupTestApp()
+	app.Get("/:username/:resourcename/raw", GetRawResource)
+	req := httptest.NewRequest("GET", "/downloadorg/org-job/raw", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	stats := fetchStats(t, "/downloadorg/org-job")
+	assert.Equal(t, 1, stats.Total)
+	assert.Equal(t, map[string]int{models.DownloadClientBrowser: 1}, stats.Clients)
+}
+
+func TestSparklinePoints(t *testing.T) {
+	daily := []DailyDownloads{{Downloads: 0}, {Downloads: 4}, {Downloads: 2}}
+	assert.Equal(t, "0.0,20.0 50.0,0.0 100.0,10.0", sparklinePoints(daily, 100, 20))
+	assert.Equal(t, "0.0,20.0 100.0,20.0", sparklinePoints(make([]DailyDownloads, 2), 100, 20))
+}
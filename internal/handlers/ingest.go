@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rmbl/internal/database"
+	"rmbl/internal/gitprovider"
+	"rmbl/internal/models"
+	"strings"
+)
+
+// versionRef returns the git ref a version is read from. Tagged versions use
+// their own name unless an explicit ref was given. Branch-tracking versions
+// follow the resource's default branch, and an empty result means the
+// repository's default branch.
+func versionRef(resource models.NomadResource, version models.ResourceVersion) string {
+	if version.Ref != "" {
+		return version.Ref
+	}
+	if version.TrackBranch {
+		return resource.DefaultBranch
+	}
+	return version.Version
+}
+
+// sourceRef returns the ref to download a version at: the commit it was
+// ingested from once that is known, so links match the stored content
+func sourceRef(resource models.NomadResource, version models.ResourceVersion) string {
+	if version.CommitSHA != "" {
+		return version.CommitSHA
+	}
+	return versionRef(resource, version)
+}
+
+// ingestVersion fetches the README, content and variables of a version and
+// stores them on the version row, along with the commit they were read from.
+// The version's ref is resolved to a commit first and every file is read at
+// that commit, so a version never mixes files from different points in time.
+// For packs it also snapshots the pack tree into registry storage and reads
+// the files from that snapshot, so what the detail page shows matches what
+// clients download.
+//
+// It is safe to call again for an existing version: tagged versions are only
+// snapshotted once, and branch-tracking versions pick up the branch head.
+// Nothing is stored if it fails. Callers normally go through the ingestion
+// queue (see enqueueIngest) rather than calling this directly.
+func ingestVersion(resourceID uint, versionStr string) error {
+	var resource models.NomadResource
+	if err := database.DB.First(&resource, resourceID).Error; err != nil {
+		return err
+	}
+	var version models.ResourceVersion
+	if err := database.DB.Where("resource_id = ? AND version = ?", resourceID, versionStr).First(&version).Error; err != nil {
+		return err
+	}
+
+	provider, err := gitprovider.New(resource.GitProvider, resource.RepositoryURL, sourceToken(resource))
+	if err != nil {
+		return err
+	}
+	ref := versionRef(resource, version)
+	sha, err := provider.CommitSHA(ref)
+	if err != nil {
+		return fmt.Errorf("could not resolve ref %q: %w", ref, err)
+	}
+	version.CommitSHA = sha
+
+	// Missing files are left empty; any other fetch error fails the
+	// ingestion so the queue retries it
+	var fetchErr error
+	fetch := func(name string) string {
+		body, err := provider.FetchFile(sha, name)
+		if err != nil {
+			if !errors.Is(err, gitprovider.ErrNotFound) && fetchErr == nil {
+				fetchErr = fmt.Errorf("could not fetch %s: %w", name, err)
+			}
+			return ""
+		}
+		return string(body)
+	}
+
+	readme := fetch("README.md")
+	var content string
+	var variablesJSON string
+	var digest string
+	if resource.Type == models.ResourceTypeJob {
+		fetchPath := resource.FilePath
+		if fetchPath == "" {
+			fetchPath = resource.Name
+			if !strings.HasSuffix(fetchPath, ".nomad.hcl") {
+				fetchPath = fetchPath + ".nomad.hcl"
+			}
+		}
+		content = fetch(fetchPath)
+		if content == "" && fetchErr == nil {
+			fetchErr = fmt.Errorf("%s not found at %s", fetchPath, sha)
+		}
+		digest = contentDigest([]byte(content))
+	} else if resource.Type == models.ResourceTypePack {
+		archive, err := ensureVersionArchive(resource, version)
+		if err != nil {
+			return fmt.Errorf("could not snapshot pack: %w", err)
+		}
+		digest = "sha256:" + archive.SHA256
+		files, err := readArchiveFiles(archive.Data, "README.md", "metadata.hcl", "variables.hcl")
+		if err != nil {
+			return fmt.Errorf("could not read snapshot: %w", err)
+		}
+
+		if r, ok := files["README.md"]; ok {
+			readme = r
+		}
+		varsContent := files["variables.hcl"]
+		if varsContent != "" {
+			if vars, err := parsePackVariables(varsContent); err == nil {
+				if b, err := json.Marshal(vars); err == nil {
+					variablesJSON = string(b)
+				}
+			}
+		}
+		content = files["metadata.hcl"]
+	}
+
+	if fetchErr != nil {
+		return fetchErr
+	}
+
+	updates := map[string]interface{}{
+		"readme": readme, "content": content, "variables": variablesJSON, "commit_sha": sha, "digest": digest,
+	}
+	// A signature only vouches for the content it was made over
+	if digest != version.Digest {
+		updates["signature"], updates["signed_by"] = "", ""
+	}
+	return database.DB.Model(&version).Updates(updates).Error
+}
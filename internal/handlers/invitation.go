@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"rmbl/internal/services/email"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// invitationTTL is how long an invitation link stays valid
+const invitationTTL = 7 * 24 * time.Hour
+
+// generateInvitationToken returns a new plaintext invitation token and its hash
+func generateInvitationToken() (string, string) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("failed to generate invitation token: " + err.Error())
+	}
+	token := hex.EncodeToString(b)
+	return token, hashAPIToken(token)
+}
+
+// findInvitation looks up an unexpired invitation by its plaintext token
+func findInvitation(token string) (models.Invitation, error) {
+	var invitation models.Invitation
+	err := database.DB.Preload("Organization").Preload("InvitedBy").
+		Where("token_hash = ? AND expires_at > ?", hashAPIToken(token), time.Now()).
+		First(&invitation).Error
+	return invitation, err
+}
+
+// pendingInvitation returns the invitation a logged-out visitor followed a
+// link to, which their session remembers until they sign up or log in
+func pendingInvitation(c *fiber.Ctx) (models.Invitation, string, bool) {
+	sess, err := Store.Get(c)
+	if err != nil {
+		return models.Invitation{}, "", false
+	}
+	token, _ := sess.Get("invite_token").(string)
+	if token == "" {
+		return models.Invitation{}, "", false
+	}
+	invitation, err := findInvitation(token)
+	return invitation, token, err == nil
+}
+
+// invitationFor reports whether an invitation was sent to the user's
+// verified email address. Only that account can accept it: the link alone
+// isn't enough, since it may have been forwarded or leaked.
+func invitationFor(invitation models.Invitation, user models.User) bool {
+	return user.EmailVerified && strings.EqualFold(invitation.Email, user.Email)
+}
+
+// joinInvitation makes the user a member in the invitation's role and uses
+// the invitation up. Users who already are members keep their role.
+func joinInvitation(c *fiber.Ctx, user models.User, invitation models.Invitation) {
+	if orgRole(user.ID, invitation.OrganizationID) == "" {
+		database.DB.Create(&models.Membership{
+			UserID:         user.ID,
+			OrganizationID: invitation.OrganizationID,
+			Role:           invitation.Role,
+		})
+		recordAuditBy(c, user.ID, AuditOrgJoin, AuditTargetOrganization, invitation.OrganizationID, invitation.Organization.Name,
+			map[string]AuditChange{"members." + user.Username: {After: invitation.Role}})
+	}
+	database.DB.Delete(&invitation)
+}
+
+// PostInviteMember invites someone to the organization by username or email
+// address. They get an email with a link to accept or decline, and can sign
+// up from it if they have no account yet.
+func PostInviteMember(c *fiber.Ctx) error {
+	orgID := c.Locals("OrgID").(uint)
+	invitee := strings.TrimSpace(c.FormValue("invitee"))
+	role := c.FormValue("role", models.RoleViewer)
+	if !isValidRole(role) {
+		return c.Status(400).SendString("Unknown role")
+	}
+	if role == models.RoleOwner && orgRole(currentUserID(c), orgID) != models.RoleOwner {
+		return c.Status(403).SendString("Only owners can invite owners")
+	}
+	if invitee == "" {
+		return c.Status(400).SendString("Enter a username or email address")
+	}
+
+	var user models.User
+	emailAddr := invitee
+	if strings.Contains(invitee, "@") {
+		database.DB.Where("email ILIKE ?", escapeLikeString(invitee)).First(&user)
+	} else {
+		if err := database.DB.Where("username = ?", invitee).First(&user).Error; err != nil {
+			return c.Status(404).SendString("User not found")
+		}
+		emailAddr = user.Email
+	}
+	if user.ID != 0 && orgRole(user.ID, orgID) != "" {
+		return c.Status(400).SendString("User is already a member")
+	}
+
+	var org models.Organization
+	database.DB.First(&org, orgID)
+	var inviter models.User
+	database.DB.First(&inviter, currentUserID(c))
+
+	// Inviting the same address again replaces the earlier invitation
+	database.DB.Where("organization_id = ? AND email ILIKE ?", orgID, escapeLikeString(emailAddr)).Delete(&models.Invitation{})
+
+	token, tokenHash := generateInvitationToken()
+	invitation := models.Invitation{
+		OrganizationID: orgID,
+		Email:          emailAddr,
+		Role:           role,
+		TokenHash:      tokenHash,
+		InvitedByID:    inviter.ID,
+		ExpiresAt:      time.Now().Add(invitationTTL),
+	}
+	if err := database.DB.Create(&invitation).Error; err != nil {
+		return c.Status(500).SendString("Could not create invitation")
+	}
+	recordAudit(c, AuditOrgInvite, AuditTargetOrganization, orgID, org.Name,
+		map[string]AuditChange{"invitations." + emailAddr: {After: role}})
+
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:3000"
+	}
+	link := fmt.Sprintf("%s/invitations/%s", baseURL, token)
+	inviterName := inviter.Name
+	if inviterName == "" {
+		inviterName = inviter.Username
+	}
+	if err := email.SendInvitationEmail(emailAddr, org.Name, inviterName, role, link); err != nil {
+		// Log error but keep the invitation; it can be revoked and sent again
+		fmt.Printf("Failed to send invitation email: %v\n", err)
+	}
+
+	SetFlash(c, "success", "Invitation sent to "+emailAddr+".")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// PostRevokeInvitation withdraws a pending invitation. Like adding owners,
+// only owners can revoke an invitation to become one.
+func PostRevokeInvitation(c *fiber.Ctx) error {
+	orgID := c.Locals("OrgID").(uint)
+
+	var invitation models.Invitation
+	if err := database.DB.Where("id = ? AND organization_id = ?", c.Params("id"), orgID).First(&invitation).Error; err != nil {
+		return c.Status(404).SendString("Invitation not found")
+	}
+	if invitation.Role == models.RoleOwner && orgRole(currentUserID(c), orgID) != models.RoleOwner {
+		return c.Status(403).SendString("Only owners can revoke invitations to become an owner")
+	}
+	database.DB.Delete(&invitation)
+	auditOrg(c, AuditOrgRevokeInvitation, orgID, map[string]AuditChange{"invitations." + invitation.Email: {Before: invitation.Role}})
+
+	SetFlash(c, "success", "Invitation revoked.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// GetInvitation shows an invitation with buttons to accept or decline it.
+// Logged-out visitors are offered to sign up or log in first; their session
+// remembers the invitation so signing up joins the organization.
+func GetInvitation(c *fiber.Ctx) error {
+	token := c.Params("token")
+	invitation, err := findInvitation(token)
+	if err != nil {
+		SetFlash(c, "error", "This invitation is invalid or has expired.")
+		return c.Redirect("/")
+	}
+
+	var user models.User
+	loggedIn := currentUserID(c) != 0 && database.DB.First(&user, currentUserID(c)).Error == nil
+	if !loggedIn {
+		if sess, err := Store.Get(c); err == nil {
+			sess.Set("invite_token", token)
+			sess.Save()
+		}
+	}
+
+	return c.Render("invitation", MergeContext(BaseContext(c), fiber.Map{
+		"Invitation": invitation,
+		"Token":      token,
+		"LoggedIn":   loggedIn,
+		"CanAccept":  invitationFor(invitation, user),
+	}), "layouts/main")
+}
+
+// PostAcceptInvitation joins the organization in the invited role. Only the
+// account with the invited, verified email address can accept.
+func PostAcceptInvitation(c *fiber.Ctx) error {
+	invitation, err := findInvitation(c.Params("token"))
+	if err != nil {
+		return c.Status(404).SendString("This invitation is invalid or has expired")
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, currentUserID(c)).Error; err != nil {
+		return c.Status(401).SendString("Please log in first")
+	}
+	if !invitationFor(invitation, user) {
+		if strings.EqualFold(invitation.Email, user.Email) {
+			return c.Status(403).SendString("Verify your email address to accept this invitation")
+		}
+		return c.Status(403).SendString("This invitation was sent to " + invitation.Email + ". Log in with that address to accept it.")
+	}
+	joinInvitation(c, user, invitation)
+	forgetInvitation(c)
+
+	SetFlash(c, "success", "You have joined "+invitation.Organization.Name+".")
+	c.Set("HX-Redirect", "/"+invitation.Organization.Name)
+	return c.SendStatus(200)
+}
+
+// PostDeclineInvitation turns an invitation down. Anyone with the link can,
+// so it works without an account.
+func PostDeclineInvitation(c *fiber.Ctx) error {
+	invitation, err := findInvitation(c.Params("token"))
+	if err != nil {
+		return c.Status(404).SendString("This invitation is invalid or has expired")
+	}
+	database.DB.Delete(&invitation)
+	forgetInvitation(c)
+
+	SetFlash(c, "success", "Invitation declined.")
+	c.Set("HX-Redirect", "/")
+	return c.SendStatus(200)
+}
+
+// forgetInvitation drops the invitation the session remembers
+func forgetInvitation(c *fiber.Ctx) {
+	sess, err := Store.Get(c)
+	if err != nil || sess.Get("invite_token") == nil {
+		return
+	}
+	sess.Delete("invite_token")
+	sess.Save()
+}
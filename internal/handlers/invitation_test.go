@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createTestInvitation invites an email address to an organization and
+// returns the plaintext token of the link
+func createTestInvitation(t *testing.T, org models.Organization, inviter models.User, email, role string, expiresAt time.Time) string {
+	token, tokenHash := generateInvitationToken()
+	require.NoError(t, database.DB.Create(&models.Invitation{
+		OrganizationID: org.ID,
+		Email:          email,
+		Role:           role,
+		TokenHash:      tokenHash,
+		InvitedByID:    inviter.ID,
+		ExpiresAt:      expiresAt,
+	}).Error)
+	return token
+}
+
+func TestPostInviteMember_ByEmail(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	owner := createTestUser(t, "inviteowner1")
+	org, _ := createTestOrgResource(t, "testinviteorg1",
+		models.Membership{UserID: owner.ID, Role: models.RoleOwner},
+	)
+
+	app := setupAuthenticatedApp(owner)
+	app.Post("/orgs/:orgname/invitations", RequireOrgOwner, PostInviteMember)
+	invite := func(role string) int {
+		req := httptest.NewRequest("POST", "/orgs/testinviteorg1/invitations", strings.NewReader("invitee=newcomer@test.com&role="+role))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	// People without an account can be invited, and inviting again replaces the invitation
+	assert.Equal(t, 200, invite(models.RolePublisher))
+	assert.Equal(t, 200, invite(models.RoleMaintainer))
+	var invitations []models.Invitation
+	database.DB.Where("organization_id = ?", org.ID).Find(&invitations)
+	require.Len(t, invitations, 1)
+	assert.Equal(t, "newcomer@test.com", invitations[0].Email)
+	assert.Equal(t, models.RoleMaintainer, invitations[0].Role)
+	assert.True(t, invitations[0].ExpiresAt.After(time.Now().Add(6*24*time.Hour)))
+
+	assert.Equal(t, 400, invite("admin"))
+}
+
+func TestPostAcceptInvitation(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	owner := createTestUser(t, "acceptowner")
+	invitee := createTestUser(t, "acceptinvitee")
+	org, _ := createTestOrgResource(t, "testacceptorg",
+		models.Membership{UserID: owner.ID, Role: models.RoleOwner},
+	)
+	token := createTestInvitation(t, org, owner, invitee.Email, models.RolePublisher, time.Now().Add(time.Hour))
+
+	// The link alone isn't enough: another account can't accept it
+	other := createTestUser(t, "acceptother")
+	otherApp := setupAuthenticatedApp(other)
+	otherApp.Post("/invitations/:token/accept", PostAcceptInvitation)
+	resp, err := otherApp.Test(httptest.NewRequest("POST", "/invitations/"+token+"/accept", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
+	assert.Empty(t, orgRole(other.ID, org.ID))
+
+	app := setupAuthenticatedApp(invitee)
+	app.Get("/invitations/:token", GetInvitation)
+	app.Post("/invitations/:token/accept", PostAcceptInvitation)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/invitations/"+token, nil))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest("POST", "/invitations/"+token+"/accept", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "/testacceptorg", resp.Header.Get("HX-Redirect"))
+	assert.Equal(t, models.RolePublisher, orgRole(invitee.ID, org.ID))
+
+	// The link only works once
+	resp, err = app.Test(httptest.NewRequest("POST", "/invitations/"+token+"/accept", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestPostDeclineInvitation(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	owner := createTestUser(t, "declineowner")
+	org, _ := createTestOrgResource(t, "testdeclineorg",
+		models.Membership{UserID: owner.ID, Role: models.RoleOwner},
+	)
+	token := createTestInvitation(t, org, owner, "decliner@test.com", models.RoleViewer, time.Now().Add(time.Hour))
+
+	// Declining needs no account
+	app := setupTestApp()
+	app.Post("/invitations/:token/decline", PostDeclineInvitation)
+	resp, err := app.Test(httptest.NewRequest("POST", "/invitations/"+token+"/decline", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var count int64
+	database.DB.Model(&models.Invitation{}).Where("organization_id = ?", org.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestInvitation_Expired(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	owner := createTestUser(t, "expiredowner")
+	invitee := createTestUser(t, "expiredinvitee")
+	org, _ := createTestOrgResource(t, "testexpiredorg",
+		models.Membership{UserID: owner.ID, Role: models.RoleOwner},
+	)
+	token := createTestInvitation(t, org, owner, invitee.Email, models.RoleViewer, time.Now().Add(-time.Minute))
+
+	app := setupAuthenticatedApp(invitee)
+	app.Get("/invitations/:token", GetInvitation)
+	app.Post("/invitations/:token/accept", PostAcceptInvitation)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/invitations/"+token, nil))
+	require.NoError(t, err)
+	assert.Equal(t, 302, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest("POST", "/invitations/"+token+"/accept", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+	assert.Equal(t, "", orgRole(invitee.ID, org.ID))
+}
+
+func TestPostRevokeInvitation(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	owner := createTestUser(t, "revokeowner")
+	maintainer := createTestUser(t, "revokemaintainer")
+	org, _ := createTestOrgResource(t, "testrevokeorg",
+		models.Membership{UserID: owner.ID, Role: models.RoleOwner},
+		models.Membership{UserID: maintainer.ID, Role: models.RoleMaintainer},
+	)
+	database.DB.Model(&org).Update("role_permissions", `{"maintainer":["manage_members"]}`)
+	createTestInvitation(t, org, owner, "future-owner@test.com", models.RoleOwner, time.Now().Add(time.Hour))
+	var invitation models.Invitation
+	require.NoError(t, database.DB.Where("organization_id = ?", org.ID).First(&invitation).Error)
+
+	revoke := func(actor models.User) int {
+		app := setupAuthenticatedApp(actor)
+		app.Post("/orgs/:orgname/invitations/:id/revoke", RequireOrgPermission(PermManageMembers), PostRevokeInvitation)
+		resp, err := app.Test(httptest.NewRequest("POST", "/orgs/testrevokeorg/invitations/"+toString(invitation.ID)+"/revoke", nil))
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, 403, revoke(maintainer), "only owners revoke owner invitations")
+	assert.Equal(t, 200, revoke(owner))
+	assert.Equal(t, 404, revoke(owner))
+}
+
+func TestPostSignup_FromInvitation(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	owner := createTestUser(t, "signupinviteowner")
+	org, _ := createTestOrgResource(t, "testsignupinviteorg",
+		models.Membership{UserID: owner.ID, Role: models.RoleOwner},
+	)
+	token := createTestInvitation(t, org, owner, "invitedsignup@test.com", models.RolePublisher, time.Now().Add(time.Hour))
+
+	app := setupTestApp()
+	app.Get("/invitations/:token", GetInvitation)
+	app.Post("/signup", PostSignup)
+
+	// Following the link while logged out remembers the invitation in the session
+	resp, err := app.Test(httptest.NewRequest("GET", "/invitations/"+token, nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	cookie := resp.Header.Get("Set-Cookie")
+	require.NotEmpty(t, cookie)
+
+	payload := strings.NewReader("username=invitedsignup&name=Invited&email=invitedsignup@test.com&password=SecurePass123!")
+	req := httptest.NewRequest("POST", "/signup", payload)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Cookie", strings.Split(cookie, ";")[0])
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "/testsignupinviteorg", resp.Header.Get("HX-Redirect"))
+
+	var user models.User
+	require.NoError(t, database.DB.Where("username = ?", "invitedsignup").First(&user).Error)
+	assert.Equal(t, models.RolePublisher, orgRole(user.ID, org.ID))
+	// The invitation was sent to this address, so it counts as verified
+	assert.True(t, user.EmailVerified)
+
+	// Signing up from the link with another address doesn't join
+	token = createTestInvitation(t, org, owner, "invitedagain@test.com", models.RoleViewer, time.Now().Add(time.Hour))
+	resp, err = app.Test(httptest.NewRequest("GET", "/invitations/"+token, nil))
+	require.NoError(t, err)
+	cookie = resp.Header.Get("Set-Cookie")
+	payload = strings.NewReader("username=linkholder&name=Holder&email=linkholder@test.com&password=SecurePass123!")
+	req = httptest.NewRequest("POST", "/signup", payload)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Cookie", strings.Split(cookie, ";")[0])
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "/", resp.Header.Get("HX-Redirect"))
+	require.NoError(t, database.DB.Where("username = ?", "linkholder").First(&user).Error)
+	assert.Empty(t, orgRole(user.ID, org.ID))
+	assert.False(t, user.EmailVerified)
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const maxReportDetails = 2000
+
+// isSuspended reports whether the request's user has been suspended
+func isSuspended(c *fiber.Ctx) bool {
+	user, ok := c.Locals("User").(models.User)
+	return ok && user.SuspendedAt != nil
+}
+
+// moderationReason reads the reason an admin gave: the answer to an
+// hx-prompt, or the reason form field
+func moderationReason(c *fiber.Ctx) string {
+	if reason := strings.TrimSpace(c.Get("HX-Prompt")); reason != "" {
+		return reason
+	}
+	return strings.TrimSpace(c.FormValue("reason"))
+}
+
+// isValidReportReason reports whether reason is one of the report reasons
+func isValidReportReason(reason string) bool {
+	for _, r := range models.ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// GetReportResource returns the report modal for a resource
+func GetReportResource(c *fiber.Ctx) error {
+	id, _ := strconv.ParseUint(c.Params("id"), 10, 32)
+	var resource models.NomadResource
+	if err := database.DB.First(&resource, uint(id)).Error; err != nil || !canViewResource(c, resource) {
+		return c.Status(404).SendString("Resource not found")
+	}
+	return c.Render("partials/report_modal", fiber.Map{
+		"ResourceID": resource.ID,
+		"Reasons":    models.ReportReasons,
+		"CSRFToken":  c.Locals("CSRFToken"),
+	})
+}
+
+// PostReportResource adds a report of a resource to the moderation queue.
+// Reporting a resource again updates the reporter's open report.
+func PostReportResource(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+	id, _ := strconv.ParseUint(c.Params("id"), 10, 32)
+	var resource models.NomadResource
+	if err := database.DB.First(&resource, uint(id)).Error; err != nil || !canViewResource(c, resource) {
+		return c.Status(404).SendString("Resource not found")
+	}
+
+	reason := c.FormValue("reason")
+	if !isValidReportReason(reason) {
+		return c.Status(400).SendString("Choose a reason for the report")
+	}
+	details := strings.TrimSpace(c.FormValue("details"))
+	if len(details) > maxReportDetails {
+		return c.Status(400).SendString("Details must be at most 2000 characters")
+	}
+
+	var report models.AbuseReport
+	err := database.DB.Where("resource_id = ? AND reporter_id = ? AND status = ?", resource.ID, userID, models.ReportOpen).First(&report).Error
+	if err != nil {
+		report = models.AbuseReport{ResourceID: resource.ID, ReporterID: &userID, Status: models.ReportOpen}
+	}
+	report.Reason = reason
+	report.Details = details
+	if err := database.DB.Save(&report).Error; err != nil {
+		return c.Status(500).SendString("Failed to save report")
+	}
+
+	SetFlash(c, "success", "Thanks for the report. An admin will look into it.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// GetAdminModeration shows reported resources, newest first, with the hidden
+// resources and suspended users
+func GetAdminModeration(c *fiber.Ctx) error {
+	status := c.Query("status", models.ReportOpen)
+	query := database.DB.Preload("Resource.User").Preload("Resource.Organization").Preload("Reporter").Preload("ResolvedBy")
+	if status != "all" {
+		query = query.Where("status = ?", status)
+	}
+	var reports []models.AbuseReport
+	query.Order("id DESC").Limit(200).Find(&reports)
+
+	var hidden []models.NomadResource
+	database.DB.Preload("User").Preload("Organization").Where("hidden_at IS NOT NULL").Order("hidden_at DESC").Find(&hidden)
+
+	var suspended []models.User
+	database.DB.Where("suspended_at IS NOT NULL").Order("suspended_at DESC").Find(&suspended)
+
+	return c.Render("admin/moderation", MergeContext(BaseContext(c), fiber.Map{
+		"Reports":   reports,
+		"Status":    status,
+		"Statuses":  []string{models.ReportOpen, models.ReportResolved, models.ReportDismissed, "all"},
+		"Hidden":    hidden,
+		"Suspended": suspended,
+		"Page":      "admin_moderation",
+	}), "layouts/main")
+}
+
+// closeReports marks the open reports of a resource as resolved or dismissed
+func closeReports(c *fiber.Ctx, resourceID uint, status string) {
+	adminID := currentUserID(c)
+	database.DB.Model(&models.AbuseReport{}).
+		Where("resource_id = ? AND status = ?", resourceID, models.ReportOpen).
+		Updates(map[string]interface{}{"status": status, "resolved_by_id": adminID, "resolved_at": time.Now()})
+}
+
+// PostHideResource hides a resource from everyone but admins, without
+// deleting it, and resolves its open reports
+func PostHideResource(c *fiber.Ctx) error {
+	var resource models.NomadResource
+	if err := database.DB.First(&resource, c.Params("id")).Error; err != nil {
+		return c.Status(404).SendString("Resource not found")
+	}
+	if resource.HiddenAt != nil {
+		return c.Status(400).SendString("Resource is already hidden")
+	}
+
+	reason := moderationReason(c)
+	now := time.Now()
+	database.DB.Model(&resource).Updates(map[string]interface{}{"hidden_at": now, "hidden_reason": reason})
+	closeReports(c, resource.ID, models.ReportResolved)
+	auditResource(c, AuditResourceHide, resource, map[string]AuditChange{
+		"hidden":        {Before: false, After: true},
+		"hidden_reason": {Before: nil, After: reason},
+	})
+
+	SetFlash(c, "success", "Resource hidden.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// PostUnhideResource makes a hidden resource visible again
+func PostUnhideResource(c *fiber.Ctx) error {
+	var resource models.NomadResource
+	if err := database.DB.First(&resource, c.Params("id")).Error; err != nil {
+		return c.Status(404).SendString("Resource not found")
+	}
+	if resource.HiddenAt == nil {
+		return c.Status(400).SendString("Resource is not hidden")
+	}
+
+	reason := resource.HiddenReason
+	database.DB.Model(&resource).Updates(map[string]interface{}{"hidden_at": nil, "hidden_reason": ""})
+	auditResource(c, AuditResourceUnhide, resource, map[string]AuditChange{
+		"hidden":        {Before: true, After: false},
+		"hidden_reason": {Before: reason, After: nil},
+	})
+
+	SetFlash(c, "success", "Resource visible again.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// PostDismissReport closes a report without acting on the resource
+func PostDismissReport(c *fiber.Ctx) error {
+	var report models.AbuseReport
+	if err := database.DB.Preload("Resource").First(&report, c.Params("id")).Error; err != nil {
+		return c.Status(404).SendString("Report not found")
+	}
+	if report.Status != models.ReportOpen {
+		return c.Status(400).SendString("Report is already closed")
+	}
+
+	adminID := currentUserID(c)
+	now := time.Now()
+	database.DB.Model(&report).Updates(map[string]interface{}{"status": models.ReportDismissed, "resolved_by_id": adminID, "resolved_at": now})
+	auditResource(c, AuditResourceDismiss, report.Resource, map[string]AuditChange{
+		"reports." + strconv.FormatUint(uint64(report.ID), 10) + ".status": {Before: models.ReportOpen, After: models.ReportDismissed},
+	})
+
+	SetFlash(c, "success", "Report dismissed.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// PostSuspendUser suspends a user: they are logged out, can't log in again
+// and their API tokens stop working. Their resources stay up; hide those
+// separately.
+func PostSuspendUser(c *fiber.Ctx) error {
+	var user models.User
+	if err := database.DB.First(&user, c.Params("id")).Error; err != nil {
+		return c.Status(404).SendString("User not found")
+	}
+	if user.ID == currentUserID(c) {
+		return c.Status(400).SendString("Cannot suspend your own account")
+	}
+	if user.IsAdmin {
+		return c.Status(400).SendString("Revoke admin privileges before suspending an admin")
+	}
+	if user.SuspendedAt != nil {
+		return c.Status(400).SendString("User is already suspended")
+	}
+
+	reason := moderationReason(c)
+	now := time.Now()
+	user.SuspendedAt, user.SuspensionReason = &now, reason
+	database.DB.Model(&user).Select("suspended_at", "suspension_reason").Updates(&user)
+	database.DB.Where("user_id = ?", user.ID).Delete(&models.Session{})
+	recordAudit(c, AuditUserSuspend, AuditTargetUser, user.ID, user.Username, map[string]AuditChange{
+		"suspended":         {Before: false, After: true},
+		"suspension_reason": {Before: nil, After: reason},
+	})
+
+	return c.Render("partials/admin_user_row", fiber.Map{
+		"User":      user,
+		"CSRFToken": c.Locals("CSRFToken"),
+	})
+}
+
+// PostUnsuspendUser lifts a user's suspension
+func PostUnsuspendUser(c *fiber.Ctx) error {
+	var user models.User
+	if err := database.DB.First(&user, c.Params("id")).Error; err != nil {
+		return c.Status(404).SendString("User not found")
+	}
+	if user.SuspendedAt == nil {
+		return c.Status(400).SendString("User is not suspended")
+	}
+
+	reason := user.SuspensionReason
+	user.SuspendedAt, user.SuspensionReason = nil, ""
+	database.DB.Model(&user).Select("suspended_at", "suspension_reason").Updates(&user)
+	recordAudit(c, AuditUserUnsuspend, AuditTargetUser, user.ID, user.Username, map[string]AuditChange{
+		"suspended":         {Before: true, After: false},
+		"suspension_reason": {Before: reason, After: nil},
+	})
+
+	return c.Render("partials/admin_user_row", fiber.Map{
+		"User":      user,
+		"CSRFToken": c.Locals("CSRFToken"),
+	})
+}
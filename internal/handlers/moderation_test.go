@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// postForm posts a form to path and returns the status code
+func postForm(t *testing.T, app *fiber.App, path, form string) int {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	return resp.StatusCode
+}
+
+func TestPostReportResource(t *testing.T) {
+	defer cleanupTestData(t)
+
+	owner := createTestUser(t, "reportedowner")
+	reporter := createTestUser(t, "reporter")
+	pack := createTestPack(t, owner.ID, "reported-pack")
+
+	app := setupAuthenticatedApp(reporter)
+	app.Post("/resource/:id/report", RequireAuth, PostReportResource)
+	path := "/resource/" + toString(pack.ID) + "/report"
+
+	assert.Equal(t, 400, postForm(t, app, path, "reason=boring"))
+	assert.Equal(t, 200, postForm(t, app, path, "reason=spam&details=Links+to+a+casino"))
+	// Reporting again updates the open report
+	assert.Equal(t, 200, postForm(t, app, path, "reason=malicious&details=Runs+a+miner"))
+
+	var reports []models.AbuseReport
+	database.DB.Where("resource_id = ?", pack.ID).Find(&reports)
+	require.Len(t, reports, 1)
+	assert.Equal(t, models.ReportMalicious, reports[0].Reason)
+	assert.Equal(t, "Runs a miner", reports[0].Details)
+	assert.Equal(t, models.ReportOpen, reports[0].Status)
+	require.NotNil(t, reports[0].ReporterID)
+	assert.Equal(t, reporter.ID, *reports[0].ReporterID)
+}
+
+func TestPostHideResource(t *testing.T) {
+	defer cleanupTestData(t)
+
+	admin := createAdminUser(t, "hideadmin")
+	owner := createTestUser(t, "hideowner")
+	pack := createTestPack(t, owner.ID, "hide-pack")
+	require.NoError(t, database.DB.Create(&models.AbuseReport{ResourceID: pack.ID, Reason: models.ReportSpam, Status: models.ReportOpen}).Error)
+
+	adminApp := setupAuthenticatedApp(admin)
+	adminApp.Post("/admin/resources/:id/hide", PostHideResource)
+	adminApp.Post("/admin/resources/:id/unhide", PostUnhideResource)
+	req := httptest.NewRequest("POST", "/admin/resources/"+toString(pack.ID)+"/hide", nil)
+	req.Header.Set("HX-Prompt", "Spam")
+	resp, err := adminApp.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var stored models.NomadResource
+	require.NoError(t, database.DB.First(&stored, pack.ID).Error)
+	require.NotNil(t, stored.HiddenAt)
+	assert.Equal(t, "Spam", stored.HiddenReason)
+	var report models.AbuseReport
+	require.NoError(t, database.DB.Where("resource_id = ?", pack.ID).First(&report).Error)
+	assert.Equal(t, models.ReportResolved, report.Status)
+
+	// Hidden from everyone but admins, owner included
+	path := "/hideowner/v1/packs/hide-pack"
+	status, _ := getStatus(t, visibilityRoutes(setupTestApp()), path, "")
+	assert.Equal(t, 404, status)
+	status, _ = getStatus(t, visibilityRoutes(setupAuthenticatedApp(owner)), path, "")
+	assert.Equal(t, 404, status)
+	_, body := getStatus(t, visibilityRoutes(setupAuthenticatedApp(owner)), "/v1/packs", "")
+	assert.NotContains(t, body, "hide-pack")
+	status, _ = getStatus(t, visibilityRoutes(setupAuthenticatedApp(admin)), path, "")
+	assert.Equal(t, 200, status)
+
+	assert.Equal(t, 200, postForm(t, adminApp, "/admin/resources/"+toString(pack.ID)+"/unhide", ""))
+	status, _ = getStatus(t, visibilityRoutes(setupTestApp()), path, "")
+	assert.Equal(t, 200, status)
+
+	event, _ := lastAuditEvent(t, AuditResourceHide)
+	assert.Equal(t, "hideowner/hide-pack", event.TargetName)
+}
+
+func TestPostSuspendUser(t *testing.T) {
+	defer cleanupTestData(t)
+
+	admin := createAdminUser(t, "suspendadmin")
+	otherAdmin := createAdminUser(t, "suspendadmin2")
+	hash, _ := bcrypt.GenerateFromPassword([]byte("CorrectPass123!"), bcrypt.DefaultCost)
+	user := createTestUser(t, "suspendee")
+	database.DB.Model(&user).Update("password_hash", string(hash))
+	token, _ := createTestToken(t, user.ID, nil, ScopeResourcesWrite)
+
+	app := setupAuthenticatedApp(admin)
+	app.Post("/admin/users/:id/suspend", PostSuspendUser)
+	app.Post("/admin/users/:id/unsuspend", PostUnsuspendUser)
+	assert.Equal(t, 400, postForm(t, app, "/admin/users/"+toString(otherAdmin.ID)+"/suspend", "reason=no"))
+	assert.Equal(t, 200, postForm(t, app, "/admin/users/"+toString(user.ID)+"/suspend", "reason=Publishing+malware"))
+
+	require.NoError(t, database.DB.First(&user, user.ID).Error)
+	require.NotNil(t, user.SuspendedAt)
+	assert.Equal(t, "Publishing malware", user.SuspensionReason)
+
+	// Sessions of suspended users can't reach authenticated pages
+	userApp := setupAuthenticatedApp(user)
+	userApp.Post("/new", RequireAuth, PostNewResource)
+	assert.Equal(t, 403, postForm(t, userApp, "/new", "name=blocked"))
+
+	// Nor log in again
+	loginApp := setupTestApp()
+	loginApp.Post("/login", PostLogin)
+	assert.Equal(t, 403, postForm(t, loginApp, "/login", "email=suspendee@test.com&password=CorrectPass123!"))
+
+	// Nor use their tokens
+	status, _ := getStatus(t, visibilityRoutes(setupTokenApp()), "/v1/packs", token)
+	assert.Equal(t, 403, status)
+
+	assert.Equal(t, 200, postForm(t, app, "/admin/users/"+toString(user.ID)+"/unsuspend", ""))
+	assert.Equal(t, 200, postForm(t, loginApp, "/login", "email=suspendee@test.com&password=CorrectPass123!"))
+}
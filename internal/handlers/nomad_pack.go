@@ -1,12 +1,15 @@
 package handlers
 
 import (
-	"fmt"
+	"encoding/json"
 	"rmbl/internal/database"
+	"rmbl/internal/gitprovider"
 	"rmbl/internal/models"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 type PackSummary struct {
@@ -16,14 +19,38 @@ type PackSummary struct {
 }
 
 type PackDetail struct {
-	Name        string        `json:"name"`
-	Description string        `json:"description"`
-	Versions    []PackVersion `json:"versions"`
+	ID            uint          `json:"id"`
+	Name          string        `json:"name"`
+	Namespace     string        `json:"namespace"`  // Owning user or organization
+	Type          string        `json:"type"`       // pack or job
+	Visibility    string        `json:"visibility"` // public, internal or private
+	Description   string        `json:"description"`
+	License       string        `json:"license,omitempty"`
+	RepositoryURL string        `json:"repository_url,omitempty"`
+	Tags          []string      `json:"tags"`
+	Stars         int           `json:"stars"`
+	Downloads     int           `json:"downloads"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+	Versions      []PackVersion `json:"versions"`
+	Deprecation   *Deprecation  `json:"deprecation,omitempty"`
 }
 
 type PackVersion struct {
-	Version string `json:"version"`
-	URL     string `json:"url"`
+	Version     string         `json:"version"`
+	URL         string         `json:"url"`
+	Ref         string         `json:"ref,omitempty"`
+	CommitSHA   string         `json:"commit_sha,omitempty"`
+	IngestState string         `json:"ingest_state,omitempty"` // pending, ready or failed
+	IngestError string         `json:"ingest_error,omitempty"`
+	Digest      string         `json:"digest,omitempty"`      // sha256:<hex> of the archive at URL, or of the raw content for jobs
+	Signature   string         `json:"signature,omitempty"`   // Publisher's base64 ed25519 signature over Digest
+	SigningKey  string         `json:"signing_key,omitempty"` // Fingerprint of the key, listed at /v1/keys/{namespace}
+	Yanked      bool           `json:"yanked,omitempty"`      // Only fetched when pinned exactly
+	YankReason  string         `json:"yank_reason,omitempty"`
+	Readme      string         `json:"readme,omitempty"`
+	Variables   []PackVariable `json:"variables,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
 }
 
 // ListPacksAPI godoc
@@ -42,11 +69,11 @@ func ListPacksAPI(c *fiber.Ctx) error {
 	var orgID *uint
 
 	var user models.User
-	if err := database.DB.Where("username ILIKE ?", namespace).First(&user).Error; err == nil {
+	if err := database.DB.Where("username ILIKE ?", escapeLikeString(namespace)).First(&user).Error; err == nil {
 		userID = user.ID
 	} else {
 		var org models.Organization
-		if err := database.DB.Where("name ILIKE ?", namespace).First(&org).Error; err == nil {
+		if err := database.DB.Where("name ILIKE ?", escapeLikeString(namespace)).First(&org).Error; err == nil {
 			orgID = &org.ID
 		} else {
 			return c.Status(404).JSON(fiber.Map{"error": "Namespace not found"})
@@ -54,7 +81,7 @@ func ListPacksAPI(c *fiber.Ctx) error {
 	}
 
 	var resources []models.NomadResource
-	dbQuery := database.DB.Where("type = ?", models.ResourceTypePack)
+	dbQuery := database.DB.Scopes(visibleTo(c)).Where("type = ?", models.ResourceTypePack)
 	if orgID != nil {
 		dbQuery = dbQuery.Where("organization_id = ?", *orgID)
 	} else {
@@ -85,7 +112,7 @@ func ListPacksAPI(c *fiber.Ctx) error {
 // @Router /v1/packs [get]
 func ListAllPacksAPI(c *fiber.Ctx) error {
 	var resources []models.NomadResource
-	if err := database.DB.Preload("User").Preload("Organization").Where("type = ?", models.ResourceTypePack).Find(&resources).Error; err != nil {
+	if err := database.DB.Scopes(visibleTo(c)).Preload("User").Preload("Organization").Where("type = ?", models.ResourceTypePack).Find(&resources).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
 	}
 
@@ -112,6 +139,60 @@ func getResourceNamespace(r models.NomadResource) string {
 	return ""
 }
 
+// resourceDetail builds the JSON detail of a resource and all its versions,
+// newest first. The resource's user, organization and tags must be loaded.
+func resourceDetail(c *fiber.Ctx, resource models.NomadResource) PackDetail {
+	namespace := getResourceNamespace(resource)
+	tags := make([]string, len(resource.Tags))
+	for i, t := range resource.Tags {
+		tags[i] = t.Name
+	}
+
+	sortVersions(resource.Versions)
+	versions := make([]PackVersion, len(resource.Versions))
+	for i, v := range resource.Versions {
+		versions[i] = PackVersion{
+			Version:     v.Version,
+			URL:         getDownloadURL(resource.GitProvider, resource.RepositoryURL, sourceRef(resource, v)),
+			Ref:         versionRef(resource, v),
+			CommitSHA:   v.CommitSHA,
+			IngestState: v.IngestState,
+			IngestError: v.IngestError,
+			Digest:      v.Digest,
+			Signature:   v.Signature,
+			SigningKey:  v.SignedBy,
+			Yanked:      v.Yanked,
+			YankReason:  v.YankReason,
+			Readme:      v.Readme,
+			CreatedAt:   v.CreatedAt,
+		}
+		if resource.Type == models.ResourceTypePack {
+			versions[i].URL = archiveURL(c, namespace, resource.Name, v.Version)
+		}
+		if v.Variables != "" {
+			_ = json.Unmarshal([]byte(v.Variables), &versions[i].Variables)
+		}
+	}
+
+	return PackDetail{
+		ID:            resource.ID,
+		Name:          resource.Name,
+		Namespace:     namespace,
+		Type:          string(resource.Type),
+		Visibility:    resource.Visibility,
+		Description:   resource.Description,
+		License:       resource.License,
+		RepositoryURL: resource.RepositoryURL,
+		Tags:          tags,
+		Stars:         resource.StarCount,
+		Downloads:     resource.DownloadCount,
+		CreatedAt:     resource.CreatedAt,
+		UpdatedAt:     resource.UpdatedAt,
+		Versions:      versions,
+		Deprecation:   resourceDeprecation(resource),
+	}
+}
+
 // GetPackAPI godoc
 // @Summary Get pack details
 // @Description Fetch detailed metadata and version history for a specific Nomad Pack.
@@ -130,11 +211,11 @@ func GetPackAPI(c *fiber.Ctx) error {
 	var orgID *uint
 
 	var user models.User
-	if err := database.DB.Where("username ILIKE ?", namespace).First(&user).Error; err == nil {
+	if err := database.DB.Where("username ILIKE ?", escapeLikeString(namespace)).First(&user).Error; err == nil {
 		userID = user.ID
 	} else {
 		var org models.Organization
-		if err := database.DB.Where("name ILIKE ?", namespace).First(&org).Error; err == nil {
+		if err := database.DB.Where("name ILIKE ?", escapeLikeString(namespace)).First(&org).Error; err == nil {
 			orgID = &org.ID
 		} else {
 			return c.Status(404).JSON(fiber.Map{"error": "Namespace not found"})
@@ -142,30 +223,20 @@ func GetPackAPI(c *fiber.Ctx) error {
 	}
 
 	var resource models.NomadResource
-	dbQuery := database.DB.Preload("Versions").Where("type = ? AND name ILIKE ?", models.ResourceTypePack, packname)
+	dbQuery := database.DB.Preload("User").Preload("Organization").Preload("Tags").Preload("Versions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("resource_versions.created_at DESC")
+	}).Where("type = ? AND name ILIKE ?", models.ResourceTypePack, escapeLikeString(packname))
 	if orgID != nil {
 		dbQuery = dbQuery.Where("organization_id = ?", *orgID)
 	} else {
 		dbQuery = dbQuery.Where("user_id = ? AND organization_id IS NULL", userID)
 	}
 
-	if err := dbQuery.First(&resource).Error; err != nil {
+	if err := dbQuery.First(&resource).Error; err != nil || !canViewResource(c, resource) {
 		return c.Status(404).JSON(fiber.Map{"error": "Pack not found"})
 	}
 
-	versions := make([]PackVersion, len(resource.Versions))
-	for i, v := range resource.Versions {
-		versions[i] = PackVersion{
-			Version: v.Version,
-			URL:     getDownloadURL(resource.RepositoryURL, v.Version),
-		}
-	}
-
-	return c.JSON(PackDetail{
-		Name:        resource.Name,
-		Description: resource.Description,
-		Versions:    versions,
-	})
+	return c.JSON(resourceDetail(c, resource))
 }
 
 // GetRegistries godoc
@@ -182,7 +253,7 @@ func GetRegistries(c *fiber.Ctx) error {
 	}
 
 	// Query for unique owners of resources of type 'pack'
-	err := database.DB.Table("nomad_resources").
+	err := database.DB.Table("nomad_resources").Scopes(visibleTo(c)).
 		Select("DISTINCT COALESCE(organizations.name, users.username) as name, CASE WHEN organization_id IS NOT NULL THEN 'organization' ELSE 'user' END as type").
 		Joins("LEFT JOIN users ON users.id = nomad_resources.user_id").
 		Joins("LEFT JOIN organizations ON organizations.id = nomad_resources.organization_id").
@@ -210,7 +281,7 @@ func GetRegistries(c *fiber.Ctx) error {
 // @Router /v1/registries [get]
 func ListUserRegistriesAPI(c *fiber.Ctx) error {
 	var names []string
-	err := database.DB.Table("nomad_resources").
+	err := database.DB.Table("nomad_resources").Scopes(visibleTo(c)).
 		Select("DISTINCT COALESCE(organizations.name, users.username)").
 		Joins("LEFT JOIN users ON users.id = nomad_resources.user_id").
 		Joins("LEFT JOIN organizations ON organizations.id = nomad_resources.organization_id").
@@ -240,7 +311,7 @@ func SearchRegistriesAPI(c *fiber.Ctx) error {
 
 	searchParam := "%" + escapeLikeString(query) + "%"
 	var names []string
-	err := database.DB.Table("nomad_resources").
+	err := database.DB.Table("nomad_resources").Scopes(visibleTo(c)).
 		Select("DISTINCT COALESCE(organizations.name, users.username) as namespace").
 		Joins("LEFT JOIN users ON users.id = nomad_resources.user_id").
 		Joins("LEFT JOIN organizations ON organizations.id = nomad_resources.organization_id").
@@ -257,10 +328,11 @@ func SearchRegistriesAPI(c *fiber.Ctx) error {
 
 // SearchPacksAPI godoc
 // @Summary Search packs
-// @Description Search for Nomad Packs across all namespaces by name or description.
+// @Description Full-text search for Nomad Packs across all namespaces, over names, descriptions, tags, READMEs and variables. Small typos in names still match.
 // @Tags nomad-pack
 // @Produce json
 // @Param q query string true "Search query"
+// @Param sort query string false "relevance (default), latest, stars, downloads or alpha"
 // @Success 200 {object} map[string][]PackSummary
 // @Router /v1/packs/search [get]
 func SearchPacksAPI(c *fiber.Ctx) error {
@@ -270,10 +342,8 @@ func SearchPacksAPI(c *fiber.Ctx) error {
 	}
 
 	var resources []models.NomadResource
-	searchParam := "%" + escapeLikeString(query) + "%"
-	err := database.DB.Preload("User").Preload("Organization").
-		Where("type = ? AND (name ILIKE ? ESCAPE '\\' OR description ILIKE ? ESCAPE '\\')", models.ResourceTypePack, searchParam, searchParam).
-		Limit(20).Find(&resources).Error
+	dbQuery := searchMatch(database.DB.Scopes(visibleTo(c)).Preload("User").Preload("Organization").Where("type = ?", models.ResourceTypePack), query)
+	err := sortResources(dbQuery, c.Query("sort", "relevance"), query).Limit(20).Find(&resources).Error
 
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
@@ -299,10 +369,23 @@ type JobSummary struct {
 }
 
 // JobDetail represents detailed job information
+// JobDetail has the same fields as PackDetail, so one converts to the other
 type JobDetail struct {
-	Name        string        `json:"name"`
-	Description string        `json:"description"`
-	Versions    []PackVersion `json:"versions"` // Reuse PackVersion structure
+	ID            uint          `json:"id"`
+	Name          string        `json:"name"`
+	Namespace     string        `json:"namespace"`
+	Type          string        `json:"type"`
+	Visibility    string        `json:"visibility"`
+	Description   string        `json:"description"`
+	License       string        `json:"license,omitempty"`
+	RepositoryURL string        `json:"repository_url,omitempty"`
+	Tags          []string      `json:"tags"`
+	Stars         int           `json:"stars"`
+	Downloads     int           `json:"downloads"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+	Versions      []PackVersion `json:"versions"` // Reuse PackVersion structure
+	Deprecation   *Deprecation  `json:"deprecation,omitempty"`
 }
 
 // ListAllJobsAPI godoc
@@ -314,7 +397,7 @@ type JobDetail struct {
 // @Router /v1/jobs [get]
 func ListAllJobsAPI(c *fiber.Ctx) error {
 	var resources []models.NomadResource
-	if err := database.DB.Preload("User").Preload("Organization").Where("type = ?", models.ResourceTypeJob).Find(&resources).Error; err != nil {
+	if err := database.DB.Scopes(visibleTo(c)).Preload("User").Preload("Organization").Where("type = ?", models.ResourceTypeJob).Find(&resources).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
 	}
 
@@ -332,10 +415,11 @@ func ListAllJobsAPI(c *fiber.Ctx) error {
 
 // SearchJobsAPI godoc
 // @Summary Search jobs
-// @Description Search for Nomad Jobs across all namespaces by name or description.
+// @Description Full-text search for Nomad Jobs across all namespaces, over names, descriptions, tags, READMEs and job files. Small typos in names still match.
 // @Tags nomad-job
 // @Produce json
 // @Param q query string true "Search query"
+// @Param sort query string false "relevance (default), latest, stars, downloads or alpha"
 // @Success 200 {object} map[string][]JobSummary
 // @Router /v1/jobs/search [get]
 func SearchJobsAPI(c *fiber.Ctx) error {
@@ -345,10 +429,8 @@ func SearchJobsAPI(c *fiber.Ctx) error {
 	}
 
 	var resources []models.NomadResource
-	searchParam := "%" + escapeLikeString(query) + "%"
-	err := database.DB.Preload("User").Preload("Organization").
-		Where("type = ? AND (name ILIKE ? ESCAPE '\\' OR description ILIKE ? ESCAPE '\\')", models.ResourceTypeJob, searchParam, searchParam).
-		Limit(20).Find(&resources).Error
+	dbQuery := searchMatch(database.DB.Scopes(visibleTo(c)).Preload("User").Preload("Organization").Where("type = ?", models.ResourceTypeJob), query)
+	err := sortResources(dbQuery, c.Query("sort", "relevance"), query).Limit(20).Find(&resources).Error
 
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
@@ -384,11 +466,11 @@ func GetJobAPI(c *fiber.Ctx) error {
 	var orgID *uint
 
 	var user models.User
-	if err := database.DB.Where("username ILIKE ?", namespace).First(&user).Error; err == nil {
+	if err := database.DB.Where("username ILIKE ?", escapeLikeString(namespace)).First(&user).Error; err == nil {
 		userID = user.ID
 	} else {
 		var org models.Organization
-		if err := database.DB.Where("name ILIKE ?", namespace).First(&org).Error; err == nil {
+		if err := database.DB.Where("name ILIKE ?", escapeLikeString(namespace)).First(&org).Error; err == nil {
 			orgID = &org.ID
 		} else {
 			return c.Status(404).JSON(fiber.Map{"error": "Namespace not found"})
@@ -396,48 +478,30 @@ func GetJobAPI(c *fiber.Ctx) error {
 	}
 
 	var resource models.NomadResource
-	dbQuery := database.DB.Preload("Versions").Where("type = ? AND name ILIKE ?", models.ResourceTypeJob, jobname)
+	dbQuery := database.DB.Preload("User").Preload("Organization").Preload("Tags").Preload("Versions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("resource_versions.created_at DESC")
+	}).Where("type = ? AND name ILIKE ?", models.ResourceTypeJob, escapeLikeString(jobname))
 	if orgID != nil {
 		dbQuery = dbQuery.Where("organization_id = ?", *orgID)
 	} else {
 		dbQuery = dbQuery.Where("user_id = ? AND organization_id IS NULL", userID)
 	}
 
-	if err := dbQuery.First(&resource).Error; err != nil {
+	if err := dbQuery.First(&resource).Error; err != nil || !canViewResource(c, resource) {
 		return c.Status(404).JSON(fiber.Map{"error": "Job not found"})
 	}
 
-	versions := make([]PackVersion, len(resource.Versions))
-	for i, v := range resource.Versions {
-		versions[i] = PackVersion{
-			Version: v.Version,
-			URL:     getDownloadURL(resource.RepositoryURL, v.Version),
-		}
-	}
-
-	return c.JSON(JobDetail{
-		Name:        resource.Name,
-		Description: resource.Description,
-		Versions:    versions,
-	})
+	return c.JSON(JobDetail(resourceDetail(c, resource)))
 }
 
-// getDownloadURL constructs a tarball download URL for GitHub or GitLab.
-func getDownloadURL(repoURL string, version string) string {
-	repoURL = strings.TrimSuffix(repoURL, ".git")
-	repoURL = strings.TrimSuffix(repoURL, "/")
-
-	if strings.Contains(repoURL, "github.com") {
-		// https://github.com/owner/repo/archive/refs/tags/v1.0.0.tar.gz
-		return fmt.Sprintf("%s/archive/refs/tags/%s.tar.gz", repoURL, version)
-	}
-
-	if strings.Contains(repoURL, "gitlab.com") {
-		// https://gitlab.com/owner/repo/-/archive/v1.0.0/repo-v1.0.0.tar.gz
-		parts := strings.Split(repoURL, "/")
-		repoName := parts[len(parts)-1]
-		return fmt.Sprintf("%s/-/archive/%s/%s-%s.tar.gz", repoURL, version, repoName, version)
+// getDownloadURL returns the upstream tarball URL of a git ref from its git
+// provider. Pack clients are given the registry archive instead (see
+// archiveURL); this is the source that archive is snapshotted from.
+func getDownloadURL(kind, repoURL, ref string) string {
+	if provider, err := gitprovider.New(kind, repoURL, ""); err == nil {
+		if archive, err := provider.ArchiveURL(ref); err == nil {
+			return archive
+		}
 	}
-
-	return repoURL // Fallback to repo URL
+	return strings.TrimSuffix(strings.TrimSuffix(repoURL, ".git"), "/") // Fallback to repo URL
 }
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/openidConnect"
+)
+
+// oidcProviderName is the provider name of the OpenID Connect login, as in
+// /auth/oidc and users' Provider
+const oidcProviderName = "oidc"
+
+// OIDCConfig configures login through a self-hosted OpenID Connect identity
+// provider such as Keycloak, Authentik or Dex
+type OIDCConfig struct {
+	DisplayName   string // Shown on the login button
+	DiscoveryURL  string
+	ClientID      string
+	ClientSecret  string
+	Scopes        []string
+	UsernameClaim string
+	// GroupsClaim names the claim listing the user's groups. Memberships are
+	// only synced when it and GroupRoles are set.
+	GroupsClaim string
+	GroupRoles  map[string][]OIDCGroupRole
+}
+
+// OIDCGroupRole grants members of an identity provider group a role in an
+// organization
+type OIDCGroupRole struct {
+	Organization string
+	Role         string
+}
+
+// oidcConfig is the configured OpenID Connect login, nil when it's off
+var oidcConfig *OIDCConfig
+
+// OIDCConfigFromEnv reads the OpenID Connect login settings. It returns nil
+// when OIDC_DISCOVERY_URL isn't set.
+func OIDCConfigFromEnv() (*OIDCConfig, error) {
+	discoveryURL := os.Getenv("OIDC_DISCOVERY_URL")
+	if discoveryURL == "" {
+		return nil, nil
+	}
+	cfg := &OIDCConfig{
+		DisplayName:   os.Getenv("OIDC_NAME"),
+		DiscoveryURL:  discoveryURL,
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		Scopes:        strings.Fields(os.Getenv("OIDC_SCOPES")),
+		UsernameClaim: os.Getenv("OIDC_USERNAME_CLAIM"),
+		GroupsClaim:   os.Getenv("OIDC_GROUPS_CLAIM"),
+	}
+	if cfg.ClientID == "" {
+		return nil, fmt.Errorf("OIDC_CLIENT_ID is required")
+	}
+	groupRoles, err := parseOIDCGroupRoles(os.Getenv("OIDC_GROUP_ROLES"))
+	if err != nil {
+		return nil, err
+	}
+	cfg.GroupRoles = groupRoles
+	return cfg, nil
+}
+
+// parseOIDCGroupRoles parses comma separated group=organization:role
+// mappings, e.g. "platform-admins=platform:owner,developers=platform:publisher".
+// A group may be listed more than once to grant roles in several
+// organizations.
+func parseOIDCGroupRoles(value string) (map[string][]OIDCGroupRole, error) {
+	groupRoles := map[string][]OIDCGroupRole{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		eq := strings.LastIndex(entry, "=")
+		colon := strings.LastIndex(entry, ":")
+		if eq <= 0 || colon < eq+2 {
+			return nil, fmt.Errorf("OIDC group mapping %q is not group=organization:role", entry)
+		}
+		group, org, role := entry[:eq], entry[eq+1:colon], entry[colon+1:]
+		if !isValidRole(role) {
+			return nil, fmt.Errorf("OIDC group mapping %q has unknown role %q", entry, role)
+		}
+		groupRoles[group] = append(groupRoles[group], OIDCGroupRole{Organization: org, Role: role})
+	}
+	return groupRoles, nil
+}
+
+// ConfigureOIDC registers the OpenID Connect provider. Its discovery document
+// is fetched now, so the identity provider must be reachable.
+func ConfigureOIDC(cfg *OIDCConfig, callbackURL string) error {
+	discoveryURL := cfg.DiscoveryURL
+	if !strings.Contains(discoveryURL, "/.well-known/") {
+		discoveryURL = strings.TrimSuffix(discoveryURL, "/") + "/.well-known/openid-configuration"
+	}
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	provider, err := openidConnect.New(cfg.ClientID, cfg.ClientSecret, callbackURL, discoveryURL, scopes...)
+	if err != nil {
+		return fmt.Errorf("could not set up OIDC login: %w", err)
+	}
+	provider.SetName(oidcProviderName)
+	if cfg.UsernameClaim != "" {
+		provider.NickNameClaims = []string{cfg.UsernameClaim}
+	}
+	if cfg.DisplayName == "" {
+		cfg.DisplayName = "Single Sign-On"
+	}
+	goth.UseProviders(provider)
+	oidcConfig = cfg
+	return nil
+}
+
+var usernameUnsafeChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// oidcUsername maps the identity provider's username claim to a free
+// username: lowercased, without any email domain, and with a number added
+// if a user or organization already has the name
+func oidcUsername(gothUser goth.User) string {
+	name := gothUser.NickName
+	if name == "" {
+		name = gothUser.Email
+	}
+	name, _, _ = strings.Cut(strings.ToLower(name), "@")
+	name = strings.Trim(usernameUnsafeChars.ReplaceAllString(name, "-"), "-")
+	if name == "" {
+		name = "user"
+	}
+
+	username := name
+	for i := 2; ; i++ {
+		var users, orgs int64
+		database.DB.Unscoped().Model(&models.User{}).Where("username = ?", username).Count(&users)
+		database.DB.Model(&models.Organization{}).Where("name ILIKE ?", escapeLikeString(username)).Count(&orgs)
+		if users == 0 && orgs == 0 {
+			return username
+		}
+		username = name + "-" + strconv.Itoa(i)
+	}
+}
+
+// oidcEmailVerified reports whether the identity provider vouches for the
+// email address. Providers that leave out the email_verified claim let users
+// set any address, so only an explicit true counts.
+func oidcEmailVerified(gothUser goth.User) bool {
+	switch verified := gothUser.RawData["email_verified"].(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true"
+	}
+	return false
+}
+
+// oidcGroups returns the groups listed in the configured groups claim
+func oidcGroups(gothUser goth.User) []string {
+	switch claim := gothUser.RawData[oidcConfig.GroupsClaim].(type) {
+	case string:
+		return []string{claim}
+	case []interface{}:
+		var groups []string
+		for _, g := range claim {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	}
+	return nil
+}
+
+// rememberOIDCGroups keeps the user's identity provider groups in the session
+// until they give their second factor, so an abandoned login doesn't change
+// their memberships
+func rememberOIDCGroups(c *fiber.Ctx, gothUser goth.User) error {
+	sess, err := Store.Get(c)
+	if err != nil {
+		return err
+	}
+	sess.Set("pending_oidc_groups", append([]string{}, oidcGroups(gothUser)...))
+	return sess.Save()
+}
+
+// syncOIDCMemberships brings the user's memberships in line with their
+// identity provider groups. Organizations named in the group mapping are
+// managed by the identity provider: members get the most privileged role
+// their groups grant and are removed when no group grants one. The last
+// owner of an organization is never demoted or removed. Other organizations
+// are left alone.
+func syncOIDCMemberships(c *fiber.Ctx, user models.User, groups []string) {
+	if oidcConfig == nil || oidcConfig.GroupsClaim == "" || len(oidcConfig.GroupRoles) == 0 {
+		return
+	}
+
+	managed := map[string]bool{}
+	for _, roles := range oidcConfig.GroupRoles {
+		for _, r := range roles {
+			managed[r.Organization] = true
+		}
+	}
+	want := map[string]string{}
+	for _, group := range groups {
+		for _, r := range oidcConfig.GroupRoles[group] {
+			if current, ok := want[r.Organization]; !ok || roleRank(r.Role) < roleRank(current) {
+				want[r.Organization] = r.Role
+			}
+		}
+	}
+
+	for orgName := range managed {
+		var org models.Organization
+		if err := database.DB.Where("name = ?", orgName).First(&org).Error; err != nil {
+			log.Printf("oidc: group mapping names unknown organization %q", orgName)
+			continue
+		}
+		key := "members." + user.Username
+		current := orgRole(user.ID, org.ID)
+		role, granted := want[orgName]
+		switch {
+		case current == role:
+		case current == models.RoleOwner && isLastOwner(org.ID):
+			log.Printf("oidc: keeping %s as the last owner of %s", user.Username, org.Name)
+		case !granted:
+			database.DB.Where("user_id = ? AND organization_id = ?", user.ID, org.ID).Delete(&models.Membership{})
+			recordAuditBy(c, user.ID, AuditOrgRemoveMember, AuditTargetOrganization, org.ID, org.Name,
+				map[string]AuditChange{key: {Before: current}})
+		case current == "":
+			database.DB.Create(&models.Membership{UserID: user.ID, OrganizationID: org.ID, Role: role})
+			recordAuditBy(c, user.ID, AuditOrgJoin, AuditTargetOrganization, org.ID, org.Name,
+				map[string]AuditChange{key: {After: role}})
+		default:
+			database.DB.Model(&models.Membership{}).Where("user_id = ? AND organization_id = ?", user.ID, org.ID).Update("role", role)
+			recordAuditBy(c, user.ID, AuditOrgChangeRole, AuditTargetOrganization, org.ID, org.Name,
+				map[string]AuditChange{key: {Before: current, After: role}})
+		}
+	}
+}
+
+// roleRank orders roles by privilege, owner first
+func roleRank(role string) int {
+	for i, r := range models.OrgRoles {
+		if r == role {
+			return i
+		}
+	}
+	return len(models.OrgRoles)
+}
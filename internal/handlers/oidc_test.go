@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"rmbl/internal/totp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockOIDCIssuer serves discovery, token and userinfo endpoints for an
+// identity provider whose ID tokens carry the claims claims returns
+func newMockOIDCIssuer(t *testing.T, claims func() map[string]interface{}) *httptest.Server {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"userinfo_endpoint":      srv.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idToken := map[string]interface{}{"iss": srv.URL, "aud": "ramble", "exp": time.Now().Add(time.Hour).Unix()}
+		for k, v := range claims() {
+			idToken[k] = v
+		}
+		payload, _ := json.Marshal(idToken)
+		enc := base64.RawURLEncoding
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "mock-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString(payload) + ".",
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"sub": claims()["sub"]})
+	})
+	return srv
+}
+
+// oidcLogin logs in through the mock issuer and returns where the callback
+// redirects to, and the session cookie it sets
+func oidcLogin(t *testing.T, app *fiber.App, issuerURL string) (string, string) {
+	resp, err := app.Test(httptest.NewRequest("GET", "/auth/oidc", nil))
+	require.NoError(t, err)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(location.String(), issuerURL+"/authorize"))
+
+	req := httptest.NewRequest("GET", "/auth/oidc/callback?code=mock&state="+location.Query().Get("state"), nil)
+	req.Header.Set("Cookie", strings.Split(resp.Header.Get("Set-Cookie"), ";")[0])
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 302, resp.StatusCode)
+	return resp.Header.Get("Location"), strings.Split(resp.Header.Get("Set-Cookie"), ";")[0]
+}
+
+func TestOIDCLogin(t *testing.T) {
+	defer cleanupOrgTestData(t)
+	defer func() { oidcConfig = nil }()
+
+	createTestUser(t, "jane-doe")
+	owner := createTestUser(t, "oidcowner")
+	org, _ := createTestOrgResource(t, "testoidcorg", models.Membership{UserID: owner.ID, Role: models.RoleOwner})
+
+	groups := []interface{}{"developers", "leads"}
+	issuer := newMockOIDCIssuer(t, func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub":                "oidc-jane",
+			"email":              "jane@test.com",
+			"preferred_username": "Jane.Doe@corp.example",
+			"groups":             groups,
+		}
+	})
+	groupRoles, err := parseOIDCGroupRoles("developers=testoidcorg:publisher,leads=testoidcorg:maintainer")
+	require.NoError(t, err)
+
+	app := setupTestApp()
+	require.NoError(t, ConfigureOIDC(&OIDCConfig{
+		DiscoveryURL: issuer.URL,
+		ClientID:     "ramble",
+		ClientSecret: "secret",
+		GroupsClaim:  "groups",
+		GroupRoles:   groupRoles,
+	}, "http://localhost:3000/auth/oidc/callback"))
+	app.Get("/auth/:provider", BeginAuth)
+	app.Get("/auth/:provider/callback", AuthCallback)
+
+	login := func() {
+		location, _ := oidcLogin(t, app, issuer.URL)
+		require.Equal(t, "/", location)
+	}
+
+	// The username claim is mapped to a free username
+	login()
+	var user models.User
+	require.NoError(t, database.DB.Where("provider = ? AND provider_id = ?", "oidc", "oidc-jane").First(&user).Error)
+	assert.Equal(t, "jane-doe-2", user.Username)
+	assert.Equal(t, "jane@test.com", user.Email)
+	// The most privileged role the groups grant wins
+	assert.Equal(t, models.RoleMaintainer, orgRole(user.ID, org.ID))
+
+	groups = []interface{}{"developers"}
+	login()
+	assert.Equal(t, models.RolePublisher, orgRole(user.ID, org.ID))
+	event, _ := lastAuditEvent(t, AuditOrgChangeRole)
+	assert.Equal(t, "testoidcorg", event.TargetName)
+
+	groups = []interface{}{}
+	login()
+	assert.Empty(t, orgRole(user.ID, org.ID))
+	assert.Equal(t, models.RoleOwner, orgRole(owner.ID, org.ID))
+
+	// With two-factor authentication, groups only apply once the code is given
+	secret := enable2FA(t, &user)
+	app.Post("/login/2fa", PostLogin2FA)
+	groups = []interface{}{"leads"}
+	location, cookie := oidcLogin(t, app, issuer.URL)
+	require.Equal(t, "/login/2fa", location)
+	assert.Empty(t, orgRole(user.ID, org.ID))
+	code, _ := totp.CodeAt(secret, totp.Step(time.Now()))
+	resp, _ := postWithCookie(t, app, "/login/2fa", "code="+code, cookie)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, models.RoleMaintainer, orgRole(user.ID, org.ID))
+}
+
+func TestOIDCLogin_EmailLinking(t *testing.T) {
+	defer cleanupTestData(t)
+	defer func() { oidcConfig = nil }()
+
+	existing := createTestUser(t, "localuser")
+	var verified interface{}
+	issuer := newMockOIDCIssuer(t, func() map[string]interface{} {
+		claims := map[string]interface{}{"sub": "oidc-local", "email": "localuser@test.com"}
+		if verified != nil {
+			claims["email_verified"] = verified
+		}
+		return claims
+	})
+	app := setupTestApp()
+	require.NoError(t, ConfigureOIDC(&OIDCConfig{DiscoveryURL: issuer.URL, ClientID: "ramble"},
+		"http://localhost:3000/auth/oidc/callback"))
+	app.Get("/auth/:provider", BeginAuth)
+	app.Get("/auth/:provider/callback", AuthCallback)
+
+	// Without the provider vouching for the address, the account isn't linked
+	for _, v := range []interface{}{nil, false} {
+		verified = v
+		location, _ := oidcLogin(t, app, issuer.URL)
+		assert.Equal(t, "/login", location)
+		require.NoError(t, database.DB.First(&existing, existing.ID).Error)
+		assert.Empty(t, existing.ProviderID)
+	}
+
+	verified = true
+	location, _ := oidcLogin(t, app, issuer.URL)
+	assert.Equal(t, "/", location)
+	require.NoError(t, database.DB.First(&existing, existing.ID).Error)
+	assert.Equal(t, "oidc-local", existing.ProviderID)
+}
+
+func TestParseOIDCGroupRoles(t *testing.T) {
+	groupRoles, err := parseOIDCGroupRoles("admins=platform:owner, /eng/devs=platform:publisher,admins=tools:maintainer")
+	require.NoError(t, err)
+	assert.Equal(t, []OIDCGroupRole{{"platform", "owner"}, {"tools", "maintainer"}}, groupRoles["admins"])
+	assert.Equal(t, []OIDCGroupRole{{"platform", "publisher"}}, groupRoles["/eng/devs"])
+
+	groupRoles, err = parseOIDCGroupRoles("")
+	require.NoError(t, err)
+	assert.Empty(t, groupRoles)
+
+	_, err = parseOIDCGroupRoles("admins=platform:superuser")
+	assert.Error(t, err)
+	_, err = parseOIDCGroupRoles("admins:owner")
+	assert.Error(t, err)
+	_, err = parseOIDCGroupRoles("admins=:owner")
+	assert.Error(t, err)
+}
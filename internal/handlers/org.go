@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"encoding/json"
 	"rmbl/internal/database"
 	"rmbl/internal/models"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -34,11 +36,11 @@ func PostCreateOrg(c *fiber.Ctx) error {
 
     // Check if name is taken by a User or another Org
     var count int64
-    database.DB.Model(&models.User{}).Where("username ILIKE ?", orgName).Count(&count)
+    database.DB.Model(&models.User{}).Where("username ILIKE ?", escapeLikeString(orgName)).Count(&count)
     if count > 0 {
         return c.Status(400).SendString("This name is already taken by a user")
     }
-    database.DB.Model(&models.Organization{}).Where("name ILIKE ?", orgName).Count(&count)
+    database.DB.Model(&models.Organization{}).Where("name ILIKE ?", escapeLikeString(orgName)).Count(&count)
     if count > 0 {
         return c.Status(400).SendString("This organization name is already taken")
     }
@@ -57,9 +59,10 @@ func PostCreateOrg(c *fiber.Ctx) error {
     membership := models.Membership{
         UserID:         userID,
         OrganizationID: org.ID,
-        Role:           "owner",
+        Role:           models.RoleOwner,
     }
     database.DB.Create(&membership)
+    recordAudit(c, AuditOrgCreate, AuditTargetOrganization, org.ID, org.Name, auditDiff(nil, orgAuditFields(org)))
 
     SetFlash(c, "success", "Organization '"+org.Name+"' created successfully!")
     c.Set("HX-Redirect", "/"+org.Name)
@@ -73,14 +76,16 @@ func RequireOrgOwner(c *fiber.Ctx) error {
 	userID := sess.Get("user_id").(uint)
 
 	var org models.Organization
-	if err := database.DB.Where("name ILIKE ?", orgName).First(&org).Error; err != nil {
+	if err := database.DB.Where("name ILIKE ?", escapeLikeString(orgName)).First(&org).Error; err != nil {
 		return c.Status(404).SendString("Organization not found")
 	}
 
-	var membership models.Membership
-	if err := database.DB.Where("user_id = ? AND organization_id = ? AND role = 'owner'", userID, org.ID).First(&membership).Error; err != nil {
+	if orgRole(userID, org.ID) != models.RoleOwner {
 		return c.Status(403).SendString("You must be an owner of this organization")
 	}
+	if org2FABlocks(userID, org) {
+		return c.Status(403).SendString(org2FAMessage)
+	}
 
 	c.Locals("OrgID", org.ID)
 	return c.Next()
@@ -89,10 +94,19 @@ func RequireOrgOwner(c *fiber.Ctx) error {
 func GetOrgSettings(c *fiber.Ctx) error {
 	orgName := c.Params("orgname")
 	var org models.Organization
-	database.DB.Preload("Memberships.User").Where("name ILIKE ?", orgName).First(&org)
+	database.DB.Preload("Memberships.User").Where("name ILIKE ?", escapeLikeString(orgName)).First(&org)
+
+	var invitations []models.Invitation
+	database.DB.Preload("InvitedBy").Where("organization_id = ?", org.ID).Order("created_at DESC").Find(&invitations)
 
 	return c.Render("org_settings", MergeContext(BaseContext(c), fiber.Map{
 		"Organization": org,
+		"IsOrgOwner":   orgRole(currentUserID(c), org.ID) == models.RoleOwner,
+		"Roles":        models.OrgRoles,
+		"Permissions":  Permissions,
+		"Matrix":       rolePermissions(org),
+		"Invitations":  invitations,
+		"Now":          time.Now(),
 	}), "layouts/main")
 }
 
@@ -101,61 +115,118 @@ func PostUpdateOrg(c *fiber.Ctx) error {
 	var org models.Organization
 	database.DB.First(&org, orgID)
 
+	before := orgAuditFields(org)
+	require2FA := c.FormValue("require_2fa") == "on"
+	if require2FA && !org.Require2FA && !userSatisfies2FA(currentUserID(c)) {
+		return c.Status(400).SendString("Turn on two-factor authentication for your own account first")
+	}
 	org.Description = c.FormValue("description")
+	org.Require2FA = require2FA
 	database.DB.Save(&org)
+	recordAudit(c, AuditOrgEdit, AuditTargetOrganization, org.ID, org.Name, auditDiff(before, orgAuditFields(org)))
 
 	SetFlash(c, "success", "Organization settings updated.")
 	c.Set("HX-Redirect", "/"+org.Name+"/settings")
 	return c.SendStatus(200)
 }
 
-func PostAddMember(c *fiber.Ctx) error {
+func PostRemoveMember(c *fiber.Ctx) error {
 	orgID := c.Locals("OrgID").(uint)
-	username := c.FormValue("username")
-	role := c.FormValue("role")
+	memberIDStr := c.Params("member_id")
+	memberID, _ := strconv.ParseUint(memberIDStr, 10, 32)
+
+	role := orgRole(uint(memberID), orgID)
+	if role == models.RoleOwner {
+		if orgRole(currentUserID(c), orgID) != models.RoleOwner {
+			return c.Status(403).SendString("Only owners can remove owners")
+		}
+		if isLastOwner(orgID) {
+			return c.Status(400).SendString("An organization needs at least one owner")
+		}
+	}
 
-	var user models.User
-	if err := database.DB.Where("username = ?", username).First(&user).Error; err != nil {
-		return c.Status(404).SendString("User not found")
+	database.DB.Where("user_id = ? AND organization_id = ?", uint(memberID), orgID).Delete(&models.Membership{})
+	if role != "" {
+		auditOrg(c, AuditOrgRemoveMember, orgID, map[string]AuditChange{memberAuditKey(uint(memberID)): {Before: role}})
 	}
 
-	// Check if already a member
-	var existing models.Membership
-	if err := database.DB.Where("user_id = ? AND organization_id = ?", user.ID, orgID).First(&existing).Error; err == nil {
-		return c.Status(400).SendString("User is already a member")
+	SetFlash(c, "success", "Member removed.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// isLastOwner reports whether an organization has at most one owner left
+func isLastOwner(orgID uint) bool {
+	var ownerCount int64
+	database.DB.Model(&models.Membership{}).Where("organization_id = ? AND role = ?", orgID, models.RoleOwner).Count(&ownerCount)
+	return ownerCount <= 1
+}
+
+// PostUpdateMemberRole changes a member's role. Only owners can make or
+// unmake owners, and the last owner keeps their role.
+func PostUpdateMemberRole(c *fiber.Ctx) error {
+	orgID := c.Locals("OrgID").(uint)
+	memberID, _ := strconv.ParseUint(c.Params("member_id"), 10, 32)
+	role := c.FormValue("role")
+	if !isValidRole(role) {
+		return c.Status(400).SendString("Unknown role")
 	}
 
-	membership := models.Membership{
-		UserID:         user.ID,
-		OrganizationID: orgID,
-		Role:           role,
+	current := orgRole(uint(memberID), orgID)
+	if current == "" {
+		return c.Status(404).SendString("Member not found")
+	}
+	if (role == models.RoleOwner || current == models.RoleOwner) && orgRole(currentUserID(c), orgID) != models.RoleOwner {
+		return c.Status(403).SendString("Only owners can change who is an owner")
+	}
+	if current == models.RoleOwner && role != models.RoleOwner && isLastOwner(orgID) {
+		return c.Status(400).SendString("An organization needs at least one owner")
 	}
-	database.DB.Create(&membership)
 
-	SetFlash(c, "success", "Member added successfully.")
+	database.DB.Model(&models.Membership{}).Where("user_id = ? AND organization_id = ?", uint(memberID), orgID).Update("role", role)
+	auditOrg(c, AuditOrgChangeRole, orgID, auditDiff(
+		map[string]interface{}{memberAuditKey(uint(memberID)): current},
+		map[string]interface{}{memberAuditKey(uint(memberID)): role},
+	))
+
+	SetFlash(c, "success", "Role updated.")
 	c.Set("HX-Refresh", "true")
 	return c.SendStatus(200)
 }
 
-func PostRemoveMember(c *fiber.Ctx) error {
+// PostUpdateOrgRoles saves the organization's permission matrix from a form
+// with one field per role listing the permissions it is granted. Owners keep
+// every permission whatever the form says.
+func PostUpdateOrgRoles(c *fiber.Ctx) error {
 	orgID := c.Locals("OrgID").(uint)
-	memberIDStr := c.Params("member_id")
-	memberID, _ := strconv.ParseUint(memberIDStr, 10, 32)
 
-	// Don't allow removing yourself if you're the only owner
-	sess, _ := Store.Get(c)
-	currentUserID := sess.Get("user_id").(uint)
-	if uint(memberID) == currentUserID {
-		var ownerCount int64
-		database.DB.Model(&models.Membership{}).Where("organization_id = ? AND role = 'owner'", orgID).Count(&ownerCount)
-		if ownerCount <= 1 {
-			return c.Status(400).SendString("You cannot remove yourself as you are the only owner")
+	valid := map[Permission]bool{}
+	for _, p := range Permissions {
+		valid[p.Key] = true
+	}
+	matrix := map[string][]Permission{}
+	for _, role := range models.OrgRoles {
+		if role == models.RoleOwner {
+			continue
+		}
+		matrix[role] = []Permission{}
+		for _, value := range c.Request().PostArgs().PeekMulti(role) {
+			if p := Permission(value); valid[p] {
+				matrix[role] = append(matrix[role], p)
+			}
 		}
 	}
 
-	database.DB.Where("user_id = ? AND organization_id = ?", uint(memberID), orgID).Delete(&models.Membership{})
+	var org models.Organization
+	database.DB.First(&org, orgID)
+	before := rolePermissions(org)
 
-	SetFlash(c, "success", "Member removed.")
+	data, _ := json.Marshal(matrix)
+	database.DB.Model(&models.Organization{}).Where("id = ?", orgID).Update("role_permissions", string(data))
+	org.RolePermissions = string(data)
+	recordAudit(c, AuditOrgEditRoles, AuditTargetOrganization, org.ID, org.Name, auditDiff(roleAuditFields(before), roleAuditFields(rolePermissions(org))))
+
+	SetFlash(c, "success", "Role permissions updated.")
 	c.Set("HX-Refresh", "true")
 	return c.SendStatus(200)
-}
\ No newline at end of file
+}
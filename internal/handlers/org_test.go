@@ -13,6 +13,7 @@ import (
 
 // cleanupOrgTestData removes org-related test data
 func cleanupOrgTestData(t *testing.T) {
+	database.DB.Exec("DELETE FROM invitations")
 	database.DB.Exec("DELETE FROM memberships")
 	database.DB.Exec("DELETE FROM organizations WHERE name LIKE 'test%'")
 	cleanupTestData(t)
@@ -202,7 +203,7 @@ func TestRequireOrgOwner_IsOwner(t *testing.T) {
 	assert.Equal(t, 200, resp.StatusCode)
 }
 
-func TestPostAddMember(t *testing.T) {
+func TestPostInviteMember(t *testing.T) {
 	defer cleanupOrgTestData(t)
 
 	owner := createTestUser(t, "addmemberowner")
@@ -225,10 +226,10 @@ func TestPostAddMember(t *testing.T) {
 	})
 
 	// RequireOrgOwner sets c.Locals("OrgID")
-	app.Post("/orgs/:orgname/members/add", RequireOrgOwner, PostAddMember)
+	app.Post("/orgs/:orgname/invitations", RequireOrgOwner, PostInviteMember)
 
-	payload := strings.NewReader("username=" + newMember.Username)
-	req := httptest.NewRequest("POST", "/orgs/testaddmemberorg/members/add", payload)
+	payload := strings.NewReader("invitee=" + newMember.Username)
+	req := httptest.NewRequest("POST", "/orgs/testaddmemberorg/invitations", payload)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	resp, err := app.Test(req)
@@ -236,11 +237,13 @@ func TestPostAddMember(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 200, resp.StatusCode)
 
-	// Verify membership was created
-	var membership models.Membership
-	err = database.DB.Where("user_id = ? AND organization_id = ?", newMember.ID, org.ID).First(&membership).Error
+	// They are invited by their email address, not added yet
+	var invitation models.Invitation
+	err = database.DB.Where("organization_id = ?", org.ID).First(&invitation).Error
 	assert.NoError(t, err)
-	assert.Equal(t, "member", membership.Role)
+	assert.Equal(t, newMember.Email, invitation.Email)
+	assert.Equal(t, models.RoleViewer, invitation.Role)
+	assert.Equal(t, "", orgRole(newMember.ID, org.ID))
 }
 
 func TestPostRemoveMember(t *testing.T) {
@@ -495,9 +498,9 @@ func TestPostCreateOrg_WhitespaceName(t *testing.T) {
 	assert.Equal(t, 400, resp.StatusCode)
 }
 
-// PostAddMember Edge Cases
+// PostInviteMember Edge Cases
 
-func TestPostAddMember_UserNotFound(t *testing.T) {
+func TestPostInviteMember_UserNotFound(t *testing.T) {
 	defer cleanupOrgTestData(t)
 
 	owner := createTestUser(t, "addmemberowner2")
@@ -518,10 +521,10 @@ func TestPostAddMember_UserNotFound(t *testing.T) {
 		return c.Next()
 	})
 
-	app.Post("/orgs/:orgname/members/add", RequireOrgOwner, PostAddMember)
+	app.Post("/orgs/:orgname/invitations", RequireOrgOwner, PostInviteMember)
 
-	payload := strings.NewReader("username=nonexistentuser")
-	req := httptest.NewRequest("POST", "/orgs/testaddmemberorg2/members/add", payload)
+	payload := strings.NewReader("invitee=nonexistentuser")
+	req := httptest.NewRequest("POST", "/orgs/testaddmemberorg2/invitations", payload)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	resp, err := app.Test(req)
@@ -530,7 +533,7 @@ func TestPostAddMember_UserNotFound(t *testing.T) {
 	assert.Equal(t, 404, resp.StatusCode)
 }
 
-func TestPostAddMember_AlreadyMember(t *testing.T) {
+func TestPostInviteMember_AlreadyMember(t *testing.T) {
 	defer cleanupOrgTestData(t)
 
 	owner := createTestUser(t, "addmemberowner3")
@@ -553,10 +556,10 @@ func TestPostAddMember_AlreadyMember(t *testing.T) {
 		return c.Next()
 	})
 
-	app.Post("/orgs/:orgname/members/add", RequireOrgOwner, PostAddMember)
+	app.Post("/orgs/:orgname/invitations", RequireOrgOwner, PostInviteMember)
 
-	payload := strings.NewReader("username=" + existingMember.Username)
-	req := httptest.NewRequest("POST", "/orgs/testaddmemberorg3/members/add", payload)
+	payload := strings.NewReader("invitee=" + existingMember.Username)
+	req := httptest.NewRequest("POST", "/orgs/testaddmemberorg3/invitations", payload)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	resp, err := app.Test(req)
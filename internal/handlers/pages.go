@@ -44,6 +44,7 @@ func getDocSections() []DocSection {
 				{Path: "cli/job", Title: "Job Commands"},
 				{Path: "cli/registry", Title: "Registry Commands"},
 				{Path: "cli/cache", Title: "Cache Commands"},
+				{Path: "cli/publish", Title: "Publishing"},
 			},
 		},
 		{
@@ -127,6 +128,7 @@ func getPageTitle(page string) string {
 		"cli/job":         "Job Commands",
 		"cli/registry":    "Registry Commands",
 		"cli/cache":       "Cache Commands",
+		"cli/publish":     "Publishing",
 		"api":             "API Reference",
 	}
 	if title, ok := titles[page]; ok {
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Ingestion queue tuning
+const (
+	maxIngestAttempts  = 6
+	ingestBaseBackoff  = 30 * time.Second
+	ingestMaxBackoff   = time.Hour
+	ingestLease        = 10 * time.Minute // A claimed job is retried if its worker dies
+	ingestPollInterval = 15 * time.Second
+)
+
+// ingestWake nudges an idle worker when a job is enqueued, so new versions
+// do not wait for the next poll
+var ingestWake = make(chan struct{}, 1)
+
+// enqueueIngest marks a version as pending and queues it for ingestion. A
+// version already in the queue is moved to the front with its attempts reset
+// and its claim dropped, so a worker still running it doesn't finish it.
+func enqueueIngest(versionID uint) error {
+	now := time.Now()
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.ResourceVersion{}).Where("id = ?", versionID).
+			Updates(map[string]interface{}{"ingest_state": models.IngestPending, "ingest_error": ""}).Error; err != nil {
+			return err
+		}
+		job := models.IngestJob{ResourceVersionID: versionID, RunAt: now}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "resource_version_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"run_at": now, "attempts": 0, "claim_token": "", "last_error": "", "updated_at": now}),
+		}).Create(&job).Error
+	})
+	if err != nil {
+		log.Printf("Could not queue ingestion of version %d: %v", versionID, err)
+		return err
+	}
+
+	select {
+	case ingestWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// StartIngestWorkers starts n workers that process the ingestion queue until
+// ctx is cancelled. Jobs survive restarts, so work queued before a shutdown is
+// picked up again.
+func StartIngestWorkers(ctx context.Context, n int) {
+	for i := 0; i < n; i++ {
+		go ingestWorker(ctx)
+	}
+}
+
+func ingestWorker(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ingestWake:
+		case <-timer.C:
+		}
+		for ctx.Err() == nil && processNextIngestJob() {
+		}
+		timer.Reset(ingestPollInterval)
+	}
+}
+
+// processNextIngestJob claims the next due job and runs it. It reports
+// whether a job was found.
+func processNextIngestJob() bool {
+	job, err := claimIngestJob()
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Could not claim ingestion job: %v", err)
+		}
+		return false
+	}
+	runIngestJob(job)
+	return true
+}
+
+// claimIngestJob locks the next due job, counts the attempt and pushes its
+// run time out by the lease, so no other worker picks it up meanwhile. The
+// job gets a new claim token; a worker only finishes or reschedules a job
+// that still carries its token.
+func claimIngestJob() (models.IngestJob, error) {
+	var job models.IngestJob
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("run_at <= ?", time.Now()).Order("run_at").First(&job).Error; err != nil {
+			return err
+		}
+		job.Attempts++
+		job.RunAt = time.Now().Add(ingestLease)
+		job.ClaimToken = newClaimToken()
+		return tx.Model(&job).Updates(map[string]interface{}{"attempts": job.Attempts, "run_at": job.RunAt, "claim_token": job.ClaimToken}).Error
+	})
+	return job, err
+}
+
+func runIngestJob(job models.IngestJob) {
+	var version models.ResourceVersion
+	if err := database.DB.First(&version, job.ResourceVersionID).Error; err != nil {
+		finishIngestJob(job) // The version was deleted
+		return
+	}
+
+	err := ingestVersion(version.ResourceID, version.Version)
+	if err == nil || job.Attempts >= maxIngestAttempts {
+		// A job re-queued while it ran goes again, so this result is stale
+		if !finishIngestJob(job) {
+			return
+		}
+		if err != nil {
+			log.Printf("Ingestion of version %d failed after %d attempts: %v", version.ID, job.Attempts, err)
+		}
+		recordIngestResult(version.ID, err)
+		return
+	}
+
+	result := database.DB.Model(&models.IngestJob{}).Where("id = ? AND claim_token = ?", job.ID, job.ClaimToken).Updates(map[string]interface{}{
+		"run_at": time.Now().Add(ingestBackoff(job.Attempts)), "last_error": err.Error(),
+	})
+	if result.RowsAffected == 1 {
+		// Keep the version pending but show why it is being retried
+		database.DB.Model(&models.ResourceVersion{}).Where("id = ?", version.ID).Update("ingest_error", err.Error())
+	}
+}
+
+// finishIngestJob removes a job and reports whether it did. A job that was
+// re-queued while running has lost its claim token and is left to run again.
+func finishIngestJob(job models.IngestJob) bool {
+	result := database.DB.Where("id = ? AND claim_token = ?", job.ID, job.ClaimToken).Delete(&models.IngestJob{})
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// newClaimToken returns a random token for a job claim
+func newClaimToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("failed to generate claim token: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// recordIngestResult stores the outcome of an ingestion on the version and
+// reindexes the resource once the version's content is in
+func recordIngestResult(versionID uint, err error) {
+	updates := map[string]interface{}{"ingest_state": models.IngestReady, "ingest_error": "", "ingested_at": time.Now()}
+	if err != nil {
+		updates = map[string]interface{}{"ingest_state": models.IngestFailed, "ingest_error": err.Error()}
+	}
+	database.DB.Model(&models.ResourceVersion{}).Where("id = ?", versionID).Updates(updates)
+
+	var version models.ResourceVersion
+	if err == nil && database.DB.First(&version, versionID).Error == nil {
+		refreshSearchIndex(version.ResourceID)
+	}
+}
+
+// ingestBackoff returns the delay before retry number attempt: 30s, 1m, 2m
+// and so on, capped at an hour
+func ingestBackoff(attempt int) time.Duration {
+	d := ingestBaseBackoff
+	for i := 1; i < attempt && d < ingestMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > ingestMaxBackoff {
+		d = ingestMaxBackoff
+	}
+	return d
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createFailingVersion returns a version whose repository URL cannot be read,
+// so every ingestion attempt fails without touching the network
+func createFailingVersion(t *testing.T, userID uint, name string) models.ResourceVersion {
+	resource := models.NomadResource{
+		Name:          name,
+		Type:          models.ResourceTypePack,
+		UserID:        userID,
+		GitProvider:   "github",
+		RepositoryURL: "not-a-repository",
+	}
+	require.NoError(t, database.DB.Create(&resource).Error)
+	version := models.ResourceVersion{ResourceID: resource.ID, Version: "v1.0.0", IngestState: models.IngestPending}
+	require.NoError(t, database.DB.Create(&version).Error)
+	return version
+}
+
+func TestIngestBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, ingestBackoff(1))
+	assert.Equal(t, time.Minute, ingestBackoff(2))
+	assert.Equal(t, 2*time.Minute, ingestBackoff(3))
+	assert.Equal(t, time.Hour, ingestBackoff(20))
+}
+
+func TestEnqueueIngest(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "queueuser1")
+	version := createFailingVersion(t, user.ID, "queue-pack-1")
+	database.DB.Model(&version).Updates(map[string]interface{}{"ingest_state": models.IngestFailed, "ingest_error": "boom"})
+
+	require.NoError(t, enqueueIngest(version.ID))
+	database.DB.Model(&models.IngestJob{}).Where("resource_version_id = ?", version.ID).Update("attempts", 3)
+	require.NoError(t, enqueueIngest(version.ID))
+
+	var jobs []models.IngestJob
+	database.DB.Where("resource_version_id = ?", version.ID).Find(&jobs)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, 0, jobs[0].Attempts)
+
+	var updated models.ResourceVersion
+	database.DB.First(&updated, version.ID)
+	assert.Equal(t, models.IngestPending, updated.IngestState)
+	assert.Empty(t, updated.IngestError)
+}
+
+func TestProcessNextIngestJob_RetriesThenFails(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "queueuser2")
+	version := createFailingVersion(t, user.ID, "queue-pack-2")
+	require.NoError(t, enqueueIngest(version.ID))
+
+	// First failure is retried later
+	assert.True(t, processNextIngestJob())
+	var job models.IngestJob
+	require.NoError(t, database.DB.Where("resource_version_id = ?", version.ID).First(&job).Error)
+	assert.Equal(t, 1, job.Attempts)
+	assert.NotEmpty(t, job.LastError)
+	assert.True(t, job.RunAt.After(time.Now()))
+
+	var updated models.ResourceVersion
+	database.DB.First(&updated, version.ID)
+	assert.Equal(t, models.IngestPending, updated.IngestState)
+	assert.NotEmpty(t, updated.IngestError)
+
+	// Nothing else is due
+	assert.False(t, processNextIngestJob())
+
+	// The last attempt marks the version as failed and drops the job
+	database.DB.Model(&job).Updates(map[string]interface{}{"attempts": maxIngestAttempts - 1, "run_at": time.Now().Add(-time.Second)})
+	assert.True(t, processNextIngestJob())
+
+	var count int64
+	database.DB.Model(&models.IngestJob{}).Where("resource_version_id = ?", version.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	database.DB.First(&updated, version.ID)
+	assert.Equal(t, models.IngestFailed, updated.IngestState)
+	assert.NotEmpty(t, updated.IngestError)
+}
+
+func TestFinishIngestJob_RequeuedWhileRunning(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "queueuser4")
+	version := createFailingVersion(t, user.ID, "queue-pack-4")
+	require.NoError(t, enqueueIngest(version.ID))
+
+	stale, err := claimIngestJob()
+	require.NoError(t, err)
+	require.NotEmpty(t, stale.ClaimToken)
+
+	// Re-queued while the first worker runs it, then claimed again
+	require.NoError(t, enqueueIngest(version.ID))
+	assert.False(t, finishIngestJob(stale))
+	current, err := claimIngestJob()
+	require.NoError(t, err)
+	assert.Equal(t, stale.ID, current.ID)
+	assert.Equal(t, 1, current.Attempts)
+
+	// Only the worker holding the current claim finishes it
+	assert.False(t, finishIngestJob(stale))
+	assert.True(t, finishIngestJob(current))
+
+	var count int64
+	database.DB.Model(&models.IngestJob{}).Where("resource_version_id = ?", version.ID).Count(&count)
+	assert.Zero(t, count)
+}
+
+func TestPostRetryIngestion(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "queueuser3")
+	version := createFailingVersion(t, user.ID, "queue-pack-3")
+	database.DB.Model(&version).Update("ingest_state", models.IngestFailed)
+
+	app := setupTestApp()
+	app.Post("/admin/ingestion/:id/retry", PostRetryIngestion)
+
+	req := httptest.NewRequest("POST", "/admin/ingestion/"+toString(version.ID)+"/retry", nil)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var count int64
+	database.DB.Model(&models.IngestJob{}).Where("resource_version_id = ?", version.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
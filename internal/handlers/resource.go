@@ -2,11 +2,11 @@ package handlers
 
 import (
 	"crypto/rand"
-	"crypto/subtle"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"regexp"
 	"rmbl/internal/database"
+	"rmbl/internal/gitprovider"
 	"rmbl/internal/models"
 	"strconv"
 	"strings"
@@ -30,10 +30,17 @@ func generateWebhookSecret() string {
 
 // Middleware to check if user is authenticated
 func RequireAuth(c *fiber.Ctx) error {
+	// Routes that accept API tokens use RequireAuthOrToken instead
+	if isTokenRequest(c) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "This endpoint does not accept API tokens"})
+	}
 	sess, err := Store.Get(c)
 	if err != nil || sess.Get("user_id") == nil {
 		return c.Redirect("/login")
 	}
+	if isSuspended(c) {
+		return c.Status(fiber.StatusForbidden).SendString("Your account has been suspended")
+	}
 	return c.Next()
 }
 
@@ -53,6 +60,9 @@ func RequireVerifiedEmail(c *fiber.Ctx) error {
 
 	// Check if email is verified
 	if !user.EmailVerified {
+		if isTokenRequest(c) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Email address must be verified"})
+		}
 		SetFlash(c, "error", "Please verify your email address before performing this action. Check your inbox for the verification link.")
 		return c.Redirect("/")
 	}
@@ -60,6 +70,38 @@ func RequireVerifiedEmail(c *fiber.Ctx) error {
 	return c.Next()
 }
 
+// sameOrg reports whether two optional organization IDs name the same namespace
+func sameOrg(a, b *uint) bool {
+	if a == nil || b == nil { return a == b }
+	return *a == *b
+}
+
+// parseOwnerOrg resolves an owner form value of "org:<id>" or "org:<name>" to an
+// organization ID. Any other value means the user's personal namespace.
+func parseOwnerOrg(owner string) (*uint, bool) {
+	if !strings.HasPrefix(owner, "org:") { return nil, true }
+	ref := strings.TrimPrefix(owner, "org:")
+	var org models.Organization
+	if id, err := strconv.ParseUint(ref, 10, 32); err == nil {
+		if database.DB.First(&org, uint(id)).Error != nil { return nil, false }
+	} else if database.DB.Where("name ILIKE ?", escapeLikeString(ref)).First(&org).Error != nil { return nil, false }
+	return &org.ID, true
+}
+
+// resourceJSON is the response body returned to API token callers after a change
+func resourceJSON(c *fiber.Ctx, resource models.NomadResource) fiber.Map {
+	database.DB.Preload("User").Preload("Organization").First(&resource, resource.ID)
+	namespace := getResourceNamespace(resource)
+	return fiber.Map{
+		"id":         resource.ID,
+		"name":       resource.Name,
+		"namespace":  namespace,
+		"type":       resource.Type,
+		"visibility": resource.Visibility,
+		"url":        GetBaseURL(c) + "/" + namespace + "/" + resource.Name,
+	}
+}
+
 func GetNewResource(c *fiber.Ctx) error {
 	isLoggedIn := c.Locals("UserID") != nil
 	var user models.User
@@ -74,6 +116,7 @@ func GetNewResource(c *fiber.Ctx) error {
 	return c.Render("new_resource", MergeContext(BaseContext(c), fiber.Map{
 		"Provider":      user.Provider,
 		"Organizations": orgs,
+		"GitProviders":  gitprovider.Kinds,
 	}), "layouts/main")
 }
 
@@ -114,6 +157,8 @@ func FetchInfo(c *fiber.Ctx) error {
 	repoURL := c.Query("repository_url"); currentType := c.Query("type"); existingTags := c.Query("tags")
 	if repoURL == "" { return c.SendString("") }
 
+	kind := c.Query("git_provider")
+
 	// Try to get token from session
 	var token string
 	sess, err := Store.Get(c)
@@ -121,40 +166,22 @@ func FetchInfo(c *fiber.Ctx) error {
 		if uID := sess.Get("user_id"); uID != nil {
 			var user models.User
 			database.DB.First(&user, uID.(uint))
-			if user.AccessToken != "" {
-				token = user.AccessToken
-			}
+			token = oauthTokenFor(user, repoURL)
 		}
 	}
 
 	name := ""; description := ""; license := ""; filePath := ""; version := "v1.0.0"; var tags []string
-	if strings.Contains(repoURL, "github.com") {
-		repo, err := fetchGitHubMetadata(repoURL, token)
-		if err == nil {
-			name = repo.Name; description = repo.Description; license = repo.License.SpdxID
-			tags = append(tags, repo.Topics...)
+	if provider, err := gitprovider.New(kind, repoURL, token); err == nil {
+		if meta, err := provider.Metadata(); err == nil {
+			name = meta.Name; description = meta.Description; license = meta.License
+			tags = append(tags, meta.Topics...)
 		}
 
 		if currentType == "job" {
-			if v, err := fetchGitHubLatestTag(repoURL, token); err == nil {
-				version = v
+			if t, err := provider.Tags(); err == nil && len(t) > 0 {
+				version = t[0]
 			}
-			if f, err := fetchGitHubJobFile(repoURL, token); err == nil {
-				filePath = f
-			}
-		}
-	} else if strings.Contains(repoURL, "gitlab.com") {
-		repo, err := fetchGitLabMetadata(repoURL, token)
-		if err == nil {
-			name = repo.Name; description = repo.Description
-			tags = append(tags, repo.TagList...)
-		}
-
-		if currentType == "job" {
-			if v, err := fetchGitLabLatestTag(repoURL, token); err == nil {
-				version = v
-			}
-			if f, err := fetchGitLabJobFile(repoURL, token); err == nil {
+			if f, err := findJobFile(provider); err == nil {
 				filePath = f
 			}
 		}
@@ -185,7 +212,7 @@ func FetchInfo(c *fiber.Ctx) error {
 	}
 	if currentType == "job" && filePath == "" { filePath = name + ".nomad.hcl"
 	} else if currentType == "pack" {
-		if metaBody, err := downloadFile(repoURL, "metadata.hcl"); err == nil && metaBody != "" {
+		if metaBody, err := downloadFile(kind, repoURL, "metadata.hcl"); err == nil && metaBody != "" {
 			if meta, err := parsePackMetadata(metaBody); err == nil {
 				name = meta.Pack.Name; description = meta.Pack.Description
 				return c.Render("partials/resource_form_fields", fiber.Map{"Name": name, "License": license, "Description": description, "Version": meta.Pack.Version, "Type": currentType, "FilePath": filePath, "Tags": tagsString})
@@ -202,34 +229,46 @@ func FetchInfo(c *fiber.Ctx) error {
 // @Accept x-www-form-urlencoded
 // @Param name formData string true "Resource name"
 // @Param type formData string true "Resource type (job, pack)"
-// @Param owner formData string true "Namespace owner (user or org:ID)"
+// @Param owner formData string true "Namespace owner (user, org:ID or org:name)"
 // @Param repository_url formData string true "Git repository URL"
 // @Param version formData string true "Initial version"
+// @Param default_branch formData string false "Branch followed by branch-tracking versions"
+// @Param ref formData string false "Git ref to read the initial version from, if not the tag named by version"
+// @Param visibility formData string false "public (default), internal or private"
 // @Success 302 {string} string "Redirect to new resource"
+// @Success 201 {object} map[string]interface{} "Created resource (API token requests)"
 // @Failure 400 {string} string "Bad Request"
+// @Security BearerAuth
 // @Router /new [post]
 func PostNewResource(c *fiber.Ctx) error {
-	sess, err := Store.Get(c); if err != nil { return c.Status(fiber.StatusUnauthorized).SendString("Unauthorized") }
-	userID := sess.Get("user_id").(uint)
+	userID := currentUserID(c); if userID == 0 { return apiError(c, fiber.StatusUnauthorized, "Unauthorized") }
 	type ResourceInput struct {
 		Name string `form:"name"`; Type string `form:"type"`; Owner string `form:"owner"`
 		Description string `form:"description"`; RepositoryURL string `form:"repository_url"`
 		FilePath string `form:"file_path"`; Version string `form:"version"`; License string `form:"license"`; Tags string `form:"tags"`
+		GitProvider string `form:"git_provider"`; DefaultBranch string `form:"default_branch"`; Ref string `form:"ref"`; TrackBranch bool `form:"track_branch"`
+		Visibility string `form:"visibility"`
 	}
 	var input ResourceInput
-	if err := c.BodyParser(&input); err != nil { return c.Status(fiber.StatusBadRequest).SendString("Invalid input") }
-	if input.Name == "" || input.Version == "" { return c.Status(fiber.StatusBadRequest).SendString("Name and Version are required") }
-	var orgID *uint
-	if strings.HasPrefix(input.Owner, "org:") {
-		id, _ := strconv.ParseUint(strings.TrimPrefix(input.Owner, "org:"), 10, 32); val := uint(id); orgID = &val
-	}
+	if err := c.BodyParser(&input); err != nil { return apiError(c, fiber.StatusBadRequest, "Invalid input") }
+	if input.Name == "" || input.Version == "" { return apiError(c, fiber.StatusBadRequest, "Name and Version are required") }
+	if input.GitProvider != "" && !gitprovider.IsValidKind(input.GitProvider) { return apiError(c, fiber.StatusBadRequest, "Unknown git provider") }
+	if !isValidRef(input.Ref) || !isValidRef(input.DefaultBranch) { return apiError(c, fiber.StatusBadRequest, "Invalid git ref") }
+	if input.Visibility == "" { input.Visibility = models.VisibilityPublic }
+	if !isValidVisibility(input.Visibility) { return apiError(c, fiber.StatusBadRequest, "Visibility must be public, internal or private") }
+	orgID, ok := parseOwnerOrg(input.Owner); if !ok { return apiError(c, fiber.StatusBadRequest, "Organization not found") }
+	if token, ok := apiTokenFromCtx(c); ok && input.Owner == "" && token.OrganizationID != nil {
+		orgID = token.OrganizationID // Organization tokens publish to their organization by default
+	}
+	if orgID != nil && !orgAllows(userID, *orgID, PermPublish) { return apiError(c, fiber.StatusForbidden, "Your role in this organization does not allow publishing") }
+	if !tokenAllowsNamespace(c, orgID) { return apiError(c, fiber.StatusForbidden, "This token cannot publish to that namespace") }
 	var existing models.NomadResource
 	dbQuery := database.DB.Where("name = ?", input.Name)
 	if orgID != nil { dbQuery = dbQuery.Where("organization_id = ?", *orgID) } else { dbQuery = dbQuery.Where("user_id = ? AND organization_id IS NULL", userID) }
-	if err := dbQuery.First(&existing).Error; err == nil { return c.Status(fiber.StatusBadRequest).SendString("A resource with this name already exists in this namespace") }
+	if err := dbQuery.First(&existing).Error; err == nil { return apiError(c, fiber.StatusBadRequest, "A resource with this name already exists in this namespace") }
 	license := input.License
 	if license == "" {
-		if licBody, err := downloadFile(input.RepositoryURL, "LICENSE"); err == nil && licBody != "" {
+		if licBody, err := downloadFile(input.GitProvider, input.RepositoryURL, "LICENSE"); err == nil && licBody != "" {
 			lines := strings.Split(licBody, "\n"); if len(lines) > 0 { license = strings.TrimPrefix(strings.TrimSpace(lines[0]), "The "); if len(license) > 25 { license = license[:25] + "..." } }
 		}
 	}
@@ -243,28 +282,15 @@ func PostNewResource(c *fiber.Ctx) error {
 	}
 	resource := models.NomadResource{
 		Name: input.Name, Type: models.ResourceType(input.Type), Description: input.Description, License: license,
-		RepositoryURL: input.RepositoryURL, FilePath: input.FilePath, WebhookSecret: generateWebhookSecret(),
-		UserID: userID, OrganizationID: orgID, Tags: tags, Versions: []models.ResourceVersion{{Version: input.Version}},
-	}
-	if err := database.DB.Create(&resource).Error; err != nil { return c.Status(fiber.StatusInternalServerError).SendString("Could not create resource") }
-	go func(resID uint, versionStr string, repoURL string, resType string, resName string, filePath string) {
-		readme, _ := downloadFile(repoURL, "README.md"); var content string
-		var variablesJSON string
-		if resType == string(models.ResourceTypeJob) {
-			fetchPath := filePath; if fetchPath == "" { fetchPath = resName; if !strings.HasSuffix(fetchPath, ".nomad.hcl") { fetchPath = fetchPath + ".nomad.hcl" } }
-			content, _ = downloadFile(repoURL, fetchPath)
-		} else if resType == string(models.ResourceTypePack) {
-			if varsContent, err := downloadFile(repoURL, "variables.hcl"); err == nil && varsContent != "" {
-				if vars, err := parsePackVariables(varsContent); err == nil {
-					if b, err := json.Marshal(vars); err == nil {
-						variablesJSON = string(b)
-					}
-				}
-			}
-			content, _ = downloadFile(repoURL, "metadata.hcl")
-		}
-		database.DB.Model(&models.ResourceVersion{}).Where("resource_id = ? AND version = ?", resID, versionStr).Updates(map[string]interface{}{"readme": readme, "content": content, "variables": variablesJSON})
-	}(resource.ID, input.Version, input.RepositoryURL, string(resource.Type), resource.Name, resource.FilePath)
+		RepositoryURL: input.RepositoryURL, GitProvider: input.GitProvider, DefaultBranch: input.DefaultBranch, FilePath: input.FilePath, WebhookSecret: generateWebhookSecret(), Visibility: input.Visibility,
+		UserID: userID, OrganizationID: orgID, Tags: tags, Versions: []models.ResourceVersion{{Version: input.Version, Ref: input.Ref, TrackBranch: input.TrackBranch, IngestState: models.IngestPending}},
+	}
+	bindSourceToken(&resource, userID)
+	if err := database.DB.Create(&resource).Error; err != nil { return apiError(c, fiber.StatusInternalServerError, "Could not create resource") }
+	enqueueIngest(resource.Versions[0].ID)
+	refreshSearchIndex(resource.ID)
+	auditResource(c, AuditResourceCreate, resource, auditDiff(nil, resourceAuditFields(resource)))
+	if isTokenRequest(c) { return c.Status(fiber.StatusCreated).JSON(resourceJSON(c, resource)) }
 	redirectPath := "/"; var user models.User; database.DB.First(&user, userID)
 	if orgID != nil { var org models.Organization; database.DB.First(&org, *orgID); redirectPath = "/" + org.Name + "/" + resource.Name } else { redirectPath = "/" + user.Username + "/" + resource.Name }
 	SetFlash(c, "success", "Resource '"+resource.Name+"' created!"); c.Set("HX-Redirect", redirectPath); return c.SendStatus(fiber.StatusOK)
@@ -278,31 +304,30 @@ func GetEditResource(c *fiber.Ctx) error {
 	namespace := c.Params("username"); resourcename := c.Params("resourcename")
 	sess, _ := Store.Get(c); currentUserID := sess.Get("user_id").(uint)
 	var userID uint; var orgID *uint; var user models.User
-	if err := database.DB.Where("username ILIKE ?", namespace).First(&user).Error; err == nil { userID = user.ID
+	if err := database.DB.Where("username ILIKE ?", escapeLikeString(namespace)).First(&user).Error; err == nil { userID = user.ID
 	} else {
 		var org models.Organization
-		if err := database.DB.Where("name ILIKE ?", namespace).First(&org).Error; err == nil { orgID = &org.ID } else { return c.Status(404).SendString("Namespace not found") }
-	}
-	var isAllowed bool
-	if orgID != nil {
-		var m models.Membership
-		if err := database.DB.Where("user_id = ? AND organization_id = ?", currentUserID, *orgID).First(&m).Error; err == nil { isAllowed = true }
-	} else { isAllowed = currentUserID == userID }
-	if !isAllowed { return c.Status(403).SendString("You don't have permission to edit this resource") }
+		if err := database.DB.Where("name ILIKE ?", escapeLikeString(namespace)).First(&org).Error; err == nil { orgID = &org.ID } else { return c.Status(404).SendString("Namespace not found") }
+	}
 	var resource models.NomadResource
-	dbQuery := database.DB.Preload("User").Preload("Tags")
-	if orgID != nil { dbQuery = dbQuery.Where("organization_id = ? AND name ILIKE ?", *orgID, resourcename)
-	} else { dbQuery = dbQuery.Where("user_id = ? AND organization_id IS NULL AND name ILIKE ?", userID, resourcename) }
+	dbQuery := database.DB.Preload("User").Preload("Tags").Preload("Versions", func(db *gorm.DB) *gorm.DB { return db.Order("resource_versions.created_at DESC") })
+	if orgID != nil { dbQuery = dbQuery.Where("organization_id = ? AND name ILIKE ?", *orgID, escapeLikeString(resourcename))
+	} else { dbQuery = dbQuery.Where("user_id = ? AND organization_id IS NULL AND name ILIKE ?", userID, escapeLikeString(resourcename)) }
 	if err := dbQuery.First(&resource).Error; err != nil { return c.Status(404).SendString("Resource not found") }
+	if !hasPermission(currentUserID, resource, PermEdit) { return c.Status(403).SendString("You don't have permission to edit this resource") }
 	var currentUser models.User; database.DB.Preload("Memberships.Organization").First(&currentUser, currentUserID)
 	var orgs []models.Organization
 	for _, m := range currentUser.Memberships { orgs = append(orgs, m.Organization) }
 	var tagNames []string
 	for _, t := range resource.Tags { tagNames = append(tagNames, t.Name) }
+	sortVersions(resource.Versions)
 	return c.Render("edit_resource", MergeContext(BaseContext(c), fiber.Map{
 		"Resource":      resource,
 		"TagsString":    strings.Join(tagNames, ", "),
 		"Organizations": orgs,
+		"GitProviders":  gitprovider.Kinds,
+		"MaxDeliveries": maxDeliveriesPerResource,
+		"Successor":     successorPath(resource),
 	}), "layouts/main")
 }
 
@@ -315,35 +340,47 @@ func GetEditResource(c *fiber.Ctx) error {
 // @Param name formData string true "New resource name"
 // @Param type formData string true "New resource type"
 // @Param description formData string false "New description"
+// @Param visibility formData string false "public, internal or private (unchanged if empty)"
 // @Success 200 {string} string "OK"
 // @Failure 403 {string} string "Unauthorized"
+// @Security BearerAuth
 // @Router /resource/{id}/edit [post]
 func PostEditResource(c *fiber.Ctx) error {
-	idStr := c.Params("id"); sess, _ := Store.Get(c); currentUserID := sess.Get("user_id").(uint); id, _ := strconv.ParseUint(idStr, 10, 32)
+	idStr := c.Params("id"); userID := currentUserID(c); id, _ := strconv.ParseUint(idStr, 10, 32)
 	var resource models.NomadResource
-	if err := database.DB.First(&resource, uint(id)).Error; err != nil { return c.Status(404).SendString("Resource not found") }
-	var isAllowed bool
-	if resource.OrganizationID != nil {
-		var m models.Membership
-		if err := database.DB.Where("user_id = ? AND organization_id = ?", currentUserID, *resource.OrganizationID).First(&m).Error; err == nil { isAllowed = true }
-	} else { isAllowed = currentUserID == resource.UserID }
-	if !isAllowed { return c.Status(403).SendString("Unauthorized") }
+	if err := database.DB.First(&resource, uint(id)).Error; err != nil { return apiError(c, 404, "Resource not found") }
+	if !hasPermission(userID, resource, PermEdit) || !tokenAllowsNamespace(c, resource.OrganizationID) { return apiError(c, 403, "Unauthorized") }
 	type EditInput struct {
 		Name string `form:"name"`; Type string `form:"type"`; Owner string `form:"owner"`; Description string `form:"description"`
 		RepositoryURL string `form:"repository_url"`; FilePath string `form:"file_path"`; License string `form:"license"`; Tags string `form:"tags"`
-	}
-	var input EditInput; if err := c.BodyParser(&input); err != nil { return c.Status(400).SendString("Invalid input") }
-	var newOrgID *uint
-	if strings.HasPrefix(input.Owner, "org:") {
-		oid, _ := strconv.ParseUint(strings.TrimPrefix(input.Owner, "org:"), 10, 32); val := uint(oid); newOrgID = &val
-	}
+		GitProvider string `form:"git_provider"`; DefaultBranch string `form:"default_branch"`; WebhookQuerySecret bool `form:"webhook_query_secret"`
+		Visibility string `form:"visibility"`
+	}
+	var input EditInput; if err := c.BodyParser(&input); err != nil { return apiError(c, 400, "Invalid input") }
+	if input.GitProvider != "" && !gitprovider.IsValidKind(input.GitProvider) { return apiError(c, 400, "Unknown git provider") }
+	if !isValidRef(input.DefaultBranch) { return apiError(c, 400, "Invalid git ref") }
+	if input.Visibility != "" && !isValidVisibility(input.Visibility) { return apiError(c, 400, "Visibility must be public, internal or private") }
+	newOrgID, ok := parseOwnerOrg(input.Owner); if !ok { return apiError(c, 400, "Organization not found") }
+	if !sameOrg(resource.OrganizationID, newOrgID) {
+		// Moving a resource takes it out of its namespace and publishes it in another
+		if !hasPermission(userID, resource, PermDelete) { return apiError(c, 403, "Your role does not allow moving this resource") }
+		if newOrgID != nil && !orgAllows(userID, *newOrgID, PermPublish) { return apiError(c, 403, "Your role in that organization does not allow publishing") }
+	}
+	if !tokenAllowsNamespace(c, newOrgID) { return apiError(c, 403, "This token cannot move resources to that namespace") }
+	before := resourceAuditFields(resource)
 	if input.Name != resource.Name || (resource.OrganizationID != newOrgID) {
 		var count int64; collideQuery := database.DB.Model(&models.NomadResource{}).Where("name = ?", input.Name)
 		if newOrgID != nil { collideQuery = collideQuery.Where("organization_id = ?", *newOrgID) } else { collideQuery = collideQuery.Where("user_id = ? AND organization_id IS NULL", resource.UserID) }
-		collideQuery.Count(&count); if count > 0 { return c.Status(400).SendString("A resource with this name already exists in that namespace") }
+		collideQuery.Count(&count); if count > 0 { return apiError(c, 400, "A resource with this name already exists in that namespace") }
 	}
+	repositoryChanged := input.RepositoryURL != resource.RepositoryURL
 	resource.Name = input.Name; resource.Type = models.ResourceType(input.Type); resource.OrganizationID = newOrgID
-	resource.Description = input.Description; resource.RepositoryURL = input.RepositoryURL; resource.FilePath = input.FilePath; resource.License = input.License
+	resource.Description = input.Description; resource.RepositoryURL = input.RepositoryURL; resource.GitProvider = input.GitProvider; resource.DefaultBranch = input.DefaultBranch; resource.WebhookQuerySecret = input.WebhookQuerySecret; resource.FilePath = input.FilePath; resource.License = input.License
+	if input.Visibility != "" { resource.Visibility = input.Visibility }
+	if repositoryChanged {
+		// The previous token was only good for the previous repository
+		bindSourceToken(&resource, userID)
+	}
 	var tags []models.Tag
 	if input.Tags != "" {
 		for _, tn := range strings.Split(input.Tags, ",") {
@@ -352,11 +389,14 @@ func PostEditResource(c *fiber.Ctx) error {
 		}
 	}
 	if err := database.DB.Model(&resource).Association("Tags").Replace(tags); err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to update tags")
+		return apiError(c, fiber.StatusInternalServerError, "Failed to update tags")
 	}
 	if err := database.DB.Save(&resource).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to save resource")
+		return apiError(c, fiber.StatusInternalServerError, "Failed to save resource")
 	}
+	refreshSearchIndex(resource.ID)
+	auditResource(c, AuditResourceEdit, resource, auditDiff(before, resourceAuditFields(resource)))
+	if isTokenRequest(c) { return c.JSON(resourceJSON(c, resource)) }
 	SetFlash(c, "success", "Resource updated successfully!")
 	newNamespace := ""
 	if resource.OrganizationID != nil {
@@ -367,30 +407,34 @@ func PostEditResource(c *fiber.Ctx) error {
 	c.Set("HX-Redirect", "/"+newNamespace+"/"+resource.Name); return c.SendStatus(200)
 }
 
+// PostNewVersion godoc
+// @Summary Add a version
+// @Description Register a new version of a resource and fetch its content from the repository.
+// @Tags resources
+// @Accept x-www-form-urlencoded
+// @Param id path string true "Resource ID"
+// @Param version formData string true "Version (git tag)"
+// @Param ref formData string false "Git ref to read the version from, if not the tag named by version"
+// @Param track_branch formData bool false "Follow a branch (ref, or the default branch) instead of a tag"
+// @Success 200 {string} string "OK"
+// @Success 201 {object} map[string]interface{} "Created version (API token requests)"
+// @Failure 403 {string} string "Unauthorized"
+// @Security BearerAuth
+// @Router /resource/{id}/version [post]
 func PostNewVersion(c *fiber.Ctx) error {
 	idStr := c.Params("id"); versionStr := c.FormValue("version"); id, _ := strconv.ParseUint(idStr, 10, 32)
-	if versionStr == "" { return c.Status(400).SendString("Version is required") }
-	var resource models.NomadResource; if err := database.DB.First(&resource, uint(id)).Error; err != nil { return c.Status(404).SendString("Resource not found") }
-	version := models.ResourceVersion{ResourceID: uint(id), Version: versionStr}
-	if err := database.DB.Create(&version).Error; err != nil { return c.Status(500).SendString("Could not add version") }
-	go func(resID uint, versionStr string, repoURL string, resType string, resName string, filePath string) {
-		readme, _ := downloadFile(repoURL, "README.md"); var content string
-		var variablesJSON string
-		if resType == string(models.ResourceTypeJob) {
-			fetchPath := filePath; if fetchPath == "" { fetchPath = resName; if !strings.HasSuffix(fetchPath, ".nomad.hcl") { fetchPath = fetchPath + ".nomad.hcl" } }
-			content, _ = downloadFile(repoURL, fetchPath)
-		} else if resType == string(models.ResourceTypePack) {
-			if varsContent, err := downloadFile(repoURL, "variables.hcl"); err == nil && varsContent != "" {
-				if vars, err := parsePackVariables(varsContent); err == nil {
-					if b, err := json.Marshal(vars); err == nil {
-						variablesJSON = string(b)
-					}
-				}
-			}
-			content, _ = downloadFile(repoURL, "metadata.hcl")
-		}
-		database.DB.Model(&models.ResourceVersion{}).Where("resource_id = ? AND version = ?", resID, versionStr).Updates(map[string]interface{}{"readme":  readme, "content": content, "variables": variablesJSON})
-	}(resource.ID, versionStr, resource.RepositoryURL, string(resource.Type), resource.Name, resource.FilePath)
+	ref := c.FormValue("ref"); trackBranch := c.FormValue("track_branch") == "true" || c.FormValue("track_branch") == "on"
+	if versionStr == "" { return apiError(c, 400, "Version is required") }
+	if !isValidRef(ref) { return apiError(c, 400, "Invalid git ref") }
+	var resource models.NomadResource; if err := database.DB.First(&resource, uint(id)).Error; err != nil { return apiError(c, 404, "Resource not found") }
+	if !hasPermission(currentUserID(c), resource, PermPublish) || !tokenAllowsNamespace(c, resource.OrganizationID) { return apiError(c, 403, "Unauthorized") }
+	var exists int64; database.DB.Model(&models.ResourceVersion{}).Where("resource_id = ? AND version = ?", resource.ID, versionStr).Count(&exists)
+	if exists > 0 { return apiError(c, 409, "Version "+versionStr+" already exists") }
+	version := models.ResourceVersion{ResourceID: uint(id), Version: versionStr, Ref: ref, TrackBranch: trackBranch, IngestState: models.IngestPending}
+	if err := database.DB.Create(&version).Error; err != nil { return apiError(c, 500, "Could not add version") }
+	enqueueIngest(version.ID)
+	auditResource(c, AuditResourcePublish, resource, map[string]AuditChange{"versions." + version.Version: {After: versionRef(resource, version)}})
+	if isTokenRequest(c) { return c.Status(fiber.StatusCreated).JSON(fiber.Map{"id": version.ID, "resource_id": resource.ID, "version": version.Version, "ref": versionRef(resource, version), "track_branch": version.TrackBranch, "ingest_state": version.IngestState}) }
 	SetFlash(c, "success", "Version "+version.Version+" added!"); c.Set("HX-Refresh", "true"); return c.SendStatus(200)
 }
 
@@ -401,35 +445,26 @@ func PostNewVersion(c *fiber.Ctx) error {
 // @Param id path string true "Resource ID"
 // @Success 200 {string} string "OK"
 // @Failure 403 {string} string "Unauthorized"
+// @Security BearerAuth
 // @Router /resource/{id} [delete]
 func DeleteResource(c *fiber.Ctx) error {
 	id := c.Params("id")
-	sess, _ := Store.Get(c)
-	userID := sess.Get("user_id").(uint)
+	userID := currentUserID(c)
 
 	var resource models.NomadResource
 	if err := database.DB.First(&resource, id).Error; err != nil {
-		return c.Status(404).SendString("Resource not found")
+		return apiError(c, 404, "Resource not found")
 	}
 
-	// Check authorization: user owns resource OR user is organization owner
-	var isAuthorized bool
-	if resource.OrganizationID != nil {
-		// Resource belongs to an organization - check if user is owner
-		var membership models.Membership
-		if err := database.DB.Where("user_id = ? AND organization_id = ? AND role = ?", userID, *resource.OrganizationID, "owner").First(&membership).Error; err == nil {
-			isAuthorized = true
-		}
-	} else {
-		// Resource belongs to a user - check if it's the current user
-		isAuthorized = resource.UserID == userID
-	}
-
-	if !isAuthorized {
-		return c.Status(403).SendString("Unauthorized")
+	if !hasPermission(userID, resource, PermDelete) || !tokenAllowsNamespace(c, resource.OrganizationID) {
+		return apiError(c, 403, "Unauthorized")
 	}
 
+	auditResource(c, AuditResourceDelete, resource, auditDiff(resourceAuditFields(resource), nil))
 	database.DB.Delete(&resource)
+	if isTokenRequest(c) {
+		return c.JSON(fiber.Map{"id": resource.ID, "deleted": true})
+	}
 	SetFlash(c, "success", "Resource deleted successfully.")
 	c.Set("HX-Redirect", "/")
 	return c.SendStatus(200)
@@ -437,172 +472,87 @@ func DeleteResource(c *fiber.Ctx) error {
 
 // HandleWebhook godoc
 // @Summary Receive Git webhooks
-// @Description Endpoint for receiving push and tag events from GitHub or GitLab. Automatically refreshes documentation or creates new versions.
+// @Description Endpoint for receiving tag, release and push events from GitHub, GitLab, Gitea or Forgejo. Deliveries are authenticated with the host's signature (X-Hub-Signature-256, X-Gitea-Signature, X-Forgejo-Signature) or token (X-Gitlab-Token) header. Tag and release events create new versions; push events refresh versions that track the pushed branch.
 // @Tags webhooks
 // @Accept json
 // @Param id path string true "Resource ID"
-// @Param secret query string true "Webhook secret"
+// @Param secret query string false "Webhook secret (legacy, only if enabled for the resource)"
 // @Success 200 {string} string "OK"
 // @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not Found"
 // @Router /resource/{id}/webhook [post]
 func HandleWebhook(c *fiber.Ctx) error {
+	start := time.Now()
 	id := c.Params("id")
-	secret := c.Query("secret")
 
 	var resource models.NomadResource
-	if err := database.DB.Preload("Versions", func(db *gorm.DB) *gorm.DB {
-		return db.Order("resource_versions.created_at DESC")
-	}).First(&resource, id).Error; err != nil {
+	if err := database.DB.First(&resource, id).Error; err != nil {
 		return c.SendStatus(404)
 	}
 
-	// Use constant-time comparison to prevent timing attacks
-	if secret == "" || subtle.ConstantTimeCompare([]byte(resource.WebhookSecret), []byte(secret)) != 1 {
-		resource.LastWebhookDelivery = time.Now()
-		resource.LastWebhookStatus = "failure"
-		resource.LastWebhookError = "Invalid or missing secret"
-		database.DB.Save(&resource)
+	if resource.HiddenAt != nil {
+		return c.SendStatus(404) // Hidden resources are frozen until a moderator restores them
+	}
+	if !verifyWebhook(c, resource) {
+		recordRejectedDelivery(resource, webhookEventName(c), start)
+		if resource.Visibility != models.VisibilityPublic {
+			return c.SendStatus(404) // Don't confirm that a hidden resource exists
+		}
 		return c.SendStatus(403)
 	}
 
-	// Initial success state (might be updated later if fetch fails)
-	resource.LastWebhookDelivery = time.Now()
-	resource.LastWebhookStatus = "success"
-	resource.LastWebhookError = ""
-	database.DB.Save(&resource)
-
-	type GitHubPayload struct {
-		Ref     string `json:"ref"`
-		RefType string `json:"ref_type"` // "tag"
-	}
-	type GitLabPayload struct {
-		ObjectKind string `json:"object_kind"` // "tag_push"
-		Ref        string `json:"ref"`         // "refs/tags/v1.0.0"
+	delivery := models.WebhookDelivery{
+		ResourceID: resource.ID,
+		Event:      webhookEventName(c),
+		Headers:    deliveryHeaders(c),
+		Payload:    truncatePayload(c.Body()),
 	}
-
-	var ghPayload GitHubPayload
-	var glPayload GitLabPayload
-	
-	newVersion := ""
-	isTagEvent := false
-
-	// Try parsing payloads
-	if c.Get("X-GitHub-Event") == "create" {
-		if err := c.BodyParser(&ghPayload); err != nil {
-			return c.Status(fiber.StatusBadRequest).SendString("Invalid GitHub payload")
-		}
-		if ghPayload.RefType == "tag" {
-			newVersion = ghPayload.Ref
-			isTagEvent = true
-		}
-	} else if c.Get("X-Gitlab-Event") == "Tag Push Hook" {
-		if err := c.BodyParser(&glPayload); err != nil {
-			return c.Status(fiber.StatusBadRequest).SendString("Invalid GitLab payload")
-		}
-		newVersion = strings.TrimPrefix(glPayload.Ref, "refs/tags/")
-		isTagEvent = true
-	}
-
-	if isTagEvent && newVersion != "" {
-		// Check if version already exists
-		var exists int64
-		database.DB.Model(&models.ResourceVersion{}).Where("resource_id = ? AND version = ?", resource.ID, newVersion).Count(&exists)
-		if exists == 0 {
-			// Create new version
-			version := models.ResourceVersion{
-				ResourceID: resource.ID,
-				Version:    newVersion,
-			}
-			database.DB.Create(&version)
-			
-			// Background Fetch for the NEW version
-			go func(resID uint, vStr string, repoURL string, resType string, resName string, filePath string) {
-				readme, _ := downloadFile(repoURL, "README.md")
-				var content string
-				var variablesJSON string
-				if resType == string(models.ResourceTypeJob) {
-					fetchPath := filePath
-					if fetchPath == "" {
-						fetchPath = resName
-						if !strings.HasSuffix(fetchPath, ".nomad.hcl") {
-							fetchPath = fetchPath + ".nomad.hcl"
-						}
-					}
-					content, _ = downloadFile(repoURL, fetchPath)
-				} else if resType == string(models.ResourceTypePack) {
-					if varsContent, err := downloadFile(repoURL, "variables.hcl"); err == nil && varsContent != "" {
-						if vars, err := parsePackVariables(varsContent); err == nil {
-							if b, err := json.Marshal(vars); err == nil {
-								variablesJSON = string(b)
-							}
-						}
-					}
-					content, _ = downloadFile(repoURL, "metadata.hcl")
-				}
-				database.DB.Model(&models.ResourceVersion{}).
-					Where("resource_id = ? AND version = ?", resID, vStr).
-					Updates(map[string]interface{}{"readme": readme, "content": content, "variables": variablesJSON})
-			}(resource.ID, newVersion, resource.RepositoryURL, string(resource.Type), resource.Name, resource.FilePath)
-			
-			return c.SendStatus(200)
-		}
+	event, err := parseWebhookEvent(delivery.Event, giteaDelivery(func(name string) string { return c.Get(name) }), c.Body())
+	if err != nil {
+		recordDelivery(resource, &delivery, start, errors.New("Invalid payload for "+event.Name+" event"))
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid payload")
 	}
+	delivery.Ref = event.Ref()
 
-	// Default behavior: Refresh latest version
-	if len(resource.Versions) > 0 {
-		latest := resource.Versions[0]
-		go func(resID uint, versionStr string, repoURL string, resType string, resName string, filePath string) {
-			readme, _ := downloadFile(repoURL, "README.md")
-			var content string
-			var variablesJSON string
-			if resType == string(models.ResourceTypeJob) {
-				fetchPath := filePath; if fetchPath == "" { fetchPath = resName; if !strings.HasSuffix(fetchPath, ".nomad.hcl") { fetchPath = fetchPath + ".nomad.hcl" } }
-				content, _ = downloadFile(repoURL, fetchPath)
-			} else if resType == string(models.ResourceTypePack) {
-				if varsContent, err := downloadFile(repoURL, "variables.hcl"); err == nil && varsContent != "" {
-					if vars, err := parsePackVariables(varsContent); err == nil {
-						if b, err := json.Marshal(vars); err == nil {
-							variablesJSON = string(b)
-						}
-					}
-				}
-				content, _ = downloadFile(repoURL, "metadata.hcl")
-			}
-			database.DB.Model(&models.ResourceVersion{}).Where("resource_id = ? AND version = ?", resID, versionStr).Updates(map[string]interface{}{"readme":  readme, "content": content, "variables": variablesJSON})
-		}(resource.ID, latest.Version, resource.RepositoryURL, string(resource.Type), resource.Name, resource.FilePath)
+	versions, err := applyWebhookEvent(resource, event)
+	delivery.Versions = strings.Join(versions, ", ")
+	recordDelivery(resource, &delivery, start, err)
+	if err != nil {
+		return c.Status(500).SendString("Could not process delivery")
 	}
-
 	return c.SendStatus(200)
 }
 
+// PostResetWebhookSecret godoc
+// @Summary Rotate webhook secret
+// @Description Generate a new webhook secret for a resource. API token callers receive the new secret in the response.
+// @Tags webhooks
+// @Param id path string true "Resource ID"
+// @Success 200 {string} string "OK"
+// @Failure 403 {string} string "Unauthorized"
+// @Security BearerAuth
+// @Router /resource/{id}/webhook/reset [post]
 func PostResetWebhookSecret(c *fiber.Ctx) error {
 	id := c.Params("id")
-	sess, _ := Store.Get(c)
-	currentUserID := sess.Get("user_id").(uint)
 
 	var resource models.NomadResource
 	if err := database.DB.First(&resource, id).Error; err != nil {
-		return c.Status(404).SendString("Resource not found")
+		return apiError(c, 404, "Resource not found")
 	}
 
 	// Verify Permission
-	var isAllowed bool
-	if resource.OrganizationID != nil {
-		var m models.Membership
-		if err := database.DB.Where("user_id = ? AND organization_id = ?", currentUserID, *resource.OrganizationID).First(&m).Error; err == nil {
-			isAllowed = true
-		}
-	} else {
-		isAllowed = currentUserID == resource.UserID
-	}
-
-	if !isAllowed {
-		return c.Status(403).SendString("Unauthorized")
+	if !hasPermission(currentUserID(c), resource, PermRotateSecrets) || !tokenAllowsNamespace(c, resource.OrganizationID) {
+		return apiError(c, 403, "Unauthorized")
 	}
 
 	// Generate New Secret
 	resource.WebhookSecret = generateWebhookSecret()
 	database.DB.Save(&resource)
+	auditResource(c, AuditResourceRotateSecret, resource, nil) // The secret itself is never logged
+
+	if isTokenRequest(c) {
+		return c.JSON(fiber.Map{"id": resource.ID, "webhook_secret": resource.WebhookSecret})
+	}
 
 	SetFlash(c, "success", "Webhook secret has been rotated. Please update your repository settings.")
 	c.Set("HX-Refresh", "true")
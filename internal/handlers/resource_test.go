@@ -1,8 +1,14 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"rmbl/internal/database"
 	"rmbl/internal/models"
 	"strings"
@@ -57,6 +63,7 @@ func TestGetRawResource(t *testing.T) {
 		Version:    "v1.0.0",
 		Content:    "job \"test\" {\n  type = \"service\"\n}",
 	}
+	version.Digest = contentDigest([]byte(version.Content))
 	err = database.DB.Create(&version).Error
 	require.NoError(t, err)
 
@@ -72,6 +79,18 @@ func TestGetRawResource(t *testing.T) {
 	// Check content type is plain text
 	contentType := resp.Header.Get("Content-Type")
 	assert.Contains(t, contentType, "text/plain")
+
+	// The stored digest is sent, and covers exactly the bytes served
+	body, _ := io.ReadAll(resp.Body)
+	sum := sha256.Sum256(body)
+	assert.Equal(t, version.Digest, resp.Header.Get("X-Ramble-Digest"))
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), resp.Header.Get("X-Ramble-Digest"))
+
+	// Content that no longer matches its digest isn't served
+	database.DB.Model(&version).Update("content", "job \"tampered\" {}")
+	resp, err = app.Test(httptest.NewRequest("GET", "/rawuser/raw-test-job/raw", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 409, resp.StatusCode)
 }
 
 func TestGetRawResource_NotFound(t *testing.T) {
@@ -89,6 +108,27 @@ func TestGetRawResource_NotFound(t *testing.T) {
 	assert.Equal(t, 404, resp.StatusCode)
 }
 
+func TestParseOwnerOrg(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	org := models.Organization{Name: "testownerorg", Description: "Test"}
+	require.NoError(t, database.DB.Create(&org).Error)
+
+	orgID, ok := parseOwnerOrg("org:TestOwnerOrg")
+	require.True(t, ok)
+	assert.Equal(t, org.ID, *orgID)
+
+	// LIKE wildcards are matched literally
+	for _, owner := range []string{"org:test%", "org:test_wnerorg"} {
+		_, ok = parseOwnerOrg(owner)
+		assert.False(t, ok, owner)
+	}
+
+	orgID, ok = parseOwnerOrg("")
+	assert.True(t, ok)
+	assert.Nil(t, orgID)
+}
+
 func TestToggleStar_Unauthenticated(t *testing.T) {
 	app := setupTestApp()
 	// Route should be behind RequireAuth middleware
@@ -498,6 +538,8 @@ func TestHandleWebhook_InvalidSecret(t *testing.T) {
 		Type:          models.ResourceTypePack,
 		UserID:        user.ID,
 		WebhookSecret: "correct-secret",
+		// Legacy query-string mode
+		WebhookQuerySecret: true,
 	}
 	database.DB.Create(&resource)
 
@@ -547,6 +589,8 @@ func TestHandleWebhook_ValidSecret(t *testing.T) {
 		Type:          models.ResourceTypePack,
 		UserID:        user.ID,
 		WebhookSecret: "valid-secret-123",
+		// Legacy query-string mode
+		WebhookQuerySecret: true,
 	}
 	database.DB.Create(&resource)
 	database.DB.Create(&models.ResourceVersion{ResourceID: resource.ID, Version: "v1.0.0"})
@@ -585,15 +629,203 @@ func TestHandleWebhook_GitHubTagEvent(t *testing.T) {
 	app.Post("/webhook/:id", HandleWebhook)
 
 	// GitHub tag create event
-	payload := strings.NewReader(`{"ref": "v2.0.0", "ref_type": "tag"}`)
-	req := httptest.NewRequest("POST", "/webhook/"+toString(resource.ID)+"?secret=github-secret", payload)
+	body := `{"ref": "v2.0.0", "ref_type": "tag"}`
+	req := httptest.NewRequest("POST", "/webhook/"+toString(resource.ID), strings.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-GitHub-Event", "create")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+signWebhook("github-secret", body))
+
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var version models.ResourceVersion
+	assert.NoError(t, database.DB.Where("resource_id = ? AND version = ?", resource.ID, "v2.0.0").First(&version).Error)
+	assert.Equal(t, models.IngestPending, version.IngestState)
+
+	var delivery models.WebhookDelivery
+	assert.NoError(t, database.DB.Where("resource_id = ?", resource.ID).First(&delivery).Error)
+	assert.Equal(t, "create", delivery.Event)
+	assert.Equal(t, "refs/tags/v2.0.0", delivery.Ref)
+	assert.Equal(t, "success", delivery.Status)
+	assert.Equal(t, "v2.0.0", delivery.Versions)
+	assert.Contains(t, delivery.Headers, "X-Hub-Signature-256")
+}
+
+func TestHandleWebhook_QuerySecretNeedsLegacyMode(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "webhookuser5")
+	resource := models.NomadResource{
+		Name:          "webhook-pack5",
+		Type:          models.ResourceTypePack,
+		UserID:        user.ID,
+		WebhookSecret: "query-secret",
+	}
+	database.DB.Create(&resource)
+
+	app := setupTestApp()
+	app.Post("/webhook/:id", HandleWebhook)
+
+	req := httptest.NewRequest("POST", "/webhook/"+toString(resource.ID)+"?secret=query-secret", nil)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
+}
+
+func TestHandleWebhook_InvalidSignature(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "webhookuser6")
+	resource := models.NomadResource{
+		Name:          "webhook-pack6",
+		Type:          models.ResourceTypePack,
+		UserID:        user.ID,
+		WebhookSecret: "signed-secret",
+	}
+	database.DB.Create(&resource)
+
+	app := setupTestApp()
+	app.Post("/webhook/:id", HandleWebhook)
+
+	body := `{"ref": "v2.0.0", "ref_type": "tag"}`
+	req := httptest.NewRequest("POST", "/webhook/"+toString(resource.ID), strings.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "create")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+signWebhook("other-secret", body))
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
 
+	var count int64
+	database.DB.Model(&models.ResourceVersion{}).Where("resource_id = ?", resource.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	var delivery models.WebhookDelivery
+	assert.NoError(t, database.DB.Where("resource_id = ?", resource.ID).First(&delivery).Error)
+	assert.Equal(t, "failure", delivery.Status)
+	assert.Equal(t, "Invalid or missing signature", delivery.Error)
+	// Nothing the unverified sender chose is kept, and the resource's last
+	// delivery is untouched
+	assert.True(t, delivery.Rejected)
+	assert.Empty(t, delivery.Payload)
+	assert.Empty(t, delivery.Headers)
+	assert.NoError(t, database.DB.First(&resource, resource.ID).Error)
+	assert.Empty(t, resource.LastWebhookStatus)
+
+	// Nor can it be replayed
+	ownerApp := setupAuthenticatedApp(user)
+	ownerApp.Post("/resource/:id/webhook/deliveries/:delivery/replay", PostReplayWebhookDelivery)
+	assert.Equal(t, 400, postForm(t, ownerApp, "/resource/"+toString(resource.ID)+"/webhook/deliveries/"+toString(delivery.ID)+"/replay", ""))
+	database.DB.Model(&models.ResourceVersion{}).Where("resource_id = ?", resource.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestHandleWebhook_GitLabReleaseHook(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "webhookuser7")
+	resource := models.NomadResource{
+		Name:          "webhook-pack7",
+		Type:          models.ResourceTypePack,
+		UserID:        user.ID,
+		WebhookSecret: "gitlab-secret",
+		RepositoryURL: "https://gitlab.com/test/webhook-pack7",
+	}
+	database.DB.Create(&resource)
+
+	app := setupTestApp()
+	app.Post("/webhook/:id", HandleWebhook)
+
+	req := httptest.NewRequest("POST", "/webhook/"+toString(resource.ID), strings.NewReader(`{"object_kind": "release", "action": "create", "tag": "v3.0.0"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gitlab-Event", "Release Hook")
+	req.Header.Set("X-Gitlab-Token", "gitlab-secret")
 	resp, err := app.Test(req)
 
 	assert.NoError(t, err)
 	assert.Equal(t, 200, resp.StatusCode)
+
+	var count int64
+	database.DB.Model(&models.ResourceVersion{}).Where("resource_id = ? AND version = ?", resource.ID, "v3.0.0").Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// The token is a credential and is not logged
+	var delivery models.WebhookDelivery
+	assert.NoError(t, database.DB.Where("resource_id = ?", resource.ID).First(&delivery).Error)
+	assert.NotContains(t, delivery.Headers, "gitlab-secret")
+}
+
+func TestGetWebhookDeliveries_Paginated(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "webhookuser8")
+	resource := createTestPack(t, user.ID, "webhook-pack8")
+	for i := 0; i < deliveriesPerPage+5; i++ {
+		database.DB.Create(&models.WebhookDelivery{ResourceID: resource.ID, Event: "push", Ref: "refs/heads/main", Status: "success"})
+	}
+
+	app := setupAuthenticatedApp(user)
+	app.Get("/resource/:id/webhook/deliveries", GetWebhookDeliveries)
+
+	req := httptest.NewRequest("GET", "/resource/"+toString(resource.ID)+"/webhook/deliveries", nil)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, deliveriesPerPage, strings.Count(string(body), "Redeliver"))
+	assert.Contains(t, string(body), "page=2")
+
+	req = httptest.NewRequest("GET", "/resource/"+toString(resource.ID)+"/webhook/deliveries?page=2", nil)
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, 5, strings.Count(string(body), "Redeliver"))
+	assert.NotContains(t, string(body), "page=3")
+}
+
+func TestGetWebhookDeliveries_NotOwner(t *testing.T) {
+	defer cleanupTestData(t)
+
+	owner := createTestUser(t, "webhookuser9")
+	other := createTestUser(t, "webhookuser10")
+	resource := createTestPack(t, owner.ID, "webhook-pack9")
+
+	app := setupAuthenticatedApp(other)
+	app.Get("/resource/:id/webhook/deliveries", GetWebhookDeliveries)
+
+	req := httptest.NewRequest("GET", "/resource/"+toString(resource.ID)+"/webhook/deliveries", nil)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
+}
+
+func TestPostReplayWebhookDelivery(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "webhookuser11")
+	resource := createTestPack(t, user.ID, "webhook-pack10")
+	original := models.WebhookDelivery{ResourceID: resource.ID, Event: "create", Ref: "refs/tags/v2.0.0", Status: "failure", Error: "boom"}
+	database.DB.Create(&original)
+
+	app := setupAuthenticatedApp(user)
+	app.Post("/resource/:id/webhook/deliveries/:delivery/replay", PostReplayWebhookDelivery)
+
+	req := httptest.NewRequest("POST", "/resource/"+toString(resource.ID)+"/webhook/deliveries/"+toString(original.ID)+"/replay", nil)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var version models.ResourceVersion
+	assert.NoError(t, database.DB.Where("resource_id = ? AND version = ?", resource.ID, "v2.0.0").First(&version).Error)
+	assert.Equal(t, models.IngestPending, version.IngestState)
+
+	var replay models.WebhookDelivery
+	assert.NoError(t, database.DB.Where("replay_of_id = ?", original.ID).First(&replay).Error)
+	assert.Equal(t, "success", replay.Status)
+	assert.Equal(t, "refs/tags/v2.0.0", replay.Ref)
 }
 
 // PostNewVersion Tests
@@ -704,6 +936,48 @@ func TestPostNewVersion_Success(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestPostNewVersion_TrackBranch(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "versionbranchuser")
+	resource := createTestPack(t, user.ID, "version-branch-pack")
+	app := setupAuthenticatedApp(user)
+	app.Post("/resource/:id/version", PostNewVersion)
+
+	payload := strings.NewReader("version=edge&ref=develop&track_branch=true")
+	req := httptest.NewRequest("POST", "/resource/"+toString(resource.ID)+"/version", payload)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var version models.ResourceVersion
+	err = database.DB.Where("resource_id = ? AND version = ?", resource.ID, "edge").First(&version).Error
+	assert.NoError(t, err)
+	assert.Equal(t, "develop", version.Ref)
+	assert.True(t, version.TrackBranch)
+}
+
+func TestPostNewVersion_InvalidRef(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "versionrefuser")
+	resource := createTestPack(t, user.ID, "version-ref-pack")
+	app := setupAuthenticatedApp(user)
+	app.Post("/resource/:id/version", PostNewVersion)
+
+	payload := strings.NewReader("version=v2.0.0&ref=--upload-pack%3Dtouch")
+	req := httptest.NewRequest("POST", "/resource/"+toString(resource.ID)+"/version", payload)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
 // PostEditResource Tests
 
 func TestPostEditResource_Unauthenticated(t *testing.T) {
@@ -1224,3 +1498,62 @@ func TestDeleteResource_ResourceNotFound(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 404, resp.StatusCode)
 }
+
+func TestPostNewResource_GitProvider(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "gitprovideruser")
+	app := setupAuthenticatedApp(user)
+	app.Post("/new", PostNewResource)
+
+	payload := strings.NewReader("name=forgejo-job&type=job&version=v1.0.0&license=MIT&git_provider=gitea&repository_url=https://git.example.com/owner/repo")
+	req := httptest.NewRequest("POST", "/new", payload)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var resource models.NomadResource
+	require.NoError(t, database.DB.Where("name = ? AND user_id = ?", "forgejo-job", user.ID).First(&resource).Error)
+	assert.Equal(t, "gitea", resource.GitProvider)
+
+	payload = strings.NewReader("name=svn-job&type=job&version=v1.0.0&git_provider=svn")
+	req = httptest.NewRequest("POST", "/new", payload)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err = app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestFetchInfo_SelectedProvider(t *testing.T) {
+	// A Gitea stand-in on a host that would not be detected as Gitea
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/repos/owner/app":
+			json.NewEncoder(w).Encode(map[string]any{"name": "app", "description": "Self-hosted app", "topics": []string{"selfhosted"}})
+		case "/api/v1/repos/owner/app/tags":
+			json.NewEncoder(w).Encode([]map[string]string{{"name": "v3.1.0"}})
+		case "/api/v1/repos/owner/app/contents/":
+			json.NewEncoder(w).Encode([]map[string]string{{"name": "app.nomad.hcl", "path": "app.nomad.hcl", "type": "file"}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	app := setupTestApp()
+	app.Get("/new/fetch-info", FetchInfo)
+
+	query := url.Values{"repository_url": {server.URL + "/owner/app"}, "type": {"job"}, "git_provider": {"gitea"}}
+	resp, err := app.Test(httptest.NewRequest("GET", "/new/fetch-info?"+query.Encode(), nil))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "Self-hosted app")
+	assert.Contains(t, string(body), "v3.1.0")
+	assert.Contains(t, string(body), "app.nomad.hcl")
+	assert.Contains(t, string(body), "selfhosted")
+}
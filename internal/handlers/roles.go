@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Permission is something organization roles can be allowed to do
+type Permission string
+
+const (
+	PermManageMembers Permission = "manage_members" // Add and remove members and change their roles
+	PermDelete        Permission = "delete"         // Delete resources or move them out of the organization
+	PermEdit          Permission = "edit"           // Edit metadata, deprecate, see webhook deliveries
+	PermPublish       Permission = "publish"        // Publish, yank and sign versions, create resources
+	PermRotateSecrets Permission = "rotate_secrets" // See and rotate webhook secrets
+	PermViewPrivate   Permission = "view_private"   // See the organization's private resources
+)
+
+// PermissionInfo describes a permission in the org settings matrix
+type PermissionInfo struct {
+	Key   Permission
+	Label string
+}
+
+// Permissions lists every permission in the order org settings shows them
+var Permissions = []PermissionInfo{
+	{PermManageMembers, "Manage members"},
+	{PermDelete, "Delete resources"},
+	{PermEdit, "Edit metadata"},
+	{PermPublish, "Publish versions"},
+	{PermRotateSecrets, "Rotate secrets"},
+	{PermViewPrivate, "View private resources"},
+}
+
+// defaultRolePermissions are what each role may do in an organization that
+// has not changed its matrix. Owners are not listed: they may do anything.
+var defaultRolePermissions = map[string][]Permission{
+	models.RoleMaintainer: {PermEdit, PermPublish, PermRotateSecrets, PermViewPrivate},
+	models.RolePublisher:  {PermPublish, PermViewPrivate},
+	models.RoleViewer:     {PermViewPrivate},
+}
+
+// rolePermissions returns what each role may do in an organization: its own
+// matrix if it has set one, otherwise the defaults. Owners always have every
+// permission, so an organization can't lock itself out.
+func rolePermissions(org models.Organization) map[string]map[Permission]bool {
+	granted := defaultRolePermissions
+	if org.RolePermissions != "" {
+		var custom map[string][]Permission
+		if err := json.Unmarshal([]byte(org.RolePermissions), &custom); err == nil {
+			granted = custom
+		}
+	}
+
+	matrix := map[string]map[Permission]bool{}
+	for _, role := range models.OrgRoles {
+		matrix[role] = map[Permission]bool{}
+		for _, p := range granted[role] {
+			matrix[role][p] = true
+		}
+	}
+	for _, p := range Permissions {
+		matrix[models.RoleOwner][p.Key] = true
+	}
+	return matrix
+}
+
+// isValidRole reports whether role is one of the membership roles
+func isValidRole(role string) bool {
+	for _, r := range models.OrgRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// membershipRole returns the role a membership grants
+func membershipRole(m models.Membership) string {
+	if m.Role == "member" {
+		return models.RoleMaintainer // Memberships from before roles were split
+	}
+	return m.Role
+}
+
+// orgRole returns the user's role in an organization, or "" if they are not
+// a member
+func orgRole(userID, orgID uint) string {
+	var m models.Membership
+	if database.DB.Where("user_id = ? AND organization_id = ?", userID, orgID).First(&m).Error != nil {
+		return ""
+	}
+	return membershipRole(m)
+}
+
+// orgAllows reports whether the user's role in an organization grants perm
+func orgAllows(userID, orgID uint, perm Permission) bool {
+	role := orgRole(userID, orgID)
+	if role == "" {
+		return false
+	}
+	var org models.Organization
+	if database.DB.First(&org, orgID).Error != nil {
+		return false
+	}
+	if org2FABlocks(userID, org) {
+		return false
+	}
+	return rolePermissions(org)[role][perm]
+}
+
+// hasPermission is the check of what a user may do to a resource. The owner
+// of a personal resource may do anything to it; on an organization's
+// resources, the user's role decides.
+func hasPermission(userID uint, resource models.NomadResource, perm Permission) bool {
+	if userID == 0 {
+		return false
+	}
+	if resource.OrganizationID != nil {
+		return orgAllows(userID, *resource.OrganizationID, perm)
+	}
+	return resource.UserID == userID
+}
+
+// RequireOrgPermission only lets through members of the :orgname
+// organization whose role grants perm. Like RequireOrgOwner it sets
+// c.Locals("OrgID").
+func RequireOrgPermission(perm Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var org models.Organization
+		if err := database.DB.Where("name ILIKE ?", escapeLikeString(c.Params("orgname"))).First(&org).Error; err != nil {
+			return c.Status(404).SendString("Organization not found")
+		}
+
+		userID := currentUserID(c)
+		role := orgRole(userID, org.ID)
+		if role == "" || !rolePermissions(org)[role][perm] {
+			return c.Status(403).SendString("Your role in this organization does not allow this")
+		}
+		if org2FABlocks(userID, org) {
+			return c.Status(403).SendString(org2FAMessage)
+		}
+
+		c.Locals("OrgID", org.ID)
+		return c.Next()
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createTestOrgResource creates an organization owning a pack, with the given
+// memberships. The first member created the pack.
+func createTestOrgResource(t *testing.T, orgName string, members ...models.Membership) (models.Organization, models.NomadResource) {
+	org := models.Organization{Name: orgName, Description: "Test"}
+	require.NoError(t, database.DB.Create(&org).Error)
+
+	for _, m := range members {
+		m.OrganizationID = org.ID
+		database.DB.Create(&m)
+	}
+	pack := createTestPack(t, members[0].UserID, orgName+"-pack")
+	database.DB.Model(&pack).Update("organization_id", org.ID)
+	pack.OrganizationID = &org.ID
+	return org, pack
+}
+
+func TestRolePermissions_Defaults(t *testing.T) {
+	matrix := rolePermissions(models.Organization{})
+
+	for _, p := range Permissions {
+		assert.True(t, matrix[models.RoleOwner][p.Key], p.Key)
+	}
+	assert.False(t, matrix[models.RoleMaintainer][PermDelete])
+	assert.True(t, matrix[models.RoleMaintainer][PermEdit])
+	assert.True(t, matrix[models.RolePublisher][PermPublish])
+	assert.False(t, matrix[models.RolePublisher][PermEdit])
+	assert.Equal(t, map[Permission]bool{PermViewPrivate: true}, matrix[models.RoleViewer])
+}
+
+func TestRolePermissions_Custom(t *testing.T) {
+	// Owners keep everything even when the matrix leaves them out
+	org := models.Organization{RolePermissions: `{"viewer":["publish"],"owner":[]}`}
+	matrix := rolePermissions(org)
+
+	assert.True(t, matrix[models.RoleOwner][PermManageMembers])
+	assert.Equal(t, map[Permission]bool{PermPublish: true}, matrix[models.RoleViewer])
+	assert.Empty(t, matrix[models.RoleMaintainer])
+}
+
+func TestHasPermission_OrgRoles(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	owner := createTestUser(t, "rolesowner")
+	publisher := createTestUser(t, "rolespublisher")
+	legacy := createTestUser(t, "roleslegacy")
+	outsider := createTestUser(t, "rolesoutsider")
+	_, pack := createTestOrgResource(t, "testrolesorg",
+		models.Membership{UserID: owner.ID, Role: models.RoleOwner},
+		models.Membership{UserID: publisher.ID, Role: models.RolePublisher},
+		models.Membership{UserID: legacy.ID, Role: "member"},
+	)
+
+	assert.True(t, hasPermission(owner.ID, pack, PermDelete))
+	assert.True(t, hasPermission(publisher.ID, pack, PermPublish))
+	assert.False(t, hasPermission(publisher.ID, pack, PermEdit))
+	assert.False(t, hasPermission(publisher.ID, pack, PermDelete))
+	// Members from before roles were split are maintainers
+	assert.True(t, hasPermission(legacy.ID, pack, PermEdit))
+	assert.False(t, hasPermission(legacy.ID, pack, PermDelete))
+	assert.False(t, hasPermission(outsider.ID, pack, PermViewPrivate))
+}
+
+func TestPublisher_CanPublishButNotDelete(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	owner := createTestUser(t, "pubowner")
+	publisher := createTestUser(t, "pubpublisher")
+	_, pack := createTestOrgResource(t, "testpuborg",
+		models.Membership{UserID: owner.ID, Role: models.RoleOwner},
+		models.Membership{UserID: publisher.ID, Role: models.RolePublisher},
+	)
+
+	app := setupAuthenticatedApp(publisher)
+	app.Post("/resource/:id/version", PostNewVersion)
+	app.Post("/resource/:id/edit", PostEditResource)
+	app.Delete("/resource/:id", DeleteResource)
+
+	form := url.Values{"version": {"v2.0.0"}, "content": {`job "pub" {}`}}
+	req := httptest.NewRequest("POST", "/resource/"+toString(pack.ID)+"/version", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	form = url.Values{"name": {pack.Name}, "description": {"Changed"}}
+	req = httptest.NewRequest("POST", "/resource/"+toString(pack.ID)+"/edit", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest("DELETE", "/resource/"+toString(pack.ID), nil))
+	require.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
+}
+
+func TestPostUpdateMemberRole(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	owner := createTestUser(t, "memberroleowner")
+	maintainer := createTestUser(t, "memberrolemaint")
+	viewer := createTestUser(t, "memberroleviewer")
+	org, _ := createTestOrgResource(t, "testmemberroleorg",
+		models.Membership{UserID: owner.ID, Role: models.RoleOwner},
+		models.Membership{UserID: maintainer.ID, Role: models.RoleMaintainer},
+		models.Membership{UserID: viewer.ID, Role: models.RoleViewer},
+	)
+	// Let maintainers manage members so the owner-only rules can be tested
+	database.DB.Model(&org).Update("role_permissions", `{"maintainer":["manage_members"]}`)
+
+	post := func(actor models.User, member models.User, role string) int {
+		app := setupAuthenticatedApp(actor)
+		app.Post("/orgs/:orgname/members/:member_id/role", RequireOrgPermission(PermManageMembers), PostUpdateMemberRole)
+		req := httptest.NewRequest("POST", "/orgs/testmemberroleorg/members/"+toString(member.ID)+"/role", strings.NewReader("role="+role))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, 200, post(maintainer, viewer, models.RolePublisher))
+	assert.Equal(t, models.RolePublisher, orgRole(viewer.ID, org.ID))
+
+	assert.Equal(t, 403, post(maintainer, viewer, models.RoleOwner), "only owners make owners")
+	assert.Equal(t, 403, post(maintainer, owner, models.RoleViewer), "only owners demote owners")
+	assert.Equal(t, 403, post(viewer, maintainer, models.RoleViewer), "publishers can't manage members")
+	assert.Equal(t, 400, post(owner, viewer, "admin"))
+	assert.Equal(t, 400, post(owner, owner, models.RoleMaintainer), "last owner")
+
+	assert.Equal(t, 200, post(owner, maintainer, models.RoleOwner))
+	assert.Equal(t, 200, post(owner, owner, models.RoleMaintainer))
+	assert.Equal(t, models.RoleMaintainer, orgRole(owner.ID, org.ID))
+}
+
+func TestPostUpdateOrgRoles(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	owner := createTestUser(t, "orgrolesowner")
+	viewer := createTestUser(t, "orgrolesviewer")
+	org, pack := createTestOrgResource(t, "testorgrolesorg",
+		models.Membership{UserID: owner.ID, Role: models.RoleOwner},
+		models.Membership{UserID: viewer.ID, Role: models.RoleViewer},
+	)
+
+	app := setupAuthenticatedApp(owner)
+	app.Post("/orgs/:orgname/roles", RequireOrgOwner, PostUpdateOrgRoles)
+
+	form := url.Values{
+		"viewer":     {"publish", "view_private", "bogus"},
+		"maintainer": {"edit"},
+		"owner":      {},
+	}
+	req := httptest.NewRequest("POST", "/orgs/testorgrolesorg/roles", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	database.DB.First(&org, org.ID)
+	matrix := rolePermissions(org)
+	assert.Equal(t, map[Permission]bool{PermPublish: true, PermViewPrivate: true}, matrix[models.RoleViewer])
+	assert.Equal(t, map[Permission]bool{PermEdit: true}, matrix[models.RoleMaintainer])
+	assert.Empty(t, matrix[models.RolePublisher])
+	assert.True(t, hasPermission(viewer.ID, pack, PermPublish))
+	assert.True(t, hasPermission(owner.ID, pack, PermManageMembers))
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxIndexedText caps how much of a README or job file goes into the search
+// index; tsvectors are limited to 1MB
+const maxIndexedText = 64 * 1024
+
+// searchMatch restricts a query to resources matching q: a full-text match
+// on the search index, a substring of the name or description, or a name or
+// description word close to q so small typos still match
+func searchMatch(db *gorm.DB, q string) *gorm.DB {
+	searchParam := "%" + escapeLikeString(q) + "%"
+	return db.Where(`nomad_resources.search_vector @@ websearch_to_tsquery('english', ?)
+		OR nomad_resources.name ILIKE ? ESCAPE '\' OR nomad_resources.description ILIKE ? ESCAPE '\'
+		OR ? <% nomad_resources.name OR ? <% nomad_resources.description`,
+		q, searchParam, searchParam, q, q)
+}
+
+// relevanceRank scores a resource against a query, taking the query twice:
+// its full-text rank, where name matches weigh most, plus how closely the
+// name resembles the query
+const relevanceRank = `(COALESCE(ts_rank(nomad_resources.search_vector, websearch_to_tsquery('english', ?)), 0)
+	+ word_similarity(?, nomad_resources.name))::float8`
+
+// orderByRelevance sorts matches for q best first. Without a query it falls
+// back to the latest updated.
+func orderByRelevance(db *gorm.DB, q string) *gorm.DB {
+	if q == "" {
+		return db.Order("nomad_resources.updated_at desc")
+	}
+	return db.Clauses(clause.OrderBy{Expression: clause.Expr{
+		SQL:                relevanceRank + " DESC, nomad_resources.star_count DESC",
+		Vars:               []interface{}{q, q},
+		WithoutParentheses: true,
+	}})
+}
+
+// sortResources orders a resource query by one of the sort modes of the
+// search pages and APIs: latest, stars, downloads, alpha or relevance to q
+func sortResources(db *gorm.DB, sort, q string) *gorm.DB {
+	switch sort {
+	case "stars":
+		return db.Order("star_count desc, nomad_resources.updated_at desc")
+	case "downloads":
+		return db.Order("download_count desc, nomad_resources.updated_at desc")
+	case "alpha":
+		return db.Order("nomad_resources.name asc")
+	case "relevance":
+		return orderByRelevance(db, q)
+	default:
+		return db.Order("nomad_resources.updated_at desc")
+	}
+}
+
+// refreshSearchIndex rebuilds a resource's search vector from its name,
+// description, tags and the README, variables and content of its latest
+// ingested version. Call it whenever any of those change.
+func refreshSearchIndex(resourceID uint) {
+	var resource models.NomadResource
+	if err := database.DB.Preload("Tags").Preload("Versions").First(&resource, resourceID).Error; err != nil {
+		return
+	}
+
+	// Index words inside names like "redis-sentinel" or "redis_sentinel" too
+	name := resource.Name + " " + strings.NewReplacer("-", " ", "_", " ", ".", " ").Replace(resource.Name)
+	summary := []string{resource.Description}
+	for _, t := range resource.Tags {
+		summary = append(summary, t.Name)
+	}
+
+	var variables, body []string
+	ingested := make([]models.ResourceVersion, 0, len(resource.Versions))
+	for _, v := range resource.Versions {
+		if v.IngestState == models.IngestReady {
+			ingested = append(ingested, v)
+		}
+	}
+	if latest, ok := latestVersion(ingested); ok {
+		var vars []PackVariable
+		if latest.Variables != "" && json.Unmarshal([]byte(latest.Variables), &vars) == nil {
+			for _, v := range vars {
+				variables = append(variables, v.Name, strings.ReplaceAll(v.Name, "_", " "), v.Description)
+			}
+		}
+		body = append(body, truncateText(latest.Readme, maxIndexedText), truncateText(latest.Content, maxIndexedText))
+	}
+
+	err := database.DB.Exec(`UPDATE nomad_resources SET search_vector =
+		setweight(to_tsvector('english', ?), 'A') ||
+		setweight(to_tsvector('english', ?), 'B') ||
+		setweight(to_tsvector('english', ?), 'C') ||
+		setweight(to_tsvector('english', ?), 'D')
+		WHERE id = ?`,
+		name, strings.Join(summary, " "), strings.Join(variables, " "), strings.Join(body, "\n"), resource.ID).Error
+	if err != nil {
+		log.Printf("search: could not index resource %d: %v", resource.ID, err)
+	}
+}
+
+// ReindexSearch builds the search vector of every resource that has none,
+// such as resources created before full-text search
+func ReindexSearch() {
+	var ids []uint
+	database.DB.Model(&models.NomadResource{}).Where("search_vector IS NULL").Pluck("id", &ids)
+	for _, id := range ids {
+		refreshSearchIndex(id)
+	}
+	if len(ids) > 0 {
+		log.Printf("search: indexed %d resources", len(ids))
+	}
+}
+
+// truncateText cuts s to at most n bytes, on a UTF-8 boundary
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 100
+	searchFacetLimit   = 20 // Values listed per tag, license and namespace facet
+)
+
+// searchSortColumns are the keyset columns of the /v1/search sort modes,
+// besides relevance. All sort descending, with the resource ID as tiebreak.
+var searchSortColumns = map[string]string{
+	"updated":   "nomad_resources.updated_at",
+	"stars":     "nomad_resources.star_count",
+	"downloads": "nomad_resources.download_count",
+}
+
+// SearchResult is a resource in /v1/search results
+type SearchResult struct {
+	Name          string    `json:"name"`
+	Namespace     string    `json:"namespace"`
+	Type          string    `json:"type"` // pack or job
+	Description   string    `json:"description"`
+	License       string    `json:"license,omitempty"`
+	Tags          []string  `json:"tags"`
+	LatestVersion string    `json:"latest_version,omitempty"`
+	Stars         int       `json:"stars"`
+	Downloads     int       `json:"downloads"`
+	Deprecated    bool      `json:"deprecated,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// FacetCount is how many matches have a value of a facet
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// SearchResponse is a page of /v1/search results
+type SearchResponse struct {
+	Results    []SearchResult          `json:"results"`
+	Total      int64                   `json:"total"`                 // Matches across all pages
+	NextCursor string                  `json:"next_cursor,omitempty"` // Empty on the last page
+	Facets     map[string][]FacetCount `json:"facets"`
+}
+
+// searchFilters are the filters of a /v1/search request
+type searchFilters struct {
+	Query     string
+	Type      string
+	Tag       string
+	Namespace string
+	License   string
+}
+
+// apply restricts db to resources matching the filters. The filter named by
+// skip is left out, so a facet counts the values its own filter would allow.
+func (f searchFilters) apply(db *gorm.DB, skip string) *gorm.DB {
+	if f.Query != "" {
+		db = searchMatch(db, f.Query)
+	}
+	if f.Type != "" && skip != "type" {
+		db = db.Where("nomad_resources.type = ?", f.Type)
+	}
+	if f.Tag != "" && skip != "tag" {
+		db = db.Where(`EXISTS (SELECT 1 FROM resource_tags JOIN tags ON tags.id = resource_tags.tag_id
+			WHERE resource_tags.nomad_resource_id = nomad_resources.id AND tags.name = ?)`, f.Tag)
+	}
+	if f.Namespace != "" && skip != "namespace" {
+		db = db.Where(`(nomad_resources.organization_id IS NULL AND nomad_resources.user_id IN (SELECT id FROM users WHERE LOWER(username) = LOWER(?)))
+			OR nomad_resources.organization_id IN (SELECT id FROM organizations WHERE LOWER(name) = LOWER(?))`, f.Namespace, f.Namespace)
+	}
+	if f.License != "" && skip != "license" {
+		db = db.Where("nomad_resources.license = ?", f.License)
+	}
+	return db
+}
+
+// searchCursor marks where the next page of a sort starts: after the
+// resource with the given sort value and ID
+type searchCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+func (cur searchCursor) encode() string {
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSearchCursor(s string) (searchCursor, bool) {
+	var cur searchCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(data, &cur) != nil {
+		return cur, false
+	}
+	return cur, true
+}
+
+// value converts the cursor's sort value back to the column's type
+func (cur searchCursor) value() (interface{}, bool) {
+	switch cur.Sort {
+	case "updated":
+		t, err := time.Parse(time.RFC3339Nano, cur.Value)
+		return t, err == nil
+	case "stars", "downloads":
+		n, err := strconv.Atoi(cur.Value)
+		return n, err == nil
+	case "relevance":
+		f, err := strconv.ParseFloat(cur.Value, 64)
+		return f, err == nil
+	}
+	return nil, false
+}
+
+// SearchAPI godoc
+// @Summary Search packs and jobs
+// @Description Full-text search over all resources with filters, facet counts and cursor pagination. Pass next_cursor back as cursor to get the next page, keeping the other parameters the same.
+// @Tags resources
+// @Produce json
+// @Param q query string false "Search query"
+// @Param type query string false "pack or job"
+// @Param tag query string false "Filter by tag"
+// @Param namespace query string false "Filter by user or organization"
+// @Param license query string false "Filter by license"
+// @Param sort query string false "relevance (default with q), updated (default without q), stars or downloads"
+// @Param limit query int false "Results per page, up to 100 (default 20)"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} SearchResponse
+// @Failure 400 {object} map[string]string
+// @Router /v1/search [get]
+func SearchAPI(c *fiber.Ctx) error {
+	filters := searchFilters{
+		Query:     c.Query("q"),
+		Type:      c.Query("type"),
+		Tag:       c.Query("tag"),
+		Namespace: c.Query("namespace"),
+		License:   c.Query("license"),
+	}
+	if filters.Type != "" && filters.Type != string(models.ResourceTypePack) && filters.Type != string(models.ResourceTypeJob) {
+		return c.Status(400).JSON(fiber.Map{"error": "type must be pack or job"})
+	}
+
+	sort := c.Query("sort")
+	if sort == "" {
+		sort = "updated"
+		if filters.Query != "" {
+			sort = "relevance"
+		}
+	}
+	if _, ok := searchSortColumns[sort]; !ok && sort != "relevance" {
+		return c.Status(400).JSON(fiber.Map{"error": "sort must be relevance, updated, stars or downloads"})
+	}
+	if sort == "relevance" && filters.Query == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "sort=relevance needs a query"})
+	}
+
+	limit := c.QueryInt("limit", searchDefaultLimit)
+	if limit < 1 || limit > searchMaxLimit {
+		return c.Status(400).JSON(fiber.Map{"error": "limit must be between 1 and " + strconv.Itoa(searchMaxLimit)})
+	}
+
+	visible := visibleTo(c)
+	var total int64
+	if err := filters.apply(database.DB.Model(&models.NomadResource{}).Scopes(visible), "").Count(&total).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	query := filters.apply(database.DB.Model(&models.NomadResource{}).Scopes(visible), "").
+		Preload("User").Preload("Organization").Preload("Tags").
+		Preload("Versions", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "resource_id", "version", "yanked")
+		})
+	column := searchSortColumns[sort]
+	if sort == "relevance" {
+		query = query.Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL:                relevanceRank + " DESC, nomad_resources.id DESC",
+			Vars:               []interface{}{filters.Query, filters.Query},
+			WithoutParentheses: true,
+		}})
+	} else {
+		query = query.Order(column + " DESC, nomad_resources.id DESC")
+	}
+
+	if s := c.Query("cursor"); s != "" {
+		cur, ok := decodeSearchCursor(s)
+		value, valid := cur.value()
+		if !ok || !valid || cur.Sort != sort {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid cursor"})
+		}
+		if sort == "relevance" {
+			query = query.Where("("+relevanceRank+", nomad_resources.id) < (?, ?)", filters.Query, filters.Query, value, cur.ID)
+		} else {
+			query = query.Where("("+column+", nomad_resources.id) < (?, ?)", value, cur.ID)
+		}
+	}
+
+	var resources []models.NomadResource
+	if err := query.Limit(limit + 1).Find(&resources).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	response := SearchResponse{Results: []SearchResult{}, Total: total, Facets: searchFacets(filters, visible)}
+	if len(resources) > limit {
+		resources = resources[:limit]
+		last := resources[limit-1]
+		cur := searchCursor{Sort: sort, ID: last.ID}
+		switch sort {
+		case "updated":
+			cur.Value = last.UpdatedAt.Format(time.RFC3339Nano)
+		case "stars":
+			cur.Value = strconv.Itoa(last.StarCount)
+		case "downloads":
+			cur.Value = strconv.Itoa(last.DownloadCount)
+		case "relevance":
+			var rank float64
+			database.DB.Raw("SELECT "+relevanceRank+" FROM nomad_resources WHERE id = ?", filters.Query, filters.Query, last.ID).Scan(&rank)
+			cur.Value = strconv.FormatFloat(rank, 'g', -1, 64)
+		}
+		response.NextCursor = cur.encode()
+	}
+
+	for _, r := range resources {
+		tags := make([]string, len(r.Tags))
+		for i, t := range r.Tags {
+			tags[i] = t.Name
+		}
+		result := SearchResult{
+			Name:        r.Name,
+			Namespace:   getResourceNamespace(r),
+			Type:        string(r.Type),
+			Description: r.Description,
+			License:     r.License,
+			Tags:        tags,
+			Stars:       r.StarCount,
+			Downloads:   r.DownloadCount,
+			Deprecated:  r.Deprecated,
+			UpdatedAt:   r.UpdatedAt,
+		}
+		if latest, ok := latestVersion(r.Versions); ok {
+			result.LatestVersion = latest.Version
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	return c.JSON(response)
+}
+
+// searchFacets counts the matches per type, tag, license and namespace. Each
+// facet ignores its own filter, so all its values stay selectable. Only
+// resources the visible scope lets through are counted.
+func searchFacets(filters searchFilters, visible func(*gorm.DB) *gorm.DB) map[string][]FacetCount {
+	facet := func(name, value string, joins ...string) []FacetCount {
+		counts := []FacetCount{}
+		db := database.DB.Model(&models.NomadResource{}).Scopes(visible)
+		for _, j := range joins {
+			db = db.Joins(j)
+		}
+		filters.apply(db, name).
+			Select(value + " AS value, COUNT(DISTINCT nomad_resources.id) AS count").
+			Where(value + " <> ''").
+			Group(value).Order("count DESC, value ASC").Limit(searchFacetLimit).
+			Scan(&counts)
+		return counts
+	}
+	return map[string][]FacetCount{
+		"type":    facet("type", "nomad_resources.type"),
+		"tag":     facet("tag", "tags.name", "JOIN resource_tags ON resource_tags.nomad_resource_id = nomad_resources.id", "JOIN tags ON tags.id = resource_tags.tag_id"),
+		"license": facet("license", "nomad_resources.license"),
+		"namespace": facet("namespace", "COALESCE(organizations.name, users.username)",
+			"LEFT JOIN users ON users.id = nomad_resources.user_id", "LEFT JOIN organizations ON organizations.id = nomad_resources.organization_id"),
+	}
+}
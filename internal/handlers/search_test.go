@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// searchPackNames runs a pack search API query and returns the names found, in order
+func searchPackNames(t *testing.T, query, sort string) []string {
+	app := setupTestApp()
+	app.Get("/v1/packs/search", SearchPacksAPI)
+
+	params := url.Values{"q": {query}}
+	if sort != "" {
+		params.Set("sort", sort)
+	}
+	resp, err := app.Test(httptest.NewRequest("GET", "/v1/packs/search?"+params.Encode(), nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var result struct {
+		Packs []PackSummary `json:"packs"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	names := make([]string, len(result.Packs))
+	for i, p := range result.Packs {
+		names[i] = p.Name
+	}
+	return names
+}
+
+func TestSearchPacksAPI_FullText(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "searchuser1")
+	ha := createTestPack(t, user.ID, "redis-ha")
+	database.DB.Model(&models.ResourceVersion{}).Where("resource_id = ?", ha.ID).
+		Update("readme", "# Redis HA\n\nRuns Redis with Sentinel for automatic failover.")
+	vars := createTestPack(t, user.ID, "cache")
+	database.DB.Model(&models.ResourceVersion{}).Where("resource_id = ?", vars.ID).
+		Update("variables", `[{"name":"sentinel_quorum","description":"Sentinels that must agree on a failover"}]`)
+	createTestPack(t, user.ID, "postgres")
+	for _, r := range []models.NomadResource{ha, vars} {
+		refreshSearchIndex(r.ID)
+	}
+	ReindexSearch()
+
+	// README and variables are searched, not just names and descriptions
+	assert.ElementsMatch(t, []string{"redis-ha", "cache"}, searchPackNames(t, "sentinel", ""))
+	assert.Equal(t, []string{"redis-ha"}, searchPackNames(t, "redis sentinel", ""))
+
+	// Names weigh most, and small typos still match
+	assert.Equal(t, "redis-ha", searchPackNames(t, "redis", "relevance")[0])
+	assert.Contains(t, searchPackNames(t, "postgress", ""), "postgres")
+}
+
+func TestSearchIndex_FollowsLatestVersion(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "searchuser2")
+	pack := createTestPack(t, user.ID, "proxy")
+	database.DB.Create(&models.ResourceVersion{ResourceID: pack.ID, Version: "v2.0.0", Readme: "Now built on envoy"})
+	refreshSearchIndex(pack.ID)
+	assert.Equal(t, []string{"proxy"}, searchPackNames(t, "envoy", ""))
+
+	// Yanking v2.0.0 makes v1.0.0 the latest again, and its README is indexed instead
+	app := setupAuthenticatedApp(user)
+	app.Post("/resource/:id/versions/:version/yank", PostYankVersion)
+	resp, err := app.Test(httptest.NewRequest("POST", "/resource/"+toString(pack.ID)+"/versions/v2.0.0/yank", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	assert.Empty(t, searchPackNames(t, "envoy", ""))
+}
+
+// searchAPI calls /v1/search with the given parameters
+func searchAPI(t *testing.T, params url.Values) (int, SearchResponse) {
+	app := setupTestApp()
+	app.Get("/v1/search", SearchAPI)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/v1/search?"+params.Encode(), nil))
+	require.NoError(t, err)
+	var result SearchResponse
+	if resp.StatusCode == 200 {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	}
+	return resp.StatusCode, result
+}
+
+func TestSearchAPI_CursorPagination(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "searchuser3")
+	for i, name := range []string{"alpha", "bravo", "charlie", "delta", "echo"} {
+		r := createTestPack(t, user.ID, name)
+		database.DB.Model(&r).Update("star_count", i%2) // Ties are broken by ID
+	}
+	createTestJob(t, user.ID, "foxtrot")
+
+	for _, sort := range []string{"updated", "stars", "downloads"} {
+		params := url.Values{"type": {"pack"}, "sort": {sort}, "limit": {"2"}}
+		var names []string
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 5, sort)
+			status, page := searchAPI(t, params)
+			require.Equal(t, 200, status)
+			assert.Equal(t, int64(5), page.Total)
+			for _, r := range page.Results {
+				names = append(names, r.Name)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			params.Set("cursor", page.NextCursor)
+		}
+		assert.ElementsMatch(t, []string{"alpha", "bravo", "charlie", "delta", "echo"}, names, sort)
+	}
+
+	// A cursor only works with the sort it was made for
+	_, page := searchAPI(t, url.Values{"sort": {"stars"}, "limit": {"1"}})
+	require.NotEmpty(t, page.NextCursor)
+	status, _ := searchAPI(t, url.Values{"sort": {"updated"}, "cursor": {page.NextCursor}})
+	assert.Equal(t, 400, status)
+	status, _ = searchAPI(t, url.Values{"cursor": {"garbage"}})
+	assert.Equal(t, 400, status)
+}
+
+func TestSearchAPI_FiltersAndFacets(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "searchuser4")
+	other := createTestUser(t, "searchuser5")
+	var dbTag models.Tag
+	database.DB.Where(models.Tag{Name: "database"}).FirstOrCreate(&dbTag)
+
+	mysql := createTestPack(t, user.ID, "mysql")
+	database.DB.Model(&mysql).Updates(map[string]interface{}{"license": "MIT"})
+	database.DB.Model(&mysql).Association("Tags").Append(&dbTag)
+	createTestPack(t, other.ID, "redis")
+	pg := createTestJob(t, user.ID, "postgres")
+	database.DB.Model(&pg).Association("Tags").Append(&dbTag)
+	ReindexSearch()
+
+	status, page := searchAPI(t, url.Values{"tag": {"database"}, "type": {"pack"}})
+	require.Equal(t, 200, status)
+	require.Len(t, page.Results, 1)
+	assert.Equal(t, "mysql", page.Results[0].Name)
+	assert.Equal(t, "v1.0.0", page.Results[0].LatestVersion)
+	assert.Equal(t, []string{"database"}, page.Results[0].Tags)
+
+	// The type facet ignores the type filter, so both types can be picked
+	assert.ElementsMatch(t, []FacetCount{{Value: "pack", Count: 1}, {Value: "job", Count: 1}}, page.Facets["type"])
+	assert.Equal(t, []FacetCount{{Value: "MIT", Count: 1}}, page.Facets["license"])
+
+	_, page = searchAPI(t, url.Values{"namespace": {"SEARCHUSER5"}})
+	assert.Equal(t, int64(1), page.Total)
+	assert.Equal(t, []FacetCount{{Value: "searchuser4", Count: 2}, {Value: "searchuser5", Count: 1}}, page.Facets["namespace"])
+
+	status, _ = searchAPI(t, url.Values{"sort": {"relevance"}})
+	assert.Equal(t, 400, status, "relevance needs a query")
+}
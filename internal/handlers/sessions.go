@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"log"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sessionTouchInterval is how often a session's last seen time and address
+// are updated. Touching a session also pushes back its expiry.
+const sessionTouchInterval = 5 * time.Minute
+
+// sessionGCInterval is how often expired sessions are deleted
+const sessionGCInterval = 10 * time.Minute
+
+// trackSession records who a session belongs to and the device it was
+// started from. id is the session's ID, read before it was saved.
+func trackSession(c *fiber.Ctx, id string, userID uint) {
+	now := time.Now()
+	database.DB.Model(&models.Session{}).Where("id = ?", database.HashSessionID(id)).Updates(map[string]interface{}{
+		"user_id":      userID,
+		"user_agent":   truncateText(c.Get(fiber.HeaderUserAgent), 512),
+		"ip":           c.IP(),
+		"last_seen_at": now,
+	})
+}
+
+// TouchSession records that the logged in user's session is in use, at most
+// once every sessionTouchInterval
+func TouchSession(c *fiber.Ctx) {
+	sess, err := Store.Get(c)
+	if err != nil {
+		return
+	}
+	seen, _ := sess.Get("seen_at").(int64)
+	if time.Since(time.Unix(seen, 0)) < sessionTouchInterval {
+		return
+	}
+	id := sess.ID()
+	sess.Set("seen_at", time.Now().Unix())
+	if err := sess.Save(); err != nil {
+		log.Printf("Error saving session: %v", err)
+		return
+	}
+	database.DB.Model(&models.Session{}).Where("id = ?", database.HashSessionID(id)).Updates(map[string]interface{}{
+		"ip":           c.IP(),
+		"last_seen_at": time.Now(),
+	})
+}
+
+// currentSessionKey returns the stored key of the request's session
+func currentSessionKey(c *fiber.Ctx) string {
+	sess, err := Store.Get(c)
+	if err != nil {
+		return ""
+	}
+	return database.HashSessionID(sess.ID())
+}
+
+// sessionDevice is a session as listed on the sessions page
+type sessionDevice struct {
+	models.Session
+	Device  string
+	Current bool
+}
+
+// GetSessions lists the user's active sessions
+func GetSessions(c *fiber.Ctx) error {
+	var sessions []models.Session
+	database.DB.Select("id", "user_agent", "ip", "created_at", "last_seen_at").
+		Where("user_id = ? AND (expires_at IS NULL OR expires_at > ?)", currentUserID(c), time.Now()).
+		Order("last_seen_at DESC NULLS LAST").Find(&sessions)
+
+	current := currentSessionKey(c)
+	devices := make([]sessionDevice, 0, len(sessions))
+	for _, s := range sessions {
+		devices = append(devices, sessionDevice{Session: s, Device: describeUserAgent(s.UserAgent), Current: s.ID == current})
+	}
+
+	return c.Render("settings_sessions", MergeContext(BaseContext(c), fiber.Map{
+		"Sessions": devices,
+	}), "layouts/main")
+}
+
+// PostRevokeSession logs one of the user's other sessions out
+func PostRevokeSession(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if id == currentSessionKey(c) {
+		return c.Status(400).SendString("Log out to end this session")
+	}
+	result := database.DB.Where("id = ? AND user_id = ?", id, currentUserID(c)).Delete(&models.Session{})
+	if result.RowsAffected == 0 {
+		return c.Status(404).SendString("Session not found")
+	}
+
+	SetFlash(c, "success", "The session has been logged out.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// PostRevokeOtherSessions logs out every session of the user but this one
+func PostRevokeOtherSessions(c *fiber.Ctx) error {
+	database.DB.Where("user_id = ? AND id <> ?", currentUserID(c), currentSessionKey(c)).Delete(&models.Session{})
+
+	SetFlash(c, "success", "All other sessions have been logged out.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// Markers of browsers and platforms in User-Agent headers, checked in order
+var (
+	userAgentBrowsers = []struct{ marker, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"}, {"Safari/", "Safari"}, {"curl/", "curl"},
+	}
+	userAgentPlatforms = []struct{ marker, name string }{
+		{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iOS"}, {"Windows", "Windows"},
+		{"Macintosh", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	}
+)
+
+// describeUserAgent names the browser and platform in a User-Agent header,
+// e.g. "Firefox on Linux"
+func describeUserAgent(ua string) string {
+	var browser, platform string
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(ua, b.marker) {
+			browser = b.name
+			break
+		}
+	}
+	for _, p := range userAgentPlatforms {
+		if strings.Contains(ua, p.marker) {
+			platform = p.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+	return "Unknown device"
+}
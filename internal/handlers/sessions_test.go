@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	firefoxLinux = "Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0"
+	safariIPhone = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.6 Mobile/15E148 Safari/604.1"
+)
+
+func TestSessionStorage(t *testing.T) {
+	defer cleanupTestData(t)
+
+	storage := database.NewSessionStorage(database.DB, time.Hour)
+	defer storage.Close()
+
+	require.NoError(t, storage.Set("session-id", []byte("data"), time.Hour))
+	data, err := storage.Get("session-id")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	// Only the hash of the ID is stored
+	var count int64
+	database.DB.Model(&models.Session{}).Where("id = ?", "session-id").Count(&count)
+	assert.Zero(t, count)
+
+	database.DB.Model(&models.Session{}).Where("id = ?", database.HashSessionID("session-id")).
+		Update("expires_at", time.Now().Add(-time.Minute))
+	data, err = storage.Get("session-id")
+	require.NoError(t, err)
+	assert.Nil(t, data)
+}
+
+func TestSessions(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createPasswordUser(t, "sessionuser")
+	loginApp := setupTestApp()
+	loginApp.Post("/login", PostLogin)
+	login := func(userAgent string) string {
+		req := httptest.NewRequest("POST", "/login", strings.NewReader("email=sessionuser@test.com&password=CorrectPass123!"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", userAgent)
+		resp, err := loginApp.Test(req)
+		require.NoError(t, err)
+		require.Equal(t, 200, resp.StatusCode)
+		return strings.Split(resp.Header.Get("Set-Cookie"), ";")[0]
+	}
+	laptop := login(firefoxLinux)
+	phone := login(safariIPhone)
+
+	var sessions []models.Session
+	database.DB.Where("user_id = ?", user.ID).Find(&sessions)
+	require.Len(t, sessions, 2)
+
+	// Sessions outlive the store, as they do a restart
+	whoami := func(cookie string) string {
+		app := setupTestApp()
+		app.Get("/whoami", func(c *fiber.Ctx) error {
+			sess, err := Store.Get(c)
+			if err != nil {
+				return err
+			}
+			return c.SendString(fmt.Sprint(sess.Get("user_id")))
+		})
+		req := httptest.NewRequest("GET", "/whoami", nil)
+		req.Header.Set("Cookie", cookie)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	assert.Equal(t, toString(user.ID), whoami(laptop))
+
+	app := setupAuthenticatedApp(user)
+	app.Get("/settings/sessions", GetSessions)
+	app.Post("/settings/sessions/:id/revoke", PostRevokeSession)
+	request := func(method, path string) (int, string) {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Cookie", laptop)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := request("GET", "/settings/sessions")
+	require.Equal(t, 200, status)
+	assert.Contains(t, body, "Firefox on Linux")
+	assert.Contains(t, body, "Safari on iOS")
+	assert.Contains(t, body, "This session")
+
+	var phoneSession models.Session
+	require.NoError(t, database.DB.Where("user_id = ? AND user_agent = ?", user.ID, safariIPhone).First(&phoneSession).Error)
+	var laptopSession models.Session
+	require.NoError(t, database.DB.Where("user_id = ? AND user_agent = ?", user.ID, firefoxLinux).First(&laptopSession).Error)
+
+	status, _ = request("POST", "/settings/sessions/"+laptopSession.ID+"/revoke")
+	assert.Equal(t, 400, status)
+	status, _ = request("POST", "/settings/sessions/"+phoneSession.ID+"/revoke")
+	assert.Equal(t, 200, status)
+	status, _ = request("POST", "/settings/sessions/"+phoneSession.ID+"/revoke")
+	assert.Equal(t, 404, status)
+
+	assert.Equal(t, "<nil>", whoami(phone))
+	assert.Equal(t, toString(user.ID), whoami(laptop))
+}
+
+func TestDescribeUserAgent(t *testing.T) {
+	assert.Equal(t, "Firefox on Linux", describeUserAgent(firefoxLinux))
+	assert.Equal(t, "Safari on iOS", describeUserAgent(safariIPhone))
+	assert.Equal(t, "Edge on Windows", describeUserAgent("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36 Edg/129.0.0.0"))
+	assert.Equal(t, "curl", describeUserAgent("curl/8.5.0"))
+	assert.Equal(t, "Unknown device", describeUserAgent(""))
+}
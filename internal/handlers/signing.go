@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"rmbl/internal/signing"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// SigningKeyInfo is the API form of a signing key
+type SigningKeyInfo struct {
+	Name        string    `json:"name"`
+	PublicKey   string    `json:"public_key"`  // ed25519:<base64>
+	Fingerprint string    `json:"fingerprint"` // Matches signing_key on signed versions
+	Revoked     bool      `json:"revoked,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// namespaceKeys scopes a query to the signing keys of a namespace: an
+// organization's keys, or a user's personal keys when orgID is nil
+func namespaceKeys(orgID *uint, userID uint) *gorm.DB {
+	if orgID != nil {
+		return database.DB.Where("organization_id = ?", *orgID)
+	}
+	return database.DB.Where("user_id = ? AND organization_id IS NULL", userID)
+}
+
+// canManageSigningKey reports whether a user added the key or may rotate its
+// organization's secrets
+func canManageSigningKey(userID uint, key models.SigningKey) bool {
+	if key.UserID == userID {
+		return true
+	}
+	if key.OrganizationID == nil {
+		return false
+	}
+	return orgAllows(userID, *key.OrganizationID, PermRotateSecrets)
+}
+
+// GetSigningKeys renders the signing key settings page, listing the user's
+// personal keys and the keys of organizations whose secrets they manage
+func GetSigningKeys(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+	orgs := ownedOrganizations(userID)
+
+	orgIDs := make([]uint, len(orgs))
+	for i, o := range orgs {
+		orgIDs[i] = o.ID
+	}
+	var keys []models.SigningKey
+	query := database.DB.Preload("Organization").Preload("User").Where("user_id = ? AND organization_id IS NULL", userID)
+	if len(orgIDs) > 0 {
+		query = query.Or("organization_id IN ?", orgIDs)
+	}
+	query.Order("created_at DESC").Find(&keys)
+
+	return c.Render("settings_keys", MergeContext(BaseContext(c), fiber.Map{
+		"Keys":          keys,
+		"Organizations": orgs,
+	}), "layouts/main")
+}
+
+// PostCreateSigningKey registers a public key for the user or one of their organizations
+func PostCreateSigningKey(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+
+	name := strings.TrimSpace(c.FormValue("name"))
+	if name == "" {
+		return c.Status(400).SendString("Key name is required")
+	}
+	pub, err := signing.ParsePublicKey(c.FormValue("public_key"))
+	if err != nil {
+		return c.Status(400).SendString("Invalid public key: paste an ed25519:... key or a PEM public key")
+	}
+	orgID, ferr := formOwner(c, userID)
+	if ferr != nil {
+		return c.Status(ferr.Code).SendString(ferr.Message)
+	}
+
+	fingerprint := signing.Fingerprint(pub)
+	var existing models.SigningKey
+	if namespaceKeys(orgID, userID).Where("fingerprint = ? AND revoked_at IS NULL", fingerprint).First(&existing).Error == nil {
+		return c.Status(400).SendString("This key is already registered as '" + existing.Name + "'")
+	}
+
+	key := models.SigningKey{
+		Name:           name,
+		PublicKey:      signing.FormatPublicKey(pub),
+		Fingerprint:    fingerprint,
+		UserID:         userID,
+		OrganizationID: orgID,
+	}
+	if err := database.DB.Create(&key).Error; err != nil {
+		return c.Status(500).SendString("Could not add key")
+	}
+
+	SetFlash(c, "success", "Signing key '"+key.Name+"' has been added.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// PostRevokeSigningKey revokes a signing key. Versions signed with it no
+// longer verify in clients.
+func PostRevokeSigningKey(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+
+	var key models.SigningKey
+	if err := database.DB.First(&key, c.Params("id")).Error; err != nil || !canManageSigningKey(userID, key) {
+		return c.Status(404).SendString("Key not found")
+	}
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+		database.DB.Save(&key)
+	}
+
+	SetFlash(c, "success", "Signing key '"+key.Name+"' has been revoked.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// ListSigningKeysAPI godoc
+// @Summary List a namespace's signing keys
+// @Description Public keys a user or organization signs releases with, including revoked keys. Clients use them to check version signatures.
+// @Tags registries
+// @Produce json
+// @Param namespace path string true "User or organization"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Router /v1/keys/{namespace} [get]
+func ListSigningKeysAPI(c *fiber.Ctx) error {
+	namespace := c.Params("namespace")
+
+	var orgID *uint
+	var userID uint
+	var user models.User
+	if err := database.DB.Where("username ILIKE ?", escapeLikeString(namespace)).First(&user).Error; err == nil {
+		userID = user.ID
+	} else {
+		var org models.Organization
+		if err := database.DB.Where("name ILIKE ?", escapeLikeString(namespace)).First(&org).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Namespace not found"})
+		}
+		orgID = &org.ID
+	}
+
+	var keys []models.SigningKey
+	namespaceKeys(orgID, userID).Order("created_at ASC").Find(&keys)
+
+	result := make([]SigningKeyInfo, len(keys))
+	for i, k := range keys {
+		result[i] = SigningKeyInfo{
+			Name:        k.Name,
+			PublicKey:   k.PublicKey,
+			Fingerprint: k.Fingerprint,
+			Revoked:     k.RevokedAt != nil,
+			CreatedAt:   k.CreatedAt,
+		}
+	}
+	return c.JSON(fiber.Map{"namespace": namespace, "keys": result})
+}
+
+// PostVersionSignature godoc
+// @Summary Sign a version
+// @Description Attach a detached ed25519 signature over the version's digest, made with one of the namespace's signing keys. The version must have finished ingestion. Replaces any earlier signature.
+// @Tags resources
+// @Param id path string true "Resource ID"
+// @Param version path string true "Version"
+// @Param signature formData string true "Base64 signature over the digest"
+// @Param key formData string true "Fingerprint of the signing key"
+// @Success 200 {object} object
+// @Failure 400 {string} string "Bad Request"
+// @Failure 403 {string} string "Unauthorized"
+// @Failure 409 {string} string "Version has no digest yet"
+// @Security BearerAuth
+// @Router /resource/{id}/versions/{version}/signature [post]
+func PostVersionSignature(c *fiber.Ctx) error {
+	var resource models.NomadResource
+	if err := database.DB.First(&resource, c.Params("id")).Error; err != nil {
+		return apiError(c, 404, "Resource not found")
+	}
+	if !hasPermission(currentUserID(c), resource, PermPublish) || !tokenAllowsNamespace(c, resource.OrganizationID) {
+		return apiError(c, 403, "Unauthorized")
+	}
+
+	var version models.ResourceVersion
+	if err := database.DB.Where("resource_id = ? AND version = ?", resource.ID, c.Params("version")).First(&version).Error; err != nil {
+		return apiError(c, 404, "Version not found")
+	}
+	if version.Digest == "" {
+		return apiError(c, 409, "Version "+version.Version+" has not been ingested yet")
+	}
+
+	var key models.SigningKey
+	fingerprint := strings.TrimSpace(c.FormValue("key"))
+	if err := namespaceKeys(resource.OrganizationID, resource.UserID).Where("fingerprint = ? AND revoked_at IS NULL", fingerprint).First(&key).Error; err != nil {
+		return apiError(c, 400, "No active signing key "+fingerprint+" in this namespace")
+	}
+	pub, err := signing.ParsePublicKey(key.PublicKey)
+	if err != nil {
+		return apiError(c, 500, "Stored key is invalid")
+	}
+	signature := strings.TrimSpace(c.FormValue("signature"))
+	if err := signing.Verify(pub, version.Digest, signature); err != nil {
+		return apiError(c, 400, "Signature does not match digest "+version.Digest)
+	}
+
+	if err := database.DB.Model(&version).Updates(map[string]interface{}{"signature": signature, "signed_by": key.Fingerprint}).Error; err != nil {
+		return apiError(c, 500, "Could not store signature")
+	}
+
+	return c.JSON(fiber.Map{"version": version.Version, "digest": version.Digest, "signing_key": key.Fingerprint})
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"rmbl/internal/signing"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostCreateSigningKey(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "keyuser1")
+	pub, _, err := signing.GenerateKey()
+	require.NoError(t, err)
+
+	app := setupAuthenticatedApp(user)
+	app.Post("/settings/keys", PostCreateSigningKey)
+
+	form := url.Values{"name": {"laptop"}, "public_key": {signing.FormatPublicKey(pub)}}
+	req := httptest.NewRequest("POST", "/settings/keys", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var key models.SigningKey
+	require.NoError(t, database.DB.Where("user_id = ?", user.ID).First(&key).Error)
+	assert.Equal(t, signing.Fingerprint(pub), key.Fingerprint)
+	assert.Nil(t, key.OrganizationID)
+
+	// The same key cannot be added twice, and garbage is rejected
+	for _, value := range []string{signing.FormatPublicKey(pub), "not a key"} {
+		form := url.Values{"name": {"again"}, "public_key": {value}}
+		req := httptest.NewRequest("POST", "/settings/keys", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode, value)
+	}
+}
+
+func TestPostVersionSignature(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "signuser1")
+	resource := createTestJob(t, user.ID, "signed-job")
+	content := "job \"signed\" {}"
+	digest := contentDigest([]byte(content))
+	database.DB.Create(&models.ResourceVersion{ResourceID: resource.ID, Version: "v1.0.0", Content: content, Digest: digest})
+
+	pub, priv, err := signing.GenerateKey()
+	require.NoError(t, err)
+	key := models.SigningKey{Name: "ci", PublicKey: signing.FormatPublicKey(pub), Fingerprint: signing.Fingerprint(pub), UserID: user.ID}
+	require.NoError(t, database.DB.Create(&key).Error)
+
+	app := setupAuthenticatedApp(user)
+	app.Post("/resource/:id/versions/:version/signature", PostVersionSignature)
+	app.Get("/v1/keys/:namespace", ListSigningKeysAPI)
+
+	sign := func(signature string) int {
+		form := url.Values{"signature": {signature}, "key": {key.Fingerprint}}
+		req := httptest.NewRequest("POST", "/resource/"+toString(resource.ID)+"/versions/v1.0.0/signature", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	// A signature over anything but the stored digest is refused
+	assert.Equal(t, 400, sign(signing.Sign(priv, "sha256:other")))
+	assert.Equal(t, 200, sign(signing.Sign(priv, digest)))
+
+	var version models.ResourceVersion
+	database.DB.Where("resource_id = ?", resource.ID).First(&version)
+	assert.NoError(t, signing.Verify(pub, version.Digest, version.Signature))
+	assert.Equal(t, key.Fingerprint, version.SignedBy)
+
+	// Revoked keys can no longer sign, and are listed as revoked
+	now := time.Now()
+	database.DB.Model(&key).Update("revoked_at", &now)
+	assert.Equal(t, 400, sign(signing.Sign(priv, digest)))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/v1/keys/signuser1", nil))
+	require.NoError(t, err)
+	var listing struct {
+		Keys []SigningKeyInfo `json:"keys"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&listing))
+	require.Len(t, listing.Keys, 1)
+	assert.Equal(t, key.PublicKey, listing.Keys[0].PublicKey)
+	assert.True(t, listing.Keys[0].Revoked)
+}
+
+func TestPostVersionSignature_OtherNamespaceKey(t *testing.T) {
+	defer cleanupTestData(t)
+
+	owner := createTestUser(t, "signuser2")
+	other := createTestUser(t, "signuser3")
+	resource := createTestJob(t, owner.ID, "signed-job-2")
+	digest := contentDigest([]byte("job {}"))
+	database.DB.Create(&models.ResourceVersion{ResourceID: resource.ID, Version: "v1.0.0", Content: "job {}", Digest: digest})
+
+	// A key registered by someone else cannot sign this namespace's versions
+	pub, priv, err := signing.GenerateKey()
+	require.NoError(t, err)
+	require.NoError(t, database.DB.Create(&models.SigningKey{Name: "theirs", PublicKey: signing.FormatPublicKey(pub), Fingerprint: signing.Fingerprint(pub), UserID: other.ID}).Error)
+
+	app := setupAuthenticatedApp(owner)
+	app.Post("/resource/:id/versions/:version/signature", PostVersionSignature)
+
+	form := url.Values{"signature": {signing.Sign(priv, digest)}, "key": {signing.Fingerprint(pub)}}
+	req := httptest.NewRequest("POST", "/resource/"+toString(resource.ID)+"/versions/v1.0.0/signature", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
@@ -30,9 +30,9 @@ func GenerateSitemap(c *fiber.Ctx) error {
 	xml.WriteString(sitemapURL(baseURL+"/packs", "", "weekly", "0.8"))
 	xml.WriteString(sitemapURL(baseURL+"/jobs", "", "weekly", "0.8"))
 
-	// All resources
+	// All public resources
 	var resources []models.NomadResource
-	database.DB.Preload("User").Preload("Organization").Find(&resources)
+	database.DB.Preload("User").Preload("Organization").Where("visibility = ? AND hidden_at IS NULL", models.VisibilityPublic).Find(&resources)
 
 	for _, r := range resources {
 		displayName := r.User.Username
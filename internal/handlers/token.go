@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// API token scopes
+const (
+	ScopeResourcesWrite  = "resources:write"
+	ScopeResourcesDelete = "resources:delete"
+	ScopeVersionsWrite   = "versions:write"
+	ScopeWebhooksWrite   = "webhooks:write"
+	ScopeAuditRead       = "audit:read" // Admins only
+)
+
+// apiTokenPrefix makes registry tokens easy to recognise in logs and secret scanners
+const apiTokenPrefix = "rmbl_"
+
+// TokenScope describes a scope that can be granted to an API token
+type TokenScope struct {
+	Name        string
+	Description string
+}
+
+// TokenScopes lists every scope offered on the token settings page
+var TokenScopes = []TokenScope{
+	{Name: ScopeResourcesWrite, Description: "Create and edit resources"},
+	{Name: ScopeVersionsWrite, Description: "Publish new versions"},
+	{Name: ScopeResourcesDelete, Description: "Delete resources"},
+	{Name: ScopeWebhooksWrite, Description: "Rotate webhook secrets and replay deliveries"},
+	{Name: ScopeAuditRead, Description: "Export the audit log (admins only)"},
+}
+
+// scopesFor returns the scopes a user may grant their tokens
+func scopesFor(c *fiber.Ctx) []TokenScope {
+	if isAdminRequest(c) {
+		return TokenScopes
+	}
+	scopes := []TokenScope{}
+	for _, s := range TokenScopes {
+		if s.Name != ScopeAuditRead {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// generateAPIToken returns a new plaintext token and its hash
+func generateAPIToken() (string, string) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("failed to generate API token: " + err.Error())
+	}
+	token := apiTokenPrefix + hex.EncodeToString(b)
+	return token, hashAPIToken(token)
+}
+
+func hashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func isValidScope(scope string) bool {
+	for _, s := range TokenScopes {
+		if s.Name == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// apiTokenFromCtx returns the API token that authenticated the request, if any
+func apiTokenFromCtx(c *fiber.Ctx) (models.APIToken, bool) {
+	token, ok := c.Locals("APIToken").(models.APIToken)
+	return token, ok
+}
+
+// isTokenRequest reports whether the request was authenticated with an API token
+func isTokenRequest(c *fiber.Ctx) bool {
+	_, ok := apiTokenFromCtx(c)
+	return ok
+}
+
+func tokenHasScope(token models.APIToken, scope string) bool {
+	for _, s := range strings.Split(token.Scopes, ",") {
+		if strings.TrimSpace(s) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenAllowsNamespace reports whether the request may act on resources owned by
+// the given organization (nil for personal namespaces). Organization tokens are
+// limited to their own organization; sessions and personal tokens are not restricted here.
+func tokenAllowsNamespace(c *fiber.Ctx, orgID *uint) bool {
+	token, ok := apiTokenFromCtx(c)
+	if !ok || token.OrganizationID == nil {
+		return true
+	}
+	return orgID != nil && *orgID == *token.OrganizationID
+}
+
+// currentUserID returns the ID of the authenticated user, whether the request was
+// authenticated by session cookie or API token. It returns 0 when neither is present.
+func currentUserID(c *fiber.Ctx) uint {
+	if id, ok := c.Locals("UserID").(uint); ok {
+		return id
+	}
+	if sess, err := Store.Get(c); err == nil {
+		if id, ok := sess.Get("user_id").(uint); ok {
+			return id
+		}
+	}
+	return 0
+}
+
+// apiError sends a JSON error to token-authenticated callers and plain text to the browser
+func apiError(c *fiber.Ctx, status int, message string) error {
+	if isTokenRequest(c) {
+		return c.Status(status).JSON(fiber.Map{"error": message})
+	}
+	return c.Status(status).SendString(message)
+}
+
+// TokenAuth authenticates requests carrying an "Authorization: Bearer" API token.
+// Requests without the header fall through to the session middleware untouched.
+func TokenAuth(c *fiber.Ctx) error {
+	header := c.Get(fiber.HeaderAuthorization)
+	if !strings.HasPrefix(header, "Bearer ") {
+		return c.Next()
+	}
+	raw := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+
+	var token models.APIToken
+	if raw == "" || database.DB.Where("token_hash = ?", hashAPIToken(raw)).First(&token).Error != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid API token"})
+	}
+	now := time.Now()
+	if token.RevokedAt != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "API token has been revoked"})
+	}
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "API token has expired"})
+	}
+
+	var user models.User
+	if err := database.DB.Preload("Memberships.Organization").First(&user, token.UserID).Error; err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid API token"})
+	}
+	if user.SuspendedAt != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Account suspended"})
+	}
+
+	database.DB.Model(&token).UpdateColumn("last_used_at", now)
+
+	c.Locals("UserID", user.ID)
+	c.Locals("User", user)
+	c.Locals("APIToken", token)
+	return c.Next()
+}
+
+// RequireAuthOrToken accepts either a logged-in session or an API token that
+// carries the given scope.
+func RequireAuthOrToken(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token, ok := apiTokenFromCtx(c)
+		if !ok {
+			return RequireAuth(c)
+		}
+		if !tokenHasScope(token, scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "API token is missing the " + scope + " scope"})
+		}
+		return c.Next()
+	}
+}
+
+// GetTokens renders the API token settings page
+func GetTokens(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+
+	var tokens []models.APIToken
+	database.DB.Preload("Organization").Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens)
+
+	return c.Render("settings_tokens", MergeContext(BaseContext(c), fiber.Map{
+		"Tokens":        tokens,
+		"Scopes":        scopesFor(c),
+		"Organizations": ownedOrganizations(userID),
+		"Now":           time.Now(),
+	}), "layouts/main")
+}
+
+// ownedOrganizations returns the organizations whose tokens and signing keys
+// a user's role lets them manage, by name
+func ownedOrganizations(userID uint) []models.Organization {
+	var orgs []models.Organization
+	database.DB.Joins("JOIN memberships ON memberships.organization_id = organizations.id").
+		Where("memberships.user_id = ? AND memberships.deleted_at IS NULL", userID).
+		Order("organizations.name ASC").Find(&orgs)
+
+	allowed := orgs[:0]
+	for _, org := range orgs {
+		if orgAllows(userID, org.ID, PermRotateSecrets) {
+			allowed = append(allowed, org)
+		}
+	}
+	return allowed
+}
+
+// formOwner reads the "owner" field of the token and signing key forms: "user"
+// for the current user, or "org:<id>" for an organization whose secrets they
+// may rotate. It returns nil for the user.
+func formOwner(c *fiber.Ctx, userID uint) (*uint, *fiber.Error) {
+	owner := c.FormValue("owner")
+	if !strings.HasPrefix(owner, "org:") {
+		return nil, nil
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(owner, "org:"), 10, 32)
+	if err != nil {
+		return nil, fiber.NewError(400, "Invalid organization")
+	}
+	if !orgAllows(userID, uint(id), PermRotateSecrets) {
+		return nil, fiber.NewError(403, "Your role in this organization does not allow managing its secrets")
+	}
+	val := uint(id)
+	return &val, nil
+}
+
+// PostCreateToken creates a new API token and shows its plaintext value once
+func PostCreateToken(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+
+	name := strings.TrimSpace(c.FormValue("name"))
+	if name == "" {
+		return c.Status(400).SendString("Token name is required")
+	}
+
+	// Scopes arrive as repeated checkbox values
+	var scopes []string
+	for _, v := range c.Context().PostArgs().PeekMulti("scopes") {
+		scope := string(v)
+		if !isValidScope(scope) {
+			return c.Status(400).SendString("Unknown scope: " + scope)
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		return c.Status(400).SendString("Select at least one scope")
+	}
+
+	orgID, ferr := formOwner(c, userID)
+	if ferr != nil {
+		return c.Status(ferr.Code).SendString(ferr.Message)
+	}
+	for _, scope := range scopes {
+		if scope == ScopeAuditRead && (!isAdminRequest(c) || orgID != nil) {
+			return c.Status(403).SendString("Only admins can create audit:read tokens, and only for themselves")
+		}
+	}
+
+	var expiresAt *time.Time
+	if days, err := strconv.Atoi(c.FormValue("expires_in")); err == nil && days > 0 {
+		t := time.Now().AddDate(0, 0, days)
+		expiresAt = &t
+	}
+
+	plaintext, hash := generateAPIToken()
+	token := models.APIToken{
+		Name:           name,
+		TokenHash:      hash,
+		Prefix:         plaintext[:len(apiTokenPrefix)+8],
+		Scopes:         strings.Join(scopes, ","),
+		UserID:         userID,
+		OrganizationID: orgID,
+		ExpiresAt:      expiresAt,
+	}
+	if err := database.DB.Create(&token).Error; err != nil {
+		return c.Status(500).SendString("Could not create token")
+	}
+
+	return c.Render("partials/token_created", fiber.Map{
+		"Token": plaintext,
+		"Name":  token.Name,
+	})
+}
+
+// PostRevokeToken revokes one of the current user's API tokens
+func PostRevokeToken(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+
+	var token models.APIToken
+	if err := database.DB.Where("id = ? AND user_id = ?", c.Params("id"), userID).First(&token).Error; err != nil {
+		return c.Status(404).SendString("Token not found")
+	}
+	if token.RevokedAt == nil {
+		now := time.Now()
+		token.RevokedAt = &now
+		database.DB.Save(&token)
+	}
+
+	SetFlash(c, "success", "Token '"+token.Name+"' has been revoked.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// GetCurrentUserAPI godoc
+// @Summary Get the authenticated user
+// @Description Returns the account behind the current session or API token. Used by `ramble login` to verify a token.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]string
+// @Security BearerAuth
+// @Router /v1/user [get]
+func GetCurrentUserAPI(c *fiber.Ctx) error {
+	user, ok := c.Locals("User").(models.User)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Not authenticated"})
+	}
+
+	orgs := make([]string, 0, len(user.Memberships))
+	for _, m := range user.Memberships {
+		orgs = append(orgs, m.Organization.Name)
+	}
+
+	result := fiber.Map{
+		"username":      user.Username,
+		"name":          user.Name,
+		"organizations": orgs,
+	}
+	if token, ok := apiTokenFromCtx(c); ok {
+		result["scopes"] = strings.Split(token.Scopes, ",")
+		if token.OrganizationID != nil {
+			for _, m := range user.Memberships {
+				if m.OrganizationID == *token.OrganizationID {
+					result["token_organization"] = m.Organization.Name
+				}
+			}
+		}
+	}
+	return c.JSON(result)
+}
@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createTestToken creates an API token for the user and returns its plaintext value
+func createTestToken(t *testing.T, userID uint, orgID *uint, scopes string) (string, models.APIToken) {
+	plaintext, hash := generateAPIToken()
+	token := models.APIToken{
+		Name:           "test-token",
+		TokenHash:      hash,
+		Prefix:         plaintext[:12],
+		Scopes:         scopes,
+		UserID:         userID,
+		OrganizationID: orgID,
+	}
+	require.NoError(t, database.DB.Create(&token).Error)
+	return plaintext, token
+}
+
+// setupTokenApp creates an app that authenticates requests with TokenAuth
+func setupTokenApp() *fiber.App {
+	app := setupTestApp()
+	app.Use(TokenAuth)
+	return app
+}
+
+func TestTokenAuth_NoHeader(t *testing.T) {
+	app := setupTokenApp()
+	app.Get("/whoami", func(c *fiber.Ctx) error {
+		assert.Nil(t, c.Locals("APIToken"))
+		return c.SendStatus(200)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/whoami", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestTokenAuth_InvalidToken(t *testing.T) {
+	app := setupTokenApp()
+	app.Get("/whoami", func(c *fiber.Ctx) error { return c.SendStatus(200) })
+
+	req := httptest.NewRequest("GET", "/whoami", nil)
+	req.Header.Set("Authorization", "Bearer rmbl_doesnotexist")
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 401, resp.StatusCode)
+}
+
+func TestTokenAuth_ValidToken(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "tokenuser")
+	plaintext, token := createTestToken(t, user.ID, nil, ScopeResourcesWrite)
+
+	app := setupTokenApp()
+	app.Get("/whoami", func(c *fiber.Ctx) error {
+		assert.Equal(t, user.ID, c.Locals("UserID"))
+		return c.SendStatus(200)
+	})
+
+	req := httptest.NewRequest("GET", "/whoami", nil)
+	req.Header.Set("Authorization", "Bearer "+plaintext)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var updated models.APIToken
+	database.DB.First(&updated, token.ID)
+	assert.NotNil(t, updated.LastUsedAt)
+}
+
+func TestTokenAuth_RevokedToken(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "revokeduser")
+	plaintext, token := createTestToken(t, user.ID, nil, ScopeResourcesWrite)
+	now := time.Now()
+	database.DB.Model(&token).Update("revoked_at", &now)
+
+	app := setupTokenApp()
+	app.Get("/whoami", func(c *fiber.Ctx) error { return c.SendStatus(200) })
+
+	req := httptest.NewRequest("GET", "/whoami", nil)
+	req.Header.Set("Authorization", "Bearer "+plaintext)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 401, resp.StatusCode)
+}
+
+func TestTokenAuth_ExpiredToken(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "expireduser")
+	plaintext, token := createTestToken(t, user.ID, nil, ScopeResourcesWrite)
+	past := time.Now().Add(-time.Hour)
+	database.DB.Model(&token).Update("expires_at", &past)
+
+	app := setupTokenApp()
+	app.Get("/whoami", func(c *fiber.Ctx) error { return c.SendStatus(200) })
+
+	req := httptest.NewRequest("GET", "/whoami", nil)
+	req.Header.Set("Authorization", "Bearer "+plaintext)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 401, resp.StatusCode)
+}
+
+func TestRequireAuthOrToken_MissingScope(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "scopeuser")
+	resource := createTestPack(t, user.ID, "scope-pack")
+	plaintext, _ := createTestToken(t, user.ID, nil, ScopeVersionsWrite)
+
+	app := setupTokenApp()
+	app.Delete("/resource/:id", RequireAuthOrToken(ScopeResourcesDelete), DeleteResource)
+
+	req := httptest.NewRequest("DELETE", "/resource/"+toString(resource.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+plaintext)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
+
+	var count int64
+	database.DB.Model(&models.NomadResource{}).Where("id = ?", resource.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestRequireAuth_RejectsToken(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "sessiononly")
+	plaintext, _ := createTestToken(t, user.ID, nil, ScopeResourcesWrite)
+
+	app := setupTokenApp()
+	app.Post("/orgs/new", RequireAuth, PostCreateOrg)
+
+	req := httptest.NewRequest("POST", "/orgs/new", strings.NewReader("name=tokenorg"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+plaintext)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
+}
+
+func TestPostNewResource_WithToken(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "tokenpublisher")
+	plaintext, _ := createTestToken(t, user.ID, nil, ScopeResourcesWrite)
+
+	app := setupTokenApp()
+	app.Post("/new", RequireAuthOrToken(ScopeResourcesWrite), RequireVerifiedEmail, PostNewResource)
+
+	payload := strings.NewReader("name=ci-pack&type=pack&owner=user&version=v1.0.0&repository_url=https://github.com/test/ci-pack")
+	req := httptest.NewRequest("POST", "/new", payload)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+plaintext)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+
+	body, _ := io.ReadAll(resp.Body)
+	var result map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &result))
+	assert.Equal(t, "ci-pack", result["name"])
+	assert.Equal(t, "tokenpublisher", result["namespace"])
+}
+
+func TestPostNewResource_OrgTokenCannotPublishPersonally(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	user := createTestUser(t, "orgtokenuser")
+	org := models.Organization{Name: "testtokenorg"}
+	require.NoError(t, database.DB.Create(&org).Error)
+	database.DB.Create(&models.Membership{UserID: user.ID, OrganizationID: org.ID, Role: "owner"})
+	plaintext, _ := createTestToken(t, user.ID, &org.ID, ScopeResourcesWrite)
+
+	app := setupTokenApp()
+	app.Post("/new", RequireAuthOrToken(ScopeResourcesWrite), PostNewResource)
+
+	payload := strings.NewReader("name=personal-pack&type=pack&owner=user&version=v1.0.0")
+	req := httptest.NewRequest("POST", "/new", payload)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+plaintext)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
+}
+
+func TestPostNewResource_NotOrgMember(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	user := createTestUser(t, "outsider")
+	org := models.Organization{Name: "testclosedorg"}
+	require.NoError(t, database.DB.Create(&org).Error)
+
+	app := setupAuthenticatedApp(user)
+	app.Post("/new", PostNewResource)
+
+	payload := strings.NewReader("name=sneaky-pack&type=pack&owner=org:" + toString(org.ID) + "&version=v1.0.0")
+	req := httptest.NewRequest("POST", "/new", payload)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
+}
+
+func TestPostNewVersion_WithToken_NotOwner(t *testing.T) {
+	defer cleanupTestData(t)
+
+	owner := createTestUser(t, "versionowner")
+	other := createTestUser(t, "versionother")
+	resource := createTestPack(t, owner.ID, "guarded-pack")
+	plaintext, _ := createTestToken(t, other.ID, nil, ScopeVersionsWrite)
+
+	app := setupTokenApp()
+	app.Post("/resource/:id/version", RequireAuthOrToken(ScopeVersionsWrite), PostNewVersion)
+
+	req := httptest.NewRequest("POST", "/resource/"+toString(resource.ID)+"/version", strings.NewReader("version=v9.9.9"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+plaintext)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
+}
+
+func TestPostNewVersion_WithToken(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "versiontokenuser")
+	resource := createTestPack(t, user.ID, "token-version-pack")
+	plaintext, _ := createTestToken(t, user.ID, nil, ScopeVersionsWrite)
+
+	app := setupTokenApp()
+	app.Post("/resource/:id/version", RequireAuthOrToken(ScopeVersionsWrite), PostNewVersion)
+
+	req := httptest.NewRequest("POST", "/resource/"+toString(resource.ID)+"/version", strings.NewReader("version=v1.1.0"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+plaintext)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "application/json")
+}
+
+func TestDeleteResource_WithToken(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "deletetokenuser")
+	resource := createTestPack(t, user.ID, "token-delete-pack")
+	plaintext, _ := createTestToken(t, user.ID, nil, ScopeResourcesDelete)
+
+	app := setupTokenApp()
+	app.Delete("/resource/:id", RequireAuthOrToken(ScopeResourcesDelete), DeleteResource)
+
+	req := httptest.NewRequest("DELETE", "/resource/"+toString(resource.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+plaintext)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var count int64
+	database.DB.Model(&models.NomadResource{}).Where("id = ?", resource.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestPostCreateToken(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "tokencreator")
+	app := setupAuthenticatedApp(user)
+	app.Post("/settings/tokens", PostCreateToken)
+
+	payload := strings.NewReader("name=deploy&owner=user&expires_in=30&scopes=resources:write&scopes=versions:write")
+	req := httptest.NewRequest("POST", "/settings/tokens", payload)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), apiTokenPrefix)
+
+	var token models.APIToken
+	require.NoError(t, database.DB.Where("user_id = ?", user.ID).First(&token).Error)
+	assert.Equal(t, "deploy", token.Name)
+	assert.Equal(t, "resources:write,versions:write", token.Scopes)
+	assert.NotNil(t, token.ExpiresAt)
+	assert.Len(t, token.TokenHash, 64)
+	assert.NotContains(t, string(body), token.TokenHash)
+}
+
+func TestPostCreateToken_InvalidScope(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "badscope")
+	app := setupAuthenticatedApp(user)
+	app.Post("/settings/tokens", PostCreateToken)
+
+	payload := strings.NewReader("name=deploy&scopes=admin:everything")
+	req := httptest.NewRequest("POST", "/settings/tokens", payload)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestPostRevokeToken(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "tokenrevoker")
+	_, token := createTestToken(t, user.ID, nil, ScopeResourcesWrite)
+
+	app := setupAuthenticatedApp(user)
+	app.Post("/settings/tokens/:id/revoke", PostRevokeToken)
+
+	req := httptest.NewRequest("POST", "/settings/tokens/"+toString(token.ID)+"/revoke", nil)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var updated models.APIToken
+	database.DB.First(&updated, token.ID)
+	assert.NotNil(t, updated.RevokedAt)
+}
+
+func TestPostRevokeToken_OtherUser(t *testing.T) {
+	defer cleanupTestData(t)
+
+	owner := createTestUser(t, "tokenowner")
+	other := createTestUser(t, "tokenthief")
+	_, token := createTestToken(t, owner.ID, nil, ScopeResourcesWrite)
+
+	app := setupAuthenticatedApp(other)
+	app.Post("/settings/tokens/:id/revoke", PostRevokeToken)
+
+	req := httptest.NewRequest("POST", "/settings/tokens/"+toString(token.ID)+"/revoke", nil)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestGetCurrentUserAPI_WithToken(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createTestUser(t, "whoamiuser")
+	plaintext, _ := createTestToken(t, user.ID, nil, ScopeResourcesWrite+","+ScopeVersionsWrite)
+
+	app := setupTokenApp()
+	app.Get("/v1/user", GetCurrentUserAPI)
+
+	req := httptest.NewRequest("GET", "/v1/user", nil)
+	req.Header.Set("Authorization", "Bearer "+plaintext)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	body, _ := io.ReadAll(resp.Body)
+	var result map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &result))
+	assert.Equal(t, "whoamiuser", result["username"])
+	assert.Len(t, result["scopes"], 2)
+}
+
+func TestGetCurrentUserAPI_Unauthenticated(t *testing.T) {
+	app := setupTokenApp()
+	app.Get("/v1/user", GetCurrentUserAPI)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/v1/user", nil))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 401, resp.StatusCode)
+}
+
+func TestPostNewResource_OwnerByOrgName(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	user := createTestUser(t, "orgnamepublisher")
+	org := models.Organization{Name: "testnamedorg"}
+	require.NoError(t, database.DB.Create(&org).Error)
+	database.DB.Create(&models.Membership{UserID: user.ID, OrganizationID: org.ID, Role: "member"})
+	plaintext, _ := createTestToken(t, user.ID, nil, ScopeResourcesWrite)
+
+	app := setupTokenApp()
+	app.Post("/new", RequireAuthOrToken(ScopeResourcesWrite), PostNewResource)
+
+	payload := strings.NewReader("name=org-pack&type=pack&owner=org:testnamedorg&version=v1.0.0")
+	req := httptest.NewRequest("POST", "/new", payload)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+plaintext)
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+
+	var resource models.NomadResource
+	require.NoError(t, database.DB.Where("name = ?", "org-pack").First(&resource).Error)
+	require.NotNil(t, resource.OrganizationID)
+	assert.Equal(t, org.ID, *resource.OrganizationID)
+}
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"rmbl/internal/totp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpIssuer        = "Ramble"
+	recoveryCodeCount = 10
+	pending2FATimeout = 5 * time.Minute // Between the password and the code
+
+	org2FAMessage = "This organization requires two-factor authentication. Turn it on under Settings → Security."
+)
+
+// has2FA reports whether a user has confirmed an authenticator app
+func has2FA(u models.User) bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// satisfies2FA reports whether a user meets a two-factor requirement.
+// Accounts without a password sign in through GitHub or GitLab, which handle
+// their own second factor.
+func satisfies2FA(u models.User) bool {
+	return has2FA(u) || u.PasswordHash == ""
+}
+
+// userSatisfies2FA is satisfies2FA by user ID
+func userSatisfies2FA(userID uint) bool {
+	var user models.User
+	if database.DB.First(&user, userID).Error != nil {
+		return false
+	}
+	return satisfies2FA(user)
+}
+
+// adminRequires2FA reports whether admins need two-factor authentication to
+// use the admin pages
+func adminRequires2FA() bool {
+	var setting models.SiteSetting
+	database.DB.Where("key = ?", models.SettingRequireAdmin2FA).Limit(1).Find(&setting)
+	return setting.Value == "true"
+}
+
+// org2FABlocks reports whether an organization's two-factor requirement keeps
+// the user from using their role in it
+func org2FABlocks(userID uint, org models.Organization) bool {
+	return org.Require2FA && !userSatisfies2FA(userID)
+}
+
+// hashRecoveryCode hashes a recovery code as typed, ignoring case, spaces
+// and dashes
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	return hashAPIToken(code)
+}
+
+// newRecoveryCodes replaces a user's recovery codes and returns the new ones,
+// as "xxxxx-xxxxx"
+func newRecoveryCodes(userID uint) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		rows = append(rows, models.RecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(code)})
+	}
+
+	database.DB.Where("user_id = ?", userID).Delete(&models.RecoveryCode{})
+	if err := database.DB.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// checkTOTP accepts a code from the user's authenticator app. A code is
+// accepted once, so one seen over someone's shoulder can't be replayed. The
+// step is consumed in the database, so of two requests racing with the same
+// code only one gets through.
+func checkTOTP(user *models.User, code string) bool {
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok || step <= user.TOTPLastStep {
+		return false
+	}
+	result := database.DB.Model(&models.User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil || result.RowsAffected != 1 {
+		return false
+	}
+	user.TOTPLastStep = step
+	return true
+}
+
+// checkSecondFactor accepts a code from the user's authenticator app or one
+// of their unused recovery codes, which is then used up. Like TOTP steps,
+// recovery codes are consumed by a conditional update.
+func checkSecondFactor(user *models.User, code string) bool {
+	if checkTOTP(user, code) {
+		return true
+	}
+	result := database.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// pending2FAUser returns the user who gave their password and still has to
+// give a code
+func pending2FAUser(c *fiber.Ctx) (models.User, bool) {
+	sess, err := Store.Get(c)
+	if err != nil {
+		return models.User{}, false
+	}
+	userID, _ := sess.Get("pending_2fa_user_id").(uint)
+	since, _ := sess.Get("pending_2fa_at").(int64)
+	if userID == 0 || time.Since(time.Unix(since, 0)) > pending2FATimeout {
+		return models.User{}, false
+	}
+	var user models.User
+	if database.DB.First(&user, userID).Error != nil || !has2FA(user) || user.SuspendedAt != nil {
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// beginSecondFactor remembers a user whose first factor checked out, for
+// the code step of the login
+func beginSecondFactor(c *fiber.Ctx, user models.User) error {
+	sess, err := Store.Get(c)
+	if err != nil {
+		return err
+	}
+	if err := sess.Regenerate(); err != nil {
+		return err
+	}
+	sess.Set("pending_2fa_user_id", user.ID)
+	sess.Set("pending_2fa_at", time.Now().Unix())
+	sess.Delete("pending_oidc_groups")
+	return sess.Save()
+}
+
+// GetLogin2FA asks for the second factor after a correct password
+func GetLogin2FA(c *fiber.Ctx) error {
+	if _, ok := pending2FAUser(c); !ok {
+		return c.Redirect("/login")
+	}
+	return c.Render("login_2fa", BaseContext(c), "layouts/main")
+}
+
+// PostLogin2FA checks the code from the authenticator app, or a recovery
+// code, and completes the login
+func PostLogin2FA(c *fiber.Ctx) error {
+	user, ok := pending2FAUser(c)
+	if !ok {
+		c.Set("HX-Redirect", "/login")
+		return c.Status(fiber.StatusUnauthorized).SendString("Your login has expired. Please sign in again.")
+	}
+	if !checkSecondFactor(&user, c.FormValue("code")) {
+		return c.Status(fiber.StatusUnauthorized).SendString("Invalid code")
+	}
+	return completeLogin(c, user)
+}
+
+// GetSecuritySettings shows two-factor authentication: the QR code to enrol
+// an authenticator app, or the state of an enrolled one
+func GetSecuritySettings(c *fiber.Ctx) error {
+	var user models.User
+	if err := database.DB.First(&user, currentUserID(c)).Error; err != nil {
+		return c.Redirect("/login")
+	}
+
+	ctx := fiber.Map{
+		"Enabled":       has2FA(user),
+		"PasswordLogin": user.PasswordHash != "",
+		"AdminRequired": user.IsAdmin && adminRequires2FA(),
+	}
+	var orgs []models.Organization
+	database.DB.Joins("JOIN memberships ON memberships.organization_id = organizations.id AND memberships.deleted_at IS NULL").
+		Where("memberships.user_id = ? AND organizations.require_2fa", user.ID).Order("organizations.name").Find(&orgs)
+	ctx["RequiringOrgs"] = orgs
+
+	if has2FA(user) {
+		var remaining int64
+		database.DB.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&remaining)
+		ctx["RecoveryCodesLeft"] = remaining
+	} else if user.PasswordHash != "" {
+		// The secret waits in the session until a code from the app confirms it
+		sess, err := Store.Get(c)
+		if err != nil {
+			return err
+		}
+		secret, _ := sess.Get("totp_setup_secret").(string)
+		if secret == "" {
+			if secret, err = totp.GenerateSecret(); err != nil {
+				return c.Status(500).SendString("Failed to generate secret")
+			}
+			sess.Set("totp_setup_secret", secret)
+			if err := sess.Save(); err != nil {
+				return c.Status(500).SendString("Failed to save session")
+			}
+		}
+		ctx["Secret"] = secret
+		ctx["ProvisioningURI"] = totp.URI(totpIssuer, user.Email, secret)
+	}
+
+	return c.Render("settings_security", MergeContext(BaseContext(c), ctx), "layouts/main")
+}
+
+// PostEnable2FA confirms enrolment with a code from the authenticator app and
+// shows the recovery codes, once
+func PostEnable2FA(c *fiber.Ctx) error {
+	var user models.User
+	if err := database.DB.First(&user, currentUserID(c)).Error; err != nil {
+		return c.Status(404).SendString("User not found")
+	}
+	if has2FA(user) {
+		return c.Status(400).SendString("Two-factor authentication is already on")
+	}
+	if user.PasswordHash == "" {
+		return c.Status(400).SendString("Accounts that sign in with GitHub or GitLab use that provider's two-factor authentication")
+	}
+
+	sess, err := Store.Get(c)
+	if err != nil {
+		return err
+	}
+	secret, _ := sess.Get("totp_setup_secret").(string)
+	step, ok := totp.Validate(secret, c.FormValue("code"), time.Now())
+	if secret == "" || !ok {
+		return c.Status(400).SendString("Invalid code. Check the time on your device and try again.")
+	}
+
+	now := time.Now()
+	user.TOTPSecret, user.TOTPEnabledAt, user.TOTPLastStep = secret, &now, step
+	database.DB.Model(&user).Select("totp_secret", "totp_enabled_at", "totp_last_step").Updates(&user)
+	codes, err := newRecoveryCodes(user.ID)
+	if err != nil {
+		return c.Status(500).SendString("Failed to create recovery codes")
+	}
+	sess.Delete("totp_setup_secret")
+	if err := sess.Save(); err != nil {
+		return c.Status(500).SendString("Failed to save session")
+	}
+	recordAudit(c, AuditUserEnable2FA, AuditTargetUser, user.ID, user.Username, map[string]AuditChange{"two_factor": {Before: false, After: true}})
+
+	return c.Render("partials/recovery_codes", fiber.Map{"Codes": codes})
+}
+
+// PostDisable2FA turns two-factor authentication off, given the password and
+// a current code. Users who are required to keep it on can't.
+func PostDisable2FA(c *fiber.Ctx) error {
+	var user models.User
+	if err := database.DB.First(&user, currentUserID(c)).Error; err != nil {
+		return c.Status(404).SendString("User not found")
+	}
+	if !has2FA(user) {
+		return c.Status(400).SendString("Two-factor authentication is not on")
+	}
+	if user.IsAdmin && adminRequires2FA() {
+		return c.Status(400).SendString("Admins are required to use two-factor authentication")
+	}
+	var org models.Organization
+	err := database.DB.Joins("JOIN memberships ON memberships.organization_id = organizations.id AND memberships.deleted_at IS NULL").
+		Where("memberships.user_id = ? AND organizations.require_2fa", user.ID).First(&org).Error
+	if err == nil {
+		return c.Status(400).SendString(org.Name + " requires its members to use two-factor authentication")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(c.FormValue("password"))) != nil {
+		return c.Status(400).SendString("Incorrect password")
+	}
+	if !checkSecondFactor(&user, c.FormValue("code")) {
+		return c.Status(400).SendString("Invalid code")
+	}
+
+	user.TOTPSecret, user.TOTPEnabledAt, user.TOTPLastStep = "", nil, 0
+	database.DB.Model(&user).Select("totp_secret", "totp_enabled_at", "totp_last_step").Updates(&user)
+	database.DB.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{})
+	recordAudit(c, AuditUserDisable2FA, AuditTargetUser, user.ID, user.Username, map[string]AuditChange{"two_factor": {Before: true, After: false}})
+
+	SetFlash(c, "success", "Two-factor authentication turned off.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
+
+// PostRegenerateRecoveryCodes replaces the recovery codes, given a current
+// code from the authenticator app
+func PostRegenerateRecoveryCodes(c *fiber.Ctx) error {
+	var user models.User
+	if err := database.DB.First(&user, currentUserID(c)).Error; err != nil {
+		return c.Status(404).SendString("User not found")
+	}
+	if !has2FA(user) {
+		return c.Status(400).SendString("Two-factor authentication is not on")
+	}
+	if !checkTOTP(&user, c.FormValue("code")) {
+		return c.Status(400).SendString("Invalid code")
+	}
+
+	codes, err := newRecoveryCodes(user.ID)
+	if err != nil {
+		return c.Status(500).SendString("Failed to create recovery codes")
+	}
+	return c.Render("partials/recovery_codes", fiber.Map{"Codes": codes})
+}
+
+// PostAdminRequire2FA turns the two-factor requirement for admins on or off.
+// An admin without two-factor authentication can't turn it on, so they can't
+// lock themselves out.
+func PostAdminRequire2FA(c *fiber.Ctx) error {
+	require := c.FormValue("require_2fa") == "on"
+	if require && !satisfies2FA(c.Locals("User").(models.User)) {
+		return c.Status(400).SendString("Turn on two-factor authentication for your own account first")
+	}
+
+	before := adminRequires2FA()
+	value := "false"
+	if require {
+		value = "true"
+	}
+	database.DB.Save(&models.SiteSetting{Key: models.SettingRequireAdmin2FA, Value: value})
+	if before != require {
+		recordAudit(c, AuditSiteEditSettings, AuditTargetSite, 0, "site", map[string]AuditChange{
+			models.SettingRequireAdmin2FA: {Before: before, After: require},
+		})
+	}
+
+	SetFlash(c, "success", "Security settings updated.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"rmbl/internal/totp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// createPasswordUser creates a user who logs in with the password
+// "CorrectPass123!"
+func createPasswordUser(t *testing.T, username string) models.User {
+	user := createTestUser(t, username)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("CorrectPass123!"), bcrypt.MinCost)
+	user.PasswordHash = string(hash)
+	require.NoError(t, database.DB.Model(&user).Update("password_hash", user.PasswordHash).Error)
+	return user
+}
+
+// enable2FA turns on two-factor authentication for a user and returns the
+// secret
+func enable2FA(t *testing.T, user *models.User) string {
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	now := time.Now()
+	user.TOTPSecret, user.TOTPEnabledAt = secret, &now
+	require.NoError(t, database.DB.Model(user).Select("totp_secret", "totp_enabled_at").Updates(user).Error)
+	return secret
+}
+
+// postWithCookie posts a form with the session cookie, if any, and returns
+// the response and the session cookie to send next
+func postWithCookie(t *testing.T, app *fiber.App, path, form, cookie string) (*http.Response, string) {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != "" {
+		req.Header.Set("Cookie", cookie)
+	}
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	if set := resp.Header.Get("Set-Cookie"); set != "" {
+		cookie = strings.Split(set, ";")[0]
+	}
+	return resp, cookie
+}
+
+func TestCheckSecondFactor_UsedOnce(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createPasswordUser(t, "onceuser")
+	secret := enable2FA(t, &user)
+	code, _ := totp.CodeAt(secret, totp.Step(time.Now()))
+
+	// A second request loaded the user before the first consumed the code
+	stale := user
+	assert.True(t, checkSecondFactor(&user, code))
+	assert.False(t, checkSecondFactor(&stale, code))
+
+	codes, err := newRecoveryCodes(user.ID)
+	require.NoError(t, err)
+	assert.True(t, checkSecondFactor(&stale, codes[0]))
+	assert.False(t, checkSecondFactor(&user, codes[0]))
+}
+
+func TestPostLogin_TwoFactor(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createPasswordUser(t, "twofactor")
+	secret := enable2FA(t, &user)
+	codes, err := newRecoveryCodes(user.ID)
+	require.NoError(t, err)
+
+	app := setupTestApp()
+	app.Post("/login", PostLogin)
+	app.Post("/login/2fa", PostLogin2FA)
+	login := func() string {
+		resp, cookie := postWithCookie(t, app, "/login", "email=twofactor@test.com&password=CorrectPass123!", "")
+		require.Equal(t, 200, resp.StatusCode)
+		require.Equal(t, "/login/2fa", resp.Header.Get("HX-Redirect"))
+		return cookie
+	}
+
+	// The password alone doesn't log in
+	cookie := login()
+	resp, _ := postWithCookie(t, app, "/login/2fa", "code=abcdef", cookie)
+	assert.Equal(t, 401, resp.StatusCode)
+	resp, _ = postWithCookie(t, app, "/login/2fa", "code=123456", "")
+	assert.Equal(t, 401, resp.StatusCode)
+
+	code, _ := totp.CodeAt(secret, totp.Step(time.Now()))
+	resp, _ = postWithCookie(t, app, "/login/2fa", "code="+code, cookie)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "/", resp.Header.Get("HX-Redirect"))
+
+	// A code works once
+	resp, _ = postWithCookie(t, app, "/login/2fa", "code="+code, login())
+	assert.Equal(t, 401, resp.StatusCode)
+
+	// So does a recovery code, typed in any case
+	resp, _ = postWithCookie(t, app, "/login/2fa", "code="+strings.ToUpper(codes[0]), login())
+	assert.Equal(t, 200, resp.StatusCode)
+	resp, _ = postWithCookie(t, app, "/login/2fa", "code="+codes[0], login())
+	assert.Equal(t, 401, resp.StatusCode)
+}
+
+func TestPostEnable2FA(t *testing.T) {
+	defer cleanupTestData(t)
+
+	user := createPasswordUser(t, "enroller")
+	app := setupAuthenticatedApp(user)
+	app.Get("/settings/security", GetSecuritySettings)
+	app.Post("/settings/security/2fa/enable", PostEnable2FA)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/settings/security", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	cookie := strings.Split(resp.Header.Get("Set-Cookie"), ";")[0]
+	body, _ := io.ReadAll(resp.Body)
+	match := regexp.MustCompile(`secret=([A-Z2-7]+)`).FindStringSubmatch(string(body))
+	require.Len(t, match, 2)
+
+	resp, _ = postWithCookie(t, app, "/settings/security/2fa/enable", "code=abcdef", cookie)
+	assert.Equal(t, 400, resp.StatusCode)
+
+	code, _ := totp.CodeAt(match[1], totp.Step(time.Now()))
+	resp, _ = postWithCookie(t, app, "/settings/security/2fa/enable", "code="+code, cookie)
+	require.Equal(t, 200, resp.StatusCode)
+	body, _ = io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "Save your recovery codes")
+
+	require.NoError(t, database.DB.First(&user, user.ID).Error)
+	assert.NotNil(t, user.TOTPEnabledAt)
+	assert.Equal(t, match[1], user.TOTPSecret)
+	var count int64
+	database.DB.Model(&models.RecoveryCode{}).Where("user_id = ?", user.ID).Count(&count)
+	assert.Equal(t, int64(recoveryCodeCount), count)
+
+	event, _ := lastAuditEvent(t, AuditUserEnable2FA)
+	assert.Equal(t, "enroller", event.TargetName)
+}
+
+func TestRequire2FA(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	admin := createPasswordUser(t, "tfaadmin")
+	database.DB.Model(&admin).Update("is_admin", true)
+	admin.IsAdmin = true
+	app := setupAuthenticatedApp(admin)
+	app.Post("/admin/settings/2fa", RequireAdmin, PostAdminRequire2FA)
+
+	// Admins can't require what they don't have themselves
+	assert.Equal(t, 400, postForm(t, app, "/admin/settings/2fa", "require_2fa=on"))
+	enable2FA(t, &admin)
+	app = setupAuthenticatedApp(admin)
+	app.Post("/admin/settings/2fa", RequireAdmin, PostAdminRequire2FA)
+	assert.Equal(t, 200, postForm(t, app, "/admin/settings/2fa", "require_2fa=on"))
+	assert.True(t, adminRequires2FA())
+
+	other := createPasswordUser(t, "tfaadmin2")
+	database.DB.Model(&other).Update("is_admin", true)
+	other.IsAdmin = true
+	otherApp := setupAuthenticatedApp(other)
+	otherApp.Get("/admin", RequireAdmin, func(c *fiber.Ctx) error { return c.SendString("ok") })
+	resp, err := otherApp.Test(httptest.NewRequest("GET", "/admin", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 302, resp.StatusCode)
+	assert.Equal(t, "/settings/security", resp.Header.Get("Location"))
+
+	// Organization members lose their role's permissions until they turn it on
+	member := createPasswordUser(t, "tfamember")
+	org, pack := createTestOrgResource(t, "testtfaorg", models.Membership{UserID: member.ID, Role: models.RoleMaintainer})
+	memberApp := setupAuthenticatedApp(member)
+	memberApp.Get("/orgs/:orgname/edit", RequireOrgPermission(PermEdit), func(c *fiber.Ctx) error { return c.SendString("ok") })
+	assert.True(t, hasPermission(member.ID, pack, PermEdit))
+	status, _ := getStatus(t, memberApp, "/orgs/testtfaorg/edit", "")
+	assert.Equal(t, 200, status)
+
+	database.DB.Model(&org).Update("require_2fa", true)
+	assert.False(t, hasPermission(member.ID, pack, PermEdit))
+	status, _ = getStatus(t, memberApp, "/orgs/testtfaorg/edit", "")
+	assert.Equal(t, 403, status)
+
+	enable2FA(t, &member)
+	assert.True(t, hasPermission(member.ID, pack, PermEdit))
+}
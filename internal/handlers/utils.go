@@ -2,6 +2,12 @@ package handlers
 
 import (
 	"fmt"
+	"net/url"
+	"regexp"
+	"rmbl/internal/gitprovider"
+	"rmbl/internal/models"
+	"rmbl/internal/semver"
+	"sort"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -80,216 +86,98 @@ func parsePackVariables(content string) ([]PackVariable, error) {
 	return config.Variables, nil
 }
 
-func downloadFile(repoURL string, fileName string) (string, error) {
+// downloadFile fetches a file from the default branch of a repository using
+// the given git provider kind ("" to detect it from the URL)
+func downloadFile(kind, repoURL, fileName string) (string, error) {
 	if repoURL == "" || fileName == "" {
 		return "", nil
 	}
 
-	repoURL = strings.TrimSuffix(repoURL, ".git")
-	repoURL = strings.TrimSuffix(repoURL, "/")
-
-	// GitHub Logic
-	if strings.Contains(repoURL, "github.com") {
-		baseURL := strings.Replace(repoURL, "github.com", "raw.githubusercontent.com", 1)
-		for _, branch := range []string{"main", "master"} {
-			tempURL := fmt.Sprintf("%s/%s/%s", baseURL, branch, fileName)
-			agent := fiber.Get(tempURL)
-			statusCode, body, errs := agent.Bytes()
-			if len(errs) == 0 && statusCode == 200 {
-				return string(body), nil
-			}
-		}
+	provider, err := gitprovider.New(kind, repoURL, "")
+	if err != nil {
+		return "", err
 	}
-
-	// GitLab Logic
-	if strings.Contains(repoURL, "gitlab.com") {
-		// Extract path: https://gitlab.com/owner/repo -> owner/repo
-		parts := strings.Split(repoURL, "gitlab.com/")
-		if len(parts) < 2 {
-			return "", fmt.Errorf("invalid gitlab url")
-		}
-		projectPath := strings.ReplaceAll(parts[1], "/", "%2F")
-		fileNameEncoded := strings.ReplaceAll(fileName, "/", "%2F")
-
-		for _, branch := range []string{"main", "master"} {
-			tempURL := fmt.Sprintf("https://gitlab.com/api/v4/projects/%s/repository/files/%s/raw?ref=%s", projectPath, fileNameEncoded, branch)
-			agent := fiber.Get(tempURL)
-			statusCode, body, errs := agent.Bytes()
-			if len(errs) == 0 && statusCode == 200 {
-				return string(body), nil
-			}
-		}
+	body, err := provider.FetchFile("", fileName)
+	if err != nil {
+		return "", err
 	}
-
-	return "", fmt.Errorf("unsupported repository host or file not found")
+	return string(body), nil
 }
 
-type GitLabProject struct {
-	Name        string   `json:"name"`
-	Description string   `json:"description"`
-	TagList     []string `json:"tag_list"`
-}
+var refRegex = regexp.MustCompile(`^[A-Za-z0-9._/+@-]*$`)
 
-type GitHubRepo struct {
-	Name        string   `json:"name"`
-	Description string   `json:"description"`
-	Topics      []string `json:"topics"`
-	License     struct {
-		SpdxID string `json:"spdx_id"`
-	} `json:"license"`
+// isValidRef reports whether ref can be used as a git branch, tag or commit
+// name. An empty ref is valid and means the default branch.
+func isValidRef(ref string) bool {
+	return !strings.HasPrefix(ref, "-") && !strings.Contains(ref, "..") && refRegex.MatchString(ref)
 }
 
-func fetchGitHubMetadata(repoURL string, token string) (*GitHubRepo, error) {
-	// Extract owner/repo from https://github.com/owner/repo
-	repoURL = strings.TrimSuffix(repoURL, ".git")
-	parts := strings.Split(repoURL, "github.com/")
-	if len(parts) < 2 {
-		return nil, fmt.Errorf("invalid github url")
-	}
-	apiURL := fmt.Sprintf("https://api.github.com/repos/%s", parts[1])
-
-	agent := fiber.Get(apiURL)
-	agent.Set("User-Agent", "RMBL-Registry")
-	if token != "" {
-		agent.Set("Authorization", "token "+token)
-	}
-
-	var repo GitHubRepo
-	statusCode, _, errs := agent.Struct(&repo)
-	if len(errs) > 0 || statusCode != 200 {
-		return nil, fmt.Errorf("failed to fetch github metadata")
-	}
-	return &repo, nil
+// sortVersions orders versions by semantic version, highest first. Names that
+// are not semantic versions, such as a branch-tracking "edge", follow in the
+// order they were given.
+func sortVersions(versions []models.ResourceVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return semver.Less(versions[j].Version, versions[i].Version)
+	})
 }
 
-func fetchGitHubLatestTag(repoURL string, token string) (string, error) {
-	repoURL = strings.TrimSuffix(repoURL, ".git")
-	parts := strings.Split(repoURL, "github.com/")
-	if len(parts) < 2 {
-		return "", fmt.Errorf("invalid github url")
-	}
-	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/tags", parts[1])
-
-	agent := fiber.Get(apiURL)
-	agent.Set("User-Agent", "RMBL-Registry")
-	if token != "" {
-		agent.Set("Authorization", "token "+token)
+// latestVersion returns the version "latest" refers to: the highest release,
+// ignoring pre-releases and yanked versions. A resource without releases falls
+// back to the first version in sortVersions order.
+func latestVersion(versions []models.ResourceVersion) (models.ResourceVersion, bool) {
+	sorted := visibleVersions(versions)
+	if len(sorted) == 0 {
+		return models.ResourceVersion{}, false
 	}
-
-	type GitHubTag struct {
-		Name string `json:"name"`
-	}
-	var tags []GitHubTag
-	statusCode, _, errs := agent.Struct(&tags)
-	if len(errs) > 0 || statusCode != 200 || len(tags) == 0 {
-		return "", fmt.Errorf("no tags found")
-	}
-	return tags[0].Name, nil
-}
-
-func fetchGitHubJobFile(repoURL string, token string) (string, error) {
-	repoURL = strings.TrimSuffix(repoURL, ".git")
-	parts := strings.Split(repoURL, "github.com/")
-	if len(parts) < 2 {
-		return "", fmt.Errorf("invalid github url")
+	sortVersions(sorted)
+	names := make([]string, len(sorted))
+	for i, v := range sorted {
+		names[i] = v.Version
 	}
-	// We'll check the default branch tree
-	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/contents", parts[1])
-
-	agent := fiber.Get(apiURL)
-	agent.Set("User-Agent", "RMBL-Registry")
-	if token != "" {
-		agent.Set("Authorization", "token "+token)
-	}
-
-	type GitHubContent struct {
-		Name string `json:"name"`
-		Type string `json:"type"`
-	}
-	var contents []GitHubContent
-	statusCode, _, errs := agent.Struct(&contents)
-	if len(errs) > 0 || statusCode != 200 {
-		return "", fmt.Errorf("failed to fetch contents")
-	}
-
-	for _, item := range contents {
-		if item.Type == "file" && (strings.HasSuffix(item.Name, ".nomad.hcl") || strings.HasSuffix(item.Name, ".nomad")) {
-			return item.Name, nil
+	if name := semver.Latest(names); name != "" {
+		for _, v := range sorted {
+			if v.Version == name {
+				return v, true
+			}
 		}
 	}
-	return "", fmt.Errorf("no nomad file found")
+	return sorted[0], true
 }
 
-func fetchGitLabMetadata(repoURL string, token string) (*GitLabProject, error) {
-	parts := strings.Split(repoURL, "gitlab.com/")
-	if len(parts) < 2 {
-		return nil, fmt.Errorf("invalid gitlab url")
-	}
-	projectPath := strings.ReplaceAll(strings.TrimSuffix(parts[1], ".git"), "/", "%2F")
-	apiURL := fmt.Sprintf("https://gitlab.com/api/v4/projects/%s", projectPath)
-
-	agent := fiber.Get(apiURL)
-	if token != "" {
-		agent.Set("Authorization", "Bearer "+token)
-	}
-
-	var project GitLabProject
-	statusCode, _, errs := agent.Struct(&project)
-	if len(errs) > 0 || statusCode != 200 {
-		return nil, fmt.Errorf("failed to fetch gitlab metadata")
+// visibleVersions returns a copy of versions without the yanked ones, for
+// listings. Yanked versions can still be fetched by name.
+func visibleVersions(versions []models.ResourceVersion) []models.ResourceVersion {
+	visible := make([]models.ResourceVersion, 0, len(versions))
+	for _, v := range versions {
+		if !v.Yanked {
+			visible = append(visible, v)
+		}
 	}
-	return &project, nil
+	return visible
 }
 
-func fetchGitLabLatestTag(repoURL string, token string) (string, error) {
-	parts := strings.Split(repoURL, "gitlab.com/")
-	if len(parts) < 2 {
-		return "", fmt.Errorf("invalid gitlab url")
-	}
-	projectPath := strings.ReplaceAll(strings.TrimSuffix(parts[1], ".git"), "/", "%2F")
-	apiURL := fmt.Sprintf("https://gitlab.com/api/v4/projects/%s/repository/tags", projectPath)
-
-	agent := fiber.Get(apiURL)
-	if token != "" {
-		agent.Set("Authorization", "Bearer "+token)
+// oauthTokenFor returns the user's OAuth access token when it was issued by
+// the host of repoURL, so it is never sent to any other server
+func oauthTokenFor(user models.User, repoURL string) string {
+	u, err := url.Parse(repoURL)
+	if err != nil || user.AccessToken == "" {
+		return ""
 	}
-
-	type GitLabTag struct {
-		Name string `json:"name"`
-	}
-	var tags []GitLabTag
-	statusCode, _, errs := agent.Struct(&tags)
-	if len(errs) > 0 || statusCode != 200 || len(tags) == 0 {
-		return "", fmt.Errorf("no tags found")
+	host := strings.ToLower(u.Hostname())
+	if (user.Provider == "github" && host == "github.com") || (user.Provider == "gitlab" && host == "gitlab.com") {
+		return user.AccessToken
 	}
-	return tags[0].Name, nil
+	return ""
 }
 
-func fetchGitLabJobFile(repoURL string, token string) (string, error) {
-	parts := strings.Split(repoURL, "gitlab.com/")
-	if len(parts) < 2 {
-		return "", fmt.Errorf("invalid gitlab url")
-	}
-	projectPath := strings.ReplaceAll(strings.TrimSuffix(parts[1], ".git"), "/", "%2F")
-	apiURL := fmt.Sprintf("https://gitlab.com/api/v4/projects/%s/repository/tree", projectPath)
-
-	agent := fiber.Get(apiURL)
-	if token != "" {
-		agent.Set("Authorization", "Bearer "+token)
-	}
-
-	type GitLabTreeItem struct {
-		Name string `json:"name"`
-		Type string `json:"type"`
-	}
-	var tree []GitLabTreeItem
-	statusCode, _, errs := agent.Struct(&tree)
-	if len(errs) > 0 || statusCode != 200 {
-		return "", fmt.Errorf("failed to fetch tree")
+// findJobFile returns the first Nomad job file in the root of a repository
+func findJobFile(provider gitprovider.Provider) (string, error) {
+	entries, err := provider.ListTree("", "")
+	if err != nil {
+		return "", err
 	}
-
-	for _, item := range tree {
-		if item.Type == "blob" && (strings.HasSuffix(item.Name, ".nomad.hcl") || strings.HasSuffix(item.Name, ".nomad")) {
+	for _, item := range entries {
+		if !item.Dir && (strings.HasSuffix(item.Name, ".nomad.hcl") || strings.HasSuffix(item.Name, ".nomad")) {
 			return item.Name, nil
 		}
 	}
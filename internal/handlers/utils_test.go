@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"rmbl/internal/models"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -143,3 +144,85 @@ variable "simple" {
 	}
 }
 
+
+func TestOauthTokenFor(t *testing.T) {
+	github := models.User{Provider: "github", AccessToken: "gho_secret"}
+	gitlab := models.User{Provider: "gitlab", AccessToken: "glpat_secret"}
+
+	assert.Equal(t, "gho_secret", oauthTokenFor(github, "https://github.com/owner/repo"))
+	assert.Equal(t, "glpat_secret", oauthTokenFor(gitlab, "https://gitlab.com/group/repo"))
+
+	// Tokens never leave the host that issued them
+	assert.Empty(t, oauthTokenFor(github, "https://gitlab.com/group/repo"))
+	assert.Empty(t, oauthTokenFor(gitlab, "https://gitlab.example.com/group/repo"))
+	assert.Empty(t, oauthTokenFor(github, "https://github.com.evil.example/owner/repo"))
+	assert.Empty(t, oauthTokenFor(models.User{Provider: "github"}, "https://github.com/owner/repo"))
+}
+
+func TestIsValidRef(t *testing.T) {
+	for _, ref := range []string{"", "main", "v1.0.0", "feature/packs", "release-1.x", "9fceb02d0ae598e95dc970b74767f19372d61af8"} {
+		assert.True(t, isValidRef(ref), ref)
+	}
+	for _, ref := range []string{"-main", "--upload-pack=x", "main..dev", "has space", "HEAD~1", "a:b"} {
+		assert.False(t, isValidRef(ref), ref)
+	}
+}
+
+func TestVersionRef(t *testing.T) {
+	resource := models.NomadResource{DefaultBranch: "develop"}
+
+	tagged := models.ResourceVersion{Version: "v1.0.0"}
+	assert.Equal(t, "v1.0.0", versionRef(resource, tagged))
+	assert.Equal(t, "v1.0.0", sourceRef(resource, tagged))
+
+	tagged.CommitSHA = "9fceb02d0ae598e95dc970b74767f19372d61af8"
+	assert.Equal(t, "v1.0.0", versionRef(resource, tagged))
+	assert.Equal(t, tagged.CommitSHA, sourceRef(resource, tagged))
+
+	explicit := models.ResourceVersion{Version: "1.0", Ref: "release-1.0"}
+	assert.Equal(t, "release-1.0", versionRef(resource, explicit))
+
+	branch := models.ResourceVersion{Version: "edge", TrackBranch: true}
+	assert.Equal(t, "develop", versionRef(resource, branch))
+	assert.Empty(t, versionRef(models.NomadResource{}, branch))
+}
+
+func TestSortVersions(t *testing.T) {
+	// As loaded from the database, newest first
+	versions := []models.ResourceVersion{
+		{Version: "v1.2.9"},
+		{Version: "edge"},
+		{Version: "v3.0.0-rc.1"},
+		{Version: "v2.0.0"},
+		{Version: "v1.10.0"},
+	}
+	sortVersions(versions)
+
+	var names []string
+	for _, v := range versions {
+		names = append(names, v.Version)
+	}
+	assert.Equal(t, []string{"v3.0.0-rc.1", "v2.0.0", "v1.10.0", "v1.2.9", "edge"}, names)
+}
+
+func TestLatestVersion(t *testing.T) {
+	latest, ok := latestVersion([]models.ResourceVersion{{Version: "v1.2.9"}, {Version: "v3.0.0-rc.1"}, {Version: "v2.0.0"}})
+	assert.True(t, ok)
+	assert.Equal(t, "v2.0.0", latest.Version)
+
+	// Without releases the highest pre-release wins over other names
+	latest, _ = latestVersion([]models.ResourceVersion{{Version: "edge"}, {Version: "v1.0.0-beta.1"}})
+	assert.Equal(t, "v1.0.0-beta.1", latest.Version)
+
+	_, ok = latestVersion(nil)
+	assert.False(t, ok)
+}
+
+func TestLatestVersion_SkipsYanked(t *testing.T) {
+	latest, ok := latestVersion([]models.ResourceVersion{{Version: "v2.0.0", Yanked: true}, {Version: "v1.9.0"}})
+	assert.True(t, ok)
+	assert.Equal(t, "v1.9.0", latest.Version)
+
+	_, ok = latestVersion([]models.ResourceVersion{{Version: "v2.0.0", Yanked: true}})
+	assert.False(t, ok)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// isAdminRequest reports whether the request was made by a site admin
+func isAdminRequest(c *fiber.Ctx) bool {
+	user, ok := c.Locals("User").(models.User)
+	return ok && user.IsAdmin
+}
+
+// privateOrgIDs returns the organizations whose private resources the
+// user's role lets them see
+func privateOrgIDs(userID uint) []uint {
+	var memberships []models.Membership
+	database.DB.Preload("Organization").Where("user_id = ?", userID).Find(&memberships)
+
+	ids := []uint{}
+	for _, m := range memberships {
+		if org2FABlocks(userID, m.Organization) {
+			continue
+		}
+		if rolePermissions(m.Organization)[membershipRole(m)][PermViewPrivate] {
+			ids = append(ids, m.OrganizationID)
+		}
+	}
+	return ids
+}
+
+// visibleTo limits a NomadResource query to what the request may see: public
+// resources for everyone, internal ones for any logged-in user, and private
+// ones for the members of their namespace. Organization tokens only see the
+// private resources of their own organization, and only admins see resources
+// hidden by a moderator. Use it with Scopes; the memberships are looked up
+// once, so one scope can be reused across queries.
+func visibleTo(c *fiber.Ctx) func(*gorm.DB) *gorm.DB {
+	userID := currentUserID(c)
+	if userID == 0 {
+		return func(db *gorm.DB) *gorm.DB {
+			return db.Where("nomad_resources.visibility = ? AND nomad_resources.hidden_at IS NULL", models.VisibilityPublic)
+		}
+	}
+	if isAdminRequest(c) {
+		return func(db *gorm.DB) *gorm.DB { return db }
+	}
+
+	orgIDs := privateOrgIDs(userID)
+	personal := userID
+	if token, ok := apiTokenFromCtx(c); ok && token.OrganizationID != nil {
+		personal = 0
+		allowed := []uint{}
+		for _, id := range orgIDs {
+			if id == *token.OrganizationID {
+				allowed = append(allowed, id)
+			}
+		}
+		orgIDs = allowed
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(`(nomad_resources.visibility <> ?
+			OR (nomad_resources.organization_id IS NULL AND nomad_resources.user_id = ?)
+			OR nomad_resources.organization_id IN ?)`, models.VisibilityPrivate, personal, orgIDs).
+			Where("nomad_resources.hidden_at IS NULL")
+	}
+}
+
+// canViewResource reports whether the request may see a resource, with the
+// same rules as visibleTo. Handlers answer 404 when it can't, so private
+// resources don't give away that they exist.
+func canViewResource(c *fiber.Ctx, resource models.NomadResource) bool {
+	if resource.HiddenAt != nil {
+		return isAdminRequest(c)
+	}
+	switch resource.Visibility {
+	case models.VisibilityPublic, "":
+		return true
+	case models.VisibilityInternal:
+		return currentUserID(c) != 0
+	}
+	userID := currentUserID(c)
+	if userID == 0 {
+		return false
+	}
+	if isAdminRequest(c) {
+		return true
+	}
+	return tokenAllowsNamespace(c, resource.OrganizationID) && hasPermission(userID, resource, PermViewPrivate)
+}
+
+// sourceToken returns the credentials ingestion fetches a resource's
+// repository with. Repositories of internal and private resources are
+// usually private too, so those are fetched with the OAuth token of the user
+// who set the repository, when it was issued by the repository's host. The
+// token is bound to that repository: pointing the resource elsewhere mustn't
+// let an editor read another repository with someone else's token.
+func sourceToken(resource models.NomadResource) string {
+	if resource.Visibility == models.VisibilityPublic || resource.Visibility == "" {
+		return ""
+	}
+	if resource.SourceUserID == nil || resource.SourceRepositoryURL != resource.RepositoryURL {
+		return ""
+	}
+	var user models.User
+	if err := database.DB.First(&user, *resource.SourceUserID).Error; err != nil {
+		return ""
+	}
+	return oauthTokenFor(user, resource.RepositoryURL)
+}
+
+// bindSourceToken binds the repository of a resource to the OAuth token of
+// the user who set it, or to none if they have no token for its host
+func bindSourceToken(resource *models.NomadResource, userID uint) {
+	var user models.User
+	if database.DB.First(&user, userID).Error == nil && oauthTokenFor(user, resource.RepositoryURL) != "" {
+		resource.SourceUserID, resource.SourceRepositoryURL = &userID, resource.RepositoryURL
+		return
+	}
+	resource.SourceUserID, resource.SourceRepositoryURL = nil, ""
+}
+
+// isValidVisibility reports whether v is one of the resource visibilities
+func isValidVisibility(v string) bool {
+	for _, known := range models.Visibilities {
+		if v == known {
+			return true
+		}
+	}
+	return false
+}
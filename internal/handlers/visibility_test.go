@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createHiddenPack creates a pack with the given visibility whose version has
+// raw content to download
+func createHiddenPack(t *testing.T, userID uint, name, visibility string) models.NomadResource {
+	pack := createTestPack(t, userID, name)
+	database.DB.Model(&pack).Update("visibility", visibility)
+	database.DB.Model(&models.ResourceVersion{}).Where("resource_id = ?", pack.ID).Update("content", `job "`+name+`" {}`)
+	pack.Visibility = visibility
+	return pack
+}
+
+// visibilityRoutes registers the read endpoints the visibility tests hit
+func visibilityRoutes(app *fiber.App) *fiber.App {
+	app.Get("/sitemap.xml", GenerateSitemap)
+	app.Get("/v1/packs", ListAllPacksAPI)
+	app.Get("/v1/search", SearchAPI)
+	app.Get("/:username/v1/packs/:packname", GetPackAPI)
+	app.Get("/:username/:resourcename/v/:version/raw", GetRawResourceVersion)
+	return app
+}
+
+// getStatus requests path and returns the status code and body
+func getStatus(t *testing.T, app *fiber.App, path, token string) (int, string) {
+	req := httptest.NewRequest("GET", path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestVisibility_RawAndAPI(t *testing.T) {
+	defer cleanupTestData(t)
+
+	owner := createTestUser(t, "visowner")
+	other := createTestUser(t, "visother")
+	createHiddenPack(t, owner.ID, "vis-internal", models.VisibilityInternal)
+	createHiddenPack(t, owner.ID, "vis-private", models.VisibilityPrivate)
+
+	anonymous := visibilityRoutes(setupTestApp())
+	loggedIn := visibilityRoutes(setupAuthenticatedApp(other))
+	ownerApp := visibilityRoutes(setupAuthenticatedApp(owner))
+
+	for _, path := range []string{"/visowner/vis-internal/v/v1.0.0/raw", "/visowner/v1/packs/vis-internal"} {
+		status, _ := getStatus(t, anonymous, path, "")
+		assert.Equal(t, 404, status, path)
+		status, _ = getStatus(t, loggedIn, path, "")
+		assert.Equal(t, 200, status, path)
+	}
+	for _, path := range []string{"/visowner/vis-private/v/v1.0.0/raw", "/visowner/v1/packs/vis-private"} {
+		status, _ := getStatus(t, anonymous, path, "")
+		assert.Equal(t, 404, status, path)
+		status, _ = getStatus(t, loggedIn, path, "")
+		assert.Equal(t, 404, status, path)
+		status, _ = getStatus(t, ownerApp, path, "")
+		assert.Equal(t, 200, status, path)
+	}
+}
+
+func TestVisibility_OrgMembersAndTokens(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	owner := createTestUser(t, "visorgowner")
+	viewer := createTestUser(t, "visorgviewer")
+	outsider := createTestUser(t, "visorgoutsider")
+	org, pack := createTestOrgResource(t, "testvisorg",
+		models.Membership{UserID: owner.ID, Role: models.RoleOwner},
+		models.Membership{UserID: viewer.ID, Role: models.RoleViewer},
+	)
+	database.DB.Model(&pack).Update("visibility", models.VisibilityPrivate)
+	createHiddenPack(t, owner.ID, "vis-personal", models.VisibilityPrivate)
+	orgPath := "/testvisorg/v1/packs/" + pack.Name
+
+	status, _ := getStatus(t, visibilityRoutes(setupAuthenticatedApp(viewer)), orgPath, "")
+	assert.Equal(t, 200, status, "members see private resources")
+	status, _ = getStatus(t, visibilityRoutes(setupAuthenticatedApp(outsider)), orgPath, "")
+	assert.Equal(t, 404, status)
+
+	// An organization token sees its organization's private resources, not
+	// the private resources of the user who created it
+	orgToken, _ := createTestToken(t, owner.ID, &org.ID, "")
+	tokenApp := visibilityRoutes(setupTokenApp())
+	status, _ = getStatus(t, tokenApp, orgPath, orgToken)
+	assert.Equal(t, 200, status)
+	status, _ = getStatus(t, tokenApp, "/visorgowner/v1/packs/vis-personal", orgToken)
+	assert.Equal(t, 404, status)
+
+	userToken, _ := createTestToken(t, owner.ID, nil, "")
+	status, _ = getStatus(t, tokenApp, "/visorgowner/v1/packs/vis-personal", userToken)
+	assert.Equal(t, 200, status)
+}
+
+func TestVisibility_ListsSearchAndSitemap(t *testing.T) {
+	defer cleanupTestData(t)
+
+	owner := createTestUser(t, "vislistowner")
+	createTestPack(t, owner.ID, "vis-list-public")
+	createHiddenPack(t, owner.ID, "vis-list-internal", models.VisibilityInternal)
+	createHiddenPack(t, owner.ID, "vis-list-private", models.VisibilityPrivate)
+
+	app := visibilityRoutes(setupTestApp())
+
+	_, body := getStatus(t, app, "/v1/packs", "")
+	assert.Contains(t, body, "vis-list-public")
+	assert.NotContains(t, body, "vis-list-internal")
+	assert.NotContains(t, body, "vis-list-private")
+
+	_, body = getStatus(t, app, "/sitemap.xml", "")
+	assert.Contains(t, body, "/vislistowner/vis-list-public")
+	assert.NotContains(t, body, "vis-list-internal")
+	assert.NotContains(t, body, "vis-list-private")
+
+	_, body = getStatus(t, app, "/v1/search?namespace=vislistowner", "")
+	var page SearchResponse
+	require.NoError(t, json.NewDecoder(strings.NewReader(body)).Decode(&page))
+	assert.Equal(t, int64(1), page.Total)
+	require.Len(t, page.Results, 1)
+	assert.Equal(t, "vis-list-public", page.Results[0].Name)
+
+	// Logged-in users also find internal resources, but not others' private ones
+	other := createTestUser(t, "vislistother")
+	_, body = getStatus(t, visibilityRoutes(setupAuthenticatedApp(other)), "/v1/search?namespace=vislistowner", "")
+	page = SearchResponse{}
+	require.NoError(t, json.NewDecoder(strings.NewReader(body)).Decode(&page))
+	assert.Equal(t, int64(2), page.Total)
+}
+
+func TestSourceToken_BoundToRepository(t *testing.T) {
+	defer cleanupOrgTestData(t)
+
+	creator := createTestUser(t, "sourcecreator")
+	editor := createTestUser(t, "sourceeditor")
+	database.DB.Model(&creator).Updates(map[string]interface{}{"provider": "github", "access_token": "creator-token"})
+	_, pack := createTestOrgResource(t, "testsourceorg",
+		models.Membership{UserID: creator.ID, Role: models.RoleOwner},
+		models.Membership{UserID: editor.ID, Role: models.RoleMaintainer})
+	pack.Visibility = models.VisibilityPrivate
+	bindSourceToken(&pack, creator.ID)
+	require.NoError(t, database.DB.Save(&pack).Error)
+	assert.Equal(t, "creator-token", sourceToken(pack))
+
+	// An editor pointing the resource at another repository doesn't get to
+	// read it with the creator's token
+	app := setupAuthenticatedApp(editor)
+	app.Post("/resource/:id/edit", PostEditResource)
+	edit := func(repo string) {
+		form := "name=" + pack.Name + "&type=pack&owner=testsourceorg&repository_url=" + repo
+		require.Equal(t, 200, postForm(t, app, "/resource/"+toString(pack.ID)+"/edit", form))
+		require.NoError(t, database.DB.First(&pack, pack.ID).Error)
+	}
+	edit("https://github.com/creator/secret")
+	assert.Empty(t, sourceToken(pack))
+
+	// They can bind their own
+	database.DB.Model(&editor).Updates(map[string]interface{}{"provider": "github", "access_token": "editor-token"})
+	edit("https://github.com/editor/private")
+	assert.Equal(t, "editor-token", sourceToken(pack))
+}
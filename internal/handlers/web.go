@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"log"
 	"rmbl/internal/database"
 	"rmbl/internal/models"
 	"strconv"
@@ -13,7 +14,7 @@ import (
 
 func Home(c *fiber.Ctx) error {
 	var results []models.NomadResource
-	database.DB.Model(&models.NomadResource{}).Preload("User").Preload("Tags").Order("updated_at desc").Limit(12).Find(&results)
+	database.DB.Model(&models.NomadResource{}).Scopes(visibleTo(c)).Preload("User").Preload("Tags").Order("updated_at desc").Limit(12).Find(&results)
 
 	nextPage := 0
 	if len(results) == 12 {
@@ -58,11 +59,10 @@ func Search(c *fiber.Ctx) error {
 
 	var results []models.NomadResource
 	
-	dbQuery := database.DB.Model(&models.NomadResource{}).Preload("User").Preload("Tags")
+	dbQuery := database.DB.Model(&models.NomadResource{}).Scopes(visibleTo(c)).Preload("User").Preload("Tags")
 
 	if query != "" {
-		searchParam := "%" + escapeLikeString(query) + "%"
-		dbQuery = dbQuery.Where("name ILIKE ? ESCAPE '\\' OR description ILIKE ? ESCAPE '\\'", searchParam, searchParam)
+		dbQuery = searchMatch(dbQuery, query)
 	}
 	if resourceType != "" {
 		dbQuery = dbQuery.Where("type = ?", resourceType)
@@ -73,17 +73,7 @@ func Search(c *fiber.Ctx) error {
 			Where("tags.name = ?", tag)
 	}
 	
-	// Apply Sorting
-	switch sort {
-	case "stars":
-		dbQuery = dbQuery.Order("star_count desc, updated_at desc")
-	case "downloads":
-		dbQuery = dbQuery.Order("download_count desc, updated_at desc")
-	case "alpha":
-		dbQuery = dbQuery.Order("name asc")
-	default:
-		dbQuery = dbQuery.Order("updated_at desc")
-	}
+	dbQuery = sortResources(dbQuery, sort, query)
 	
 	offset := (page - 1) * pageSize
 	dbQuery.Limit(pageSize).Offset(offset).Find(&results)
@@ -136,12 +126,12 @@ func GetResource(c *fiber.Ctx) error {
 	var displayName string
 
 	var user models.User
-	if err := database.DB.Where("username ILIKE ?", namespace).First(&user).Error; err == nil {
+	if err := database.DB.Where("username ILIKE ?", escapeLikeString(namespace)).First(&user).Error; err == nil {
 		userID = user.ID
 		displayName = user.Username
 	} else {
 		var org models.Organization
-		if err := database.DB.Where("name ILIKE ?", namespace).First(&org).Error; err == nil {
+		if err := database.DB.Where("name ILIKE ?", escapeLikeString(namespace)).First(&org).Error; err == nil {
 			orgID = &org.ID
 			displayName = org.Name
 		} else {
@@ -150,37 +140,24 @@ func GetResource(c *fiber.Ctx) error {
 	}
 
 	var resource models.NomadResource
-	dbQuery := database.DB.Preload("User").Preload("Tags").Preload("StarredBy").Preload("Versions", func(db *gorm.DB) *gorm.DB {
+	dbQuery := database.DB.Preload("User").Preload("Organization").Preload("Tags").Preload("StarredBy").Preload("Versions", func(db *gorm.DB) *gorm.DB {
 		return db.Order("resource_versions.created_at DESC")
 	})
 
 	if orgID != nil {
-		dbQuery = dbQuery.Where("organization_id = ? AND name ILIKE ?", *orgID, resourcename)
+		dbQuery = dbQuery.Where("organization_id = ? AND name ILIKE ?", *orgID, escapeLikeString(resourcename))
 	} else {
-		dbQuery = dbQuery.Where("user_id = ? AND organization_id IS NULL AND name ILIKE ?", userID, resourcename)
+		dbQuery = dbQuery.Where("user_id = ? AND organization_id IS NULL AND name ILIKE ?", userID, escapeLikeString(resourcename))
 	}
 
-	if err := dbQuery.First(&resource).Error; err != nil {
+	if err := dbQuery.First(&resource).Error; err != nil || !canViewResource(c, resource) {
 		return c.Status(404).SendString("Resource not found")
 	}
 
 	isLoggedIn := c.Locals("UserID") != nil
-	var isOwner bool
 	var isStarred bool
 	if isLoggedIn {
 		currentUserID := c.Locals("UserID").(uint)
-		
-		// If it's a personal repo, current user must be owner
-		// If it's an org repo, current user must be member of that org
-		if orgID != nil {
-			var membership models.Membership
-			if err := database.DB.Where("user_id = ? AND organization_id = ?", currentUserID, *orgID).First(&membership).Error; err == nil {
-				isOwner = true // members can edit
-			}
-		} else {
-			isOwner = currentUserID == resource.UserID
-		}
-
 		for _, u := range resource.StarredBy {
 			if u.ID == currentUserID {
 				isStarred = true
@@ -189,25 +166,25 @@ func GetResource(c *fiber.Ctx) error {
 		}
 	}
 
+	sortVersions(resource.Versions)
+	latest, _ := latestVersion(resource.Versions)
 	var latestVariables []PackVariable
-	if len(resource.Versions) > 0 && resource.Versions[0].Variables != "" {
-		_ = json.Unmarshal([]byte(resource.Versions[0].Variables), &latestVariables)
+	if latest.Variables != "" {
+		_ = json.Unmarshal([]byte(latest.Variables), &latestVariables)
 	}
 
 	// API response for nomad-pack registry
 	if wantsJSON(c) {
-		versions := make([]PackVersion, len(resource.Versions))
-		for i, v := range resource.Versions {
-			versions[i] = PackVersion{
-				Version: v.Version,
-				URL:     getDownloadURL(resource.RepositoryURL, v.Version),
-			}
-		}
-		return c.JSON(PackDetail{
-			Name:        resource.Name,
-			Description: resource.Description,
-			Versions:    versions,
-		})
+		return c.JSON(resourceDetail(c, resource))
+	}
+
+	// Yanked versions stay reachable by exact version but are not listed
+	resource.Versions = visibleVersions(resource.Versions)
+
+	stats := downloadStats(resource, statsDefaultDays)
+	recentDownloads := 0
+	for _, d := range stats.Daily {
+		recentDownloads += d.Downloads
 	}
 
 	// Generate SEO data
@@ -215,12 +192,20 @@ func GetResource(c *fiber.Ctx) error {
 
 	return c.Render("resource_detail", MergeContext(BaseContext(c), fiber.Map{
 		"Resource":               resource,
-		"IsOwner":                isOwner,
+		"CanEdit":                hasPermission(currentUserID(c), resource, PermEdit),
+		"CanDelete":              hasPermission(currentUserID(c), resource, PermDelete),
+		"CanPublish":             hasPermission(currentUserID(c), resource, PermPublish),
+		"CanRotateSecrets":       hasPermission(currentUserID(c), resource, PermRotateSecrets),
+		"IsAdmin":                isAdminRequest(c),
 		"IsStarred":              isStarred,
 		"StarCount":              len(resource.StarredBy),
 		"DisplayName":            displayName,
 		"Host":                   c.Hostname(),
+		"LatestVersion":          latest,
+		"Successor":              successorPath(resource),
 		"LatestVersionVariables": latestVariables,
+		"RecentDownloads":        recentDownloads,
+		"DownloadSparkline":      sparklinePoints(stats.Daily, 120, 24),
 		// SEO fields
 		"SEOTitle":       seoData.Title,
 		"SEODescription": seoData.Description,
@@ -245,13 +230,8 @@ func GetResourceVersion(c *fiber.Ctx) error {
 	resourcename := c.Params("resourcename")
 	versionStr := c.Query("version")
 
-	var user models.User
-	if err := database.DB.Where("username ILIKE ?", username).First(&user).Error; err != nil {
-		return c.Status(404).SendString("User not found")
-	}
-
-	var resource models.NomadResource
-	if err := database.DB.Where("user_id = ? AND name ILIKE ?", user.ID, resourcename).First(&resource).Error; err != nil {
+	resource, err := findResource(username, resourcename)
+	if err != nil || !canViewResource(c, resource) {
 		return c.Status(404).SendString("Resource not found")
 	}
 
@@ -270,6 +250,7 @@ func GetResourceVersion(c *fiber.Ctx) error {
 		"Resource":  resource,
 		"Host":      c.Hostname(),
 		"Variables": variables,
+		"CanEdit":   hasPermission(currentUserID(c), resource, PermEdit),
 	})
 }
 
@@ -283,19 +264,15 @@ func GetResourceVersion(c *fiber.Ctx) error {
 // @Param version path string true "Version string"
 // @Success 200 {string} string "Raw content"
 // @Failure 404 {string} string "Not Found"
+// @Failure 409 {string} string "Content doesn't match the version's digest"
 // @Router /{username}/{resourcename}/v/{version}/raw [get]
 func GetRawResourceVersion(c *fiber.Ctx) error {
 	username := c.Params("username")
 	resourcename := c.Params("resourcename")
 	versionStr := c.Params("version")
 
-	var user models.User
-	if err := database.DB.Where("username ILIKE ?", username).First(&user).Error; err != nil {
-		return c.Status(404).SendString("User not found")
-	}
-
-	var resource models.NomadResource
-	if err := database.DB.Where("user_id = ? AND name ILIKE ?", user.ID, resourcename).First(&resource).Error; err != nil {
+	resource, err := findResource(username, resourcename)
+	if err != nil || !canViewResource(c, resource) {
 		return c.Status(404).SendString("Resource not found")
 	}
 
@@ -308,9 +285,25 @@ func GetRawResourceVersion(c *fiber.Ctx) error {
 		return c.Status(404).SendString("No content available for this version")
 	}
 
-	// Increment download count
-	database.DB.Model(&resource).Update("download_count", gorm.Expr("download_count + ?", 1))
+	return sendVersionContent(c, resource, version)
+}
 
+// sendVersionContent serves a version's raw content. A job's content is what
+// its stored digest covers, so the digest is sent along and content that no
+// longer matches it is refused. A pack's digest covers its archive, not the
+// metadata.hcl served here, so no digest is sent for packs.
+func sendVersionContent(c *fiber.Ctx, resource models.NomadResource, version models.ResourceVersion) error {
+	if resource.Type == models.ResourceTypeJob && version.Digest != "" {
+		if digest := contentDigest([]byte(version.Content)); digest != version.Digest {
+			log.Printf("Content of %s@%s is %s but the version's digest is %s; refusing to serve it", resource.Name, version.Version, digest, version.Digest)
+			return c.Status(409).SendString("This version's content doesn't match its digest")
+		}
+		c.Set("X-Ramble-Digest", version.Digest)
+	}
+
+	recordDownload(c, resource, version.Version)
+
+	setVersionWarning(c, resource, version)
 	c.Set("Content-Type", "text/plain")
 	return c.SendString(version.Content)
 }
@@ -322,7 +315,7 @@ func GetRawResourceVersion(c *fiber.Ctx) error {
 // @Produce html
 // @Param username path string true "User or Organization namespace"
 // @Param q query string false "Search query"
-// @Param sort query string false "Sort order (latest, stars, alpha, downloads)"
+// @Param sort query string false "Sort order (latest, stars, alpha, downloads, relevance)"
 // @Param page query int false "Page number"
 // @Success 200 {string} string "HTML content"
 // @Failure 404 {string} string "Not Found"
@@ -348,7 +341,7 @@ func GetUserProfile(c *fiber.Ctx) error {
 
 	var user models.User
 
-	if err := database.DB.Preload("Memberships.Organization").Where("username ILIKE ?", namespace).First(&user).Error; err == nil {
+	if err := database.DB.Preload("Memberships.Organization").Where("username ILIKE ?", escapeLikeString(namespace)).First(&user).Error; err == nil {
 
 		profileUser = &user
 
@@ -356,7 +349,7 @@ func GetUserProfile(c *fiber.Ctx) error {
 
 		var org models.Organization
 
-		if err := database.DB.Preload("Memberships.User").Where("name ILIKE ?", namespace).First(&org).Error; err == nil {
+		if err := database.DB.Preload("Memberships.User").Where("name ILIKE ?", escapeLikeString(namespace)).First(&org).Error; err == nil {
 
 			profileOrg = &org
 
@@ -374,6 +367,8 @@ func GetUserProfile(c *fiber.Ctx) error {
 
 	dbQuery := database.DB.Model(&models.NomadResource{}).
 
+		Scopes(visibleTo(c)).
+
 		Preload("User").
 
 		Preload("Tags")
@@ -394,55 +389,13 @@ func GetUserProfile(c *fiber.Ctx) error {
 
 	if query != "" {
 
-		searchParam := "%" + escapeLikeString(query) + "%"
-
-		dbQuery = dbQuery.Where("name ILIKE ? ESCAPE '\\' OR description ILIKE ? ESCAPE '\\'", searchParam, searchParam)
+		dbQuery = searchMatch(dbQuery, query)
 
 	}
 
 
 
-		// Apply Sorting
-
-
-
-		switch sort {
-
-
-
-		case "stars":
-
-
-
-			dbQuery = dbQuery.Order("star_count desc, updated_at desc")
-
-
-
-		case "downloads":
-
-
-
-			dbQuery = dbQuery.Order("download_count desc, updated_at desc")
-
-
-
-		case "alpha":
-
-
-
-			dbQuery = dbQuery.Order("name asc")
-
-
-
-		default:
-
-
-
-			dbQuery = dbQuery.Order("updated_at desc")
-
-
-
-		}
+		dbQuery = sortResources(dbQuery, sort, query)
 
 
 
@@ -505,11 +458,7 @@ func GetUserProfile(c *fiber.Ctx) error {
 
 			currentUserID := c.Locals("UserID").(uint)
 
-			var count int64
-
-			database.DB.Model(&models.Membership{}).Where("user_id = ? AND organization_id = ?", currentUserID, profileOrg.ID).Count(&count)
-
-			isOwner = count > 0
+			isOwner = orgAllows(currentUserID, profileOrg.ID, PermManageMembers)
 
 		}
 
@@ -541,64 +490,71 @@ func GetUserProfile(c *fiber.Ctx) error {
 // @Description Get the raw .nomad.hcl content for the latest version of a resource.
 // @Tags resources
 // @Produce text/plain
-// @Param username path string true "User namespace"
+// @Param username path string true "User or Organization namespace"
 // @Param resourcename path string true "Resource name"
 // @Success 200 {string} string "Raw HCL content"
+// @Failure 409 {string} string "Content doesn't match the version's digest"
 // @Router /{username}/{resourcename}/raw [get]
 func GetRawResource(c *fiber.Ctx) error {
 	username := c.Params("username")
 	resourcename := c.Params("resourcename")
 
-	var user models.User
-	if err := database.DB.Where("username ILIKE ?", username).First(&user).Error; err != nil {
-		return c.Status(404).SendString("User not found")
-	}
-
-	var resource models.NomadResource
-	if err := database.DB.Preload("Versions", func(db *gorm.DB) *gorm.DB {
-		return db.Order("resource_versions.created_at DESC")
-	}).Where("user_id = ? AND name ILIKE ?", user.ID, resourcename).First(&resource).Error; err != nil {
+	resource, err := findResource(username, resourcename)
+	if err != nil || !canViewResource(c, resource) {
 		return c.Status(404).SendString("Resource not found")
 	}
+	database.DB.Where("resource_id = ?", resource.ID).Order("created_at DESC").Find(&resource.Versions)
 
-	if len(resource.Versions) == 0 || resource.Versions[0].Content == "" {
+	latest, ok := latestVersion(resource.Versions)
+	if !ok || latest.Content == "" {
 		return c.Status(404).SendString("No content available for this resource")
 	}
 
-	// Increment download count
-	database.DB.Model(&resource).Update("download_count", gorm.Expr("download_count + ?", 1))
-
-	c.Set("Content-Type", "text/plain")
-	return c.SendString(resource.Versions[0].Content)
+	return sendVersionContent(c, resource, latest)
 }
 
+// FetchReadme returns the stored README of a resource's latest version. It
+// never contacts the repository; editors refresh a version through
+// PostRefreshVersion.
 func FetchReadme(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var resource models.NomadResource
 	if err := database.DB.Preload("Versions", func(db *gorm.DB) *gorm.DB {
 		return db.Order("resource_versions.created_at DESC")
-	}).First(&resource, id).Error; err != nil {
+	}).First(&resource, id).Error; err != nil || !canViewResource(c, resource) {
 		return c.Status(404).SendString("Resource not found")
 	}
 
-	readme, _ := downloadFile(resource.RepositoryURL, "README.md")
-	var content string
-	if resource.Type == models.ResourceTypeJob {
-		fetchPath := resource.FilePath
-		if fetchPath == "" {
-			fetchPath = resource.Name
-			if !strings.HasSuffix(fetchPath, ".nomad.hcl") {
-				fetchPath = fetchPath + ".nomad.hcl"
-			}
-		}
-		content, _ = downloadFile(resource.RepositoryURL, fetchPath)
-	} else if resource.Type == models.ResourceTypePack {
-		content, _ = downloadFile(resource.RepositoryURL, "metadata.hcl")
+	var readme string
+	if version, ok := latestVersion(resource.Versions); ok {
+		readme = version.Readme
+	}
+
+	if readme == "" {
+		return c.SendString("<p class='text-gray-400 italic'>No README available for this version.</p>")
+	}
+
+	return c.SendString("<div id='readme-content' _='on load call renderMarkdown(my.textContent) then set my.innerHTML to it then call hljs.highlightAll()'>" + readme + "</div>")
+}
+
+// PostRefreshVersion queues a version to be read from its repository again,
+// and fills in the resource's license if it isn't known yet
+func PostRefreshVersion(c *fiber.Ctx) error {
+	var resource models.NomadResource
+	if err := database.DB.First(&resource, c.Params("id")).Error; err != nil {
+		return c.Status(404).SendString("Resource not found")
+	}
+	if !hasPermission(currentUserID(c), resource, PermEdit) {
+		return c.Status(403).SendString("You don't have permission to edit this resource")
+	}
+	var version models.ResourceVersion
+	if err := database.DB.Where("resource_id = ? AND version = ?", resource.ID, c.Params("version")).First(&version).Error; err != nil {
+		return c.Status(404).SendString("Version not found")
 	}
 
 	// Update License if unknown
 	if resource.License == "" || resource.License == "Unknown" {
-		if licBody, err := downloadFile(resource.RepositoryURL, "LICENSE"); err == nil && licBody != "" {
+		if licBody, err := downloadFile(resource.GitProvider, resource.RepositoryURL, "LICENSE"); err == nil && licBody != "" {
 			lines := strings.Split(licBody, "\n")
 			if len(lines) > 0 {
 				lic := strings.TrimSpace(lines[0])
@@ -606,32 +562,27 @@ func FetchReadme(c *fiber.Ctx) error {
 				if len(lic) > 25 {
 					lic = lic[:25] + "..."
 				}
-				resource.License = lic
-				database.DB.Save(&resource)
+				database.DB.Model(&resource).Update("license", lic)
 			}
 		}
 	}
 
-	// Update the latest version
-	if len(resource.Versions) > 0 {
-		version := resource.Versions[0]
-		version.Readme = readme
-		version.Content = content
-		database.DB.Save(&version)
+	if err := enqueueIngest(version.ID); err != nil {
+		return c.Status(500).SendString("Could not queue version")
 	}
-
-	if readme == "" {
-		return c.SendString("<p class='text-red-500'>Failed to fetch README. Please check the repository URL.</p>")
-	}
-
-	return c.SendString("<div id='readme-content' _='on load call renderMarkdown(my.textContent) then set my.innerHTML to it then call hljs.highlightAll()'>" + readme + "</div>")
+	SetFlash(c, "success", "Version "+version.Version+" queued to be fetched from the repository.")
+	c.Set("HX-Refresh", "true")
+	return c.SendStatus(200)
 }
 
+// GetPopularTags returns the tags used most by public resources
 func GetPopularTags() []models.Tag {
 	var tags []models.Tag
 	database.DB.Table("tags").
 		Select("tags.*, COUNT(resource_tags.nomad_resource_id) as usage_count").
 		Joins("JOIN resource_tags ON resource_tags.tag_id = tags.id").
+		Joins("JOIN nomad_resources ON nomad_resources.id = resource_tags.nomad_resource_id").
+		Where("nomad_resources.visibility = ? AND nomad_resources.hidden_at IS NULL AND nomad_resources.deleted_at IS NULL", models.VisibilityPublic).
 		Group("tags.id").
 		Order("usage_count DESC").
 		Limit(12).
@@ -31,12 +31,11 @@ func GetPacks(c *fiber.Ctx) error {
 	offset := (page - 1) * pageSize
 
 	// Build Query
-	dbQuery := database.DB.Preload("User").Preload("Tags").
+	dbQuery := database.DB.Scopes(visibleTo(c)).Preload("User").Preload("Tags").
 		Where("type = ?", models.ResourceTypePack)
 
 	if query != "" {
-		searchParam := "%" + escapeLikeString(query) + "%"
-		dbQuery = dbQuery.Where("name ILIKE ? ESCAPE '\\' OR description ILIKE ? ESCAPE '\\'", searchParam, searchParam)
+		dbQuery = searchMatch(dbQuery, query)
 	}
 	if tag != "" {
 		dbQuery = dbQuery.Joins("JOIN resource_tags ON resource_tags.nomad_resource_id = nomad_resources.id").
@@ -44,17 +43,7 @@ func GetPacks(c *fiber.Ctx) error {
 			Where("tags.name = ?", tag)
 	}
 
-	// Apply Sorting
-	switch sort {
-	case "stars":
-		dbQuery = dbQuery.Order("star_count desc, updated_at desc")
-	case "downloads":
-		dbQuery = dbQuery.Order("download_count desc, updated_at desc")
-	case "alpha":
-		dbQuery = dbQuery.Order("name asc")
-	default:
-		dbQuery = dbQuery.Order("updated_at desc")
-	}
+	dbQuery = sortResources(dbQuery, sort, query)
 
 	dbQuery.Limit(pageSize).Offset(offset).Find(&results)
 
@@ -99,12 +88,11 @@ func GetJobs(c *fiber.Ctx) error {
 	offset := (page - 1) * pageSize
 
 	// Build Query
-	dbQuery := database.DB.Preload("User").Preload("Tags").
+	dbQuery := database.DB.Scopes(visibleTo(c)).Preload("User").Preload("Tags").
 		Where("type = ?", models.ResourceTypeJob)
 
 	if query != "" {
-		searchParam := "%" + escapeLikeString(query) + "%"
-		dbQuery = dbQuery.Where("name ILIKE ? ESCAPE '\\' OR description ILIKE ? ESCAPE '\\'", searchParam, searchParam)
+		dbQuery = searchMatch(dbQuery, query)
 	}
 	if tag != "" {
 		dbQuery = dbQuery.Joins("JOIN resource_tags ON resource_tags.nomad_resource_id = nomad_resources.id").
@@ -112,17 +100,7 @@ func GetJobs(c *fiber.Ctx) error {
 			Where("tags.name = ?", tag)
 	}
 
-	// Apply Sorting
-	switch sort {
-	case "stars":
-		dbQuery = dbQuery.Order("star_count desc, updated_at desc")
-	case "downloads":
-		dbQuery = dbQuery.Order("download_count desc, updated_at desc")
-	case "alpha":
-		dbQuery = dbQuery.Order("name asc")
-	default:
-		dbQuery = dbQuery.Order("updated_at desc")
-	}
+	dbQuery = sortResources(dbQuery, sort, query)
 
 	dbQuery.Limit(pageSize).Offset(offset).Find(&results)
 
@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"rmbl/internal/database"
+	"rmbl/internal/models"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Webhook delivery log limits
+const (
+	maxStoredPayload         = 64 << 10 // Larger bodies are truncated in the log
+	maxDeliveriesPerResource = 200      // Older deliveries are pruned
+	maxRejectedPerResource   = 20       // Older rejected deliveries are pruned
+	deliveriesPerPage        = 20
+)
+
+// redactedHeaders are never written to the delivery log
+var redactedHeaders = map[string]bool{
+	"Authorization":  true,
+	"Cookie":         true,
+	"X-Gitlab-Token": true,
+}
+
+// verifyWebhook checks that a delivery was sent by a host that knows the
+// resource's webhook secret. GitHub, Gitea and Forgejo sign the body with an
+// HMAC-SHA256; GitLab echoes the secret in X-Gitlab-Token. The ?secret= query
+// parameter is only accepted when the resource has opted into legacy mode.
+func verifyWebhook(c *fiber.Ctx, resource models.NomadResource) bool {
+	if resource.WebhookSecret == "" {
+		return false
+	}
+	secret := []byte(resource.WebhookSecret)
+
+	if sig := c.Get("X-Hub-Signature-256"); sig != "" {
+		return validSignature(secret, c.Body(), strings.TrimPrefix(sig, "sha256="))
+	}
+	for _, header := range []string{"X-Gitea-Signature", "X-Forgejo-Signature"} {
+		if sig := c.Get(header); sig != "" {
+			return validSignature(secret, c.Body(), sig)
+		}
+	}
+	if token := c.Get("X-Gitlab-Token"); token != "" {
+		return subtle.ConstantTimeCompare(secret, []byte(token)) == 1
+	}
+	if resource.WebhookQuerySecret {
+		if query := c.Query("secret"); query != "" {
+			return subtle.ConstantTimeCompare(secret, []byte(query)) == 1
+		}
+	}
+	return false
+}
+
+// validSignature reports whether sig is the hex HMAC-SHA256 of body
+func validSignature(secret, body []byte, sig string) bool {
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// webhookEvent is the part of a delivery the registry acts on
+type webhookEvent struct {
+	Name   string // Event name as sent by the host, e.g. "push" or "Tag Push Hook"
+	Tag    string // Set when a tag was created or a release published
+	Branch string // Set when a branch was pushed to
+}
+
+// Ref returns the full git ref of the event, or "" if it names neither a tag
+// nor a branch
+func (e webhookEvent) Ref() string {
+	if e.Tag != "" {
+		return "refs/tags/" + e.Tag
+	}
+	if e.Branch != "" {
+		return "refs/heads/" + e.Branch
+	}
+	return ""
+}
+
+// webhookEventFromRef rebuilds an event from a ref stored by Ref
+func webhookEventFromRef(name, ref string) webhookEvent {
+	event := webhookEvent{Name: name}
+	if strings.HasPrefix(ref, "refs/tags/") {
+		event.Tag = strings.TrimPrefix(ref, "refs/tags/")
+	} else if strings.HasPrefix(ref, "refs/heads/") {
+		event.Branch = strings.TrimPrefix(ref, "refs/heads/")
+	}
+	return event
+}
+
+// webhookEventName returns the event header of whichever host sent the delivery
+func webhookEventName(c *fiber.Ctx) string {
+	for _, header := range []string{"X-GitHub-Event", "X-Gitlab-Event", "X-Gitea-Event", "X-Forgejo-Event"} {
+		if name := c.Get(header); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// giteaDelivery reports whether a delivery came from Gitea or Forgejo. They
+// also send X-GitHub-Event, so look for their own event headers. header
+// returns a request header by name.
+func giteaDelivery(header func(string) string) bool {
+	return header("X-Gitea-Event") != "" || header("X-Forgejo-Event") != ""
+}
+
+// errInvalidPayload is returned for a delivery whose body cannot be parsed
+var errInvalidPayload = errors.New("invalid webhook payload")
+
+// parseWebhookEvent reads the tag or branch from a GitHub, GitLab, Gitea or
+// Forgejo delivery; gitea tells the latter two apart from GitHub. Events that
+// are not about tags, releases or pushes, deletions and draft releases come
+// back with neither set.
+func parseWebhookEvent(name string, gitea bool, body []byte) (webhookEvent, error) {
+	event := webhookEvent{Name: name}
+	var payload struct {
+		Ref     string `json:"ref"`
+		RefType string `json:"ref_type"` // GitHub and Gitea create events
+		After   string `json:"after"`    // All zeros when a ref is deleted
+		Deleted bool   `json:"deleted"`
+		Action  string `json:"action"`
+		Tag     string `json:"tag"` // GitLab release hooks
+		Release struct {
+			TagName string `json:"tag_name"`
+			Draft   bool   `json:"draft"`
+		} `json:"release"`
+	}
+
+	switch name {
+	case "create", "push", "release", "Tag Push Hook", "Push Hook", "Release Hook":
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return event, errInvalidPayload
+		}
+	default:
+		return event, nil
+	}
+	if payload.Deleted || (payload.After != "" && strings.Trim(payload.After, "0") == "") {
+		return event, nil
+	}
+
+	switch name {
+	case "create":
+		if payload.RefType == "tag" {
+			event.Tag = payload.Ref
+		}
+	case "release":
+		// GitHub sends "published", Gitea and Forgejo send "published" or
+		// "created". GitHub's "created" also fires for drafts, whose tag may
+		// not exist yet.
+		if !payload.Release.Draft && (payload.Action == "published" || (gitea && payload.Action == "created")) {
+			event.Tag = payload.Release.TagName
+		}
+	case "Release Hook":
+		if payload.Action == "create" {
+			event.Tag = payload.Tag
+		}
+	case "push", "Tag Push Hook", "Push Hook":
+		if strings.HasPrefix(payload.Ref, "refs/tags/") {
+			event.Tag = strings.TrimPrefix(payload.Ref, "refs/tags/")
+		} else if strings.HasPrefix(payload.Ref, "refs/heads/") {
+			event.Branch = strings.TrimPrefix(payload.Ref, "refs/heads/")
+		}
+	}
+	return event, nil
+}
+
+// applyWebhookEvent creates or refreshes the versions an event is about and
+// returns their names. A tag creates its version, or re-reads it if it already
+// exists. Anything else refreshes the versions that track the pushed branch;
+// tagged versions are fixed to their tag, so the latest one is only re-read
+// when the resource has no branch-tracking versions.
+func applyWebhookEvent(resource models.NomadResource, event webhookEvent) ([]string, error) {
+	if event.Tag != "" {
+		var version models.ResourceVersion
+		err := database.DB.Where("resource_id = ? AND version = ?", resource.ID, event.Tag).First(&version).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			version = models.ResourceVersion{
+				ResourceID:  resource.ID,
+				Version:     event.Tag,
+				IngestState: models.IngestPending,
+			}
+			err = database.DB.Create(&version).Error
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not create version %s: %w", event.Tag, err)
+		}
+		if err := enqueueIngest(version.ID); err != nil {
+			return nil, fmt.Errorf("could not queue version %s: %w", version.Version, err)
+		}
+		return []string{version.Version}, nil
+	}
+
+	var versions []models.ResourceVersion
+	if err := database.DB.Where("resource_id = ?", resource.ID).Order("created_at DESC").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+
+	var refreshed []string
+	hasBranchVersions := false
+	for _, v := range versions {
+		if !v.TrackBranch {
+			continue
+		}
+		hasBranchVersions = true
+		if branch := versionRef(resource, v); event.Branch != "" && branch != "" && branch != event.Branch {
+			continue
+		}
+		if err := enqueueIngest(v.ID); err != nil {
+			return refreshed, fmt.Errorf("could not queue version %s: %w", v.Version, err)
+		}
+		refreshed = append(refreshed, v.Version)
+	}
+	if latest, ok := latestVersion(versions); ok && !hasBranchVersions {
+		if err := enqueueIngest(latest.ID); err != nil {
+			return nil, fmt.Errorf("could not queue version %s: %w", latest.Version, err)
+		}
+		refreshed = append(refreshed, latest.Version)
+	}
+	return refreshed, nil
+}
+
+// deliveryHeaders returns the request headers as JSON, without credentials
+func deliveryHeaders(c *fiber.Ctx) string {
+	headers := map[string]string{}
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		if !redactedHeaders[string(key)] {
+			headers[string(key)] = string(value)
+		}
+	})
+	b, _ := json.Marshal(headers)
+	return string(b)
+}
+
+// truncatePayload caps a request body at maxStoredPayload bytes
+func truncatePayload(body []byte) string {
+	if len(body) > maxStoredPayload {
+		body = body[:maxStoredPayload]
+	}
+	return string(body)
+}
+
+// recordDelivery stores a delivery with its outcome and duration, keeps the
+// resource's last-delivery summary in step, and prunes the oldest entries
+func recordDelivery(resource models.NomadResource, delivery *models.WebhookDelivery, start time.Time, err error) {
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	delivery.Status = "success"
+	if err != nil {
+		delivery.Status = "failure"
+		delivery.Error = err.Error()
+	}
+	if err := database.DB.Create(delivery).Error; err != nil {
+		log.Printf("Could not record webhook delivery for resource %d: %v", resource.ID, err)
+	}
+
+	database.DB.Model(&models.NomadResource{}).Where("id = ?", resource.ID).Updates(map[string]interface{}{
+		"last_webhook_delivery": delivery.CreatedAt,
+		"last_webhook_status":   delivery.Status,
+		"last_webhook_error":    delivery.Error,
+	})
+
+	pruneDeliveries(resource.ID, false, maxDeliveriesPerResource)
+}
+
+// recordRejectedDelivery notes a delivery that failed verification. Anyone
+// can send one, so only the event name is kept: nothing the sender chose is
+// stored or replayed, the resource's last-delivery summary is left alone,
+// and rejected deliveries are pruned separately so they can't push real ones
+// out of the log.
+func recordRejectedDelivery(resource models.NomadResource, event string, start time.Time) {
+	delivery := models.WebhookDelivery{
+		ResourceID: resource.ID,
+		Event:      truncateText(event, 100),
+		Status:     "failure",
+		Error:      "Invalid or missing signature",
+		DurationMs: time.Since(start).Milliseconds(),
+		Rejected:   true,
+	}
+	if err := database.DB.Create(&delivery).Error; err != nil {
+		log.Printf("Could not record webhook delivery for resource %d: %v", resource.ID, err)
+	}
+	pruneDeliveries(resource.ID, true, maxRejectedPerResource)
+}
+
+// pruneDeliveries keeps the newest limit deliveries of a resource that were,
+// or weren't, rejected
+func pruneDeliveries(resourceID uint, rejected bool, limit int) {
+	keep := database.DB.Model(&models.WebhookDelivery{}).Select("id").
+		Where("resource_id = ? AND rejected = ?", resourceID, rejected).Order("id DESC").Limit(limit)
+	database.DB.Unscoped().Where("resource_id = ? AND rejected = ? AND id NOT IN (?)", resourceID, rejected, keep).
+		Delete(&models.WebhookDelivery{})
+}
+
+// webhookDeliveryJSON is the API form of a delivery
+func webhookDeliveryJSON(d models.WebhookDelivery) fiber.Map {
+	return fiber.Map{
+		"id":           d.ID,
+		"created_at":   d.CreatedAt,
+		"event":        d.Event,
+		"ref":          d.Ref,
+		"status":       d.Status,
+		"error":        d.Error,
+		"duration_ms":  d.DurationMs,
+		"versions":     d.Versions,
+		"replay_of_id": d.ReplayOfID,
+		"rejected":     d.Rejected,
+	}
+}
+
+// GetWebhookDeliveries godoc
+// @Summary List webhook deliveries
+// @Description List the recorded webhook deliveries of a resource, newest first, 20 per page. Browser requests receive the delivery log HTML fragment.
+// @Tags webhooks
+// @Produce json
+// @Param id path string true "Resource ID"
+// @Param page query int false "Page number"
+// @Success 200 {array} object
+// @Failure 403 {string} string "Unauthorized"
+// @Security BearerAuth
+// @Router /resource/{id}/webhook/deliveries [get]
+func GetWebhookDeliveries(c *fiber.Ctx) error {
+	var resource models.NomadResource
+	if err := database.DB.First(&resource, c.Params("id")).Error; err != nil {
+		return apiError(c, 404, "Resource not found")
+	}
+	if !hasPermission(currentUserID(c), resource, PermEdit) || !tokenAllowsNamespace(c, resource.OrganizationID) {
+		return apiError(c, 403, "Unauthorized")
+	}
+	return renderWebhookDeliveries(c, resource, c.QueryInt("page", 1))
+}
+
+// renderWebhookDeliveries responds with one page of a resource's delivery log
+func renderWebhookDeliveries(c *fiber.Ctx, resource models.NomadResource, page int) error {
+	if page < 1 {
+		page = 1
+	}
+	var deliveries []models.WebhookDelivery
+	database.DB.Where("resource_id = ?", resource.ID).Order("id DESC").
+		Offset((page - 1) * deliveriesPerPage).Limit(deliveriesPerPage + 1).Find(&deliveries)
+	hasMore := len(deliveries) > deliveriesPerPage
+	if hasMore {
+		deliveries = deliveries[:deliveriesPerPage]
+	}
+
+	if isTokenRequest(c) {
+		items := make([]fiber.Map, len(deliveries))
+		for i, d := range deliveries {
+			items[i] = webhookDeliveryJSON(d)
+		}
+		return c.JSON(fiber.Map{"deliveries": items, "page": page, "has_more": hasMore})
+	}
+
+	return c.Render("partials/webhook_deliveries", fiber.Map{
+		"ResourceID": resource.ID,
+		"Deliveries": deliveries,
+		"Page":       page,
+		"PrevPage":   page - 1,
+		"NextPage":   page + 1,
+		"HasMore":    hasMore,
+	})
+}
+
+// PostReplayWebhookDelivery godoc
+// @Summary Replay a webhook delivery
+// @Description Re-run ingestion for a recorded delivery as if it had just been received. The replay is recorded as a new delivery.
+// @Tags webhooks
+// @Produce json
+// @Param id path string true "Resource ID"
+// @Param delivery path string true "Delivery ID"
+// @Success 200 {object} object
+// @Failure 403 {string} string "Unauthorized"
+// @Failure 404 {string} string "Not found"
+// @Security BearerAuth
+// @Router /resource/{id}/webhook/deliveries/{delivery}/replay [post]
+func PostReplayWebhookDelivery(c *fiber.Ctx) error {
+	start := time.Now()
+
+	var resource models.NomadResource
+	if err := database.DB.First(&resource, c.Params("id")).Error; err != nil {
+		return apiError(c, 404, "Resource not found")
+	}
+	if !hasPermission(currentUserID(c), resource, PermPublish) || !tokenAllowsNamespace(c, resource.OrganizationID) {
+		return apiError(c, 403, "Unauthorized")
+	}
+
+	var original models.WebhookDelivery
+	if err := database.DB.Where("id = ? AND resource_id = ?", c.Params("delivery"), resource.ID).First(&original).Error; err != nil {
+		return apiError(c, 404, "Delivery not found")
+	}
+	if original.Rejected {
+		return apiError(c, 400, "Deliveries that failed verification can't be replayed")
+	}
+
+	// Verified deliveries that failed to parse have no ref; the owner asked
+	// for the replay, so read it from the stored payload instead
+	event := webhookEventFromRef(original.Event, original.Ref)
+	if original.Ref == "" {
+		var headers map[string]string
+		json.Unmarshal([]byte(original.Headers), &headers)
+		gitea := giteaDelivery(func(name string) string { return headers[name] })
+		if parsed, err := parseWebhookEvent(original.Event, gitea, []byte(original.Payload)); err == nil {
+			event = parsed
+		}
+	}
+
+	delivery := models.WebhookDelivery{
+		ResourceID: resource.ID,
+		Event:      original.Event,
+		Ref:        event.Ref(),
+		Headers:    original.Headers,
+		Payload:    original.Payload,
+		ReplayOfID: &original.ID,
+	}
+	versions, err := applyWebhookEvent(resource, event)
+	delivery.Versions = strings.Join(versions, ", ")
+	recordDelivery(resource, &delivery, start, err)
+
+	if isTokenRequest(c) {
+		return c.JSON(webhookDeliveryJSON(delivery))
+	}
+	return renderWebhookDeliveries(c, resource, 1)
+}
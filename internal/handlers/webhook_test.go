@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// signWebhook returns the hex HMAC-SHA256 a git host would send for body
+func signWebhook(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidSignature(t *testing.T) {
+	body := []byte(`{"ref":"v1.0.0"}`)
+	assert.True(t, validSignature([]byte("secret"), body, signWebhook("secret", string(body))))
+	assert.False(t, validSignature([]byte("secret"), body, signWebhook("other", string(body))))
+	assert.False(t, validSignature([]byte("secret"), []byte(`{"ref":"v2.0.0"}`), signWebhook("secret", string(body))))
+	assert.False(t, validSignature([]byte("secret"), body, "not-hex"))
+}
+
+func TestParseWebhookEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  string
+		gitea  bool
+		body   string
+		tag    string
+		branch string
+	}{
+		{"github create tag", "create", false, `{"ref":"v1.0.0","ref_type":"tag"}`, "v1.0.0", ""},
+		{"github create branch", "create", false, `{"ref":"feature","ref_type":"branch"}`, "", ""},
+		{"github release", "release", false, `{"action":"published","release":{"tag_name":"v1.1.0"}}`, "v1.1.0", ""},
+		{"github release edited", "release", false, `{"action":"edited","release":{"tag_name":"v1.1.0"}}`, "", ""},
+		{"github tag push", "push", false, `{"ref":"refs/tags/v1.2.0","after":"abc123"}`, "v1.2.0", ""},
+		{"github branch push", "push", false, `{"ref":"refs/heads/main","after":"abc123"}`, "", "main"},
+		{"github tag deleted", "push", false, `{"ref":"refs/tags/v1.2.0","deleted":true}`, "", ""},
+		{"gitlab tag push", "Tag Push Hook", false, `{"ref":"refs/tags/v2.0.0","after":"abc123"}`, "v2.0.0", ""},
+		{"gitlab tag deleted", "Tag Push Hook", false, `{"ref":"refs/tags/v2.0.0","after":"0000000000000000000000000000000000000000"}`, "", ""},
+		{"gitlab release", "Release Hook", false, `{"action":"create","tag":"v2.1.0"}`, "v2.1.0", ""},
+		{"gitlab push", "Push Hook", false, `{"ref":"refs/heads/develop","after":"abc123"}`, "", "develop"},
+		{"github release created", "release", false, `{"action":"created","release":{"tag_name":"v1.1.0"}}`, "", ""},
+		{"github draft release", "release", false, `{"action":"published","release":{"tag_name":"v1.1.0","draft":true}}`, "", ""},
+		{"gitea release", "release", true, `{"action":"created","release":{"tag_name":"v0.3.0"}}`, "v0.3.0", ""},
+		{"gitea draft release", "release", true, `{"action":"created","release":{"tag_name":"v0.3.0","draft":true}}`, "", ""},
+		{"ping", "ping", false, `not json`, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := parseWebhookEvent(tt.event, tt.gitea, []byte(tt.body))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.tag, event.Tag)
+			assert.Equal(t, tt.branch, event.Branch)
+		})
+	}
+
+	_, err := parseWebhookEvent("push", false, []byte("not json"))
+	assert.ErrorIs(t, err, errInvalidPayload)
+}
+
+func TestWebhookEventRef(t *testing.T) {
+	for _, event := range []webhookEvent{
+		{Name: "create", Tag: "v1.0.0"},
+		{Name: "push", Branch: "main"},
+		{Name: "ping"},
+	} {
+		assert.Equal(t, event, webhookEventFromRef(event.Name, event.Ref()))
+	}
+	assert.Equal(t, "refs/tags/v1.0.0", webhookEvent{Tag: "v1.0.0"}.Ref())
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"errors"
 	"time"
 	"gorm.io/gorm"
 )
@@ -16,6 +17,13 @@ type User struct {
 	ProviderID   string // Unique ID from the provider
 	AccessToken  string // OAuth token for API calls
 	IsAdmin      bool   `gorm:"default:false"`
+	// Moderation
+	SuspendedAt      *time.Time // Suspended users can't log in, publish or use their tokens
+	SuspensionReason string
+	// Two-factor authentication
+	TOTPSecret       string     // Base32 secret of the authenticator app; empty until enrolled
+	TOTPEnabledAt    *time.Time // Set once enrolment is confirmed with a code
+	TOTPLastStep     int64      // Time step of the last code accepted, so a code works only once
 	// Relations
 	Memberships []Membership    `gorm:"foreignKey:UserID"`
 	Resources   []NomadResource `gorm:"foreignKey:UserID"`
@@ -33,8 +41,10 @@ type User struct {
 
 type Organization struct {
 	gorm.Model
-	Name        string `gorm:"uniqueIndex;not null"`
-	Description string
+	Name            string `gorm:"uniqueIndex;not null"`
+	Description     string
+	RolePermissions string `gorm:"type:text"` // JSON object of role to granted permissions; empty for the defaults
+	Require2FA      bool   `gorm:"column:require_2fa;default:false"` // Members need two-factor authentication to use their role
 	// Relations
 	Memberships []Membership    `gorm:"foreignKey:OrganizationID"`
 	Resources   []NomadResource `gorm:"foreignKey:OrganizationID"`
@@ -44,12 +54,49 @@ type Membership struct {
 	gorm.Model
 	UserID         uint   `gorm:"uniqueIndex:idx_user_org"`
 	OrganizationID uint   `gorm:"uniqueIndex:idx_user_org"`
-	Role           string `gorm:"default:'member'"` // 'owner' or 'member'
+	Role           string `gorm:"default:'viewer'"` // owner, maintainer, publisher or viewer
 	// Relations
 	User         User         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 	Organization Organization `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 }
 
+// Roles of an organization Membership, most privileged first. What each may
+// do is set by the organization's permission matrix; owners may do anything.
+const (
+	RoleOwner      = "owner"
+	RoleMaintainer = "maintainer"
+	RolePublisher  = "publisher"
+	RoleViewer     = "viewer"
+)
+
+// OrgRoles lists the membership roles, most privileged first
+var OrgRoles = []string{RoleOwner, RoleMaintainer, RolePublisher, RoleViewer}
+
+// Invitation asks someone, by email, to join an organization in a role. Only
+// a hash of its token is stored; the token itself is in the emailed link.
+type Invitation struct {
+	gorm.Model
+	OrganizationID uint      `gorm:"index;not null"`
+	Email          string    `gorm:"index;not null"`
+	Role           string    `gorm:"not null"`
+	TokenHash      string    `gorm:"uniqueIndex;not null"`
+	InvitedByID    uint      `gorm:"not null"`
+	ExpiresAt      time.Time `gorm:"not null"`
+	// Relations
+	Organization Organization `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	InvitedBy    User         `gorm:"foreignKey:InvitedByID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// Visibilities of a NomadResource. Internal resources are visible to any
+// logged-in user, private ones to the members of their namespace only.
+const (
+	VisibilityPublic   = "public"
+	VisibilityInternal = "internal"
+	VisibilityPrivate  = "private"
+)
+
+var Visibilities = []string{VisibilityPublic, VisibilityInternal, VisibilityPrivate}
+
 type ResourceType string
 
 const (
@@ -65,16 +112,28 @@ type NomadResource struct {
 	Type           ResourceType `gorm:"default:'job'"` // job or pack
 	License        string       // e.g., MIT, Apache-2.0
 	RepositoryURL  string       // Link to GitHub/GitLab
+	GitProvider    string       // github, gitlab, gitea or git; empty to detect from RepositoryURL
+	DefaultBranch  string       // Branch followed by branch-tracking versions; empty for the repository default
 	FilePath       string       // Path to the main .nomad.hcl or pack directory
 	WebhookSecret  string       // Secret for validating incoming webhooks
+	WebhookQuerySecret bool     `gorm:"default:false"` // Also accept the secret as ?secret= (legacy)
 	LastWebhookDelivery time.Time
 	LastWebhookStatus   string // 'success', 'failure'
 	LastWebhookError    string // Error message if failed
 	StarCount      int          `gorm:"default:0"` // Denormalized count for sorting
-	DownloadCount  int          `gorm:"default:0"` // Count of raw HCL fetches
+	DownloadCount  int          `gorm:"default:0"` // Distinct downloads of all versions; see DownloadStat
+	Visibility     string       `gorm:"default:'public';not null;index"` // public, internal or private
 	OrganizationID *uint        `gorm:"uniqueIndex:idx_user_res_name"`
 	UserID         uint         `gorm:"uniqueIndex:idx_user_res_name"`
+	Deprecated         bool   `gorm:"default:false"`
+	DeprecationMessage string // Shown to users of a deprecated resource
+	SuccessorID        *uint  // Resource to use instead, if any
+	HiddenAt           *time.Time // Hidden by a moderator from everyone but admins
+	HiddenReason       string
+	SourceUserID        *uint  // User whose OAuth token fetches the repository of a non-public resource
+	SourceRepositoryURL string // Repository that user's token was bound to; it isn't used for any other
 	// Relations
+	Successor    *NomadResource    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
 	Organization Organization      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
 	User         User              `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 	Tags         []Tag             `gorm:"many2many:resource_tags;"`
@@ -84,15 +143,253 @@ type NomadResource struct {
 
 type ResourceVersion struct {
 	gorm.Model
-	ResourceID uint   `gorm:"index;not null"`
-	Version    string `gorm:"not null"`
-	Readme     string `gorm:"type:text"`
-	Content    string `gorm:"type:text"` // Stores the actual .nomad.hcl content
-	Variables  string `gorm:"type:text"` // JSON string of variables
+	ResourceID  uint   `gorm:"index;not null"`
+	Version     string `gorm:"not null"`
+	Ref         string // Git ref the version is read from; empty for the tag named Version
+	TrackBranch bool   `gorm:"default:false"` // Follows a branch and is refreshed on push
+	CommitSHA   string // Commit the stored content was read from
+	Digest      string // "sha256:<hex>" of the pack archive, or of Content for jobs
+	Signature   string // Publisher's base64 ed25519 signature over Digest
+	SignedBy    string // Fingerprint of the SigningKey that made Signature
+	IngestState string `gorm:"default:'ready';index"` // pending, ready or failed
+	IngestError string // Last ingestion error, if any
+	IngestedAt  *time.Time
+	Yanked      bool   `gorm:"default:false"` // Withdrawn: skipped by latest and listings, still fetchable when pinned
+	YankReason  string
+	YankedAt    *time.Time
+	Readme      string `gorm:"type:text"`
+	Content     string `gorm:"type:text"` // Stores the actual .nomad.hcl content
+	Variables   string `gorm:"type:text"` // JSON string of variables
 }
 
+// Ingestion states of a ResourceVersion. Versions are pending from creation
+// until the ingestion queue has fetched their content.
+const (
+	IngestPending = "pending"
+	IngestReady   = "ready"
+	IngestFailed  = "failed"
+)
+
 type Tag struct {
 	gorm.Model
 	Name      string          `gorm:"uniqueIndex;not null"`
 	Resources []NomadResource `gorm:"many2many:resource_tags;"`
 }
+
+// APIToken is a personal or organization access token used by scripts and CI
+// to call the registry without a browser session. Only the SHA-256 hash of the
+// token is stored; the plaintext is shown once when it is created.
+type APIToken struct {
+	gorm.Model
+	Name           string `gorm:"not null"`
+	TokenHash      string `gorm:"uniqueIndex;not null"`
+	Prefix         string // First characters of the token, for display
+	Scopes         string // Comma-separated list, e.g. "resources:write,versions:write"
+	UserID         uint   `gorm:"index;not null"`
+	OrganizationID *uint  `gorm:"index"` // Set for organization tokens
+	ExpiresAt      *time.Time
+	LastUsedAt     *time.Time
+	RevokedAt      *time.Time
+	// Relations
+	User         User         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Organization Organization `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// SigningKey is an ed25519 public key a user or organization signs releases
+// with. Keys are revoked rather than deleted so clients can tell a signature
+// made with a withdrawn key from an unknown one.
+type SigningKey struct {
+	gorm.Model
+	Name           string `gorm:"not null"`
+	PublicKey      string `gorm:"not null"`       // ed25519:<base64>
+	Fingerprint    string `gorm:"index;not null"` // SHA256:<base64>, see signing.Fingerprint
+	UserID         uint   `gorm:"index;not null"` // Who added the key
+	OrganizationID *uint  `gorm:"index"`          // Set for organization keys
+	RevokedAt      *time.Time
+	// Relations
+	User         User         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Organization Organization `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// VersionArchive is the registry's own snapshot of a pack version, taken at
+// ingestion. Archives of tagged versions are never overwritten, so a version
+// always serves the same bytes even if its tag is moved or deleted upstream.
+// Branch-tracking versions are re-snapshotted when their branch moves.
+type VersionArchive struct {
+	gorm.Model
+	ResourceVersionID uint   `gorm:"uniqueIndex;not null"`
+	Data              []byte `gorm:"type:bytea;not null"` // Normalized tar.gz of the pack tree
+	Size              int64
+	SHA256            string `gorm:"not null"` // Hex digest of Data
+	SourceURL         string // Upstream tarball the snapshot was taken from
+	CommitSHA         string // Commit the snapshot was taken at
+	// Relations
+	ResourceVersion ResourceVersion `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// IngestJob is a queued ingestion of a resource version. Workers claim jobs
+// with SELECT ... FOR UPDATE SKIP LOCKED, so any number of server instances
+// can share the queue. Rows are hard-deleted once the version is ingested or
+// has failed for good, which is why there is no soft-delete column.
+type IngestJob struct {
+	ID                uint `gorm:"primarykey"`
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	ResourceVersionID uint      `gorm:"uniqueIndex;not null"`
+	Attempts          int       `gorm:"default:0"`
+	RunAt             time.Time `gorm:"index;not null"` // Not claimed before this time
+	ClaimToken        string    // Set by the worker that claimed the job, cleared when it's re-queued
+	LastError         string
+	// Relations
+	ResourceVersion ResourceVersion `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// WebhookDelivery records one webhook request to a resource, so owners can
+// see intermittent failures and replay a delivery. Credentials are removed
+// from the stored headers.
+type WebhookDelivery struct {
+	gorm.Model
+	ResourceID uint   `gorm:"index;not null"`
+	Event      string // Event header value, e.g. "push" or "Tag Push Hook"
+	Ref        string // Tag or branch from the payload, e.g. refs/tags/v1.0.0
+	Headers    string `gorm:"type:text"` // JSON object
+	Payload    string `gorm:"type:text"`
+	Status     string // success or failure
+	Error      string
+	DurationMs int64
+	Versions   string // Versions created or refreshed, comma separated
+	ReplayOfID *uint  // Delivery this one replayed
+	Rejected   bool   // Failed verification; stored without headers or payload and never replayed
+	// Relations
+	Resource NomadResource `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// Kinds of client counted in DownloadStat, told apart by User-Agent
+const (
+	DownloadClientCLI       = "cli"
+	DownloadClientBrowser   = "browser"
+	DownloadClientNomadPack = "nomad-pack"
+	DownloadClientOther     = "other"
+)
+
+// DownloadEvent marks that a visitor downloaded a version on a day, so that
+// repeated downloads from one IP count once a day. Only today's events are
+// kept; the counts themselves live in DownloadStat.
+type DownloadEvent struct {
+	ID         uint      `gorm:"primarykey"`
+	ResourceID uint      `gorm:"uniqueIndex:idx_download_event;not null"`
+	Version    string    `gorm:"uniqueIndex:idx_download_event;not null"`
+	Day        time.Time `gorm:"type:date;uniqueIndex:idx_download_event;index;not null"`
+	Visitor    string    `gorm:"uniqueIndex:idx_download_event;not null"` // Hex SHA-256 of the day and client IP
+}
+
+// DownloadStat counts the distinct downloads of a version on a day by one
+// kind of client
+type DownloadStat struct {
+	ID         uint      `gorm:"primarykey"`
+	ResourceID uint      `gorm:"uniqueIndex:idx_download_stat;not null"`
+	Version    string    `gorm:"uniqueIndex:idx_download_stat;not null"`
+	Day        time.Time `gorm:"type:date;uniqueIndex:idx_download_stat;not null"`
+	Client     string    `gorm:"uniqueIndex:idx_download_stat;not null"` // One of the DownloadClient kinds
+	Count      int       `gorm:"not null;default:0"`
+	// Relations
+	Resource NomadResource `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// AuditEvent records an administrative, organization or resource action.
+// Events are only ever added: actor and target names are copied in so they
+// stay readable after the user or resource is gone.
+type AuditEvent struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time `gorm:"index"`
+	ActorID    *uint     `gorm:"index"` // Nil for anonymous requests
+	ActorName  string
+	APITokenID *uint  // Token the request was authenticated with, if any
+	Action     string `gorm:"index;not null"` // e.g. resource.delete
+	TargetType string `gorm:"index;not null"` // user, organization or resource
+	TargetID   uint   `gorm:"index"`
+	TargetName string
+	IP         string
+	Diff       string `gorm:"type:text"` // JSON object of field to {"before", "after"}
+}
+
+// ErrAuditAppendOnly is returned when something tries to change or remove an
+// audit event
+var ErrAuditAppendOnly = errors.New("audit events cannot be changed or deleted")
+
+// BeforeUpdate keeps audit events append-only
+func (AuditEvent) BeforeUpdate(tx *gorm.DB) error { return ErrAuditAppendOnly }
+
+// BeforeDelete keeps audit events append-only
+func (AuditEvent) BeforeDelete(tx *gorm.DB) error { return ErrAuditAppendOnly }
+
+// Statuses of an AbuseReport
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"  // A moderator acted on it, e.g. hid the resource
+	ReportDismissed = "dismissed" // A moderator found nothing wrong
+)
+
+// Reasons a resource can be reported for
+const (
+	ReportSpam      = "spam"
+	ReportMalicious = "malicious"
+	ReportCopyright = "copyright"
+	ReportOther     = "other"
+)
+
+var ReportReasons = []string{ReportSpam, ReportMalicious, ReportCopyright, ReportOther}
+
+// AbuseReport is a user's report of a resource, waiting in the moderation
+// queue until an admin resolves or dismisses it
+type AbuseReport struct {
+	gorm.Model
+	ResourceID   uint   `gorm:"index;not null"`
+	ReporterID   *uint  `gorm:"index"` // Nil once the reporter's account is deleted
+	Reason       string `gorm:"not null"` // One of ReportReasons
+	Details      string `gorm:"type:text"`
+	Status       string `gorm:"default:'open';not null;index"`
+	ResolvedByID *uint
+	ResolvedAt   *time.Time
+	// Relations
+	Resource   NomadResource `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Reporter   *User         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	ResolvedBy *User         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+}
+
+// RecoveryCode is a one-time code that stands in for an authenticator app
+// when logging in. Like API tokens, only the SHA-256 hash is stored.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"index;not null"`
+	CodeHash string `gorm:"not null"`
+	UsedAt   *time.Time
+	// Relations
+	User User `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// SiteSetting is an instance-wide setting admins change at runtime
+type SiteSetting struct {
+	Key       string `gorm:"primaryKey"`
+	Value     string
+	UpdatedAt time.Time
+}
+
+// Keys of SiteSetting
+const (
+	SettingRequireAdmin2FA = "require_admin_2fa" // "true" when admins need two-factor authentication
+)
+
+// Session is a browser session. Only the SHA-256 hash of the session ID in
+// the cookie is stored. Data is the encoded session data; UserID and the
+// device fields are set at login so users can see and revoke their sessions.
+type Session struct {
+	ID         string `gorm:"primaryKey"` // Hash of the session ID
+	Data       []byte
+	ExpiresAt  *time.Time `gorm:"index"`
+	UserID     *uint      `gorm:"index"`
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt *time.Time
+}
@@ -2,16 +2,38 @@ package pack
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
+// manifestFile is written next to each cached pack and records the digest of
+// every extracted file
+const manifestFile = ".ramble-manifest.json"
+
+// maxPackDownload caps the size of a pack tarball
+const maxPackDownload = 100 << 20
+
+// ErrNoManifest is returned by Verify for packs cached without a manifest
+var ErrNoManifest = errors.New("no digest recorded")
+
+// cacheManifest is the content of manifestFile
+type cacheManifest struct {
+	Digest string            `json:"digest"` // Digest of the tarball the pack was extracted from
+	Files  map[string]string `json:"files"`  // Slash-separated path to file digest
+}
+
 // Cache manages locally cached packs
 type Cache struct {
 	Dir string
@@ -55,36 +77,169 @@ func (c *Cache) Load(registry, namespace, name, version string) (string, error)
 	return path, nil
 }
 
-// Store downloads and caches a pack from a tarball URL
-func (c *Cache) Store(registry, namespace, name, version, tarballURL string) (string, error) {
-	packPath := c.PackPath(registry, namespace, name, version)
-
-	// Create cache directory
-	if err := os.MkdirAll(packPath, 0755); err != nil {
-		return "", fmt.Errorf("failed to create cache directory: %w", err)
+// Download fetches a pack archive into memory, refusing archives larger
+// than the cache accepts
+func Download(tarballURL string) ([]byte, error) {
+	req, err := http.NewRequest("GET", tarballURL, nil)
+	if err != nil {
+		return nil, err
 	}
+	req.Header.Set("User-Agent", UserAgent)
+	return fetchArchive(req)
+}
 
-	// Download tarball
-	resp, err := http.Get(tarballURL)
+// fetchArchive performs a download request built by Download or
+// Client.Download
+func fetchArchive(req *http.Request) ([]byte, error) {
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to download pack: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to download pack: status %d", resp.StatusCode)
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPackDownload+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxPackDownload {
+		return nil, fmt.Errorf("larger than %d bytes", maxPackDownload)
+	}
+	return data, nil
+}
+
+// Store downloads and caches a pack from a tarball URL. The tarball is
+// checked against digest before anything is written, and a manifest of the
+// extracted files is kept so the cached copy can be verified later. An empty
+// digest skips the download check.
+func (c *Cache) Store(registry, namespace, name, version, tarballURL, digest string) (string, error) {
+	data, err := Download(tarballURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to download pack: %w", err)
+	}
+	return c.StoreArchive(registry, namespace, name, version, data, digest)
+}
+
+// StoreArchive caches a pack from a tarball that was already downloaded,
+// with the same checks as Store
+func (c *Cache) StoreArchive(registry, namespace, name, version string, data []byte, digest string) (string, error) {
+	packPath := c.PackPath(registry, namespace, name, version)
+
+	if err := VerifyDigest(data, digest); err != nil {
+		return "", fmt.Errorf("refusing to cache %s/%s@%s: %w", namespace, name, version, err)
+	}
+
+	// Replace any earlier, partial copy
+	if err := os.RemoveAll(packPath); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	if err := os.MkdirAll(packPath, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
 	// Extract tarball
-	if err := extractTarGz(resp.Body, packPath); err != nil {
+	files, err := extractTarGz(bytes.NewReader(data), packPath)
+	if err != nil {
 		// Clean up on failure
 		os.RemoveAll(packPath)
 		return "", fmt.Errorf("failed to extract pack: %w", err)
 	}
 
+	manifest, err := json.MarshalIndent(cacheManifest{Digest: Digest(data), Files: files}, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(packPath, manifestFile), manifest, 0644)
+	}
+	if err != nil {
+		os.RemoveAll(packPath)
+		return "", fmt.Errorf("failed to write cache manifest: %w", err)
+	}
+
 	return packPath, nil
 }
 
+// VerifiedDigest checks a cached pack against its manifest and returns the
+// digest of the tarball it was extracted from, so it can be compared with a
+// signed digest
+func (c *Cache) VerifiedDigest(registry, namespace, name, version string) (string, error) {
+	p := CachedPack{
+		Registry: urlToHost(registry), Namespace: namespace, Name: name, Version: version,
+		Path: c.PackPath(registry, namespace, name, version),
+	}
+	if err := c.Verify(p); err != nil {
+		return "", err
+	}
+	raw, err := os.ReadFile(filepath.Join(p.Path, manifestFile))
+	if err != nil {
+		return "", fmt.Errorf("failed to read cache manifest: %w", err)
+	}
+	var manifest cacheManifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return "", fmt.Errorf("invalid cache manifest: %w", err)
+	}
+	return manifest.Digest, nil
+}
+
+// Verify re-checks a cached pack against the manifest written when it was
+// stored. It returns ErrNoManifest for packs cached before manifests were
+// kept, and an error wrapping ErrDigestMismatch listing every file that was
+// changed, added or removed.
+func (c *Cache) Verify(p CachedPack) error {
+	raw, err := os.ReadFile(filepath.Join(p.Path, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNoManifest
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read cache manifest: %w", err)
+	}
+	var manifest cacheManifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return fmt.Errorf("invalid cache manifest: %w", err)
+	}
+
+	var problems []string
+	seen := make(map[string]bool)
+	err = filepath.Walk(p.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(p.Path, path)
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() || rel == manifestFile {
+			return nil
+		}
+		expected, ok := manifest.Files[rel]
+		if !ok || !info.Mode().IsRegular() {
+			problems = append(problems, rel+" was added")
+			return nil
+		}
+		seen[rel] = true
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if Digest(data) != expected {
+			problems = append(problems, rel+" was modified")
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read cached pack: %w", err)
+	}
+	for rel := range manifest.Files {
+		if !seen[rel] {
+			problems = append(problems, rel+" is missing")
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%w: %s", ErrDigestMismatch, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
 // Clear removes all cached packs
 func (c *Cache) Clear() error {
 	return os.RemoveAll(c.Dir)
@@ -139,15 +294,17 @@ func urlToHost(rawURL string) string {
 	return u.Host
 }
 
-// extractTarGz extracts a tar.gz archive to a directory
-func extractTarGz(r io.Reader, destDir string) error {
+// extractTarGz extracts a tar.gz archive to a directory and returns the
+// digest of each file written, keyed by its slash-separated relative path
+func extractTarGz(r io.Reader, destDir string) (map[string]string, error) {
 	gzr, err := gzip.NewReader(r)
 	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
 	}
 	defer gzr.Close()
 
 	tr := tar.NewReader(gzr)
+	files := make(map[string]string)
 
 	// Track the root directory name to strip it
 	var rootDir string
@@ -158,7 +315,7 @@ func extractTarGz(r io.Reader, destDir string) error {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("failed to read tar: %w", err)
+			return nil, fmt.Errorf("failed to read tar: %w", err)
 		}
 
 		// Get the path relative to root
@@ -191,26 +348,29 @@ func extractTarGz(r io.Reader, destDir string) error {
 		switch header.Typeflag {
 		case tar.TypeDir:
 			if err := os.MkdirAll(target, 0755); err != nil {
-				return fmt.Errorf("failed to create directory: %w", err)
+				return nil, fmt.Errorf("failed to create directory: %w", err)
 			}
 		case tar.TypeReg:
 			// Ensure parent directory exists
 			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
-				return fmt.Errorf("failed to create parent directory: %w", err)
+				return nil, fmt.Errorf("failed to create parent directory: %w", err)
 			}
 
 			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
 			if err != nil {
-				return fmt.Errorf("failed to create file: %w", err)
+				return nil, fmt.Errorf("failed to create file: %w", err)
 			}
 
-			if _, err := io.Copy(f, tr); err != nil {
+			h := sha256.New()
+			if _, err := io.Copy(io.MultiWriter(f, h), tr); err != nil {
 				f.Close()
-				return fmt.Errorf("failed to write file: %w", err)
+				return nil, fmt.Errorf("failed to write file: %w", err)
 			}
 			f.Close()
+			rel, _ := filepath.Rel(destDir, target)
+			files[filepath.ToSlash(rel)] = "sha256:" + hex.EncodeToString(h.Sum(nil))
 		}
 	}
 
-	return nil
+	return files, nil
 }
@@ -0,0 +1,114 @@
+package pack
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildTarball returns a tar.gz with files under a "pack-v1/" root, the way
+// the registry serves pack archives
+func buildTarball(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "pack-v1/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "pack-v1/" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+	return buf.Bytes()
+}
+
+func serveTarball(data []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+}
+
+func TestCacheStore_VerifiesDigest(t *testing.T) {
+	tarball := buildTarball(t, map[string]string{
+		"metadata.hcl":                `pack { name = "example" }`,
+		"templates/example.nomad.tpl": `job "example" {}`,
+	})
+	server := serveTarball(tarball)
+	defer server.Close()
+
+	cache := &Cache{Dir: t.TempDir()}
+
+	path, err := cache.Store("registry.example.com", "user", "example", "v1.0.0", server.URL, Digest(tarball))
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(path, "templates", "example.nomad.tpl"))
+	assert.True(t, cache.IsCached("registry.example.com", "user", "example", "v1.0.0"))
+
+	packs, err := cache.List()
+	require.NoError(t, err)
+	require.Len(t, packs, 1)
+	assert.NoError(t, cache.Verify(packs[0]))
+}
+
+func TestCacheStore_RefusesMismatch(t *testing.T) {
+	tarball := buildTarball(t, map[string]string{"metadata.hcl": `pack { name = "example" }`})
+	server := serveTarball(tarball[:len(tarball)-8]) // Truncated in transit
+	defer server.Close()
+
+	cache := &Cache{Dir: t.TempDir()}
+
+	_, err := cache.Store("registry.example.com", "user", "example", "v1.0.0", server.URL, Digest(tarball))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrDigestMismatch))
+	assert.False(t, cache.IsCached("registry.example.com", "user", "example", "v1.0.0"))
+}
+
+func TestCacheVerify_DetectsChanges(t *testing.T) {
+	tarball := buildTarball(t, map[string]string{
+		"metadata.hcl":  `pack { name = "example" }`,
+		"variables.hcl": `variable "count" { default = 1 }`,
+		"README.md":     "# Example",
+	})
+	server := serveTarball(tarball)
+	defer server.Close()
+
+	cache := &Cache{Dir: t.TempDir()}
+	path, err := cache.Store("registry.example.com", "user", "example", "v1.0.0", server.URL, "")
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(path, "variables.hcl"), []byte(`variable "count" { default = 9 }`), 0644))
+	require.NoError(t, os.Remove(filepath.Join(path, "README.md")))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "extra.hcl"), []byte("x"), 0644))
+
+	packs, err := cache.List()
+	require.NoError(t, err)
+	require.Len(t, packs, 1)
+	err = cache.Verify(packs[0])
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrDigestMismatch))
+	assert.Contains(t, err.Error(), "variables.hcl was modified")
+	assert.Contains(t, err.Error(), "README.md is missing")
+	assert.Contains(t, err.Error(), "extra.hcl was added")
+}
+
+func TestCacheVerify_NoManifest(t *testing.T) {
+	cache := &Cache{Dir: t.TempDir()}
+	path := cache.PackPath("registry.example.com", "user", "old", "v1.0.0")
+	require.NoError(t, os.MkdirAll(path, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "metadata.hcl"), []byte("pack {}"), 0644))
+
+	packs, err := cache.List()
+	require.NoError(t, err)
+	require.Len(t, packs, 1)
+	assert.ErrorIs(t, cache.Verify(packs[0]), ErrNoManifest)
+}
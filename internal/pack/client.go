@@ -2,14 +2,25 @@ package pack
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
+
+	"rmbl/internal/semver"
 )
 
+// ErrNotFound is returned when the registry has no such pack or job
+var ErrNotFound = errors.New("not found")
+
+// UserAgent is sent with every registry request and download. The registry
+// counts downloads by kind of client, recognising "ramble-cli" ones.
+var UserAgent = "ramble-cli"
+
 // PackSummary represents a pack in list responses
 type PackSummary struct {
 	Name        string `json:"name"`
@@ -19,15 +30,104 @@ type PackSummary struct {
 
 // PackVersion represents a version with download URL
 type PackVersion struct {
-	Version string `json:"version"`
-	URL     string `json:"url"`
+	Version     string        `json:"version"`
+	URL         string        `json:"url"`
+	Ref         string        `json:"ref,omitempty"`
+	CommitSHA   string        `json:"commit_sha,omitempty"`   // Commit the version was built from
+	IngestState string        `json:"ingest_state,omitempty"` // pending, ready or failed
+	IngestError string        `json:"ingest_error,omitempty"`
+	Digest      string        `json:"digest,omitempty"`      // sha256:<hex> of the content at URL, or of the raw job
+	Signature   string        `json:"signature,omitempty"`   // Publisher's signature over Digest, see CheckSignature
+	SigningKey  string        `json:"signing_key,omitempty"` // Fingerprint of the key that made Signature
+	Yanked      bool          `json:"yanked,omitempty"`      // Withdrawn; only used when pinned exactly
+	YankReason  string        `json:"yank_reason,omitempty"`
+	Readme      string        `json:"readme,omitempty"`
+	Variables   []VariableDoc `json:"variables,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+}
+
+// VariableDoc is the name and description of an input variable of a pack or
+// job version, as the registry lists it
+type VariableDoc struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Deprecation is the notice of a deprecated pack or job
+type Deprecation struct {
+	Message   string `json:"message,omitempty"`
+	Successor string `json:"successor,omitempty"` // namespace/name to use instead
+}
+
+// ResolveVersion picks the version a reference stands for: an exact version
+// name, a constraint such as "~1.4" or ">=2.0,<3", or "" for the latest
+// release. Pre-releases are only chosen when asked for, and yanked versions
+// only when named exactly.
+func ResolveVersion(versions []PackVersion, ref string) (PackVersion, error) {
+	var names []string
+	for _, v := range versions {
+		if v.Version == ref {
+			return v, nil
+		}
+		if !v.Yanked {
+			names = append(names, v.Version)
+		}
+	}
+	name, err := semver.Resolve(ref, names)
+	if err != nil {
+		return PackVersion{}, err
+	}
+	for _, v := range versions {
+		if v.Version == name {
+			return v, nil
+		}
+	}
+	return PackVersion{}, fmt.Errorf("version not found: %s", name)
+}
+
+// Warning describes the deprecation for display, e.g. "user1/mysql is
+// deprecated: No longer maintained (use user1/mariadb instead)"
+func (d *Deprecation) Warning(name string) string {
+	w := name + " is deprecated"
+	if d.Message != "" {
+		w += ": " + d.Message
+	}
+	if d.Successor != "" {
+		w += " (use " + d.Successor + " instead)"
+	}
+	return w
+}
+
+// YankWarning describes a yanked version for display, or returns "" if the
+// version is not yanked
+func (v PackVersion) YankWarning() string {
+	if !v.Yanked {
+		return ""
+	}
+	w := "version " + v.Version + " has been yanked"
+	if v.YankReason != "" {
+		w += ": " + v.YankReason
+	}
+	return w
 }
 
 // PackDetail represents detailed pack information
 type PackDetail struct {
-	Name        string        `json:"name"`
-	Description string        `json:"description"`
-	Versions    []PackVersion `json:"versions"`
+	ID            uint          `json:"id"`
+	Name          string        `json:"name"`
+	Namespace     string        `json:"namespace"`            // Owning user or organization
+	Type          string        `json:"type"`                 // pack or job
+	Visibility    string        `json:"visibility,omitempty"` // public, internal or private
+	Description   string        `json:"description"`
+	License       string        `json:"license,omitempty"`
+	RepositoryURL string        `json:"repository_url,omitempty"`
+	Tags          []string      `json:"tags"`
+	Stars         int           `json:"stars"`
+	Downloads     int           `json:"downloads"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+	Versions      []PackVersion `json:"versions"` // Newest first
+	Deprecation   *Deprecation  `json:"deprecation,omitempty"`
 }
 
 // RegistryListResponse wraps the registry list API response
@@ -49,9 +149,21 @@ type JobSummary struct {
 
 // JobDetail represents detailed job information
 type JobDetail struct {
-	Name        string        `json:"name"`
-	Description string        `json:"description"`
-	Versions    []PackVersion `json:"versions"`
+	ID            uint          `json:"id"`
+	Name          string        `json:"name"`
+	Namespace     string        `json:"namespace"`
+	Type          string        `json:"type"`
+	Visibility    string        `json:"visibility,omitempty"`
+	Description   string        `json:"description"`
+	License       string        `json:"license,omitempty"`
+	RepositoryURL string        `json:"repository_url,omitempty"`
+	Tags          []string      `json:"tags"`
+	Stars         int           `json:"stars"`
+	Downloads     int           `json:"downloads"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+	Versions      []PackVersion `json:"versions"`
+	Deprecation   *Deprecation  `json:"deprecation,omitempty"`
 }
 
 // JobListResponse wraps the job list API response
@@ -59,9 +171,89 @@ type JobListResponse struct {
 	Jobs []JobSummary `json:"jobs"`
 }
 
+// SearchOptions are the filters and sort of a registry search. Empty fields
+// are not sent.
+type SearchOptions struct {
+	Query     string
+	Type      string // pack or job
+	Tag       string
+	Namespace string
+	License   string
+	Sort      string // relevance, updated, stars or downloads
+	Limit     int    // Results per page; the registry's default when 0
+	Cursor    string // NextCursor of the previous page
+}
+
+// SearchResult is a pack or job found by Search
+type SearchResult struct {
+	Name          string   `json:"name"`
+	Namespace     string   `json:"namespace"`
+	Type          string   `json:"type"`
+	Description   string   `json:"description"`
+	License       string   `json:"license,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	LatestVersion string   `json:"latest_version,omitempty"`
+	Stars         int      `json:"stars"`
+	Downloads     int      `json:"downloads"`
+	Deprecated    bool     `json:"deprecated,omitempty"`
+}
+
+// FacetCount is how many matches have a value of a facet
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// SearchPage is one page of search results
+type SearchPage struct {
+	Results    []SearchResult          `json:"results"`
+	Total      int64                   `json:"total"`
+	NextCursor string                  `json:"next_cursor,omitempty"`
+	Facets     map[string][]FacetCount `json:"facets"`
+}
+
+// CurrentUser is the account an API token belongs to
+type CurrentUser struct {
+	Username          string   `json:"username"`
+	Name              string   `json:"name"`
+	Organizations     []string `json:"organizations"`
+	Scopes            []string `json:"scopes"`
+	TokenOrganization string   `json:"token_organization,omitempty"`
+}
+
+// NewResource describes a resource to create in the registry
+type NewResource struct {
+	Name          string
+	Type          string // "pack" or "job"
+	Owner         string // "user" or "org:<name>"
+	Description   string
+	RepositoryURL string
+	FilePath      string
+	Version       string
+	License       string
+	Tags          []string
+}
+
+// Resource is returned after a resource is created or changed
+type Resource struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Type      string `json:"type"`
+	URL       string `json:"url"`
+}
+
+// Version is returned after a version is published
+type Version struct {
+	ID         uint   `json:"id"`
+	ResourceID uint   `json:"resource_id"`
+	Version    string `json:"version"`
+}
+
 // Client is an HTTP client for the Ramble registry API
 type Client struct {
 	BaseURL    string
+	Token      string // Optional API token sent as a Bearer credential
 	HTTPClient *http.Client
 }
 
@@ -174,7 +366,7 @@ func (c *Client) GetPack(namespace, name string) (*PackDetail, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("pack not found: %s/%s", namespace, name)
+		return nil, fmt.Errorf("pack %w: %s/%s", ErrNotFound, namespace, name)
 	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
@@ -238,7 +430,7 @@ func (c *Client) GetJob(namespace, name string) (*JobDetail, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("job not found: %s/%s", namespace, name)
+		return nil, fmt.Errorf("job %w: %s/%s", ErrNotFound, namespace, name)
 	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
@@ -272,7 +464,69 @@ func (c *Client) SearchJobs(query string) ([]JobSummary, error) {
 	return result.Jobs, nil
 }
 
-// GetRawContent fetches raw pack content (HCL)
+// Search returns a page of results from the registry's /v1/search API. It
+// returns ErrNotFound from registries that predate it.
+func (c *Client) Search(opts SearchOptions) (*SearchPage, error) {
+	params := url.Values{}
+	for key, value := range map[string]string{
+		"q": opts.Query, "type": opts.Type, "tag": opts.Tag, "namespace": opts.Namespace,
+		"license": opts.License, "sort": opts.Sort, "cursor": opts.Cursor,
+	} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+	if opts.Limit > 0 {
+		params.Set("limit", strconv.Itoa(opts.Limit))
+	}
+
+	resp, err := c.get("/v1/search?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("search API %w", ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	var page SearchPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &page, nil
+}
+
+// maxSearchPage is the largest page the registry's search API returns
+const maxSearchPage = 100
+
+// SearchAll follows Search's cursors and returns up to max results, or all
+// of them when max is 0
+func (c *Client) SearchAll(opts SearchOptions, max int) ([]SearchResult, error) {
+	pageSize := opts.Limit
+	var results []SearchResult
+	for {
+		opts.Limit = pageSize
+		if remaining := max - len(results); max > 0 && (pageSize == 0 || pageSize > remaining) {
+			opts.Limit = min(remaining, maxSearchPage)
+		}
+		page, err := c.Search(opts)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, page.Results...)
+		if page.NextCursor == "" || (max > 0 && len(results) >= max) {
+			return results, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// GetRawContent fetches raw pack content (HCL). The content is checked
+// against the registry's X-Ramble-Digest header when one is sent.
 func (c *Client) GetRawContent(namespace, name, version string) (string, error) {
 	var path string
 	if version != "" {
@@ -298,15 +552,168 @@ func (c *Client) GetRawContent(namespace, name, version string) (string, error)
 	if err != nil {
 		return "", fmt.Errorf("failed to read response: %w", err)
 	}
+	if err := VerifyDigest(body, resp.Header.Get("X-Ramble-Digest")); err != nil {
+		return "", err
+	}
 
 	return string(body), nil
 }
 
+// WhoAmI returns the account the client's token belongs to
+func (c *Client) WhoAmI() (*CurrentUser, error) {
+	if c.Token == "" {
+		return nil, fmt.Errorf("not logged in")
+	}
+
+	resp, err := c.getJSON("/v1/user")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	var result CurrentUser
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// CreateResource registers a new resource along with its first version
+func (c *Client) CreateResource(r NewResource) (*Resource, error) {
+	form := url.Values{}
+	form.Set("name", r.Name)
+	form.Set("type", r.Type)
+	form.Set("owner", r.Owner)
+	form.Set("description", r.Description)
+	form.Set("repository_url", r.RepositoryURL)
+	form.Set("file_path", r.FilePath)
+	form.Set("version", r.Version)
+	form.Set("license", r.License)
+	form.Set("tags", strings.Join(r.Tags, ","))
+
+	resp, err := c.postForm("/new", form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, responseError(resp)
+	}
+
+	var result Resource
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// AddVersion publishes a new version of an existing resource
+func (c *Client) AddVersion(resourceID uint, version string) (*Version, error) {
+	form := url.Values{}
+	form.Set("version", version)
+
+	resp, err := c.postForm(fmt.Sprintf("/resource/%d/version", resourceID), form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, responseError(resp)
+	}
+
+	var result Version
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// GetSigningKeys returns the signing keys a namespace has registered,
+// including revoked ones
+func (c *Client) GetSigningKeys(namespace string) ([]SigningKey, error) {
+	resp, err := c.getJSON("/v1/keys/" + url.PathEscape(namespace))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("namespace %w: %s", ErrNotFound, namespace)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Keys []SigningKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return result.Keys, nil
+}
+
+// SignVersion attaches a signature over a version's digest, made with the
+// key with the given fingerprint
+func (c *Client) SignVersion(resourceID uint, version, signature, fingerprint string) error {
+	form := url.Values{}
+	form.Set("signature", signature)
+	form.Set("key", fingerprint)
+
+	resp, err := c.postForm(fmt.Sprintf("/resource/%d/versions/%s/signature", resourceID, url.PathEscape(version)), form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// Download fetches a pack archive like the package-level Download. Archives
+// the registry serves itself are fetched with the client's token, so
+// internal and private packs can be downloaded; other hosts never see it.
+func (c *Client) Download(tarballURL string) ([]byte, error) {
+	if c.Token == "" || !strings.HasPrefix(tarballURL, c.BaseURL+"/") {
+		return Download(tarballURL)
+	}
+	req, err := c.newRequest("GET", strings.TrimPrefix(tarballURL, c.BaseURL), nil)
+	if err != nil {
+		return nil, err
+	}
+	return fetchArchive(req)
+}
+
+// newRequest builds a request against the registry, attaching the token if set
+func (c *Client) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return req, nil
+}
+
 // get performs a GET request to the specified path
 func (c *Client) get(path string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", c.BaseURL+path, nil)
+	req, err := c.newRequest("GET", path, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
 
 	return c.HTTPClient.Do(req)
@@ -314,11 +721,35 @@ func (c *Client) get(path string) (*http.Response, error) {
 
 // getJSON performs a GET request with Accept: application/json header
 func (c *Client) getJSON(path string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", c.BaseURL+path, nil)
+	req, err := c.newRequest("GET", path, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	return c.HTTPClient.Do(req)
+}
+
+// postForm performs a form-encoded POST request to the specified path
+func (c *Client) postForm(path string, form url.Values) (*http.Response, error) {
+	req, err := c.newRequest("POST", path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 
 	return c.HTTPClient.Do(req)
 }
+
+// responseError turns a non-success response into an error, using the
+// server's {"error": "..."} message when there is one
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err == nil && body.Error != "" {
+		return fmt.Errorf("%s (status %d)", body.Error, resp.StatusCode)
+	}
+	return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+}
@@ -6,6 +6,8 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"rmbl/internal/signing"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -92,9 +94,14 @@ func TestGetPack(t *testing.T) {
 		assert.Equal(t, "/myuser/mypack", r.URL.Path)
 		json.NewEncoder(w).Encode(PackDetail{
 			Name:        "mypack",
+			Namespace:   "myuser",
 			Description: "My pack description",
+			License:     "MIT",
+			Tags:        []string{"database"},
+			Stars:       3,
 			Versions: []PackVersion{
-				{Version: "v1.0.0", URL: "https://example.com/v1.0.0.tar.gz"},
+				{Version: "v1.0.0", URL: "https://example.com/v1.0.0.tar.gz", Readme: "# mypack",
+					Variables: []VariableDoc{{Name: "port", Description: "Port to listen on"}}},
 				{Version: "v0.9.0", URL: "https://example.com/v0.9.0.tar.gz"},
 			},
 		})
@@ -106,8 +113,14 @@ func TestGetPack(t *testing.T) {
 
 	require.NoError(t, err)
 	assert.Equal(t, "mypack", detail.Name)
+	assert.Equal(t, "myuser", detail.Namespace)
+	assert.Equal(t, "MIT", detail.License)
+	assert.Equal(t, []string{"database"}, detail.Tags)
+	assert.Equal(t, 3, detail.Stars)
 	assert.Len(t, detail.Versions, 2)
 	assert.Equal(t, "v1.0.0", detail.Versions[0].Version)
+	assert.Equal(t, "# mypack", detail.Versions[0].Readme)
+	assert.Equal(t, "port", detail.Versions[0].Variables[0].Name)
 }
 
 func TestGetPackNotFound(t *testing.T) {
@@ -143,6 +156,41 @@ func TestSearchPacks(t *testing.T) {
 	assert.Equal(t, "mysql", packs[0].Name)
 }
 
+func TestSearchAll(t *testing.T) {
+	pages := map[string]SearchPage{
+		"":      {Results: []SearchResult{{Name: "mysql"}, {Name: "mariadb"}}, Total: 3, NextCursor: "page2"},
+		"page2": {Results: []SearchResult{{Name: "postgres"}}, Total: 3},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/search", r.URL.Path)
+		assert.Equal(t, "pack", r.URL.Query().Get("type"))
+		assert.Equal(t, "database", r.URL.Query().Get("tag"))
+		json.NewEncoder(w).Encode(pages[r.URL.Query().Get("cursor")])
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	opts := SearchOptions{Type: "pack", Tag: "database"}
+	results, err := client.SearchAll(opts, 0)
+	require.NoError(t, err)
+	assert.Len(t, results, 3)
+
+	// A maximum stops paging early
+	results, err = client.SearchAll(opts, 2)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+}
+
+func TestSearch_NotSupported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL).Search(SearchOptions{Query: "mysql"})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
 func TestListAllJobs(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "/v1/jobs", r.URL.Path)
@@ -206,6 +254,26 @@ func TestGetRawContent(t *testing.T) {
 	assert.Contains(t, content, "version")
 }
 
+func TestGetRawContent_DigestMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Ramble-Digest", Digest([]byte("job \"example\" {}")))
+		w.Write([]byte("job \"exam"))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	_, err := client.GetRawContent("user", "job", "v1.0.0")
+	assert.ErrorIs(t, err, ErrDigestMismatch)
+}
+
+func TestVerifyDigest(t *testing.T) {
+	data := []byte("job \"example\" {}")
+	assert.NoError(t, VerifyDigest(data, Digest(data)))
+	assert.NoError(t, VerifyDigest(data, ""))
+	assert.ErrorIs(t, VerifyDigest([]byte("other"), Digest(data)), ErrDigestMismatch)
+	assert.Error(t, VerifyDigest(data, "md5:abc"))
+}
+
 func TestListRegistries(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "/v1/registries", r.URL.Path)
@@ -222,3 +290,219 @@ func TestListRegistries(t *testing.T) {
 	assert.Len(t, registries, 3)
 	assert.Contains(t, registries, "user1")
 }
+
+func TestWhoAmI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/user", r.URL.Path)
+		assert.Equal(t, "Bearer rmbl_secret", r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode(CurrentUser{Username: "alice", Organizations: []string{"acme"}})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.Token = "rmbl_secret"
+	user, err := client.WhoAmI()
+
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.Username)
+	assert.Equal(t, []string{"acme"}, user.Organizations)
+}
+
+func TestWhoAmI_NoToken(t *testing.T) {
+	client := NewClient("https://example.com")
+	_, err := client.WhoAmI()
+	assert.Error(t, err)
+}
+
+func TestWhoAmI_InvalidToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "API token has expired"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.Token = "rmbl_old"
+	_, err := client.WhoAmI()
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "API token has expired")
+}
+
+func TestCreateResource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/new", r.URL.Path)
+		assert.Equal(t, "Bearer rmbl_secret", r.Header.Get("Authorization"))
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "mysql", r.PostForm.Get("name"))
+		assert.Equal(t, "org:acme", r.PostForm.Get("owner"))
+		assert.Equal(t, "v1.0.0", r.PostForm.Get("version"))
+		assert.Equal(t, "db,sql", r.PostForm.Get("tags"))
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(Resource{ID: 7, Name: "mysql", Namespace: "acme"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.Token = "rmbl_secret"
+	res, err := client.CreateResource(NewResource{
+		Name:    "mysql",
+		Type:    "pack",
+		Owner:   "org:acme",
+		Version: "v1.0.0",
+		Tags:    []string{"db", "sql"},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, uint(7), res.ID)
+	assert.Equal(t, "acme", res.Namespace)
+}
+
+func TestAddVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/resource/7/version", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "v1.1.0", r.PostForm.Get("version"))
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(Version{ID: 3, ResourceID: 7, Version: "v1.1.0"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.Token = "rmbl_secret"
+	v, err := client.AddVersion(7, "v1.1.0")
+
+	require.NoError(t, err)
+	assert.Equal(t, "v1.1.0", v.Version)
+}
+
+func TestAddVersion_Conflict(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Version v1.0.0 already exists"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.Token = "rmbl_secret"
+	_, err := client.AddVersion(7, "v1.0.0")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already exists")
+}
+
+func TestResolveVersion(t *testing.T) {
+	// Registries list newest first, so a back-ported release comes first
+	versions := []PackVersion{
+		{Version: "v1.2.9", URL: "https://example.com/v1.2.9.tar.gz"},
+		{Version: "v2.1.0-rc.1"},
+		{Version: "v2.0.0", URL: "https://example.com/v2.0.0.tar.gz"},
+		{Version: "v1.4.7"},
+	}
+
+	latest, err := ResolveVersion(versions, "")
+	require.NoError(t, err)
+	assert.Equal(t, "v2.0.0", latest.Version)
+	assert.Equal(t, "https://example.com/v2.0.0.tar.gz", latest.URL)
+
+	v, err := ResolveVersion(versions, "~1.4")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.4.7", v.Version)
+
+	v, err = ResolveVersion(versions, "v1.2.9")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.2.9", v.Version)
+
+	_, err = ResolveVersion(versions, ">=3")
+	assert.Error(t, err)
+}
+
+func TestResolveVersion_Yanked(t *testing.T) {
+	versions := []PackVersion{
+		{Version: "v2.0.0", Yanked: true, YankReason: "broken migration"},
+		{Version: "v1.9.0"},
+	}
+
+	latest, err := ResolveVersion(versions, "")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.9.0", latest.Version)
+
+	v, err := ResolveVersion(versions, "^2")
+	assert.Error(t, err)
+
+	// Exact pins still resolve, with a warning to show
+	v, err = ResolveVersion(versions, "v2.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, "version v2.0.0 has been yanked: broken migration", v.YankWarning())
+	assert.Empty(t, latest.YankWarning())
+}
+
+func TestDeprecationWarning(t *testing.T) {
+	d := &Deprecation{Message: "No longer maintained", Successor: "user1/mariadb"}
+	assert.Equal(t, "user1/mysql is deprecated: No longer maintained (use user1/mariadb instead)", d.Warning("user1/mysql"))
+	assert.Equal(t, "user1/mysql is deprecated", (&Deprecation{}).Warning("user1/mysql"))
+}
+
+func TestCheckSignature(t *testing.T) {
+	pub, priv, err := signing.GenerateKey()
+	require.NoError(t, err)
+	other, _, err := signing.GenerateKey()
+	require.NoError(t, err)
+
+	digest := Digest([]byte("job \"example\" {}"))
+	signed := PackVersion{Version: "v1.0.0", Digest: digest, Signature: signing.Sign(priv, digest), SigningKey: signing.Fingerprint(pub)}
+	trusted := []string{signing.FormatPublicKey(other), signing.FormatPublicKey(pub)}
+
+	assert.NoError(t, CheckSignature(signed, trusted))
+	assert.ErrorIs(t, CheckSignature(signed, []string{signing.FormatPublicKey(other)}), signing.ErrBadSignature)
+	assert.ErrorIs(t, CheckSignature(PackVersion{Version: "v1.0.0", Digest: digest}, trusted), ErrUnsigned)
+
+	// A signature copied onto other content does not verify
+	tampered := signed
+	tampered.Digest = Digest([]byte("job \"evil\" {}"))
+	assert.ErrorIs(t, CheckSignature(tampered, trusted), signing.ErrBadSignature)
+}
+
+func TestGetSigningKeys(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/keys/myorg", r.URL.Path)
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]any{
+				{"name": "old", "public_key": "ed25519:AAAA", "fingerprint": "SHA256:a", "revoked": true},
+				{"name": "ci", "public_key": "ed25519:BBBB", "fingerprint": "SHA256:b"},
+			},
+		})
+	}))
+	defer server.Close()
+
+	keys, err := NewClient(server.URL).GetSigningKeys("myorg")
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.Equal(t, []string{"ed25519:BBBB"}, ActiveKeys(keys))
+}
+
+func TestClientDownload_TokenOnlyForRegistry(t *testing.T) {
+	var auth []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		w.Write([]byte("archive"))
+	})
+	registry := httptest.NewServer(handler)
+	defer registry.Close()
+	elsewhere := httptest.NewServer(handler)
+	defer elsewhere.Close()
+
+	client := NewClient(registry.URL)
+	client.Token = "rmbl_secret"
+
+	data, err := client.Download(registry.URL + "/acme/private-pack/v/v1.0.0/archive.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, "archive", string(data))
+	_, err = client.Download(elsewhere.URL + "/archive.tar.gz")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Bearer rmbl_secret", ""}, auth)
+}
@@ -0,0 +1,35 @@
+package pack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrDigestMismatch is returned when downloaded or cached content does not
+// match the digest the registry published for it
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// Digest returns the digest of data in the registry's "sha256:<hex>" form
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// VerifyDigest checks data against a digest published by the registry. An
+// empty digest is accepted, as registries that predate digests don't send
+// one.
+func VerifyDigest(data []byte, digest string) error {
+	if digest == "" {
+		return nil
+	}
+	if !strings.HasPrefix(digest, "sha256:") {
+		return fmt.Errorf("unsupported digest %q", digest)
+	}
+	if got := Digest(data); !strings.EqualFold(got, digest) {
+		return fmt.Errorf("%w: expected %s, got %s", ErrDigestMismatch, digest, got)
+	}
+	return nil
+}
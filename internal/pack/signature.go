@@ -0,0 +1,56 @@
+package pack
+
+import (
+	"errors"
+	"fmt"
+
+	"rmbl/internal/signing"
+)
+
+// ErrUnsigned is returned by CheckSignature for versions without a signature
+var ErrUnsigned = errors.New("version is not signed")
+
+// SigningKey is a publisher's public key as listed by the registry
+type SigningKey struct {
+	Name        string `json:"name"`
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+	Revoked     bool   `json:"revoked,omitempty"`
+}
+
+// ActiveKeys returns the public keys of the keys that are not revoked
+func ActiveKeys(keys []SigningKey) []string {
+	var active []string
+	for _, k := range keys {
+		if !k.Revoked {
+			active = append(active, k.PublicKey)
+		}
+	}
+	return active
+}
+
+// CheckSignature verifies that a version's signature over its digest was
+// made with one of the given public keys. It returns ErrUnsigned if the
+// version has no signature, and an error wrapping signing.ErrBadSignature if
+// no key matches.
+func CheckSignature(v PackVersion, keys []string) error {
+	if v.Signature == "" {
+		return ErrUnsigned
+	}
+	if v.Digest == "" {
+		return fmt.Errorf("version %s has a signature but no digest", v.Version)
+	}
+	for _, k := range keys {
+		pub, err := signing.ParsePublicKey(k)
+		if err != nil {
+			continue
+		}
+		if signing.Verify(pub, v.Digest, v.Signature) == nil {
+			return nil
+		}
+	}
+	if v.SigningKey != "" {
+		return fmt.Errorf("%w: signed by %s, which is not a trusted key", signing.ErrBadSignature, v.SigningKey)
+	}
+	return signing.ErrBadSignature
+}
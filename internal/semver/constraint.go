@@ -0,0 +1,237 @@
+package semver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// comparator is a single bound such as ">=1.4.0"
+type comparator struct {
+	op string // One of = != > >= < <=
+	v  Version
+}
+
+func (c comparator) check(v Version) bool {
+	cmp := Compare(v, c.v)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// Constraint is a set of version ranges. Comparators separated by commas must
+// all match; alternatives separated by "||" need only one to match.
+//
+// Supported forms:
+//
+//	1.4.2, =1.4.2   exactly 1.4.2
+//	1.4, 1.4.x      any 1.4 release (>=1.4.0, <1.5.0)
+//	>=2.0, <3       2.x releases
+//	~1.4.2          >=1.4.2, <1.5.0
+//	^1.4.2          >=1.4.2, <2.0.0 (below 1.0 the minor version is fixed)
+//	!=1.4.3         anything but 1.4.3
+//
+// Pre-releases only match a range that names a pre-release of the same
+// major, minor and patch version, so "~1.4" never selects "1.5.0-rc.1".
+type Constraint struct {
+	sets     [][]comparator
+	original string
+}
+
+// ParseConstraint parses a constraint such as "~1.4" or ">=2.0,<3"
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{original: s}
+	for _, alt := range strings.Split(s, "||") {
+		var set []comparator
+		for _, part := range strings.Split(alt, ",") {
+			part = strings.Join(strings.Fields(part), "")
+			if part == "" {
+				return Constraint{}, fmt.Errorf("invalid constraint %q: empty range", s)
+			}
+			comps, err := parseComparator(part)
+			if err != nil {
+				return Constraint{}, fmt.Errorf("invalid constraint %q: %w", s, err)
+			}
+			set = append(set, comps...)
+		}
+		c.sets = append(c.sets, set)
+	}
+	return c, nil
+}
+
+// parseComparator expands one range into the bounds it stands for
+func parseComparator(s string) ([]comparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", "!=", ">", "<", "=", "~", "^"} {
+		if strings.HasPrefix(s, prefix) {
+			op, s = prefix, s[len(prefix):]
+			break
+		}
+	}
+
+	v, n, err := parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		n = -1 - n // Components given before the wildcard
+	}
+	lo := Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch, Prerelease: v.Prerelease}
+	hi := bump(lo, n) // First version past the range a partial version covers
+
+	switch op {
+	case "", "=":
+		if n == 3 {
+			return []comparator{{"=", lo}}, nil
+		}
+		return boundedRange(lo, hi, n), nil
+	case "!=":
+		if n == 3 {
+			return []comparator{{"!=", lo}}, nil
+		}
+		return nil, fmt.Errorf("%q needs a full version", "!=")
+	case ">":
+		if n == 3 {
+			return []comparator{{">", lo}}, nil
+		}
+		if n == 0 {
+			return []comparator{{"<", Version{}}}, nil // Nothing is above "*"
+		}
+		return []comparator{{">=", hi}}, nil
+	case ">=":
+		return []comparator{{">=", lo}}, nil
+	case "<":
+		return []comparator{{"<", lo}}, nil
+	case "<=":
+		if n == 3 {
+			return []comparator{{"<=", lo}}, nil
+		}
+		return boundedRange(Version{}, hi, n), nil
+	case "~":
+		if n == 3 {
+			return []comparator{{">=", lo}, {"<", bump(lo, 2)}}, nil
+		}
+		return boundedRange(lo, hi, n), nil
+	case "^":
+		if n == 0 {
+			return nil, nil
+		}
+		// Allow changes below the left-most non-zero component given
+		fixed := 1
+		if lo.Major == 0 && n >= 2 {
+			fixed = 2
+			if lo.Minor == 0 && n == 3 {
+				fixed = 3
+			}
+		}
+		return []comparator{{">=", lo}, {"<", bump(lo, fixed)}}, nil
+	}
+	return nil, fmt.Errorf("unknown operator in %q", s)
+}
+
+// boundedRange returns >=lo, <hi, or nothing for "*"
+func boundedRange(lo, hi Version, n int) []comparator {
+	if n == 0 {
+		return nil
+	}
+	return []comparator{{">=", lo}, {"<", hi}}
+}
+
+// bump returns the lowest version above every version that starts with the
+// first n components of v: bump(1.4.2, 2) is 1.5.0
+func bump(v Version, n int) Version {
+	switch n {
+	case 1:
+		return Version{Major: v.Major + 1}
+	case 2:
+		return Version{Major: v.Major, Minor: v.Minor + 1}
+	case 3:
+		return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+	}
+	return Version{}
+}
+
+// Check reports whether v satisfies the constraint
+func (c Constraint) Check(v Version) bool {
+	for _, set := range c.sets {
+		if matchesSet(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesSet(set []comparator, v Version) bool {
+	for _, comp := range set {
+		if !comp.check(v) {
+			return false
+		}
+	}
+	if !v.IsPrerelease() {
+		return true
+	}
+	for _, comp := range set {
+		if comp.v.IsPrerelease() && comp.v.Major == v.Major && comp.v.Minor == v.Minor && comp.v.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the constraint as it was written
+func (c Constraint) String() string {
+	return c.original
+}
+
+// Resolve picks the version a reference such as "v1.4.2", "~1.4" or
+// ">=2.0,<3" stands for among names. A name that matches the reference
+// exactly is always used, so tags that are not semantic versions can still
+// be asked for. An empty reference means the latest release; when there are
+// no releases the first name is used, as registries list newest first.
+func Resolve(ref string, names []string) (string, error) {
+	if len(names) == 0 {
+		return "", fmt.Errorf("no versions available")
+	}
+	for _, name := range names {
+		if name == ref {
+			return name, nil
+		}
+	}
+	if ref == "" {
+		if latest := Latest(names); latest != "" {
+			return latest, nil
+		}
+		return names[0], nil
+	}
+
+	c, err := ParseConstraint(ref)
+	if err != nil {
+		return "", err
+	}
+	var best string
+	var bestVersion Version
+	for _, name := range names {
+		v, err := Parse(name)
+		if err != nil || !c.Check(v) {
+			continue
+		}
+		if best == "" || Compare(v, bestVersion) > 0 {
+			best, bestVersion = name, v
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("no version matches %q", ref)
+	}
+	return best, nil
+}
@@ -0,0 +1,185 @@
+// Package semver parses, orders and matches semantic versions. It is shared
+// by the registry, which orders a resource's versions, and the CLI, which
+// resolves constraints such as "~1.4" against a pack's version list.
+//
+// Versions may carry a leading "v" and may leave out the minor and patch
+// numbers, so "v1", "1.4" and "1.4.0" all parse. Build metadata is ignored.
+package semver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed semantic version
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string // e.g. "rc.1"; empty for releases
+	Original   string // The string it was parsed from
+}
+
+// Parse parses a version such as "v1.2.3", "1.4" or "2.0.0-rc.1"
+func Parse(s string) (Version, error) {
+	v, parts, err := parse(s)
+	if err != nil {
+		return Version{}, err
+	}
+	if parts < 0 {
+		return Version{}, fmt.Errorf("invalid version %q: wildcards are only allowed in constraints", s)
+	}
+	return v, nil
+}
+
+// parse parses a version that may end in a wildcard ("1.x", "1.4.*") or
+// leave out components. It returns how many numeric components were given,
+// or -1-n when component n+1 is a wildcard.
+func parse(s string) (Version, int, error) {
+	v := Version{Original: s}
+	str := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(str, '+'); i >= 0 {
+		str = str[:i]
+	}
+	if i := strings.IndexByte(str, '-'); i >= 0 {
+		v.Prerelease = str[i+1:]
+		str = str[:i]
+		if v.Prerelease == "" {
+			return Version{}, 0, fmt.Errorf("invalid version %q: empty pre-release", s)
+		}
+	}
+
+	fields := strings.Split(str, ".")
+	if str == "" || len(fields) > 3 {
+		return Version{}, 0, fmt.Errorf("invalid version %q", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, f := range fields {
+		if f == "x" || f == "X" || f == "*" {
+			if i != len(fields)-1 || v.Prerelease != "" {
+				return Version{}, 0, fmt.Errorf("invalid version %q: wildcard must be last", s)
+			}
+			return v, -1 - i, nil
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 || (len(f) > 1 && f[0] == '0') {
+			return Version{}, 0, fmt.Errorf("invalid version %q", s)
+		}
+		*nums[i] = n
+	}
+	return v, len(fields), nil
+}
+
+// IsPrerelease reports whether v is a pre-release such as "2.0.0-rc.1"
+func (v Version) IsPrerelease() bool {
+	return v.Prerelease != ""
+}
+
+// String returns the canonical form of v, without a leading "v"
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 as a is lower than, equal to or higher than b,
+// following semver precedence: a pre-release is lower than its release.
+func Compare(a, b Version) int {
+	if c := compareInt(a.Major, b.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(a.Minor, b.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(a.Patch, b.Patch); c != 0 {
+		return c
+	}
+	return comparePrerelease(a.Prerelease, b.Prerelease)
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// comparePrerelease compares dot-separated identifiers: numeric ones
+// numerically, others lexically, and numeric below alphanumeric
+func comparePrerelease(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" {
+		return 1
+	}
+	if b == "" {
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if c := compareInt(an, bn); c != 0 {
+				return c
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInt(len(as), len(bs))
+}
+
+// Sort orders version names from highest to lowest. Names that are not
+// semantic versions keep their relative order and go after the others.
+func Sort(names []string) {
+	sort.SliceStable(names, func(i, j int) bool {
+		return Less(names[j], names[i])
+	})
+}
+
+// Less reports whether version name a orders below b. Names that do not
+// parse order below every version that does, and equal to each other.
+func Less(a, b string) bool {
+	av, aErr := Parse(a)
+	bv, bErr := Parse(b)
+	switch {
+	case aErr != nil:
+		return bErr == nil
+	case bErr != nil:
+		return false
+	}
+	return Compare(av, bv) < 0
+}
+
+// Latest returns the highest release among names, skipping pre-releases and
+// names that are not semantic versions. It returns "" if there is none.
+func Latest(names []string) string {
+	var latest string
+	var best Version
+	for _, name := range names {
+		v, err := Parse(name)
+		if err != nil || v.IsPrerelease() {
+			continue
+		}
+		if latest == "" || Compare(v, best) > 0 {
+			latest, best = name, v
+		}
+	}
+	return latest
+}
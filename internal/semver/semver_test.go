@@ -0,0 +1,161 @@
+package semver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{"v1.2.3", "1.2.3", false},
+		{"1.2.3", "1.2.3", false},
+		{"1.4", "1.4.0", false},
+		{"v2", "2.0.0", false},
+		{"2.0.0-rc.1", "2.0.0-rc.1", false},
+		{"1.0.0+build.5", "1.0.0", false},
+		{"edge", "", true},
+		{"1.2.3.4", "", true},
+		{"01.2.3", "", true},
+		{"1.2.3-", "", true},
+		{"1.x", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			v, err := Parse(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, v.String())
+			assert.Equal(t, tt.input, v.Original)
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.2.9", "1.10.0", "2.0.0",
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		a, _ := Parse(ordered[i])
+		b, _ := Parse(ordered[i+1])
+		assert.Equal(t, -1, Compare(a, b), "%s < %s", ordered[i], ordered[i+1])
+		assert.Equal(t, 1, Compare(b, a), "%s > %s", ordered[i+1], ordered[i])
+	}
+
+	a, _ := Parse("v1.4")
+	b, _ := Parse("1.4.0")
+	assert.Equal(t, 0, Compare(a, b))
+}
+
+func TestSort(t *testing.T) {
+	names := []string{"v1.2.0", "edge", "v2.0.0", "v1.2.9", "v2.1.0-rc.1", "main", "v1.10.0"}
+	Sort(names)
+	assert.Equal(t, []string{"v2.1.0-rc.1", "v2.0.0", "v1.10.0", "v1.2.9", "v1.2.0", "edge", "main"}, names)
+}
+
+func TestLatest(t *testing.T) {
+	assert.Equal(t, "v2.0.0", Latest([]string{"v1.2.9", "v2.0.0", "v1.3.0"}))
+	assert.Equal(t, "v2.0.0", Latest([]string{"v2.0.0", "v3.0.0-beta.1", "edge"}))
+	assert.Equal(t, "", Latest([]string{"v3.0.0-beta.1", "edge"}))
+	assert.Equal(t, "", Latest(nil))
+}
+
+func TestConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matches    []string
+		rejects    []string
+	}{
+		{"1.4.2", []string{"1.4.2", "v1.4.2"}, []string{"1.4.3"}},
+		{"1.4", []string{"1.4.0", "1.4.9"}, []string{"1.5.0", "1.3.9"}},
+		{"1.x", []string{"1.0.0", "1.9.9"}, []string{"2.0.0"}},
+		{"*", []string{"0.0.1", "9.0.0"}, []string{"1.0.0-rc.1"}},
+		{"~1.4", []string{"1.4.0", "1.4.7"}, []string{"1.5.0", "1.3.0"}},
+		{"~1.4.2", []string{"1.4.2", "1.4.9"}, []string{"1.4.1", "1.5.0"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"^1.4.2", []string{"1.4.2", "1.9.0"}, []string{"1.4.1", "2.0.0"}},
+		{"^0.4.2", []string{"0.4.2", "0.4.9"}, []string{"0.5.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{">=2.0,<3", []string{"2.0.0", "2.9.9"}, []string{"1.9.9", "3.0.0", "3.0.0-rc.1"}},
+		{">= 2.0, < 3", []string{"2.5.0"}, []string{"3.0.0"}},
+		{">1.4", []string{"1.5.0"}, []string{"1.4.9"}},
+		{"<=1.4", []string{"1.4.9", "0.1.0"}, []string{"1.5.0"}},
+		{"!=1.4.3", []string{"1.4.2", "1.4.4"}, []string{"1.4.3"}},
+		{"~1.4 || >=3", []string{"1.4.1", "3.2.0"}, []string{"2.0.0"}},
+		{">=2.0.0-rc.1", []string{"2.0.0-rc.2", "2.0.0", "2.1.0"}, []string{"2.1.0-rc.1", "2.0.0-beta.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			c, err := ParseConstraint(tt.constraint)
+			require.NoError(t, err)
+			for _, s := range tt.matches {
+				v, err := Parse(s)
+				require.NoError(t, err)
+				assert.True(t, c.Check(v), "%s should match %s", s, tt.constraint)
+			}
+			for _, s := range tt.rejects {
+				v, err := Parse(s)
+				require.NoError(t, err)
+				assert.False(t, c.Check(v), "%s should not match %s", s, tt.constraint)
+			}
+		})
+	}
+}
+
+func TestParseConstraint_Invalid(t *testing.T) {
+	for _, s := range []string{"", "~", ">=2.0,", "!=1.4", "~edge", "1.x.3"} {
+		_, err := ParseConstraint(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	names := []string{"v2.1.0-rc.1", "v2.0.0", "v1.4.7", "v1.4.2", "v1.2.9", "edge"}
+
+	tests := []struct {
+		ref      string
+		expected string
+		wantErr  bool
+	}{
+		{"", "v2.0.0", false},
+		{"v1.4.2", "v1.4.2", false},
+		{"edge", "edge", false},
+		{"v2.1.0-rc.1", "v2.1.0-rc.1", false},
+		{"~1.4", "v1.4.7", false},
+		{"1.2", "v1.2.9", false},
+		{">=2.0,<3", "v2.0.0", false},
+		{"^3", "", true},
+		{"not a version", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := Resolve(tt.ref, names)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+
+	got, err := Resolve("", []string{"edge", "v3.0.0-beta.1"})
+	require.NoError(t, err)
+	assert.Equal(t, "edge", got)
+
+	_, err = Resolve("", nil)
+	assert.Error(t, err)
+}
@@ -1,9 +1,11 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -38,6 +40,17 @@ func Run(cfg Config) error {
 		database.SeedInitialUser(database.DB)
 	}
 
+	// Background ingestion of new versions. Set INGEST_WORKERS=0 to run an
+	// instance that only serves requests.
+	ingestWorkers := 2
+	if n, err := strconv.Atoi(os.Getenv("INGEST_WORKERS")); err == nil && n >= 0 {
+		ingestWorkers = n
+	}
+	handlers.StartIngestWorkers(context.Background(), ingestWorkers)
+
+	// Index resources created before full-text search, or by the seed
+	go handlers.ReindexSearch()
+
 	// 2. Setup Template Engine
 	engine := html.New("./views", ".html")
 	if os.Getenv("ENV") != "production" {
@@ -105,9 +118,16 @@ func Run(cfg Config) error {
 		ReferrerPolicy:            "same-origin",
 	}))
 
+	// API token authentication (must run before CSRF so token requests can skip it)
+	app.Use(handlers.TokenAuth)
+
 	// CSRF Middleware
 	isProduction := os.Getenv("ENV") == "production"
 	app.Use(csrf.New(csrf.Config{
+		Next: func(c *fiber.Ctx) bool {
+			// Bearer tokens are never sent automatically by browsers
+			return c.Locals("APIToken") != nil
+		},
 		KeyLookup:      "header:X-CSRF-Token",
 		ContextKey:     "csrf",
 		CookieName:     "csrf_token",
@@ -120,13 +140,24 @@ func Run(cfg Config) error {
 
 	// Session and Flash middleware
 	app.Use(func(c *fiber.Ctx) error {
+		if c.Locals("APIToken") != nil {
+			// User was already loaded by TokenAuth
+			return c.Next()
+		}
 		sess, err := handlers.Store.Get(c)
 		if err == nil {
 			if userID := sess.Get("user_id"); userID != nil {
 				var user models.User
 				if err := database.DB.Preload("Memberships.Organization").First(&user, userID).Error; err == nil {
-					c.Locals("UserID", userID)
-					c.Locals("User", user)
+					if user.SuspendedAt != nil {
+						// Suspended users are logged out on their next request
+						if err := sess.Destroy(); err != nil {
+							log.Printf("Error destroying suspended user's session: %v", err)
+						}
+					} else {
+						c.Locals("UserID", userID)
+						c.Locals("User", user)
+					}
 				}
 			}
 
@@ -143,6 +174,9 @@ func Run(cfg Config) error {
 					log.Printf("Error saving session in middleware: %v", err)
 				}
 			}
+			if c.Locals("User") != nil {
+				handlers.TouchSession(c)
+			}
 		}
 
 		c.Locals("CSRFToken", c.Locals("csrf"))
@@ -188,6 +222,8 @@ func Run(cfg Config) error {
 
 	app.Get("/login", handlers.GetLogin)
 	app.Post("/login", authLimiter, handlers.PostLogin)
+	app.Get("/login/2fa", handlers.GetLogin2FA)
+	app.Post("/login/2fa", authLimiter, handlers.PostLogin2FA)
 	app.Get("/signup", handlers.GetSignup)
 	app.Post("/signup", authLimiter, handlers.PostSignup)
 	app.Get("/logout", handlers.Logout)
@@ -201,10 +237,36 @@ func Run(cfg Config) error {
 	// Org Routes
 	app.Get("/orgs/new", handlers.RequireAuth, handlers.GetCreateOrg)
 	app.Post("/orgs/new", handlers.RequireAuth, handlers.RequireVerifiedEmail, handlers.PostCreateOrg)
-	app.Get("/orgs/:orgname/settings", handlers.RequireAuth, handlers.RequireOrgOwner, handlers.GetOrgSettings)
+	manageMembers := handlers.RequireOrgPermission(handlers.PermManageMembers)
+	app.Get("/orgs/:orgname/settings", handlers.RequireAuth, manageMembers, handlers.GetOrgSettings)
 	app.Post("/orgs/:orgname/update", handlers.RequireAuth, handlers.RequireVerifiedEmail, handlers.RequireOrgOwner, handlers.PostUpdateOrg)
-	app.Post("/orgs/:orgname/members/add", handlers.RequireAuth, handlers.RequireVerifiedEmail, handlers.RequireOrgOwner, handlers.PostAddMember)
-	app.Post("/orgs/:orgname/members/:member_id/remove", handlers.RequireAuth, handlers.RequireVerifiedEmail, handlers.RequireOrgOwner, handlers.PostRemoveMember)
+	app.Post("/orgs/:orgname/roles", handlers.RequireAuth, handlers.RequireVerifiedEmail, handlers.RequireOrgOwner, handlers.PostUpdateOrgRoles)
+	app.Post("/orgs/:orgname/invitations", handlers.RequireAuth, handlers.RequireVerifiedEmail, manageMembers, handlers.PostInviteMember)
+	app.Post("/orgs/:orgname/invitations/:id/revoke", handlers.RequireAuth, handlers.RequireVerifiedEmail, manageMembers, handlers.PostRevokeInvitation)
+	app.Post("/orgs/:orgname/members/:member_id/role", handlers.RequireAuth, handlers.RequireVerifiedEmail, manageMembers, handlers.PostUpdateMemberRole)
+	app.Post("/orgs/:orgname/members/:member_id/remove", handlers.RequireAuth, handlers.RequireVerifiedEmail, manageMembers, handlers.PostRemoveMember)
+	app.Get("/invitations/:token", handlers.GetInvitation)
+	app.Post("/invitations/:token/accept", handlers.RequireAuth, handlers.PostAcceptInvitation)
+	app.Post("/invitations/:token/decline", handlers.PostDeclineInvitation)
+
+	// Account Settings Routes
+	settings := app.Group("/settings", handlers.RequireAuth)
+	settings.Get("/tokens", handlers.GetTokens)
+	settings.Post("/tokens", handlers.RequireVerifiedEmail, handlers.PostCreateToken)
+	settings.Post("/tokens/:id/revoke", handlers.PostRevokeToken)
+	settings.Get("/keys", handlers.GetSigningKeys)
+	settings.Post("/keys", handlers.RequireVerifiedEmail, handlers.PostCreateSigningKey)
+	settings.Post("/keys/:id/revoke", handlers.PostRevokeSigningKey)
+	settings.Get("/security", handlers.GetSecuritySettings)
+	settings.Post("/security/2fa/enable", handlers.PostEnable2FA)
+	settings.Post("/security/2fa/disable", handlers.PostDisable2FA)
+	settings.Post("/security/recovery-codes", handlers.PostRegenerateRecoveryCodes)
+	settings.Get("/sessions", handlers.GetSessions)
+	settings.Post("/sessions/revoke-others", handlers.PostRevokeOtherSessions)
+	settings.Post("/sessions/:id/revoke", handlers.PostRevokeSession)
+	settings.Get("/account", handlers.GetAccountSettings)
+	settings.Get("/account/export", handlers.GetAccountExport)
+	settings.Post("/account/delete", handlers.PostDeleteAccount)
 
 	// OAuth Routes
 	app.Get("/auth/:provider", handlers.BeginAuth)
@@ -223,21 +285,42 @@ func Run(cfg Config) error {
 	admin.Get("/organizations/:id/edit", handlers.GetEditOrganization)
 	admin.Post("/organizations/:id/edit", handlers.PostEditOrganization)
 	admin.Delete("/organizations/:id", handlers.DeleteOrganization)
+	admin.Get("/ingestion", handlers.GetAdminIngestion)
+	admin.Post("/ingestion/:id/retry", handlers.PostRetryIngestion)
+	admin.Get("/audit", handlers.GetAdminAudit)
+	admin.Get("/audit/export", handlers.GetAuditExport)
+	admin.Get("/moderation", handlers.GetAdminModeration)
+	admin.Post("/reports/:id/dismiss", handlers.PostDismissReport)
+	admin.Post("/resources/:id/hide", handlers.PostHideResource)
+	admin.Post("/resources/:id/unhide", handlers.PostUnhideResource)
+	admin.Post("/users/:id/suspend", handlers.PostSuspendUser)
+	admin.Post("/users/:id/unsuspend", handlers.PostUnsuspendUser)
+	admin.Post("/settings/2fa", handlers.PostAdminRequire2FA)
 
 	// Resource Routes
 	app.Get("/new", handlers.RequireAuth, handlers.GetNewResource)
 	app.Get("/new/my-repos", handlers.RequireAuth, handlers.GetMyRepos)
 	app.Get("/new/fetch-info", handlers.RequireAuth, handlers.FetchInfo)
-	app.Post("/new", handlers.RequireAuth, handlers.RequireVerifiedEmail, handlers.PostNewResource)
-	app.Delete("/resource/:id", handlers.RequireAuth, handlers.RequireVerifiedEmail, handlers.DeleteResource)
+	app.Post("/new", handlers.RequireAuthOrToken(handlers.ScopeResourcesWrite), handlers.RequireVerifiedEmail, handlers.PostNewResource)
+	app.Delete("/resource/:id", handlers.RequireAuthOrToken(handlers.ScopeResourcesDelete), handlers.RequireVerifiedEmail, handlers.DeleteResource)
 	app.Post("/resource/:id/webhook", handlers.HandleWebhook)
-	app.Post("/resource/:id/webhook/reset", handlers.RequireAuth, handlers.RequireVerifiedEmail, handlers.PostResetWebhookSecret)
+	app.Post("/resource/:id/webhook/reset", handlers.RequireAuthOrToken(handlers.ScopeWebhooksWrite), handlers.RequireVerifiedEmail, handlers.PostResetWebhookSecret)
+	app.Get("/resource/:id/webhook/deliveries", handlers.RequireAuthOrToken(handlers.ScopeWebhooksWrite), handlers.GetWebhookDeliveries)
+	app.Post("/resource/:id/webhook/deliveries/:delivery/replay", handlers.RequireAuthOrToken(handlers.ScopeWebhooksWrite), handlers.RequireVerifiedEmail, handlers.PostReplayWebhookDelivery)
 	app.Get("/resource/:id/fetch-readme", handlers.FetchReadme)
+	app.Post("/resource/:id/versions/:version/refresh", handlers.RequireAuth, handlers.RequireVerifiedEmail, handlers.PostRefreshVersion)
 	app.Get("/resource/:id/new-version", handlers.RequireAuth, handlers.GetNewVersion)
-	app.Post("/resource/:id/version", handlers.RequireAuth, handlers.RequireVerifiedEmail, handlers.PostNewVersion)
+	app.Get("/resource/:id/report", handlers.RequireAuth, handlers.GetReportResource)
+	app.Post("/resource/:id/report", handlers.RequireAuth, handlers.PostReportResource)
+	app.Post("/resource/:id/version", handlers.RequireAuthOrToken(handlers.ScopeVersionsWrite), handlers.RequireVerifiedEmail, handlers.PostNewVersion)
+	app.Post("/resource/:id/versions/:version/yank", handlers.RequireAuthOrToken(handlers.ScopeVersionsWrite), handlers.RequireVerifiedEmail, handlers.PostYankVersion)
+	app.Post("/resource/:id/versions/:version/unyank", handlers.RequireAuthOrToken(handlers.ScopeVersionsWrite), handlers.RequireVerifiedEmail, handlers.PostUnyankVersion)
+	app.Post("/resource/:id/versions/:version/signature", handlers.RequireAuthOrToken(handlers.ScopeVersionsWrite), handlers.RequireVerifiedEmail, handlers.PostVersionSignature)
+	app.Post("/resource/:id/deprecate", handlers.RequireAuthOrToken(handlers.ScopeResourcesWrite), handlers.RequireVerifiedEmail, handlers.PostDeprecateResource)
+	app.Post("/resource/:id/undeprecate", handlers.RequireAuthOrToken(handlers.ScopeResourcesWrite), handlers.RequireVerifiedEmail, handlers.PostUndeprecateResource)
 	app.Post("/resource/:id/star", handlers.RequireAuth, handlers.ToggleStar)
 	app.Get("/:username/:resourcename/edit", handlers.RequireAuth, handlers.GetEditResource)
-	app.Post("/resource/:id/edit", handlers.RequireAuth, handlers.RequireVerifiedEmail, handlers.PostEditResource)
+	app.Post("/resource/:id/edit", handlers.RequireAuthOrToken(handlers.ScopeResourcesWrite), handlers.RequireVerifiedEmail, handlers.PostEditResource)
 
 	// Dev Routes (disabled in production)
 	if os.Getenv("ENV") != "production" {
@@ -251,6 +334,10 @@ func Run(cfg Config) error {
 	app.Get("/v1/registries/search", handlers.SearchRegistriesAPI)
 	app.Get("/v1/jobs", handlers.ListAllJobsAPI)
 	app.Get("/v1/jobs/search", handlers.SearchJobsAPI)
+	app.Get("/v1/search", handlers.SearchAPI)
+	app.Get("/v1/user", handlers.GetCurrentUserAPI)
+	app.Get("/v1/keys/:namespace", handlers.ListSigningKeysAPI)
+	app.Get("/v1/audit", handlers.RequireAuthOrToken(handlers.ScopeAuditRead), handlers.GetAuditExport)
 
 	// Namespaced Routes (catch-all, must be last)
 	app.Get("/:username", handlers.GetUserProfile)
@@ -258,6 +345,8 @@ func Run(cfg Config) error {
 	app.Get("/:username/:resourcename/v", handlers.GetResourceVersion)
 	app.Get("/:username/:resourcename/raw", handlers.GetRawResource)
 	app.Get("/:username/:resourcename/v/:version/raw", handlers.GetRawResourceVersion)
+	app.Get("/:username/:resourcename/v/:version/archive.tar.gz", handlers.GetVersionArchive)
+	app.Get("/:username/:resourcename/stats", handlers.GetResourceStats)
 
 	// 7. Start Server
 	port := cfg.Port
@@ -52,6 +52,33 @@ func SendVerificationEmail(toEmail string, verificationLink string) error {
 	return sendEmailWithTLS(host, port, user, password, from, []string{toEmail}, msg)
 }
 
+// SendInvitationEmail invites someone to join an organization. The link
+// leads to a page where they can accept or decline, or sign up first.
+func SendInvitationEmail(toEmail string, orgName string, inviterName string, role string, invitationLink string) error {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	user := os.Getenv("SMTP_USER")
+	password := os.Getenv("SMTP_PASSWORD")
+	from := os.Getenv("FROM_ADDRESS")
+
+	if host == "" || user == "" || password == "" {
+		return fmt.Errorf("SMTP configuration missing")
+	}
+
+	msg := []byte(fmt.Sprintf("To: %s\r\n"+
+		"Subject: Join %s on RMBL\r\n"+
+		"\r\n"+
+		"%s has invited you to join the %s organization on RMBL as a %s.\r\n"+
+		"\r\n"+
+		"Accept or decline the invitation here:\r\n"+
+		"\r\n"+
+		"%s\r\n"+
+		"\r\n"+
+		"This link will expire in 7 days. If you don't have an account yet, you can create one from the link.\r\n", toEmail, orgName, inviterName, orgName, role, invitationLink))
+
+	return sendEmailWithTLS(host, port, user, password, from, []string{toEmail}, msg)
+}
+
 // sendEmailWithTLS sends email using STARTTLS for secure transmission
 func sendEmailWithTLS(host, port, user, password, from string, to []string, msg []byte) error {
 	addr := net.JoinHostPort(host, port)
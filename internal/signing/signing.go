@@ -0,0 +1,111 @@
+// Package signing handles the ed25519 keys publishers sign releases with. It
+// is shared by the registry, which checks signatures when they are attached
+// to a version, and the CLI, which creates them and checks them before a pack
+// or job is run.
+//
+// A signature covers a version's digest string, e.g. "sha256:9f86d0...", so
+// it vouches for the exact archive or job content clients download.
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// keyPrefix marks public keys in their text form, "ed25519:<base64>"
+const keyPrefix = "ed25519:"
+
+// ErrBadSignature is returned when a signature does not match the digest
+var ErrBadSignature = errors.New("signature does not match")
+
+// ParsePublicKey reads a public key given as "ed25519:<base64>" or as a PEM
+// "PUBLIC KEY" block, such as the output of
+// "openssl pkey -in key.pem -pubout"
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key: %w", err)
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("invalid public key: not an ed25519 key")
+		}
+		return pub, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, keyPrefix))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key: expected %s<base64 of %d bytes>", keyPrefix, ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// FormatPublicKey returns the text form of a public key, "ed25519:<base64>"
+func FormatPublicKey(pub ed25519.PublicKey) string {
+	return keyPrefix + base64.StdEncoding.EncodeToString(pub)
+}
+
+// Fingerprint identifies a public key in listings and signatures, in the
+// style of ssh-keygen: "SHA256:<unpadded base64>"
+func Fingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// GenerateKey creates a new key pair
+func GenerateKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// EncodePrivateKey returns a private key as a PKCS #8 PEM block, the format
+// written by "openssl genpkey -algorithm ed25519"
+func EncodePrivateKey(priv ed25519.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// DecodePrivateKey reads a private key written by EncodePrivateKey or openssl
+func DecodePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid private key: no PEM block found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid private key: not an ed25519 key")
+	}
+	return priv, nil
+}
+
+// Sign returns the base64 signature of a version digest
+func Sign(priv ed25519.PrivateKey, digest string) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(digest)))
+}
+
+// Verify checks a base64 signature of a version digest
+func Verify(pub ed25519.PublicKey, digest, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid signature encoding")
+	}
+	if !ed25519.Verify(pub, []byte(digest), sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package signing
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	pub, priv, err := GenerateKey()
+	require.NoError(t, err)
+
+	digest := "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	sig := Sign(priv, digest)
+	assert.NoError(t, Verify(pub, digest, sig))
+	assert.ErrorIs(t, Verify(pub, "sha256:0000", sig), ErrBadSignature)
+
+	other, _, err := GenerateKey()
+	require.NoError(t, err)
+	assert.ErrorIs(t, Verify(other, digest, sig), ErrBadSignature)
+	assert.Error(t, Verify(pub, digest, "not base64!"))
+}
+
+func TestParsePublicKey(t *testing.T) {
+	pub, _, err := GenerateKey()
+	require.NoError(t, err)
+
+	parsed, err := ParsePublicKey(FormatPublicKey(pub))
+	require.NoError(t, err)
+	assert.Equal(t, pub, parsed)
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	parsed, err = ParsePublicKey(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	require.NoError(t, err)
+	assert.Equal(t, pub, parsed)
+	assert.Equal(t, Fingerprint(pub), Fingerprint(parsed))
+
+	for _, s := range []string{"", "ed25519:abc", "ssh-ed25519 AAAA"} {
+		_, err := ParsePublicKey(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestPrivateKeyRoundTrip(t *testing.T) {
+	_, priv, err := GenerateKey()
+	require.NoError(t, err)
+
+	data, err := EncodePrivateKey(priv)
+	require.NoError(t, err)
+	decoded, err := DecodePrivateKey(data)
+	require.NoError(t, err)
+	assert.Equal(t, priv, decoded)
+
+	_, err = DecodePrivateKey([]byte("garbage"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeAt(t *testing.T) {
+	// The RFC's 8-digit codes, cut to the last six digits
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		code, err := CodeAt(rfcSecret, Step(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, want, code, "time %d", unix)
+	}
+
+	_, err := CodeAt("not base32!", 1)
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := CodeAt(rfcSecret, Step(now))
+
+	step, ok := Validate(rfcSecret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// A step of clock drift either way is tolerated, more is not
+	_, ok = Validate(rfcSecret, code, now.Add(30*time.Second))
+	assert.True(t, ok)
+	_, ok = Validate(rfcSecret, code, now.Add(-30*time.Second))
+	assert.True(t, ok)
+	_, ok = Validate(rfcSecret, code, now.Add(90*time.Second))
+	assert.False(t, ok)
+
+	_, ok = Validate(rfcSecret, code[:3]+" "+code[3:], now)
+	assert.True(t, ok)
+	_, ok = Validate(rfcSecret, "000000", now)
+	assert.False(t, ok)
+	_, ok = Validate(rfcSecret, "12345", now)
+	assert.False(t, ok)
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	require.NoError(t, err)
+	b, _ := GenerateSecret()
+	assert.Len(t, a, 32)
+	assert.NotEqual(t, a, b)
+	_, err = CodeAt(a, 1)
+	assert.NoError(t, err)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Ramble", "alice@example.com", rfcSecret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Ramble:alice@example.com?"))
+	assert.Contains(t, uri, "secret="+rfcSecret)
+	assert.Contains(t, uri, "issuer=Ramble")
+}